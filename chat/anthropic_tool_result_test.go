@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// writeAnthropicSSE writes a streamed Anthropic message with a single content block
+func writeAnthropicSSE(w http.ResponseWriter, contentBlock string, delta string, stopReason string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-7-sonnet","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":` + contentBlock + `}`,
+		`{"type":"content_block_delta","index":0,"delta":` + delta + `}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"` + stopReason + `"},"usage":{"output_tokens":5}}`,
+		`{"type":"message_stop"}`,
+	}
+	for _, event := range events {
+		var typ struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(event), &typ)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ.Type, event)
+	}
+}
+
+func TestAnthropicMultiPartToolResult(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, string(body))
+		n := len(requests)
+		mutex.Unlock()
+
+		if n == 1 {
+			writeAnthropicSSE(w,
+				`{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}`,
+				`{"type":"input_json_delta","partial_json":"{\"city\":\"Tokyo\"}"}`,
+				"tool_use",
+			)
+			return
+		}
+		writeAnthropicSSE(w,
+			`{"type":"text","text":""}`,
+			`{"type":"text_delta","text":"It is sunny in Tokyo."}`,
+			"end_turn",
+		)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "claude-3-7-sonnet",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	toolCallback := func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		return types.ToolResult{
+			Content: []types.ToolResultPart{
+				{Type: types.ToolResultPartType_Text, Text: "Weather report for Tokyo"},
+				{Type: types.ToolResultPartType_JSON, Data: map[string]interface{}{"temperature": 22, "condition": "sunny"}},
+			},
+		}, true, nil
+	}
+
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(toolCallback),
+		WithMaxRounds(2),
+	)
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+
+	var req struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type      string `json:"type"`
+				ToolUseID string `json:"tool_use_id"`
+				Content   []struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(requests[1]), &req); err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != "user" || len(last.Content) != 1 || last.Content[0].Type != "tool_result" {
+		t.Fatalf("expected last message to be a tool result, got: %s", requests[1])
+	}
+	parts := last.Content[0].Content
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 tool result parts, got %d: %s", len(parts), requests[1])
+	}
+	if parts[0].Type != "text" || parts[0].Text != "Weather report for Tokyo" {
+		t.Errorf("unexpected first part: %+v", parts[0])
+	}
+	if parts[1].Type != "text" || !strings.Contains(parts[1].Text, `"condition":"sunny"`) {
+		t.Errorf("unexpected second part: %+v", parts[1])
+	}
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// auditRecord is one line of the audit log, a tool execution and its outcome
+type auditRecord struct {
+	Time       string      `json:"time"`
+	ToolUseID  string      `json:"tool_use_id"`
+	Tool       string      `json:"tool"`
+	Args       interface{} `json:"args,omitempty"`
+	WorkingDir string      `json:"working_dir,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+	Success    bool        `json:"success"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// auditLog appends each tool execution as a JSON line to file
+type auditLog struct {
+	file string
+
+	mutex sync.Mutex
+}
+
+func newAuditLog(file string) *auditLog {
+	if file == "" {
+		return nil
+	}
+	return &auditLog{file: file}
+}
+
+// record writes the execution of call started at start, err being
+// an error of the execution itself rather than of the tool
+func (c *auditLog) record(call types.ToolCall, workingDir string, start time.Time, result types.ToolResult, err error) {
+	record := auditRecord{
+		Time:       start.Format(time.RFC3339Nano),
+		ToolUseID:  call.ID,
+		Tool:       call.Name,
+		Args:       traceBody([]byte(call.RawArgs)),
+		WorkingDir: workingDir,
+		DurationMS: time.Since(start).Milliseconds(),
+		Error:      result.Error,
+		Result:     result.Content,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	record.Success = record.Error == ""
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	f, openErr := os.OpenFile(c.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if openErr != nil {
+		fmt.Fprintf(os.Stderr, "open audit log: %v\n", openErr)
+		return
+	}
+	defer f.Close()
+	if encodeErr := json.NewEncoder(f).Encode(record); encodeErr != nil {
+		fmt.Fprintf(os.Stderr, "write audit log: %v\n", encodeErr)
+	}
+}
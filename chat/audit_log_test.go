@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	var requests atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"run_terminal_cmd","arguments":"{\"command\":\"echo audited\",\"is_background\":false}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer apiServer.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	dir := t.TempDir()
+	auditFile := filepath.Join(dir, "audit.jsonl")
+	_, err = client.Chat(context.Background(), "echo something",
+		WithTools("run_terminal_cmd"),
+		WithDefaultToolCwd(dir),
+		WithMaxRounds(2),
+		WithAuditLog(auditFile),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	f, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("decode %s: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(records))
+	}
+	record := records[0]
+	if record.Tool != "run_terminal_cmd" || record.ToolUseID != "call_1" || record.WorkingDir != dir || record.Time == "" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	args, _ := json.Marshal(record.Args)
+	if !strings.Contains(string(args), `"command":"echo audited"`) {
+		t.Errorf("expected the arguments recorded, got %s", args)
+	}
+	result, _ := json.Marshal(record.Result)
+	if !record.Success || !strings.Contains(string(result), "audited") {
+		t.Errorf("expected a successful result with the output, got %v %s", record.Success, result)
+	}
+}
@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// autoSaver keeps the session messages in memory and periodically
+// rewrites the record file with them
+type autoSaver struct {
+	file string
+
+	mutex    sync.Mutex
+	messages []types.Message
+	dirty    bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAutoSaver(file string, history []types.Message) *autoSaver {
+	return &autoSaver{
+		file:     file,
+		messages: append([]types.Message(nil), history...),
+	}
+}
+
+// Add appends msg to the in-memory session
+func (c *autoSaver) Add(msg types.Message) {
+	// same as AppendToHistory
+	if msg.Time == "" {
+		msg.Time = types.Now().Format(time.RFC3339)
+	}
+	c.mutex.Lock()
+	c.messages = append(c.messages, msg)
+	c.dirty = true
+	c.mutex.Unlock()
+}
+
+// Append adds msg to the in-memory session and appends it to the record file,
+// holding the lock of Flush so that a rewrite never interleaves with the append
+func (c *autoSaver) Append(msg types.Message) error {
+	if msg.Time == "" {
+		msg.Time = types.Now().Format(time.RFC3339)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.messages = append(c.messages, msg)
+	if err := AppendToHistory(c.file, msg); err != nil {
+		// the next flush writes it
+		c.dirty = true
+		return err
+	}
+	return nil
+}
+
+// Flush writes the session to the record file if changed since last flush
+func (c *autoSaver) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	if err := SaveHistory(c.file, c.messages); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Start flushes every interval until Stop is called, onError receives flush errors
+func (c *autoSaver) Start(interval time.Duration, onError func(err error)) {
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if err := c.Flush(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops periodic flushing and does a final flush
+func (c *autoSaver) Stop() error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+	return c.Flush()
+}
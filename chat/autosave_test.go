@@ -0,0 +1,157 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestAutoSaverPeriodicFlush(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "record.json")
+	history := []types.Message{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "hello", Time: "2025-01-01T00:00:00Z"},
+	}
+
+	saver := newAutoSaver(file, history)
+	saver.Start(10*time.Millisecond, func(err error) {
+		t.Errorf("auto save: %v", err)
+	})
+	defer saver.Stop()
+
+	saver.Add(types.Message{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "hi"})
+	waitHistoryLen(t, file, 2)
+
+	// a second flush picks up messages added after the first one
+	saver.Add(types.Message{Type: types.MsgType_Msg, Role: types.Role_User, Content: "bye"})
+	messages := waitHistoryLen(t, file, 3)
+
+	if messages[0].Content != "hello" || messages[1].Content != "hi" || messages[2].Content != "bye" {
+		t.Errorf("unexpected messages: %+v", messages)
+	}
+	if messages[1].Time == "" {
+		t.Errorf("expected time to be filled")
+	}
+}
+
+func TestAutoSaverStopFlushes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "record.json")
+
+	saver := newAutoSaver(file, nil)
+	saver.Start(time.Hour, nil)
+	saver.Add(types.Message{Type: types.MsgType_Msg, Role: types.Role_User, Content: "hello"})
+	if err := saver.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	messages, err := LoadHistory(file)
+	if err != nil {
+		t.Fatalf("load history: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Content != "hello" {
+		t.Errorf("unexpected messages: %+v", messages)
+	}
+}
+
+func TestSaveOnInterrupt(t *testing.T) {
+	apiServer := startToolCallServer(t)
+	file := filepath.Join(t.TempDir(), "record.json")
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	handler := NewCliHandler(client, CliOptions{
+		RecordFile:          file,
+		NoIncrementalRecord: true,
+		SaveOnInterrupt:     true,
+	})
+
+	// interrupted while the tool runs, before the second round
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = handler.HandleCli(ctx, "What's the weather in Tokyo?",
+		WithMaxRounds(2),
+		WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			cancel()
+			return types.ToolResult{Content: "sunny"}, true, nil
+		}),
+	)
+	if err == nil {
+		t.Fatal("expected the interrupted chat to fail")
+	}
+
+	messages, err := LoadHistory(file)
+	if err != nil {
+		t.Fatalf("load history: %v", err)
+	}
+	var gotTypes []types.MsgType
+	for _, msg := range messages {
+		if msg.Type.HistorySendable() {
+			gotTypes = append(gotTypes, msg.Type)
+		}
+	}
+	want := []types.MsgType{types.MsgType_Msg, types.MsgType_ToolCall, types.MsgType_ToolResult}
+	if fmt.Sprint(gotTypes) != fmt.Sprint(want) {
+		t.Errorf("expected the partial session %v saved, got %v", want, gotTypes)
+	}
+}
+
+func waitHistoryLen(t *testing.T, file string, n int) []types.Message {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		messages, err := LoadHistory(file)
+		if err == nil && len(messages) == n {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d messages in %s, got %d, err: %v", n, file, len(messages), err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAutoSaverAppendDuringFlush(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "record.json")
+
+	saver := newAutoSaver(file, nil)
+	saver.Start(time.Millisecond, func(err error) {
+		t.Errorf("auto save: %v", err)
+	})
+	const n = 200
+	for i := 0; i < n; i++ {
+		msg := types.Message{Type: types.MsgType_Msg, Role: types.Role_User, Content: fmt.Sprintf("msg-%d", i)}
+		if i%2 == 0 {
+			// marks the session dirty, so the ticker keeps rewriting the file
+			saver.Add(msg)
+			continue
+		}
+		if err := saver.Append(msg); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if err := saver.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	messages, err := LoadHistory(file)
+	if err != nil {
+		t.Fatalf("load history: %v", err)
+	}
+	if len(messages) != n {
+		t.Fatalf("expected %d messages, got %d", n, len(messages))
+	}
+	for i, msg := range messages {
+		if msg.Content != fmt.Sprintf("msg-%d", i) {
+			t.Fatalf("expected msg-%d at %d, got %s", i, i, msg.Content)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	openai_opt "github.com/openai/openai-go/option"
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/jsonschema"
+)
+
+// cachePrefixTool is the part of a tool sent to the provider
+type cachePrefixTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  *jsonschema.JsonSchema `json:"parameters,omitempty"`
+}
+
+// cachePrefixHash hashes the cache-relevant prefix of a request,
+// any change to the system prompt or tools busts the provider's prompt cache
+func cachePrefixHash(systemPrompt string, toolSchemas tools.UnifiedTools) string {
+	prefix := struct {
+		System string            `json:"system"`
+		Tools  []cachePrefixTool `json:"tools"`
+	}{
+		System: systemPrompt,
+	}
+	for _, tool := range toolSchemas {
+		prefix.Tools = append(prefix.Tools, cachePrefixTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	data, err := json.Marshal(prefix)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// lastCachePrefixHash returns the prefix hash of the last run recorded in history
+func lastCachePrefixHash(history []types.Message) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		if msg.Type == types.MsgType_CacheInfo && msg.Metadata.CacheInfo != nil && msg.Metadata.CacheInfo.PrefixHash != "" {
+			return msg.Metadata.CacheInfo.PrefixHash
+		}
+	}
+	return ""
+}
+
+// promptCacheKeyOpenAI sets prompt_cache_key, which the SDK has no field for yet
+func promptCacheKeyOpenAI(needCache bool, key string) []openai_opt.RequestOption {
+	if !needCache || key == "" {
+		return nil
+	}
+	return []openai_opt.RequestOption{openai_opt.WithJSONSet("prompt_cache_key", key)}
+}
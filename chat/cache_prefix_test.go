@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestCachePrefixChangedWarning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// run chats one after another, resuming from the recorded events
+	var history []types.Message
+	run := func(systemPrompt string, tools ...string) *types.CacheInfoMetadata {
+		t.Helper()
+		var cacheInfo *types.CacheInfoMetadata
+		var events []types.Message
+		_, err := client.Chat(context.Background(), "hello",
+			WithSystemPrompt(systemPrompt),
+			WithTools(tools...),
+			WithHistory(history),
+			WithEventCallback(func(event types.Message) {
+				if event.Type == types.MsgType_CacheInfo {
+					cacheInfo = event.Metadata.CacheInfo
+					if cacheInfo.PrefixChanged && !strings.Contains(event.Content, "prompt cache will miss") {
+						t.Errorf("expected warning in content, got: %s", event.Content)
+					}
+				}
+				if event.Type.IsFileRecordable() {
+					events = append(events, event)
+				}
+			}),
+		)
+		if err != nil {
+			t.Fatalf("chat: %v", err)
+		}
+		if cacheInfo == nil || cacheInfo.PrefixHash == "" {
+			t.Fatalf("expected cache info with prefix hash, got %+v", cacheInfo)
+		}
+		history = append(history, events...)
+		return cacheInfo
+	}
+
+	first := run("You are helpful.", "list_dir")
+	if first.PrefixChanged {
+		t.Errorf("expected no warning without prior run")
+	}
+	same := run("You are helpful.", "list_dir")
+	if same.PrefixChanged || same.PrefixHash != first.PrefixHash {
+		t.Errorf("expected same prefix, first: %+v, second: %+v", first, same)
+	}
+	changedSystem := run("You are very helpful.", "list_dir")
+	if !changedSystem.PrefixChanged {
+		t.Errorf("expected warning on changed system prompt")
+	}
+	changedTools := run("You are very helpful.", "list_dir", "read_file")
+	if !changedTools.PrefixChanged {
+		t.Errorf("expected warning on changed tools")
+	}
+}
+
+func TestPromptCacheKey(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = nil
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":5,"total_tokens":105,"prompt_tokens_details":{"cached_tokens":80}}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	resp, err := client.Chat(context.Background(), "hello", WithPromptCacheKey("session-1"))
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if body["prompt_cache_key"] != "session-1" {
+		t.Errorf("expected prompt_cache_key session-1, got %v", body["prompt_cache_key"])
+	}
+	if resp.TokenUsage.InputBreakdown.CacheRead != 80 {
+		t.Errorf("expected cache read 80, got %d", resp.TokenUsage.InputBreakdown.CacheRead)
+	}
+
+	_, err = client.Chat(context.Background(), "hello", WithPromptCacheKey("session-1"), WithCache(false))
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if _, ok := body["prompt_cache_key"]; ok {
+		t.Errorf("expected no prompt_cache_key with cache disabled, got %v", body["prompt_cache_key"])
+	}
+}
@@ -22,6 +22,10 @@ func Chat(ctx context.Context, req types.Request) (*types.Response, error) {
 		Model:   req.Model,
 		Token:   req.Token,
 		BaseURL: req.BaseURL,
+		Headers: req.Headers,
+
+		OpenAIOrganization: req.OpenAIOrganization,
+		OpenAIProject:      req.OpenAIProject,
 	})
 	if err != nil {
 		return nil, err
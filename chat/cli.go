@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strings"
 	"time"
 
@@ -33,6 +34,31 @@ type CliOptions struct {
 	Verbose            bool   // Verbose output
 	JSONOutput         bool   // Output response as JSON
 
+	// ShowRoles prefixes assistant messages of the plain output with the role and model
+	ShowRoles bool
+	// RoundSeparator is printed between rounds of the plain output, empty prints none
+	RoundSeparator string
+
+	// AutoSaveInterval periodically rewrites RecordFile with the in-memory session, 0 disables it
+	AutoSaveInterval time.Duration
+	// NoIncrementalRecord disables per-message appends to RecordFile, requires AutoSaveInterval or SaveOnInterrupt
+	NoIncrementalRecord bool
+	// HistoryLast sends only the last N turns of RecordFile along with its system prompt, 0 sends all.
+	// The record itself is kept whole
+	HistoryLast int
+	// SaveOnInterrupt cancels the chat on SIGINT and saves the session to RecordFile before returning
+	SaveOnInterrupt bool
+	// RecordLogProbs keeps token log probabilities in RecordFile, they are stripped by default
+	RecordLogProbs bool
+
+	// ConfirmFileChanges shows the diff of write_file, search_replace, create_file_with_content
+	// and delete_file and asks to accept or reject it before it is applied. It is a tool
+	// callback, so it is rejected with builtin-first and builtin-only tool resolution and
+	// with a chat server, see ValidateConfirmFileChanges
+	ConfirmFileChanges bool
+	// FileChangePolicy decides file changes when stdin is not a terminal: accept(default) or reject
+	FileChangePolicy string
+
 	StreamPair *types.StreamPair
 }
 
@@ -40,6 +66,9 @@ type CliOptions struct {
 type CliHandler struct {
 	client *Client
 	opts   CliOptions
+
+	// a tool result was printed, the next assistant output starts a new round
+	roundEnded bool
 }
 
 // NewCliHandler creates a new CLI handler
@@ -66,6 +95,23 @@ func (h *CliHandler) HandleCliWithServer(ctx context.Context, message string, se
 }
 
 func (h *CliHandler) handleCliEnablingServer(ctx context.Context, message string, server string, chatWithServer func(ctx context.Context, server string, req types.Request) (*types.Response, error), coreOpts ...types.ChatOption) error {
+	if h.opts.NoIncrementalRecord && h.opts.AutoSaveInterval <= 0 && !h.opts.SaveOnInterrupt {
+		return fmt.Errorf("no incremental record requires auto save interval or save on interrupt")
+	}
+	if err := ValidateFileChangePolicy(h.opts.FileChangePolicy); err != nil {
+		return err
+	}
+	parentCtx := ctx
+	if h.opts.SaveOnInterrupt && h.opts.RecordFile != "" {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		go func() {
+			// a second interrupt exits right away
+			<-ctx.Done()
+			stop()
+		}()
+	}
 	// Load history if record file is specified
 	var loadedHistory []types.Message
 	if h.opts.RecordFile != "" {
@@ -76,6 +122,13 @@ func (h *CliHandler) handleCliEnablingServer(ctx context.Context, message string
 		}
 	}
 
+	// show where a resumed session is in its plan
+	if !h.opts.JSONOutput {
+		if todos := LastTodos(loadedHistory); todos != nil {
+			fmt.Fprintf(os.Stderr, "Current plan:\n%s", FormatTodos(todos))
+		}
+	}
+
 	// Check for duplicate messages
 	message, stop, err := h.checkDuplicateMessage(message, loadedHistory)
 	if err != nil {
@@ -85,6 +138,9 @@ func (h *CliHandler) handleCliEnablingServer(ctx context.Context, message string
 		return nil
 	}
 
+	// shown while waiting for the model, set before the chat starts
+	var status *statusLine
+
 	// Create event callback for output formatting
 	var eventCallback types.EventCallback
 	if h.opts.JSONOutput {
@@ -102,7 +158,32 @@ func (h *CliHandler) handleCliEnablingServer(ctx context.Context, message string
 		}
 	} else {
 		eventCallback = func(event types.Message) {
+			if status == nil {
+				h.formatOutput(event)
+				return
+			}
+			shown := status.Pause()
 			h.formatOutput(event)
+			switch event.Type {
+			case types.MsgType_ToolResult:
+				// the next round is requested once tools finish
+				status.Resume()
+			case types.MsgType_TokenUsage:
+				status.NextRound()
+				if shown {
+					status.Resume()
+				}
+			}
+		}
+	}
+
+	var saver *autoSaver
+	if h.opts.RecordFile != "" && (h.opts.AutoSaveInterval > 0 || h.opts.SaveOnInterrupt) {
+		saver = newAutoSaver(h.opts.RecordFile, loadedHistory)
+		if h.opts.AutoSaveInterval > 0 {
+			saver.Start(h.opts.AutoSaveInterval, func(err error) {
+				fmt.Fprintf(os.Stderr, "auto save: %v\n", err)
+			})
 		}
 	}
 
@@ -112,14 +193,29 @@ func (h *CliHandler) handleCliEnablingServer(ctx context.Context, message string
 			if prev != nil {
 				prev(event)
 			}
-			if event.Type.IsFileRecordable() {
-				h.saveToRecord(event)
+			if event.Type.IsFileRecordable() && !event.IsPartial() {
+				if !h.opts.RecordLogProbs {
+					event.Metadata.LogProbs = nil
+				}
+				switch {
+				case saver == nil:
+					h.saveToRecord(event)
+				case h.opts.NoIncrementalRecord:
+					saver.Add(event)
+				default:
+					// the saver serializes the append with its rewrites
+					saver.Append(event)
+				}
 			}
 		}
 	}
 
 	// Prepare core options
-	allOpts := append(coreOpts, WithHistory(loadedHistory))
+	history := loadedHistory
+	if h.opts.HistoryLast > 0 {
+		history = LastTurns(loadedHistory, h.opts.HistoryLast)
+	}
+	allOpts := append(coreOpts, WithHistory(history))
 	allOpts = append(allOpts, WithEventCallback(eventCallback))
 
 	// Log chat start if enabled
@@ -127,7 +223,7 @@ func (h *CliHandler) handleCliEnablingServer(ctx context.Context, message string
 		eventCallback(types.Message{
 			Type:      types.MsgType_Info,
 			Content:   "Request...",
-			Timestamp: time.Now().Unix(),
+			Timestamp: types.Now().Unix(),
 		})
 	}
 	req := types.Request{
@@ -138,32 +234,69 @@ func (h *CliHandler) handleCliEnablingServer(ctx context.Context, message string
 		req.Model = h.client.config.Model
 		req.Token = h.client.config.Token
 		req.BaseURL = h.client.config.BaseURL
+		req.Headers = h.client.config.Headers
+		req.OpenAIOrganization = h.client.config.OpenAIOrganization
+		req.OpenAIProject = h.client.config.OpenAIProject
 	}
 
 	// Apply options
 	for _, opt := range allOpts {
 		opt(&req)
 	}
+	if h.opts.ConfirmFileChanges {
+		if err := ValidateConfirmFileChanges(req.ToolResolution, server != ""); err != nil {
+			return err
+		}
+		confirmer := &fileChangeConfirmer{
+			in:  bufio.NewReader(os.Stdin),
+			out: os.Stderr,
+			// stdin of a stream pair carries the protocol
+			interactive: terminal.IsStdinTTY() && req.StreamPair == nil,
+			policy:      h.opts.FileChangePolicy,
+		}
+		req.ToolCallback = confirmer.wrap(req.ToolCallback)
+	}
 
 	h.opts.StreamPair = req.StreamPair
-	return h.handleCliRequest(ctx, server, chatWithServer, req)
+	if h.showStatusLine() {
+		status = newStatusLine(os.Stderr)
+		defer status.Close()
+		status.Resume()
+	}
+	err = h.handleCliRequest(ctx, server, chatWithServer, req, status)
+	var saveErr error
+	if saver != nil {
+		saveErr = saver.Stop()
+	}
+	if err != nil && ctx.Err() != nil && parentCtx.Err() == nil {
+		if saveErr != nil {
+			return fmt.Errorf("interrupted, save session: %w", saveErr)
+		}
+		fmt.Fprintf(os.Stderr, "Interrupted, session saved to %s\n", h.opts.RecordFile)
+		return fmt.Errorf("interrupted")
+	}
+	if saveErr != nil && err == nil {
+		err = fmt.Errorf("auto save: %w", saveErr)
+	}
+	return err
 }
 
 func (h *CliHandler) handleCliRequest(ctx context.Context,
 	server string,
-	chatWithServer func(ctx context.Context, server string, req types.Request) (*types.Response, error), req types.Request) error {
+	chatWithServer func(ctx context.Context, server string, req types.Request) (*types.Response, error), req types.Request, status *statusLine) error {
 	var response *types.Response
 	var err error
+	// record user message
+	if req.EventCallback != nil && req.Message != "" && !req.EstimateOnly {
+		req.EventCallback(types.Message{
+			Type:         types.MsgType_Msg,
+			Role:         types.Role_User,
+			Content:      req.Message,
+			UserMetadata: req.UserMetadata,
+			Timestamp:    types.Now().Unix(),
+		})
+	}
 	if server != "" && chatWithServer != nil {
-		// record user message
-		if req.EventCallback != nil && req.Message != "" {
-			req.EventCallback(types.Message{
-				Type:      types.MsgType_Msg,
-				Role:      types.Role_User,
-				Content:   req.Message,
-				Timestamp: time.Now().Unix(),
-			})
-		}
 		systemPrompt := req.SystemPrompt
 		if systemPrompt != "" {
 			var err error
@@ -179,17 +312,69 @@ func (h *CliHandler) handleCliRequest(ctx context.Context,
 				cleanHistory = append(cleanHistory, msg)
 			}
 		}
+		// context files and the git diff are local to the client,
+		// so send their content as the last history message,
+		// which is right before the user message
+		contextMsg, err := readContextFiles(req.ContextFiles)
+		if err != nil {
+			return fmt.Errorf("read context files: %w", err)
+		}
+		if req.GitDiff {
+			diffMsg, err := readGitDiff(req.DefaultToolCwd, req.GitDiffRev)
+			if err != nil {
+				return fmt.Errorf("read git diff: %w", err)
+			}
+			if diffMsg != "" && contextMsg != "" {
+				contextMsg += "\n"
+			}
+			contextMsg += diffMsg
+		}
+		if contextMsg != "" {
+			cleanHistory = append(cleanHistory, types.Message{
+				Type:    types.MsgType_Msg,
+				Role:    types.Role_User,
+				Content: contextMsg,
+			})
+		}
 		cloneReq := req
 		cloneReq.SystemPrompt = systemPrompt
 		cloneReq.History = cleanHistory
+		cloneReq.ContextFiles = nil
+		cloneReq.GitDiff = false
+		cloneReq.GitDiffRev = ""
+		// documents are local too, send their content
+		if len(req.Documents) > 0 {
+			documents, err := loadDocuments(req.Documents)
+			if err != nil {
+				return err
+			}
+			for i := range documents {
+				documents[i].File = ""
+			}
+			cloneReq.Documents = documents
+		}
+		// the server cannot write to a local file
+		cloneReq.TraceFile = ""
+		cloneReq.AuditLog = ""
+		cloneReq.ToolCacheDir = ""
 		response, err = chatWithServer(ctx, server, cloneReq)
 	} else {
 		// Execute chat
 		response, err = h.client.ChatRequest(ctx, req)
 	}
+	if status != nil {
+		status.Pause()
+	}
 	if err != nil {
 		return fmt.Errorf("chat request: %w", err)
 	}
+	if response.Estimate != nil {
+		h.printEstimate(*response.Estimate)
+		return nil
+	}
+	if h.opts.JSONOutput && req.EventCallback != nil {
+		req.EventCallback(summaryEvent(response))
+	}
 
 	// Log token usage if enabled
 	if h.opts.LogChat && (!h.opts.JSONOutput && h.opts.StreamPair == nil) {
@@ -203,6 +388,42 @@ func (h *CliHandler) handleCliRequest(ctx context.Context,
 	return nil
 }
 
+// summaryEvent is the last event of the JSON output, so consumers
+// get the totals of the chat without aggregating the events
+func summaryEvent(response *types.Response) types.Message {
+	lastAssistantMsg := response.LastAssistantMsg
+	if lastAssistantMsg == "" {
+		for _, msg := range response.Messages {
+			if msg.Type == types.MsgType_Msg && msg.Role == types.Role_Assistant {
+				lastAssistantMsg = msg.Content
+			}
+		}
+	}
+	return types.Message{
+		Type: types.MsgType_Summary,
+		Metadata: types.Metadata{
+			Summary: &types.SummaryMetadata{
+				TokenUsage:       response.TokenUsage,
+				Cost:             response.Cost,
+				Rounds:           response.RoundsUsed,
+				NumToolCalls:     response.NumToolCalls,
+				StopReason:       response.StopReason,
+				LastAssistantMsg: lastAssistantMsg,
+			},
+		},
+		Timestamp: types.Now().Unix(),
+	}
+}
+
+// showStatusLine reports whether a status line is shown while waiting for the model,
+// only for plain output on a terminal, so nothing leaks into piped or JSON output
+func (h *CliHandler) showStatusLine() bool {
+	if h.opts.JSONOutput || h.opts.StreamPair != nil {
+		return false
+	}
+	return terminal.IsStdoutTerminal() && terminal.IsStderrTerminal()
+}
+
 // loadHistory loads historical messages from the record file
 func (h *CliHandler) loadHistory() ([]types.Message, error) {
 	return LoadHistory(h.opts.RecordFile)
@@ -263,14 +484,37 @@ func (h *CliHandler) formatOutput(event types.Message) {
 	event = event.TimeFilled()
 	switch event.Type {
 	case types.MsgType_Msg:
+		if event.Role == types.Role_User {
+			// typed by the user, not echoed
+			break
+		}
+		h.printRoundSeparator()
+		if h.opts.ShowRoles {
+			fmt.Printf("[%s %s] ", event.Role, event.Model)
+		}
 		// Print message content directly (streaming)
 		fmt.Println(event.Content)
+		if event.Metadata.Citations != nil {
+			for _, citation := range event.Metadata.Citations.Citations {
+				fmt.Printf("  [%s] %q\n", citationSource(citation), citation.CitedText)
+			}
+		}
 
 	case types.MsgType_ToolCall:
+		if event.IsPartial() {
+			fmt.Fprintf(os.Stderr, "\r<tool_call>%s: receiving arguments(%d bytes)...", event.ToolName, len(event.Content))
+			break
+		}
+		h.printRoundSeparator()
 		toolCallStr := fmt.Sprintf("<tool_call>%s(%s)</tool_call>", event.ToolName, event.Content)
 		fmt.Println(toolCallStr)
 
 	case types.MsgType_ToolResult:
+		h.roundEnded = true
+		if event.Metadata.Todos != nil {
+			fmt.Printf("Plan:\n%s", FormatTodos(event.Metadata.Todos))
+			break
+		}
 		toolResultStr := fmt.Sprintf("<tool_result>%s</tool_result>", event.Content)
 		fmt.Println(toolResultStr)
 
@@ -288,8 +532,42 @@ func (h *CliHandler) formatOutput(event types.Message) {
 	case types.MsgType_CacheInfo:
 		if h.opts.LogChat {
 			fmt.Println(event.Content)
+		} else if event.Metadata.CacheInfo != nil && event.Metadata.CacheInfo.PrefixChanged {
+			fmt.Fprintln(os.Stderr, event.Content)
+		}
+	}
+}
+
+// printRoundSeparator prints RoundSeparator before the first output of a new round
+func (h *CliHandler) printRoundSeparator() {
+	if !h.roundEnded {
+		return
+	}
+	h.roundEnded = false
+	if h.opts.RoundSeparator != "" {
+		fmt.Println(h.opts.RoundSeparator)
+	}
+}
+
+// printEstimate prints the input size of a request not sent
+func (h *CliHandler) printEstimate(estimate types.TokenEstimate) {
+	if h.opts.JSONOutput {
+		var stdout io.Writer = os.Stdout
+		if h.opts.StreamPair != nil {
+			stdout = h.opts.StreamPair.Output
 		}
+		json.NewEncoder(stdout).Encode(estimate)
+		return
+	}
+	tokens := fmt.Sprintf("%d", estimate.InputTokens)
+	if estimate.Approximate {
+		tokens = "~" + tokens
+	}
+	cost := "unknown"
+	if estimate.CostUSD != "" {
+		cost = "$" + estimate.CostUSD
 	}
+	fmt.Printf("Estimated input tokens: %s, input cost: %s\n", tokens, cost)
 }
 
 // printTokenUsage prints token usage information
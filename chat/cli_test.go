@@ -1,8 +1,16 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/xhd2015/kode-ai/types"
 )
@@ -262,6 +270,88 @@ func TestCLIHandlerFormatOutput(t *testing.T) {
 	}
 }
 
+func TestCLIHandlerShowRoles(t *testing.T) {
+	events := []types.Message{
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, Model: "gpt-4o", ToolName: "get_weather", Content: `{"city":"Tokyo"}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "get_weather", Content: `"sunny"`},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Model: "gpt-4o", Content: "It's sunny"},
+	}
+	format := func(opts CliOptions) string {
+		handler := NewCliHandler(nil, opts)
+		return captureStdout(t, func() {
+			for _, event := range events {
+				handler.formatOutput(event)
+			}
+		})
+	}
+
+	output := format(CliOptions{ShowRoles: true, RoundSeparator: "---"})
+	expected := "<tool_call>get_weather({\"city\":\"Tokyo\"})</tool_call>\n<tool_result>\"sunny\"</tool_result>\n---\n[assistant gpt-4o] It's sunny\n"
+	if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+
+	output = format(CliOptions{})
+	if strings.Contains(output, "[assistant") || strings.Contains(output, "---") {
+		t.Errorf("expected no role prefix or separator by default, got %q", output)
+	}
+}
+
+func TestCLIHandlerJSONSummary(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	handler := NewCliHandler(client, CliOptions{JSONOutput: true})
+	var handleErr error
+	output := captureStdout(t, func() {
+		handleErr = handler.HandleCli(context.Background(), "What's the weather in Tokyo?",
+			WithMaxRounds(3),
+			WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+				return types.ToolResult{Content: "sunny"}, true, nil
+			}),
+		)
+	})
+	if handleErr != nil {
+		t.Fatalf("handle cli: %v", handleErr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	var last types.Message
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("unmarshal last event: %v", err)
+	}
+	if last.Type != types.MsgType_Summary || last.Metadata.Summary == nil {
+		t.Fatalf("expected the summary as the last event, got %s", lines[len(lines)-1])
+	}
+	summary := last.Metadata.Summary
+	if summary.TokenUsage.Input != 30 || summary.TokenUsage.Output != 10 || summary.TokenUsage.Total != 40 {
+		t.Errorf("expected the total usage of both rounds, got %+v", summary.TokenUsage)
+	}
+	if summary.Cost == nil || summary.Cost.TotalUSD == "" {
+		t.Errorf("expected the cost, got %+v", summary.Cost)
+	}
+	if summary.Rounds != 2 || summary.NumToolCalls != 1 || summary.LastAssistantMsg != "Sunny" {
+		t.Errorf("expected 2 rounds, 1 tool call and the last answer, got %+v", summary)
+	}
+}
+
 func TestGetUsageString(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -298,3 +388,109 @@ func TestGetUsageString(t *testing.T) {
 		})
 	}
 }
+
+func TestCLIHandlerHistoryLast(t *testing.T) {
+	var body struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	recordFile := filepath.Join(t.TempDir(), "record.json")
+	history := []types.Message{{Type: types.MsgType_Msg, Role: types.Role_System, Content: "be brief"}}
+	for i := 1; i <= 5; i++ {
+		callID := fmt.Sprintf("call_%d", i)
+		history = append(history,
+			types.Message{Type: types.MsgType_Msg, Role: types.Role_User, Content: fmt.Sprintf("question %d", i)},
+			types.Message{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: callID, Content: `{}`},
+			types.Message{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: callID, Content: `{"files":[]}`},
+			types.Message{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: fmt.Sprintf("answer %d", i)},
+		)
+	}
+	if err := SaveHistory(recordFile, history); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewCliHandler(client, CliOptions{RecordFile: recordFile, HistoryLast: 2})
+	if err := handler.HandleCli(context.Background(), "question 6"); err != nil {
+		t.Fatalf("handle cli: %v", err)
+	}
+	var sent []string
+	for _, msg := range body.Messages {
+		if msg.Role == "system" || msg.Role == "user" {
+			sent = append(sent, msg.Content)
+		}
+	}
+	expected := []string{"be brief", "question 4", "question 5", "question 6"}
+	if strings.Join(sent, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected system and user messages %v, got %v", expected, sent)
+	}
+	// 2 turns of 4 messages and the new user msg, plus the system prompt
+	if len(body.Messages) != 10 {
+		t.Errorf("expected 10 messages sent, got %d", len(body.Messages))
+	}
+
+	recorded, err := LoadHistory(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) <= len(history) {
+		t.Errorf("expected the whole record kept and appended to, got %d messages", len(recorded))
+	}
+}
+
+func TestCLIHandlerJSONFrozenClock(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer server.Close()
+
+	frozen := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	defer types.SetClock(func() time.Time { return frozen })()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	handler := NewCliHandler(client, CliOptions{JSONOutput: true})
+	var handleErr error
+	output := captureStdout(t, func() {
+		handleErr = handler.HandleCli(context.Background(), "hello")
+	})
+	if handleErr != nil {
+		t.Fatalf("handle cli: %v", handleErr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for _, line := range lines {
+		var event types.Message
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		if event.Timestamp != frozen.Unix() || event.Time != "2025-01-02T03:04:05Z" {
+			t.Errorf("expected the frozen time filled, got %d %q of %s", event.Timestamp, event.Time, event.Type)
+		}
+	}
+}
@@ -3,14 +3,16 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	anth_opt "github.com/anthropics/anthropic-sdk-go/option"
@@ -34,8 +36,49 @@ type Client struct {
 	config   Config
 	apiShape providers.APIShape
 
-	stdinReader types.StdinReader
-	logger      types.Logger
+	logger types.Logger
+
+	// resources of requests in progress, released by Close
+	closeMutex sync.Mutex
+	closers    map[int]func() error
+	nextCloser int
+}
+
+// requestState is the state of one ChatRequest, kept off the Client
+// so that concurrent requests on the same Client do not share it
+type requestState struct {
+	*Client
+
+	stdinReader    types.StdinReader
+	toolResolution types.ToolResolution
+	sandbox        bool
+	toolTimeout    time.Duration
+	toolTimeouts   map[string]time.Duration
+	strictToolArgs bool
+	oncePerTool    bool
+	// malformed tool calls sent back to the model to retry, toolRetriesLeft counts down from maxToolRetries
+	maxToolRetries  int
+	toolRetriesLeft int
+	auditLog        *auditLog
+	toolCache       *toolCache
+	conversation    *conversationState
+}
+
+func (c *Client) newRequestState(req types.Request) *requestState {
+	return &requestState{
+		Client:          c,
+		toolResolution:  req.ToolResolution,
+		sandbox:         req.Sandbox,
+		toolTimeout:     req.ToolTimeout,
+		toolTimeouts:    req.ToolTimeouts,
+		strictToolArgs:  req.StrictToolArgs,
+		oncePerTool:     req.OncePerTool,
+		maxToolRetries:  req.MaxToolRetries,
+		toolRetriesLeft: req.MaxToolRetries,
+		auditLog:        newAuditLog(req.AuditLog),
+		toolCache:       newToolCache(req.ToolCacheDir),
+		conversation:    newConversationState(c.computeCost),
+	}
 }
 
 // NewClient creates a new chat client
@@ -46,6 +89,9 @@ func NewClient(config Config) (*Client, error) {
 	if config.Token == "" {
 		return nil, fmt.Errorf("token is required")
 	}
+	if err := config.LogRedact.Validate(); err != nil {
+		return nil, err
+	}
 
 	// Auto-detect API shape from model if not provided
 	apiShape, err := providers.GetModelAPIShape(config.Model)
@@ -83,17 +129,56 @@ func (c *Client) Chat(ctx context.Context, message string, opts ...types.ChatOpt
 
 // ChatRequest performs a chat conversation using a direct request
 func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Response, error) {
+	if err := req.OnMaxRounds.Validate(); err != nil {
+		return nil, err
+	}
+	if err := req.ToolResolution.Validate(); err != nil {
+		return nil, err
+	}
+	for _, setting := range req.SafetySettings {
+		if err := setting.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return c.newRequestState(req).chat(ctx, req)
+}
+
+// chat runs the rounds of req
+func (c *requestState) chat(ctx context.Context, req types.Request) (*types.Response, error) {
+	req.EventCallback = types.FilterEvents(req.EventCallback, req.EventFilter)
+
+	if req.EventSinkURL != "" {
+		sink := newEventSink(req.EventSinkURL, func(err error) {
+			c.logger.Log(ctx, types.LogType_Error, "event sink: %v", err)
+		})
+		defer sink.Close()
+		req.EventCallback = sink.Wrap(req.EventCallback)
+	}
+
 	// Create clients
-	clients, err := c.createClients(ctx)
+	clients, err := c.createClients(ctx, req.TraceFile)
 	if err != nil {
 		return nil, fmt.Errorf("create clients: %w", err)
 	}
+	defer c.track(clients.Close)()
 
 	// Prepare tools
 	toolInfoMapping, toolSchemas, err := c.prepareTools(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("prepare tools: %w", err)
 	}
+	defer c.track(toolInfoMapping.Close)()
+
+	toolChoice, err := resolveToolChoice(req.ToolChoice, toolInfoMapping)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveReasoningEffort(c.apiShape, c.config.Model, req.ReasoningEffort, toolChoice); err != nil {
+		return nil, err
+	}
+	if err := checkAssistantPrefill(c.config.Model, req.AssistantPrefill); err != nil {
+		return nil, err
+	}
 
 	// Convert tools to provider-specific formats
 	var toolsOpenAI []openai.ChatCompletionToolParam
@@ -123,12 +208,14 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 	var systemMessageOpenAI *openai.ChatCompletionMessageParamUnion
 	var systemAnthropic []anthropic.TextBlockParam
 	var systemMessageGemini *genai.Content
+	var systemPrompt string
 
 	if req.SystemPrompt != "" {
 		content, err := ioread.ReadOrContent(req.SystemPrompt)
 		if err != nil {
 			return nil, fmt.Errorf("read system prompt: %w", err)
 		}
+		systemPrompt = content
 
 		switch c.apiShape {
 		case providers.APIShapeOpenAI:
@@ -155,6 +242,7 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 		}
 	} else if len(systemPrompts) > 0 {
 		lastSystemPrompt := systemPrompts[len(systemPrompts)-1]
+		systemPrompt = lastSystemPrompt
 		switch c.apiShape {
 		case providers.APIShapeOpenAI:
 			systemMessageOpenAI = &openai.ChatCompletionMessageParamUnion{
@@ -207,11 +295,43 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 		return nil, fmt.Errorf("unsupported provider: %s", c.apiShape)
 	}
 
+	// Load context files
+	contextMsg, err := readContextFiles(req.ContextFiles)
+	if err != nil {
+		return nil, fmt.Errorf("read context files: %w", err)
+	}
+	if req.GitDiff {
+		diffMsg, err := readGitDiff(req.DefaultToolCwd, req.GitDiffRev)
+		if err != nil {
+			return nil, fmt.Errorf("read git diff: %w", err)
+		}
+		if diffMsg != "" && contextMsg != "" {
+			contextMsg += "\n"
+		}
+		contextMsg += diffMsg
+	}
+
+	var documents []types.Document
+	if len(req.Documents) > 0 {
+		if c.apiShape != providers.APIShapeAnthropic {
+			return nil, fmt.Errorf("documents are only supported by Anthropic, got %s", c.apiShape)
+		}
+		documents, err = loadDocuments(req.Documents)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Build messages
-	msgsUnion, err := c.buildMessages(req.Message, systemMessageOpenAI, historicalMessagesOpenAI, historicalMessagesAnthropic, historicalMessagesGemini)
+	msgsUnion, err := c.buildMessages(req.Message, contextMsg, documents, systemMessageOpenAI, historicalMessagesOpenAI, historicalMessagesAnthropic, historicalMessagesGemini)
 	if err != nil {
 		return nil, fmt.Errorf("build messages: %w", err)
 	}
+	// the prefill is only sent in the first round, then its reply includes it
+	prefill := req.AssistantPrefill
+	if prefill != "" {
+		msgsUnion.OpenAI = append(msgsUnion.OpenAI, prefillMessageOpenAI(prefill))
+	}
 
 	// Determine cache settings
 	needCache := !req.NoCache
@@ -225,22 +345,47 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 		stream = types.NewStreamContext(req.StreamPair.Output)
 	}
 
+	if req.EstimateOnly {
+		estimate, err := c.estimate(ctx, clients, estimateRequest{
+			messages:        msgsUnion,
+			toolsOpenAI:     toolsOpenAI,
+			systemAnthropic: systemAnthropic,
+			toolsAnthropic:  toolsAnthropic,
+			systemGemini:    systemMessageGemini,
+			toolsGemini:     toolsGemini,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("estimate: %w", err)
+		}
+		return &types.Response{Estimate: estimate}, nil
+	}
+
 	// Emit cache info event
 	if req.EventCallback != nil {
 		cacheStatus := "enabled"
 		if !needCache {
 			cacheStatus = "disabled"
 		}
+		content := fmt.Sprintf("Prompt cache %s with %s", cacheStatus, c.config.Model)
+
+		prefixHash := cachePrefixHash(systemPrompt, toolSchemas)
+		lastPrefixHash := lastCachePrefixHash(req.History)
+		prefixChanged := lastPrefixHash != "" && lastPrefixHash != prefixHash
+		if prefixChanged {
+			content += fmt.Sprintf(", warning: system prompt or tools changed since last run(prefix hash %s -> %s), prompt cache will miss", lastPrefixHash, prefixHash)
+		}
 		req.EventCallback(types.Message{
 			Type:    types.MsgType_CacheInfo,
 			Model:   c.config.Model,
-			Content: fmt.Sprintf("Prompt cache %s with %s", cacheStatus, c.config.Model),
+			Content: content,
 			Metadata: types.Metadata{
 				CacheInfo: &types.CacheInfoMetadata{
-					CacheEnabled: needCache,
+					CacheEnabled:  needCache,
+					PrefixHash:    prefixHash,
+					PrefixChanged: prefixChanged,
 				},
 			},
-			Timestamp: time.Now().Unix(),
+			Timestamp: types.Now().Unix(),
 		})
 	}
 
@@ -254,6 +399,16 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 	var totalTokenUsage types.TokenUsage
 	var allToolCalls []types.ToolCall
 	hasMaxRound := req.MaxRounds > 1
+	var stopReason string
+	var answer []string
+
+	// the empty response was nudged, only once per chat
+	var nudged bool
+	// one more round was run for messages injected in the last round
+	var injectedRound bool
+	var roundsUsed int
+	// the last round called tools, but no round is left to answer their results
+	var maxRoundsReached bool
 
 	var toolUseNum int
 	for _, msg := range req.History {
@@ -271,25 +426,46 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 			c.stdinReader = types.NewStdinReader(req.StreamPair.Input)
 		}
 	}
+	injectedUserMsgs := subscribeInjectedUserMsgs(c.stdinReader)
+	defer injectedUserMsgs.close()
 
 	for round := 0; round < maxRounds; round++ {
 		// Make API call
 		var tokenUsage types.TokenUsage
 		var newToolUseNum int
 		var stopped bool
+		roundToolCalls := len(allToolCalls)
+		roundMessages := len(allMessages)
+		roundsUsed++
+		c.conversation.startRound()
+		roundChoice := roundToolChoice(toolChoice, round)
 
 		switch c.apiShape {
 		case providers.APIShapeOpenAI:
 			result, err := clients.OpenAI.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-				Model:    c.config.Model,
-				Messages: msgsUnion.OpenAI,
-				Tools:    toolsOpenAI,
-				N:        param.NewOpt(int64(1)),
-			})
+				Model:           c.config.Model,
+				Messages:        msgsUnion.OpenAI,
+				Tools:           toolsOpenAI,
+				ToolChoice:      toolChoiceOpenAI(roundChoice),
+				N:               param.NewOpt(int64(1)),
+				Logprobs:        logProbsOpenAI(req.LogProbs),
+				TopLogprobs:     topLogProbsOpenAI(req.LogProbs, req.TopLogProbs),
+				ReasoningEffort: reasoningEffortOpenAI(req.ReasoningEffort),
+				Prediction:      predictionOpenAI(req.PredictedOutput),
+				Seed:            seedOpenAI(req.Seed),
+			}, promptCacheKeyOpenAI(needCache, req.PromptCacheKey)...)
 			if err != nil {
-				return nil, fmt.Errorf("OpenAI API call: %w", err)
+				return nil, c.newChatError(fmt.Errorf("OpenAI API call: %w", err))
+			}
+			if prefill != "" {
+				msgsUnion.OpenAI = msgsUnion.OpenAI[:len(msgsUnion.OpenAI)-1]
+				if len(result.Choices) > 0 {
+					result.Choices[0].Message.Content = prefill + result.Choices[0].Message.Content
+				}
+				prefill = ""
 			}
 
+			c.conversation.respond(tokenUsageOpenAI(result.Usage))
 			res, err := c.processOpenAIResponse(ctx, stream, result, hasMaxRound, req, toolInfoMapping)
 			if err != nil {
 				return nil, fmt.Errorf("process OpenAI response: %w", err)
@@ -308,20 +484,27 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 			if needCache {
 				sendMessage = anthropic_helper.MarkMsgsEphemeralCache(msgsUnion.Anthropic)
 			}
-			result, err := anthropic_helper.Stream(ctx, clients.Anthropic, anthropic.MessageNewParams{
+			var onEvent func(event anthropic.MessageStreamEventUnion)
+			if req.StreamToolArgs && req.EventCallback != nil {
+				onEvent = newToolArgsPreview(c.config.Model, req.EventCallback).onAnthropicEvent
+			}
+			result, err := anthropic_helper.StreamWithCallback(ctx, clients.Anthropic, anthropic.MessageNewParams{
 				// without streaming
 				// if MaxTokens > 20K:  anthropic API call: streaming is strongly recommended for operations that may take longer than 10 minutes
 				// with streaming, whatever
-				MaxTokens: 20 * 1024, // according to Anthropic, max for 4.5 is 64K, this effectively disables the limit
-				Model:     anthropic.Model(c.config.Model),
-				Messages:  sendMessage,
-				System:    systemAnthropic,
-				Tools:     toolsAnthropic,
-			})
+				MaxTokens:  20 * 1024, // according to Anthropic, max for 4.5 is 64K, this effectively disables the limit
+				Model:      anthropic.Model(c.config.Model),
+				Messages:   sendMessage,
+				System:     systemAnthropic,
+				Tools:      toolsAnthropic,
+				ToolChoice: toolChoiceAnthropic(roundChoice),
+				Thinking:   thinkingAnthropic(req.ReasoningEffort),
+			}, onEvent)
 			if err != nil {
-				return nil, fmt.Errorf("anthropic API call: %w", err)
+				return nil, c.newChatError(fmt.Errorf("anthropic API call: %w", err))
 			}
 
+			c.conversation.respond(tokenUsageAnthropic(result.Usage))
 			res, err := c.processAnthropicResponse(ctx, stream, result, hasMaxRound, req, toolInfoMapping)
 			if err != nil {
 				return nil, fmt.Errorf("process Anthropic response: %w", err)
@@ -355,12 +538,16 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 				},
 				SystemInstruction: systemMessageGemini,
 				Tools:             toolsGemini,
+				ToolConfig:        toolConfigGemini(roundChoice),
+				ThinkingConfig:    thinkingConfigGemini(req.ReasoningEffort),
+				SafetySettings:    safetySettingsGemini(req.SafetySettings),
 				CandidateCount:    1,
 			})
 			if err != nil {
-				return nil, fmt.Errorf("Gemini API call: %w", err)
+				return nil, c.newChatError(fmt.Errorf("Gemini API call: %w", err))
 			}
 
+			c.conversation.respond(tokenUsageGemini(result.UsageMetadata))
 			res, err := c.processGeminiResponse(ctx, stream, result, toolUseNum, hasMaxRound, req, toolInfoMapping)
 			if err != nil {
 				return nil, fmt.Errorf("process Gemini response: %w", err)
@@ -383,14 +570,68 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 		}
 
 		totalTokenUsage = totalTokenUsage.Add(tokenUsage)
+		c.conversation.endRound(tokenUsage)
 		if req.EventCallback != nil {
 			req.EventCallback(types.Message{
 				Type:       types.MsgType_TokenUsage,
+				Model:      c.config.Model,
 				TokenUsage: &tokenUsage,
 			})
 		}
 
 		toolUseNum += newToolUseNum
+		if req.StopOnSendAnswer {
+			if sendAnswer, ok := findSendAnswer(allToolCalls[roundToolCalls:]); ok {
+				stopReason = types.StopReason_SendAnswer
+				answer = sendAnswer
+				if req.EventCallback != nil {
+					req.EventCallback(types.Message{
+						Type:    types.MsgType_StopReason,
+						Content: stopReason,
+					})
+				}
+				break
+			}
+		}
+		if req.NudgeOnEmpty && !nudged && newToolUseNum == 0 && isEmptyResponse(allMessages[roundMessages:]) {
+			nudged = true
+			// the nudge gets its own round
+			maxRounds++
+			nudge := CreateMessage(types.MsgType_Msg, types.Role_User, c.config.Model, nudgeMessage)
+			if err := addToMsgUnion(c.apiShape, msgsUnion, nudge); err != nil {
+				return nil, fmt.Errorf("append nudge: %w", err)
+			}
+			allMessages = append(allMessages, nudge)
+			if req.EventCallback != nil {
+				req.EventCallback(nudge)
+			}
+			continue
+		}
+		injected := injectedUserMsgs.take()
+		if len(injected) > 0 && round+1 >= maxRounds {
+			// injected in the last round they get one more round like the nudge,
+			// only once per chat
+			if injectedRound {
+				reportUnprocessedInjectedMsgs(req, injected)
+				injected = nil
+			} else {
+				injectedRound = true
+				maxRounds++
+			}
+		}
+		if len(injected) > 0 {
+			// the next round answers them, along with the tool results if any
+			for _, msg := range injected {
+				if err := addToMsgUnion(c.apiShape, msgsUnion, msg); err != nil {
+					return nil, fmt.Errorf("append injected message: %w", err)
+				}
+				allMessages = append(allMessages, msg)
+				if req.EventCallback != nil {
+					req.EventCallback(msg)
+				}
+			}
+			continue
+		}
 		if stopped || newToolUseNum == 0 {
 			// no more tool calls, stop
 			// check if stream pair allow asking for user input
@@ -422,6 +663,12 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 			}
 			break
 		}
+		maxRoundsReached = round+1 >= maxRounds
+	}
+	reportUnprocessedInjectedMsgs(req, injectedUserMsgs.take())
+	if maxRoundsReached {
+		stopReason = types.StopReason_MaxRounds
+		allMessages = append(allMessages, c.endAtMaxRounds(req, maxRounds)...)
 	}
 
 	// Compute cost if possible
@@ -431,9 +678,13 @@ func (c *Client) ChatRequest(ctx context.Context, req types.Request) (*types.Res
 	}
 
 	return &types.Response{
-		TokenUsage: totalTokenUsage,
-		Cost:       cost,
-		RoundsUsed: len(allMessages), // TODO: should be the number of rounds used
+		TokenUsage:   totalTokenUsage,
+		Cost:         cost,
+		RoundsUsed:   roundsUsed,
+		NumToolCalls: len(allToolCalls),
+		StopReason:   stopReason,
+		Answer:       answer,
+		Messages:     allMessages,
 	}, nil
 }
 
@@ -441,7 +692,7 @@ func addToMsgUnion(apiShape providers.APIShape, msgsUnion *MessagesUnion, msg ty
 	msgs := Messages{msg}
 	switch apiShape {
 	case providers.APIShapeOpenAI:
-		providerMsgs, _, err := msgs.ToOpenAI(false)
+		providerMsgs, _, err := msgs.toOpenAI(false)
 		if err != nil {
 			return fmt.Errorf("convert message to OpenAI format: %w", err)
 		}
@@ -450,7 +701,7 @@ func addToMsgUnion(apiShape providers.APIShape, msgsUnion *MessagesUnion, msg ty
 		}
 		msgsUnion.OpenAI = append(msgsUnion.OpenAI, providerMsgs...)
 	case providers.APIShapeAnthropic:
-		providerMsgs, _, err := msgs.ToAnthropic()
+		providerMsgs, _, err := msgs.toAnthropic()
 		if err != nil {
 			return fmt.Errorf("convert message to Anthropic format: %w", err)
 		}
@@ -459,7 +710,7 @@ func addToMsgUnion(apiShape providers.APIShape, msgsUnion *MessagesUnion, msg ty
 		}
 		msgsUnion.Anthropic = append(msgsUnion.Anthropic, providerMsgs...)
 	case providers.APIShapeGemini:
-		providerMsgs, _, err := msgs.ToGemini()
+		providerMsgs, _, err := msgs.toGemini()
 		if err != nil {
 			return fmt.Errorf("convert message to Gemini format: %w", err)
 		}
@@ -504,17 +755,38 @@ type GeminiResponseResult struct {
 	ToolResults  []*genai.Content
 }
 
+// MAX_PRINT_LIMIT is the default Config.PrintLimit
 const MAX_PRINT_LIMIT = 2048
 
-func limitPrintLength(s string) string {
-	if len(s) < MAX_PRINT_LIMIT+3 {
+// limitPrintLength truncates s for display in events with the
+// configured limit, what is sent to the model is never truncated
+func (c *Client) limitPrintLength(s string) string {
+	limit := c.config.PrintLimit
+	if limit == 0 {
+		limit = MAX_PRINT_LIMIT
+	}
+	return LimitPrintLength(s, limit)
+}
+
+// LimitPrintLength truncates s to at most limit bytes on a rune boundary,
+// preferring a line boundary if it does not drop more than half of the kept
+// content, and appends "... (N more bytes)". limit <= 0 disables truncation
+func LimitPrintLength(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
 		return s
 	}
-	return s[:MAX_PRINT_LIMIT] + "..."
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if idx := strings.LastIndexByte(s[:cut], '\n'); idx > 0 && idx >= cut/2 {
+		cut = idx
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", s[:cut], len(s)-cut)
 }
 
 // processOpenAIResponse processes OpenAI API response
-func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamContext, result *openai.ChatCompletion, hasMaxRound bool, req types.Request, toolInfoMapping ToolInfoMapping) (*ResponseResult, error) {
+func (c *requestState) processOpenAIResponse(ctx context.Context, stream types.StreamContext, result *openai.ChatCompletion, hasMaxRound bool, req types.Request, toolInfoMapping ToolInfoMapping) (*ResponseResult, error) {
 	if len(result.Choices) == 0 {
 		return nil, fmt.Errorf("response no choices")
 	}
@@ -524,6 +796,8 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 	var toolCalls []types.ToolCall
 	var respMessages []openai.ChatCompletionMessageParamUnion
 	var toolResults []openai.ChatCompletionMessageParamUnion
+	// usage of summarizing large tool results
+	var compactTokenUsage types.TokenUsage
 
 	// Handle main content
 	if firstChoice.Message.Content != "" {
@@ -534,18 +808,14 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 				Content:   firstChoice.Message.Content,
 				Role:      types.Role_Assistant,
 				Model:     c.config.Model,
-				Timestamp: time.Now().Unix(),
+				Timestamp: types.Now().Unix(),
+				Metadata: types.Metadata{
+					LogProbs:    logProbsMetadataOpenAI(firstChoice.Logprobs),
+					Fingerprint: fingerprintMetadata(req.Seed, result.SystemFingerprint),
+				},
 			})
 		}
 
-		respMessages = append(respMessages, openai.ChatCompletionMessageParamUnion{
-			OfAssistant: &openai.ChatCompletionAssistantMessageParam{
-				Content: openai.ChatCompletionAssistantMessageParamContentUnion{
-					OfString: param.NewOpt(firstChoice.Message.Content),
-				},
-			},
-		})
-
 		messages = append(messages, CreateMessage(types.MsgType_Msg, types.Role_Assistant, c.config.Model, firstChoice.Message.Content))
 	}
 
@@ -554,7 +824,7 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 	for _, toolCall := range firstChoice.Message.ToolCalls {
 		toolUseNum++
 
-		call, err := parseToolCall(toolCall.Function.Name, toolCall.ID, toolCall.Function.Arguments, req.DefaultToolCwd)
+		call, malformed, err := c.checkToolCall(toolCall.Function.Name, toolCall.ID, toolCall.Function.Arguments, req.DefaultToolCwd, toolInfoMapping)
 		if err != nil {
 			return nil, fmt.Errorf("parse tool call: %w", err)
 		}
@@ -569,7 +839,11 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 				ToolName:  toolCall.Function.Name,
 				Model:     c.config.Model,
 				Role:      types.Role_Assistant,
-				Timestamp: time.Now().Unix(),
+				Timestamp: types.Now().Unix(),
+				Metadata: types.Metadata{
+					ToolCall:    toolCallMetadata(call),
+					Fingerprint: fingerprintMetadata(req.Seed, result.SystemFingerprint),
+				},
 			})
 		}
 
@@ -588,9 +862,15 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 		if req.StreamPair != nil {
 			stdout = req.StreamPair.Output
 		}
-		result, err := c.executeToolWithCallback(ctx, stream, call, req.ToolCallback, req.EventCallback, stdout, req.DefaultToolCwd, toolInfoMapping)
-		if err != nil {
-			return nil, fmt.Errorf("execute tool: %w", err)
+		result := types.ToolResult{Error: malformed}
+		if malformed == "" {
+			result, err = c.executeToolWithCallback(ctx, stream, call, req.ToolCallback, req.EventCallback, stdout, req.DefaultToolCwd, toolInfoMapping)
+			if err != nil {
+				return nil, fmt.Errorf("execute tool: %w", err)
+			}
+		}
+		if result.Error != "" && req.AbortOnToolError {
+			return nil, &ToolError{ToolName: call.Name, ToolUseID: call.ID, Err: result.Error}
 		}
 
 		var resultStr string
@@ -609,20 +889,25 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 		if req.EventCallback != nil {
 			req.EventCallback(types.Message{
 				Type:      types.MsgType_ToolResult,
-				Content:   limitPrintLength(resultStr),
+				Content:   c.limitPrintLength(resultStr),
 				ToolUseID: toolCall.ID,
 				ToolName:  toolCall.Function.Name,
 				Model:     c.config.Model,
 				Role:      types.Role_User,
-				Timestamp: time.Now().Unix(),
+				Timestamp: types.Now().Unix(),
+				Metadata: types.Metadata{
+					Todos: todosMetadata(toolCall.Function.Name, resultStr),
+				},
 			})
 		}
 
+		sentResult, _, compactUsage := c.compactToolResult(ctx, req, call, result, resultStr)
+		compactTokenUsage = compactTokenUsage.Add(compactUsage)
 		toolResults = append(toolResults, openai.ChatCompletionMessageParamUnion{
 			OfTool: &openai.ChatCompletionToolMessageParam{
 				ToolCallID: toolCall.ID,
 				Content: openai.ChatCompletionToolMessageParamContentUnion{
-					OfString: param.NewOpt(resultStr),
+					OfString: param.NewOpt(sentResult),
 				},
 			},
 		})
@@ -630,12 +915,15 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 		messages = append(messages, CreateToolResultMessage(types.Role_User, c.config.Model, toolCall.Function.Name, toolCall.ID, resultStr))
 	}
 
-	if len(recordToolCalls) > 0 {
-		respMessages = append(respMessages, openai.ChatCompletionMessageParamUnion{
-			OfAssistant: &openai.ChatCompletionAssistantMessageParam{
-				ToolCalls: recordToolCalls,
-			},
-		})
+	// one assistant message with the content and all tool calls, which the tool results follow
+	if firstChoice.Message.Content != "" || len(recordToolCalls) > 0 {
+		assistant := &openai.ChatCompletionAssistantMessageParam{
+			ToolCalls: recordToolCalls,
+		}
+		if firstChoice.Message.Content != "" {
+			assistant.Content.OfString = param.NewOpt(firstChoice.Message.Content)
+		}
+		respMessages = append(respMessages, openai.ChatCompletionMessageParamUnion{OfAssistant: assistant})
 	}
 
 	return &ResponseResult{
@@ -645,25 +933,80 @@ func (c *Client) processOpenAIResponse(ctx context.Context, stream types.StreamC
 		RespMessages: respMessages,
 		ToolResults:  toolResults,
 		Stopped:      firstChoice.FinishReason == "stop",
-		TokenUsage: types.TokenUsage{
-			Input:  result.Usage.PromptTokens,
-			Output: result.Usage.CompletionTokens,
-			Total:  result.Usage.TotalTokens,
-			InputBreakdown: types.TokenUsageInputBreakdown{
-				CacheRead:    result.Usage.PromptTokensDetails.CachedTokens,
-				NonCacheRead: result.Usage.PromptTokens - result.Usage.PromptTokensDetails.CachedTokens,
-			},
-		},
+		TokenUsage:   compactTokenUsage.Add(tokenUsageOpenAI(result.Usage)),
 	}, nil
 }
 
+func tokenUsageOpenAI(usage openai.CompletionUsage) types.TokenUsage {
+	return types.TokenUsage{
+		Input:  usage.PromptTokens,
+		Output: usage.CompletionTokens,
+		Total:  usage.TotalTokens,
+		InputBreakdown: types.TokenUsageInputBreakdown{
+			CacheRead:    usage.PromptTokensDetails.CachedTokens,
+			NonCacheRead: usage.PromptTokens - usage.PromptTokensDetails.CachedTokens,
+		},
+	}
+}
+
+// anthropicToolResultContent maps a multi-part tool result to separate content blocks,
+// otherwise a single text block of resultStr
+func anthropicToolResultContent(toolResult types.ToolResult, resultStr string) []anthropic.ToolResultBlockParamContentUnion {
+	parts, ok := toolResult.Content.([]types.ToolResultPart)
+	if !ok || len(parts) == 0 || toolResult.Error != "" {
+		return []anthropic.ToolResultBlockParamContentUnion{
+			{
+				OfText: &anthropic.TextBlockParam{
+					Text: resultStr,
+				},
+			},
+		}
+	}
+	contents := make([]anthropic.ToolResultBlockParamContentUnion, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case types.ToolResultPartType_Image:
+			contents = append(contents, anthropic.ToolResultBlockParamContentUnion{
+				OfImage: &anthropic.ImageBlockParam{
+					Source: anthropic.ImageBlockParamSourceUnion{
+						OfBase64: &anthropic.Base64ImageSourceParam{
+							Data:      part.Base64,
+							MediaType: anthropic.Base64ImageSourceMediaType(part.MediaType),
+						},
+					},
+				},
+			})
+		case types.ToolResultPartType_JSON:
+			data, err := json.Marshal(part.Data)
+			text := string(data)
+			if err != nil {
+				text = fmt.Sprintf("Error marshaling result: %v", err)
+			}
+			contents = append(contents, anthropic.ToolResultBlockParamContentUnion{
+				OfText: &anthropic.TextBlockParam{
+					Text: text,
+				},
+			})
+		default:
+			contents = append(contents, anthropic.ToolResultBlockParamContentUnion{
+				OfText: &anthropic.TextBlockParam{
+					Text: part.Text,
+				},
+			})
+		}
+	}
+	return contents
+}
+
 // processAnthropicResponse processes Anthropic API response
-func (c *Client) processAnthropicResponse(ctx context.Context, stream types.StreamContext, result *anthropic.Message, hasMaxRound bool, req types.Request, toolInfoMapping ToolInfoMapping) (*AnthropicResponseResult, error) {
+func (c *requestState) processAnthropicResponse(ctx context.Context, stream types.StreamContext, result *anthropic.Message, hasMaxRound bool, req types.Request, toolInfoMapping ToolInfoMapping) (*AnthropicResponseResult, error) {
 	var toolUseNum int
 	var messages []types.Message
 	var toolCalls []types.ToolCall
 	var respContents []anthropic.ContentBlockParamUnion
 	var toolResults []anthropic.ContentBlockParamUnion
+	// usage of summarizing large tool results
+	var compactTokenUsage types.TokenUsage
 
 	for _, msg := range result.Content {
 		switch msg.Type {
@@ -676,7 +1019,11 @@ func (c *Client) processAnthropicResponse(ctx context.Context, stream types.Stre
 					Type:      types.MsgType_Msg,
 					Role:      types.Role_Assistant,
 					Content:   txt.Text,
-					Timestamp: time.Now().Unix(),
+					Model:     c.config.Model,
+					Timestamp: types.Now().Unix(),
+					Metadata: types.Metadata{
+						Citations: citationsMetadataAnthropic(txt.Citations),
+					},
 				})
 			}
 
@@ -688,11 +1035,18 @@ func (c *Client) processAnthropicResponse(ctx context.Context, stream types.Stre
 
 			messages = append(messages, CreateMessage(types.MsgType_Msg, types.Role_Assistant, c.config.Model, txt.Text))
 
+		// thinking blocks must be sent back unmodified along with tool results
+		case "thinking":
+			thinking := msg.AsThinking()
+			respContents = append(respContents, anthropic.NewThinkingBlock(thinking.Signature, thinking.Thinking))
+		case "redacted_thinking":
+			respContents = append(respContents, anthropic.NewRedactedThinkingBlock(msg.AsRedactedThinking().Data))
+
 		case "tool_use":
 			toolUseNum++
 			toolUse := msg.AsToolUse()
 
-			call, err := parseToolCall(toolUse.Name, toolUse.ID, string(toolUse.Input), req.DefaultToolCwd)
+			call, malformed, err := c.checkToolCall(toolUse.Name, toolUse.ID, string(toolUse.Input), req.DefaultToolCwd, toolInfoMapping)
 			if err != nil {
 				return nil, fmt.Errorf("parse tool call: %w", err)
 			}
@@ -705,13 +1059,20 @@ func (c *Client) processAnthropicResponse(ctx context.Context, stream types.Stre
 					Content:   string(toolUse.Input),
 					Model:     c.config.Model,
 					Role:      types.Role_Assistant,
-					Timestamp: time.Now().Unix(),
+					Timestamp: types.Now().Unix(),
 					ToolUseID: toolUse.ID,
 					ToolName:  toolUse.Name,
+					Metadata: types.Metadata{
+						ToolCall: toolCallMetadata(call),
+					},
 				})
 			}
 
 			input := json.RawMessage(toolUse.Input)
+			if !json.Valid(input) {
+				// the malformed input cannot be sent back as history
+				input = json.RawMessage("{}")
+			}
 			respContents = append(respContents, anthropic.ContentBlockParamUnion{
 				OfToolUse: &anthropic.ToolUseBlockParam{
 					ID:    toolUse.ID,
@@ -727,9 +1088,15 @@ func (c *Client) processAnthropicResponse(ctx context.Context, stream types.Stre
 			if req.StreamPair != nil {
 				stdout = req.StreamPair.Output
 			}
-			toolResult, err := c.executeToolWithCallback(ctx, stream, call, req.ToolCallback, req.EventCallback, stdout, req.DefaultToolCwd, toolInfoMapping)
-			if err != nil {
-				return nil, fmt.Errorf("execute tool: %w", err)
+			toolResult := types.ToolResult{Error: malformed}
+			if malformed == "" {
+				toolResult, err = c.executeToolWithCallback(ctx, stream, call, req.ToolCallback, req.EventCallback, stdout, req.DefaultToolCwd, toolInfoMapping)
+				if err != nil {
+					return nil, fmt.Errorf("execute tool: %w", err)
+				}
+			}
+			if toolResult.Error != "" && req.AbortOnToolError {
+				return nil, &ToolError{ToolName: call.Name, ToolUseID: call.ID, Err: toolResult.Error}
 			}
 
 			var resultStr string
@@ -748,25 +1115,28 @@ func (c *Client) processAnthropicResponse(ctx context.Context, stream types.Stre
 			if req.EventCallback != nil {
 				req.EventCallback(types.Message{
 					Type:      types.MsgType_ToolResult,
-					Content:   limitPrintLength(resultStr),
+					Content:   c.limitPrintLength(resultStr),
 					Model:     c.config.Model,
 					Role:      types.Role_User,
-					Timestamp: time.Now().Unix(),
+					Timestamp: types.Now().Unix(),
 					ToolUseID: toolUse.ID,
 					ToolName:  toolUse.Name,
+					Metadata: types.Metadata{
+						Todos: todosMetadata(toolUse.Name, resultStr),
+					},
 				})
 			}
 
+			sentResult, compacted, compactUsage := c.compactToolResult(ctx, req, call, toolResult, resultStr)
+			compactTokenUsage = compactTokenUsage.Add(compactUsage)
+			sentToolResult := toolResult
+			if compacted {
+				sentToolResult = types.ToolResult{}
+			}
 			toolResults = append(toolResults, anthropic.ContentBlockParamUnion{
 				OfToolResult: &anthropic.ToolResultBlockParam{
 					ToolUseID: toolUse.ID,
-					Content: []anthropic.ToolResultBlockParamContentUnion{
-						{
-							OfText: &anthropic.TextBlockParam{
-								Text: resultStr,
-							},
-						},
-					},
+					Content:   anthropicToolResultContent(sentToolResult, sentResult),
 				},
 			})
 
@@ -777,7 +1147,6 @@ func (c *Client) processAnthropicResponse(ctx context.Context, stream types.Stre
 		}
 	}
 
-	totalInput := result.Usage.InputTokens + result.Usage.CacheCreationInputTokens + result.Usage.CacheReadInputTokens
 	return &AnthropicResponseResult{
 		Messages:     messages,
 		ToolCalls:    toolCalls,
@@ -785,26 +1154,33 @@ func (c *Client) processAnthropicResponse(ctx context.Context, stream types.Stre
 		RespMessages: respContents,
 		ToolResults:  toolResults,
 		Stopped:      result.StopReason == "end_turn",
-		TokenUsage: types.TokenUsage{
-			Input:  totalInput,
-			Output: result.Usage.OutputTokens,
-			Total:  totalInput + result.Usage.OutputTokens,
-			InputBreakdown: types.TokenUsageInputBreakdown{
-				CacheWrite:   result.Usage.CacheCreationInputTokens,
-				CacheRead:    result.Usage.CacheReadInputTokens,
-				NonCacheRead: result.Usage.InputTokens,
-			},
-		},
+		TokenUsage:   compactTokenUsage.Add(tokenUsageAnthropic(result.Usage)),
 	}, nil
 }
 
+func tokenUsageAnthropic(usage anthropic.Usage) types.TokenUsage {
+	totalInput := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+	return types.TokenUsage{
+		Input:  totalInput,
+		Output: usage.OutputTokens,
+		Total:  totalInput + usage.OutputTokens,
+		InputBreakdown: types.TokenUsageInputBreakdown{
+			CacheWrite:   usage.CacheCreationInputTokens,
+			CacheRead:    usage.CacheReadInputTokens,
+			NonCacheRead: usage.InputTokens,
+		},
+	}
+}
+
 // processGeminiResponse processes Gemini API response
-func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamContext, result *genai.GenerateContentResponse, toolUsedNum int, hasMaxRound bool, req types.Request, toolInfoMapping ToolInfoMapping) (*GeminiResponseResult, error) {
+func (c *requestState) processGeminiResponse(ctx context.Context, stream types.StreamContext, result *genai.GenerateContentResponse, toolUsedNum int, hasMaxRound bool, req types.Request, toolInfoMapping ToolInfoMapping) (*GeminiResponseResult, error) {
 	var toolUseNum int
 	var messages []types.Message
 	var toolCalls []types.ToolCall
 	var respContents []*genai.Content
 	var toolResults []*genai.Content
+	// usage of summarizing large tool results
+	var compactTokenUsage types.TokenUsage
 
 	if len(result.Candidates) == 0 {
 		return nil, fmt.Errorf("empty result candidates")
@@ -826,7 +1202,7 @@ func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamC
 			}
 			argsJSONStr := string(argsJSON)
 
-			call, err := parseToolCall(toolUse.Name, toolRecordID, argsJSONStr, req.DefaultToolCwd)
+			call, malformed, err := c.checkToolCall(toolUse.Name, toolRecordID, argsJSONStr, req.DefaultToolCwd, toolInfoMapping)
 			if err != nil {
 				return nil, fmt.Errorf("parse tool call: %w", err)
 			}
@@ -838,10 +1214,13 @@ func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamC
 					Type:      types.MsgType_ToolCall,
 					Content:   argsJSONStr,
 					Model:     c.config.Model,
-					Timestamp: time.Now().Unix(),
+					Timestamp: types.Now().Unix(),
 					Role:      types.Role_Assistant,
 					ToolUseID: toolUse.ID,
 					ToolName:  toolUse.Name,
+					Metadata: types.Metadata{
+						ToolCall: toolCallMetadata(call),
+					},
 				})
 			}
 
@@ -862,9 +1241,15 @@ func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamC
 			if req.StreamPair != nil {
 				stdout = req.StreamPair.Output
 			}
-			toolResult, err := c.executeToolWithCallback(ctx, stream, call, req.ToolCallback, req.EventCallback, stdout, req.DefaultToolCwd, toolInfoMapping)
-			if err != nil {
-				return nil, fmt.Errorf("execute tool: %w", err)
+			toolResult := types.ToolResult{Error: malformed}
+			if malformed == "" {
+				toolResult, err = c.executeToolWithCallback(ctx, stream, call, req.ToolCallback, req.EventCallback, stdout, req.DefaultToolCwd, toolInfoMapping)
+				if err != nil {
+					return nil, fmt.Errorf("execute tool: %w", err)
+				}
+			}
+			if toolResult.Error != "" && req.AbortOnToolError {
+				return nil, &ToolError{ToolName: call.Name, ToolUseID: call.ID, Err: toolResult.Error}
 			}
 
 			var resultStr string
@@ -883,19 +1268,28 @@ func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamC
 			if req.EventCallback != nil {
 				req.EventCallback(types.Message{
 					Type:      types.MsgType_ToolResult,
-					Content:   limitPrintLength(resultStr),
+					Content:   c.limitPrintLength(resultStr),
 					Model:     c.config.Model,
 					Role:      types.Role_User,
-					Timestamp: time.Now().Unix(),
+					Timestamp: types.Now().Unix(),
 					ToolUseID: toolUse.ID,
 					ToolName:  toolUse.Name,
+					Metadata: types.Metadata{
+						Todos: todosMetadata(toolUse.Name, resultStr),
+					},
 				})
 			}
 
 			var response map[string]any
-			err = jsondecode.UnmarshalSafe([]byte(resultStr), &response)
-			if err != nil {
-				return nil, fmt.Errorf("unmarshal tool result: %w", err)
+			sentResult, compacted, compactUsage := c.compactToolResult(ctx, req, call, toolResult, resultStr)
+			compactTokenUsage = compactTokenUsage.Add(compactUsage)
+			if compacted {
+				response = map[string]any{"summary": sentResult}
+			} else {
+				err = jsondecode.UnmarshalSafe([]byte(resultStr), &response)
+				if err != nil {
+					return nil, fmt.Errorf("unmarshal tool result: %w", err)
+				}
 			}
 
 			toolResults = append(toolResults, &genai.Content{
@@ -922,7 +1316,8 @@ func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamC
 					Type:      types.MsgType_Msg,
 					Content:   txt,
 					Role:      types.Role_Assistant,
-					Timestamp: time.Now().Unix(),
+					Model:     c.config.Model,
+					Timestamp: types.Now().Unix(),
 				})
 			}
 
@@ -939,25 +1334,6 @@ func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamC
 		}
 	}
 
-	var tokenUsage types.TokenUsage
-	if result.UsageMetadata != nil {
-		usage := result.UsageMetadata
-
-		inputToken := usage.PromptTokenCount + usage.ToolUsePromptTokenCount
-		outputToken := usage.CandidatesTokenCount
-		cacheRead := usage.CachedContentTokenCount
-
-		tokenUsage = types.TokenUsage{
-			Input:  int64(inputToken),
-			Output: int64(outputToken),
-			Total:  int64(usage.TotalTokenCount),
-			InputBreakdown: types.TokenUsageInputBreakdown{
-				CacheRead:    int64(cacheRead),
-				NonCacheRead: int64(inputToken - cacheRead),
-			},
-		}
-	}
-
 	return &GeminiResponseResult{
 		Messages:     messages,
 		ToolCalls:    toolCalls,
@@ -965,16 +1341,40 @@ func (c *Client) processGeminiResponse(ctx context.Context, stream types.StreamC
 		RespMessages: respContents,
 		ToolResults:  toolResults,
 		Stopped:      choice.FinishReason == genai.FinishReasonStop,
-		TokenUsage:   tokenUsage,
+		TokenUsage:   compactTokenUsage.Add(tokenUsageGemini(result.UsageMetadata)),
 	}, nil
 }
 
-// createClients creates provider-specific clients
-func (c *Client) createClients(ctx context.Context) (*ClientUnion, error) {
+func tokenUsageGemini(usage *genai.GenerateContentResponseUsageMetadata) types.TokenUsage {
+	if usage == nil {
+		return types.TokenUsage{}
+	}
+	inputToken := usage.PromptTokenCount + usage.ToolUsePromptTokenCount
+	outputToken := usage.CandidatesTokenCount
+	cacheRead := usage.CachedContentTokenCount
+
+	return types.TokenUsage{
+		Input:  int64(inputToken),
+		Output: int64(outputToken),
+		Total:  int64(usage.TotalTokenCount),
+		InputBreakdown: types.TokenUsageInputBreakdown{
+			CacheRead:    int64(cacheRead),
+			NonCacheRead: int64(inputToken - cacheRead),
+		},
+	}
+}
+
+// createClients creates provider-specific clients, tracing requests to traceFile if set
+func (c *Client) createClients(ctx context.Context, traceFile string) (*ClientUnion, error) {
 	var clientOpenAI *openai.Client
 	var clientAnthropic *anthropic.Client
 	var clientGemini *genai.Client
 
+	var httpClient *http.Client
+	if traceFile != "" {
+		httpClient = newTraceHTTPClient(traceFile)
+	}
+
 	switch c.apiShape {
 	case providers.APIShapeOpenAI:
 		var clientOptions []openai_opt.RequestOption
@@ -982,10 +1382,22 @@ func (c *Client) createClients(ctx context.Context) (*ClientUnion, error) {
 			clientOptions = append(clientOptions, openai_opt.WithBaseURL(c.config.BaseURL))
 		}
 		clientOptions = append(clientOptions, openai_opt.WithAPIKey(c.config.Token))
+		for key, value := range c.config.Headers {
+			clientOptions = append(clientOptions, openai_opt.WithHeader(key, value))
+		}
+		if c.config.OpenAIOrganization != "" {
+			clientOptions = append(clientOptions, openai_opt.WithOrganization(c.config.OpenAIOrganization))
+		}
+		if c.config.OpenAIProject != "" {
+			clientOptions = append(clientOptions, openai_opt.WithProject(c.config.OpenAIProject))
+		}
 		if c.config.LogLevel >= types.LogLevelRequest {
-			logger := log.New(os.Stderr, "", log.LstdFlags)
+			logger := newRequestLogger(os.Stderr, c.config.LogRedact)
 			clientOptions = append(clientOptions, openai_opt.WithDebugLog(logger))
 		}
+		if httpClient != nil {
+			clientOptions = append(clientOptions, openai_opt.WithHTTPClient(httpClient))
+		}
 		client := openai.NewClient(clientOptions...)
 		clientOpenAI = &client
 
@@ -995,19 +1407,38 @@ func (c *Client) createClients(ctx context.Context) (*ClientUnion, error) {
 			clientOpts = append(clientOpts, anth_opt.WithBaseURL(c.config.BaseURL))
 		}
 		clientOpts = append(clientOpts, anth_opt.WithAPIKey(c.config.Token))
+		for key, value := range c.config.Headers {
+			clientOpts = append(clientOpts, anth_opt.WithHeader(key, value))
+		}
 		if c.config.LogLevel >= types.LogLevelRequest {
-			logger := log.New(os.Stderr, "", log.LstdFlags)
+			logger := newRequestLogger(os.Stderr, c.config.LogRedact)
 			clientOpts = append(clientOpts, anth_opt.WithDebugLog(logger))
 		}
+		if httpClient != nil {
+			clientOpts = append(clientOpts, anth_opt.WithHTTPClient(httpClient))
+		}
 		clientAnthropic = anthropic_helper.NewClient(clientOpts...)
 
 	case providers.APIShapeGemini:
+		if httpClient == nil {
+			// a dedicated transport, so that closing its connections does not affect others
+			httpClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+		}
+		var headers http.Header
+		if len(c.config.Headers) > 0 {
+			headers = make(http.Header, len(c.config.Headers))
+			for key, value := range c.config.Headers {
+				headers.Set(key, value)
+			}
+		}
 		var err error
 		clientGemini, err = genai.NewClient(ctx, &genai.ClientConfig{
-			APIKey:  c.config.Token,
-			Backend: genai.BackendGeminiAPI,
+			APIKey:     c.config.Token,
+			Backend:    genai.BackendGeminiAPI,
+			HTTPClient: httpClient,
 			HTTPOptions: genai.HTTPOptions{
 				BaseURL: c.config.BaseURL,
+				Headers: headers,
 			},
 		})
 		if err != nil {
@@ -1018,19 +1449,34 @@ func (c *Client) createClients(ctx context.Context) (*ClientUnion, error) {
 		return nil, fmt.Errorf("unsupported provider: %s", c.apiShape)
 	}
 
-	return &ClientUnion{
+	clientUnion := &ClientUnion{
 		OpenAI:    clientOpenAI,
 		Anthropic: clientAnthropic,
 		Gemini:    clientGemini,
-	}, nil
+	}
+	if clientGemini != nil {
+		clientUnion.geminiHTTPClient = httpClient
+	}
+	return clientUnion, nil
 }
 
-// prepareTools prepares the tool schemas and mappings
-func (c *Client) prepareTools(ctx context.Context, req types.Request) (ToolInfoMapping, tools.UnifiedTools, error) {
-	toolInfoMapping := make(ToolInfoMapping)
+// prepareTools prepares the tool schemas and mappings, MCP clients
+// are started here and must be closed with ToolInfoMapping.Close
+func (c *Client) prepareTools(ctx context.Context, req types.Request) (toolInfoMapping ToolInfoMapping, toolSchemas tools.UnifiedTools, err error) {
+	toolInfoMapping = make(ToolInfoMapping)
+
+	// stop MCP clients already started if any step fails
+	var mcpClients []*client.Client
+	defer func() {
+		if err != nil {
+			for _, mcpClient := range mcpClients {
+				mcpClient.Close()
+			}
+		}
+	}()
 
 	// Parse custom tool schemas
-	toolSchemas, err := tools.ParseSchemas(req.ToolFiles, req.ToolJSONs, req.ToolDefinitions)
+	toolSchemas, err = tools.ParseSchemas(req.ToolFiles, req.ToolJSONs, req.ToolDefinitions)
 	if err != nil {
 		return nil, nil, fmt.Errorf("parse tool schemas: %w", err)
 	}
@@ -1043,8 +1489,20 @@ func (c *Client) prepareTools(ctx context.Context, req types.Request) (ToolInfoM
 		}
 	}
 
+	// Go tools registered by RegisterTool are named like builtin tools
+	registeredTools, builtinNames := splitRegisteredTools(req.Tools)
+	for _, tool := range registeredTools {
+		if err := toolInfoMapping.AddTool(tool.Name, &ToolInfo{
+			Name:           tool.Name,
+			ToolDefinition: tool,
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	toolSchemas = append(toolSchemas, registeredTools...)
+
 	// Get builtin tools
-	builtinTools, err := tools.GetBuiltinTools(req.Tools)
+	builtinTools, err := tools.GetBuiltinTools(builtinNames)
 	if err != nil {
 		return nil, nil, fmt.Errorf("get builtin tools: %w", err)
 	}
@@ -1060,29 +1518,37 @@ func (c *Client) prepareTools(ctx context.Context, req types.Request) (ToolInfoM
 	toolSchemas = append(toolSchemas, builtinTools...)
 
 	// Setup MCP clients
-	for _, mcpServer := range req.MCPServers {
-		mcpClient, err := c.connectToMCPServer(mcpServer)
-		if err != nil {
-			return nil, nil, fmt.Errorf("connect to MCP server: %w", err)
-		}
-		res, err := mcpClient.Initialize(ctx, mcp.InitializeRequest{})
-		if err != nil {
-			return nil, nil, fmt.Errorf("initialize MCP client: %w", err)
-		}
-		_ = res
-
-		// Get MCP tools
-		mcpTools, err := c.getMCPTools(ctx, mcpClient)
-		if err != nil {
-			return nil, nil, fmt.Errorf("list mcp tools: %w", err)
+	var serverNames []string
+	if req.MCPNamespace {
+		serverNames = mcpServerNames(req.MCPServers)
+	}
+	startedServers, err := c.startMCPServers(ctx, req.MCPServers, req.MCPConcurrency)
+	for _, started := range startedServers {
+		if started.client != nil {
+			mcpClients = append(mcpClients, started.client)
 		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// tools are added in the order of the servers, whichever started first
+	for i, mcpServer := range req.MCPServers {
+		mcpClient := startedServers[i].client
+		mcpTools := startedServers[i].tools
 		for _, tool := range mcpTools {
-			if err := toolInfoMapping.AddTool(tool.Name, &ToolInfo{
+			toolInfo := &ToolInfo{
 				Name:           tool.Name,
 				MCPServer:      mcpServer,
 				MCPClient:      mcpClient,
 				ToolDefinition: tool,
-			}); err != nil {
+			}
+			if req.MCPNamespace {
+				tool.Name = serverNames[i] + mcpNamespaceSeparator + tool.Name
+			}
+			if err := toolInfoMapping.AddTool(tool.Name, toolInfo); err != nil {
+				if !req.MCPNamespace {
+					return nil, nil, fmt.Errorf("%w, namespace MCP tools to keep both", err)
+				}
 				return nil, nil, err
 			}
 		}
@@ -1092,23 +1558,46 @@ func (c *Client) prepareTools(ctx context.Context, req types.Request) (ToolInfoM
 	return toolInfoMapping, toolSchemas, nil
 }
 
+// readContextFiles reads each context file and wraps its content
+// in a <file> tag, so that the model can tell files apart
+func readContextFiles(contextFiles []string) (string, error) {
+	var parts []string
+	for _, file := range contextFiles {
+		content, err := ioread.ReadOrContent(file)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", file, err)
+		}
+		parts = append(parts, fmt.Sprintf("<file path=%q>\n%s\n</file>", file, content))
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
 // buildMessages builds provider-specific message formats
-func (c *Client) buildMessages(msg string, systemMessageOpenAI *openai.ChatCompletionMessageParamUnion, historicalMessagesOpenAI []openai.ChatCompletionMessageParamUnion, historicalMessagesAnthropic []anthropic.MessageParam, historicalMessagesGemini []*genai.Content) (*MessagesUnion, error) {
+func (c *Client) buildMessages(msg string, contextMsg string, documents []types.Document, systemMessageOpenAI *openai.ChatCompletionMessageParamUnion, historicalMessagesOpenAI []openai.ChatCompletionMessageParamUnion, historicalMessagesAnthropic []anthropic.MessageParam, historicalMessagesGemini []*genai.Content) (*MessagesUnion, error) {
 	var messagesOpenAI []openai.ChatCompletionMessageParamUnion
 	var messagesAnthropic []anthropic.MessageParam
 	var messagesGemini []*genai.Content
 
+	// context goes before the user message
+	var userMsgs []string
+	if contextMsg != "" {
+		userMsgs = append(userMsgs, contextMsg)
+	}
+	if msg != "" {
+		userMsgs = append(userMsgs, msg)
+	}
+
 	switch c.apiShape {
 	case providers.APIShapeOpenAI:
 		if systemMessageOpenAI != nil {
 			messagesOpenAI = append(messagesOpenAI, *systemMessageOpenAI)
 		}
 		messagesOpenAI = append(messagesOpenAI, historicalMessagesOpenAI...)
-		if msg != "" {
+		for _, userMsg := range userMsgs {
 			messagesOpenAI = append(messagesOpenAI, openai.ChatCompletionMessageParamUnion{
 				OfUser: &openai.ChatCompletionUserMessageParam{
 					Content: openai.ChatCompletionUserMessageParamContentUnion{
-						OfString: param.NewOpt(msg),
+						OfString: param.NewOpt(userMsg),
 					},
 				},
 			})
@@ -1119,10 +1608,17 @@ func (c *Client) buildMessages(msg string, systemMessageOpenAI *openai.ChatCompl
 
 	case providers.APIShapeAnthropic:
 		messagesAnthropic = append(messagesAnthropic, historicalMessagesAnthropic...)
-		if msg != "" {
-			messagesAnthropic = append(messagesAnthropic, anthropic.NewUserMessage(
-				anthropic.NewTextBlock(msg)),
-			)
+		for i, userMsg := range userMsgs {
+			var blocks []anthropic.ContentBlockParamUnion
+			// documents go with the last user message
+			if i == len(userMsgs)-1 {
+				blocks = documentBlocksAnthropic(documents)
+			}
+			blocks = append(blocks, anthropic.NewTextBlock(userMsg))
+			messagesAnthropic = append(messagesAnthropic, anthropic.NewUserMessage(blocks...))
+		}
+		if len(userMsgs) == 0 && len(documents) > 0 {
+			messagesAnthropic = append(messagesAnthropic, anthropic.NewUserMessage(documentBlocksAnthropic(documents)...))
 		}
 		if len(messagesAnthropic) == 0 {
 			return nil, fmt.Errorf("requires msg")
@@ -1130,11 +1626,11 @@ func (c *Client) buildMessages(msg string, systemMessageOpenAI *openai.ChatCompl
 
 	case providers.APIShapeGemini:
 		messagesGemini = append(messagesGemini, historicalMessagesGemini...)
-		if msg != "" {
+		for _, userMsg := range userMsgs {
 			messagesGemini = append(messagesGemini, &genai.Content{
 				Parts: []*genai.Part{
 					{
-						Text: msg,
+						Text: userMsg,
 					},
 				},
 				Role: genai.RoleUser,
@@ -1157,11 +1653,68 @@ func (c *Client) buildMessages(msg string, systemMessageOpenAI *openai.ChatCompl
 
 // computeCost computes the cost for the given token usage
 func (c *Client) computeCost(usage types.TokenUsage) (types.TokenCost, bool) {
-	// For now, return a simple implementation
-	// This would need the full implementation from run/usage.go
-	return types.TokenCost{
-		TotalUSD: "0.00", // Placeholder
-	}, false
+	return providers.ComputeCost(c.apiShape, c.config.Model, usage)
+}
+
+// defaultMCPConcurrency is the number of MCP servers started at a time without Request.MCPConcurrency
+const defaultMCPConcurrency = 4
+
+// startedMCPServer is an initialized MCP client and the tools of its server
+type startedMCPServer struct {
+	client *client.Client
+	tools  []*tools.UnifiedTool
+}
+
+// startMCPServers starts the servers concurrently, at most concurrency at a time, in the order
+// of servers. The errors of all failed servers are joined, the clients started are returned
+// even on error so the caller closes them
+func (c *Client) startMCPServers(ctx context.Context, servers []string, concurrency int) ([]startedMCPServer, error) {
+	if concurrency <= 0 {
+		concurrency = defaultMCPConcurrency
+	}
+	started := make([]startedMCPServer, len(servers))
+	errs := make([]error, len(servers))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			started[i], errs[i] = c.startMCPServer(ctx, server)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("MCP server %s: %w", server, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return started, errors.Join(errs...)
+}
+
+// startMCPServer connects to the server, initializes it and lists its tools
+func (c *Client) startMCPServer(ctx context.Context, server string) (startedMCPServer, error) {
+	mcpClient, err := c.connectToMCPServer(server)
+	if err != nil {
+		return startedMCPServer{}, fmt.Errorf("connect to MCP server: %w", err)
+	}
+	started := startedMCPServer{client: mcpClient}
+	initReq := mcp.InitializeRequest{}
+	initReq.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initReq.Params.ClientInfo = mcp.Implementation{Name: "kode"}
+	res, err := mcpClient.Initialize(ctx, initReq)
+	if err != nil {
+		return started, fmt.Errorf("initialize MCP client: %w", err)
+	}
+	// a server not declaring the tools capability has no tools to list
+	if res.Capabilities.Tools == nil {
+		return started, nil
+	}
+	started.tools, err = c.getMCPTools(ctx, mcpClient)
+	if err != nil {
+		return started, fmt.Errorf("list mcp tools: %w", err)
+	}
+	return started, nil
 }
 
 // connectToMCPServer connects to an MCP server
@@ -2,7 +2,16 @@ package chat
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/xhd2015/kode-ai/types"
 )
@@ -146,6 +155,44 @@ func TestChatOptions(t *testing.T) {
 	}
 }
 
+func TestBuildMessagesWithContextFiles(t *testing.T) {
+	dir := t.TempDir()
+	contextFile := filepath.Join(dir, "context.txt")
+	if err := os.WriteFile(contextFile, []byte("the answer is 42"), 0644); err != nil {
+		t.Fatalf("failed to write context file: %v", err)
+	}
+
+	contextMsg, err := readContextFiles([]string{contextFile})
+	if err != nil {
+		t.Fatalf("failed to read context files: %v", err)
+	}
+	if !strings.Contains(contextMsg, fmt.Sprintf("<file path=%q>", contextFile)) {
+		t.Errorf("expected context to be wrapped in file tag, got %q", contextMsg)
+	}
+
+	client, err := NewClient(Config{
+		Model: "gpt-4o",
+		Token: "test-token",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	msgs, err := client.buildMessages("what is the answer?", contextMsg, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to build messages: %v", err)
+	}
+	if len(msgs.OpenAI) != 2 {
+		t.Fatalf("expected 2 messages but got %d", len(msgs.OpenAI))
+	}
+	if msgs.OpenAI[0].OfUser == nil || !strings.Contains(msgs.OpenAI[0].OfUser.Content.OfString.Value, "the answer is 42") {
+		t.Errorf("expected first message to be the context file content")
+	}
+	if msgs.OpenAI[1].OfUser == nil || msgs.OpenAI[1].OfUser.Content.OfString.Value != "what is the answer?" {
+		t.Errorf("expected second message to be the user message")
+	}
+}
+
 func TestEventTypes(t *testing.T) {
 	// Test that all message types are defined
 	messageTypes := []types.MsgType{
@@ -241,3 +288,93 @@ func TestTokenUsageAdd(t *testing.T) {
 		t.Errorf("expected non-cache read 255 but got %d", result.InputBreakdown.NonCacheRead)
 	}
 }
+
+func TestChatResponseMessages(t *testing.T) {
+	apiServer := startToolCallServer(t)
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	resp, err := client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(handledToolCallback),
+		WithMaxRounds(2),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	wants := []struct {
+		msgType types.MsgType
+		role    types.Role
+	}{
+		{types.MsgType_ToolCall, types.Role_Assistant},
+		{types.MsgType_ToolResult, types.Role_User},
+		{types.MsgType_Msg, types.Role_Assistant},
+	}
+	if len(resp.Messages) != len(wants) {
+		t.Fatalf("expected %d messages, got %d: %+v", len(wants), len(resp.Messages), resp.Messages)
+	}
+	for i, want := range wants {
+		msg := resp.Messages[i]
+		if msg.Type != want.msgType || msg.Role != want.role {
+			t.Errorf("message %d: expected %s/%s, got %s/%s", i, want.msgType, want.role, msg.Type, msg.Role)
+		}
+	}
+	if resp.Messages[0].ToolUseID != "call_1" || resp.Messages[1].ToolUseID != "call_1" {
+		t.Errorf("expected tool call and result of call_1, got %+v", resp.Messages[:2])
+	}
+	if resp.Messages[2].Content != "Sunny" {
+		t.Errorf("expected final answer Sunny, got %q", resp.Messages[2].Content)
+	}
+}
+
+// run with -race to check requests do not share state through the client
+func TestConcurrentChatRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(data), `"role":"tool"`) {
+			fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"list_dir","arguments":"{\"relative_workspace_path\":\".\"}"}}]},"finish_reason":"tool_calls"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	requests := []types.Request{
+		{Message: "list", Tools: []string{"list_dir"}, MaxRounds: 3, ToolCallback: handledToolCallback, StrictToolArgs: true, MaxToolRetries: 1, OncePerTool: true},
+		{Message: "list", Tools: []string{"list_dir"}, MaxRounds: 3, ToolCallback: handledToolCallback, ToolResolution: types.ToolResolution_CallbackOnly, ToolTimeout: time.Second},
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(requests)*4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.ChatRequest(context.Background(), requests[i%len(requests)])
+			if err == nil && (resp.NumToolCalls != 1 || resp.RoundsUsed != 2) {
+				err = fmt.Errorf("expected 1 tool call in 2 rounds, got %d in %d", resp.NumToolCalls, resp.RoundsUsed)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("request %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"errors"
+	"sync"
+)
+
+// Close releases resources held by requests still in progress, such as
+// MCP subprocesses. Resources of a finished request are already released
+// when ChatRequest returns, so Close is only needed for long-lived clients
+// that may be abandoned while a request is running
+func (c *Client) Close() error {
+	c.closeMutex.Lock()
+	closers := c.closers
+	c.closers = nil
+	c.closeMutex.Unlock()
+
+	var errs []error
+	for _, closeFn := range closers {
+		if err := closeFn(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// track registers closeFn to be called by Close, the returned
+// release calls closeFn and unregisters it, closeFn runs at most once
+func (c *Client) track(closeFn func() error) (release func() error) {
+	var once sync.Once
+	var closeErr error
+	run := func() error {
+		once.Do(func() {
+			closeErr = closeFn()
+		})
+		return closeErr
+	}
+
+	c.closeMutex.Lock()
+	if c.closers == nil {
+		c.closers = make(map[int]func() error)
+	}
+	id := c.nextCloser
+	c.nextCloser++
+	c.closers[id] = run
+	c.closeMutex.Unlock()
+
+	return func() error {
+		c.closeMutex.Lock()
+		delete(c.closers, id)
+		c.closeMutex.Unlock()
+		return run()
+	}
+}
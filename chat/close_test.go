@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// testMCPServerEnv makes the test binary serve as a stdio MCP server
+const testMCPServerEnv = "KODE_TEST_MCP_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMCPServerEnv) != "" {
+		s := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+		s.AddTool(mcp.NewTool("echo", mcp.WithDescription("echo the input")), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("echo"), nil
+		})
+		s.AddTool(mcp.NewTool("search", mcp.WithDescription("search the server")), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("searched " + os.Getenv(testMCPServerEnv)), nil
+		})
+		if err := server.ServeStdio(s); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// writeTestMCPServerScript writes a script starting the test MCP server,
+// which appends its pid to pidFile
+func writeTestMCPServerScript(t *testing.T, pidFile string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(t.TempDir(), "mcp-server.sh")
+	content := fmt.Sprintf("#!/bin/sh\necho $$ >> %q\n%s=1 exec %q\n", pidFile, testMCPServerEnv, exe)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func readPids(t *testing.T, pidFile string) []int {
+	t.Helper()
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pids []int
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+func TestChatRequestClosesMCPClients(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer apiServer.Close()
+
+	pidFile := filepath.Join(t.TempDir(), "pids")
+	script := writeTestMCPServerScript(t, pidFile)
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		_, err := client.Chat(context.Background(), "hello", WithMCPServers(script))
+		if err != nil {
+			t.Fatalf("chat %d: %v", i, err)
+		}
+	}
+
+	pids := readPids(t, pidFile)
+	if len(pids) != n {
+		t.Fatalf("expected %d MCP servers started, got %d", n, len(pids))
+	}
+	var alive int
+	for _, pid := range pids {
+		if processAlive(pid) {
+			alive++
+		}
+	}
+	if alive != 0 {
+		t.Errorf("expected all MCP servers to exit, %d of %d still alive", alive, n)
+	}
+}
+
+func TestClientCloseReleasesInProgress(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pids")
+	script := writeTestMCPServerScript(t, pidFile)
+
+	// the API call blocks until the client is closed
+	closed := make(chan struct{})
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer apiServer.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Chat(context.Background(), "hello", WithMCPServers(script))
+	}()
+
+	// wait for the MCP server to start
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := os.ReadFile(pidFile); len(data) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("MCP server not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// wait for tools to be prepared
+	time.Sleep(200 * time.Millisecond)
+
+	if err := client.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	for _, pid := range readPids(t, pidFile) {
+		if processAlive(pid) {
+			t.Errorf("expected MCP server %d to exit after Close", pid)
+		}
+	}
+	close(closed)
+	<-done
+}
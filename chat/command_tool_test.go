@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestCommandToolEnv(t *testing.T) {
+	apiServer := startToolCallServer(t)
+	t.Setenv("KODE_TEST_UNIT", "celsius")
+	dir := t.TempDir()
+
+	toolDef, err := json.Marshal(types.UnifiedTool{
+		Name:    "get_weather",
+		Command: []string{"sh", "-c", `echo "$0 $1 $2 $KODE_TEST_FORECAST"; pwd`, "${city}", "${env.KODE_TEST_UNIT}", "${cwd}"},
+		Env:     map[string]string{"KODE_TEST_FORECAST": "sunny"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var toolResult string
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolJSONs(string(toolDef)),
+		WithDefaultToolCwd(dir),
+		WithMaxRounds(2),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolResult {
+				toolResult = event.Content
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	var result struct {
+		Output string `json:"output"`
+	}
+	if err := json.Unmarshal([]byte(toolResult), &result); err != nil {
+		t.Fatalf("decode tool result %s: %v", toolResult, err)
+	}
+	output := result.Output
+	want := "Tokyo celsius " + dir + " sunny\n" + dir + "\n"
+	if output != want {
+		t.Errorf("expected %q, got %q", want, output)
+	}
+	if strings.Contains(output, "${") {
+		t.Errorf("expected all references substituted, got %q", output)
+	}
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// compactResultPrompt asks for the summary of a large tool result
+const compactResultPrompt = `Summarize the result of a tool call made by a coding agent. The agent continues its task with only your summary in place of the result, so keep what it likely needs: file paths, line numbers, identifiers, error messages and exact values. Reply with the summary only.
+
+Tool: %s
+Arguments: %s
+
+<tool_result>
+%s
+</tool_result>`
+
+// compactToolResult returns the result sent to the model for a tool result resultStr. With
+// req.CompactToolResults, a successful text result longer than it is replaced by a summary
+// generated by req.CompactModel, default the chat model, and compacted is true. The full result
+// is still recorded, tokenUsage is the usage of the summary. If the summary cannot be
+// generated the full result is sent
+func (c *Client) compactToolResult(ctx context.Context, req types.Request, call types.ToolCall, toolResult types.ToolResult, resultStr string) (sent string, compacted bool, tokenUsage types.TokenUsage) {
+	if req.CompactToolResults <= 0 || len(resultStr) <= req.CompactToolResults || toolResult.Error != "" {
+		return resultStr, false, types.TokenUsage{}
+	}
+	if _, ok := toolResult.Content.([]types.ToolResultPart); ok {
+		// images cannot be summarized as text
+		return resultStr, false, types.TokenUsage{}
+	}
+
+	config := c.config
+	if req.CompactModel != "" {
+		config.Model = req.CompactModel
+	}
+	// a client of its own, the chat in progress keeps its state on c
+	summarizer, err := NewClient(config)
+	if err != nil {
+		c.logger.Log(ctx, types.LogType_Error, "compact tool result of %s: %v", call.Name, err)
+		return resultStr, false, types.TokenUsage{}
+	}
+	defer summarizer.Close()
+
+	var summary string
+	resp, err := summarizer.Chat(ctx, fmt.Sprintf(compactResultPrompt, call.Name, call.RawArgs, resultStr),
+		WithMaxRounds(1),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+				summary += event.Content
+			}
+		}),
+	)
+	if err != nil || summary == "" {
+		c.logger.Log(ctx, types.LogType_Error, "compact tool result of %s: %v", call.Name, err)
+		return resultStr, false, types.TokenUsage{}
+	}
+	sent = fmt.Sprintf("[summary of the %d bytes result, call the tool with a narrower scope for details]\n%s", len(resultStr), summary)
+	return sent, true, resp.TokenUsage
+}
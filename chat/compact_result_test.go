@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestCompactToolResults(t *testing.T) {
+	largeResult := strings.Repeat("line of a large file\n", 100)
+
+	var mutex sync.Mutex
+	var bodies []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body := string(data)
+		mutex.Lock()
+		bodies = append(bodies, body)
+		n := len(bodies)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(body, "Summarize the result of a tool call"):
+			fmt.Fprint(w, `{"id":"chatcmpl-s","object":"chat.completion","created":1,"model":"gpt-4o-mini","choices":[{"index":0,"message":{"role":"assistant","content":"100 repeated lines"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":3,"total_tokens":103}}`)
+		case n == 1:
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"read_big","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+		default:
+			fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+		}
+	}))
+	defer apiServer.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	resp, err := client.Chat(context.Background(), "read the big file",
+		WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			return types.ToolResult{Content: largeResult}, true, nil
+		}),
+		WithMaxRounds(3),
+		WithCompactToolResults(1000, "gpt-4o-mini"),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	if len(bodies) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(bodies))
+	}
+	if !strings.Contains(bodies[1], `"model":"gpt-4o-mini"`) || !strings.Contains(bodies[1], "line of a large file") {
+		t.Errorf("expected the summary requested from gpt-4o-mini with the full result, got %s", bodies[1])
+	}
+	resent := bodies[2]
+	if strings.Contains(resent, "line of a large file") || !strings.Contains(resent, "100 repeated lines") {
+		t.Errorf("expected the summary re-sent instead of the full result, got %s", resent)
+	}
+	var recorded string
+	for _, msg := range resp.Messages {
+		if msg.Type == types.MsgType_ToolResult {
+			recorded = msg.Content
+		}
+	}
+	if !strings.Contains(recorded, "line of a large file") {
+		t.Errorf("expected the full result recorded, got %q", recorded)
+	}
+	if resp.TokenUsage.Total != 15+103+25 {
+		t.Errorf("expected summary tokens counted, got %d", resp.TokenUsage.Total)
+	}
+}
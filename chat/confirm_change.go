@@ -0,0 +1,270 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// decisions on a file change made without asking, see CliOptions.FileChangePolicy
+const (
+	FileChangePolicy_Accept = "accept" // default
+	FileChangePolicy_Reject = "reject"
+)
+
+// ValidateFileChangePolicy reports an unknown policy, empty means accept
+func ValidateFileChangePolicy(policy string) error {
+	switch policy {
+	case "", FileChangePolicy_Accept, FileChangePolicy_Reject:
+		return nil
+	}
+	return fmt.Errorf("invalid file change policy: %s, expect accept or reject", policy)
+}
+
+// ValidateConfirmFileChanges reports a setup where builtin file tools run without
+// going through the confirmation, which is a tool callback: builtin-first and
+// builtin-only tool resolution run them before or without the callback, and
+// a chat server runs them on the server
+func ValidateConfirmFileChanges(resolution types.ToolResolution, withServer bool) error {
+	if withServer {
+		return fmt.Errorf("confirming file changes is not supported with a chat server")
+	}
+	switch resolution {
+	case types.ToolResolution_BuiltinFirst, types.ToolResolution_BuiltinOnly:
+		return fmt.Errorf("confirming file changes is not supported with tool resolution %s", resolution)
+	}
+	return nil
+}
+
+// maxDiffCells caps the size of the table of lineDiff, larger
+// files are shown as a whole replacement
+const maxDiffCells = 4 << 20
+
+// fileChangeConfirmer asks the user to accept or reject the change of a
+// file-mutating tool before it is applied, showing the change as a diff
+type fileChangeConfirmer struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interactive bool
+	policy      string
+}
+
+// fileChange is the change a file-mutating tool call would make
+type fileChange struct {
+	path    string
+	before  string
+	after   string
+	deleted bool
+}
+
+// wrap returns a tool callback confirming file changes before
+// passing the call to next, or to the builtin tool if next is nil
+func (f *fileChangeConfirmer) wrap(next types.ToolCallback) types.ToolCallback {
+	return func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		change, ok := proposedFileChange(call)
+		if ok {
+			accepted, instruction, err := f.confirm(change)
+			if err != nil {
+				return types.ToolResult{}, true, err
+			}
+			if !accepted {
+				msg := fmt.Sprintf("the user rejected the change to %s", change.path)
+				if instruction != "" {
+					msg += ": " + instruction
+				}
+				return types.ToolResult{Error: msg}, true, nil
+			}
+		}
+		if next == nil {
+			return types.ToolResult{}, false, nil
+		}
+		return next(ctx, stream, call)
+	}
+}
+
+// confirm shows the change and reads the decision, without
+// a terminal it is decided by the policy
+func (f *fileChangeConfirmer) confirm(change fileChange) (accepted bool, instruction string, err error) {
+	if !f.interactive {
+		return f.policy != FileChangePolicy_Reject, "", nil
+	}
+	action := "Apply change to"
+	if change.deleted {
+		action = "Delete"
+	}
+	fmt.Fprintf(f.out, "%s %s:\n%s", action, change.path, lineDiff(change.before, change.after))
+	for {
+		fmt.Fprintf(f.out, "%s?\n  y:accept, n:reject, e:<instruction> reject and tell the model what to do instead\n", action)
+		fmt.Fprint(f.out, "user> ")
+		response, err := f.in.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || response == "") {
+			return false, "", fmt.Errorf("failed to read response: %v", err)
+		}
+		decision := strings.TrimSpace(response)
+		if suffix, ok := strings.CutPrefix(decision, "e:"); ok {
+			if suffix = strings.TrimSpace(suffix); suffix == "" {
+				continue
+			}
+			return false, suffix, nil
+		}
+		switch decision {
+		case "y":
+			return true, "", nil
+		case "n":
+			return false, "", nil
+		}
+	}
+}
+
+// proposedFileChange returns the change call would make if it is a file-mutating
+// builtin tool with valid arguments, others are left to the tool itself
+func proposedFileChange(call types.ToolCall) (fileChange, bool) {
+	var args struct {
+		WorkspaceRoot string `json:"workspace_root"`
+		TargetFile    string `json:"target_file"`
+		Content       string `json:"content"`
+
+		// search_replace
+		File string `json:"file"`
+		Old  string `json:"old"`
+		New  string `json:"new"`
+	}
+	if err := json.Unmarshal([]byte(call.RawArgs), &args); err != nil {
+		return fileChange{}, false
+	}
+	root := args.WorkspaceRoot
+	if root == "" {
+		root = call.WorkingDir
+	}
+	file := args.TargetFile
+	if call.Name == "search_replace" {
+		file = args.File
+	}
+	if file == "" {
+		return fileChange{}, false
+	}
+	path := file
+	if !filepath.IsAbs(path) && root != "" {
+		path = filepath.Join(root, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fileChange{}, false
+	}
+	change := fileChange{path: path, before: string(data)}
+
+	switch call.Name {
+	case "write_file", "create_file_with_content":
+		change.after = args.Content
+	case "search_replace":
+		if args.Old == "" || !strings.Contains(change.before, args.Old) {
+			return fileChange{}, false
+		}
+		change.after = strings.Replace(change.before, args.Old, args.New, 1)
+	case "delete_file":
+		if err != nil {
+			return fileChange{}, false
+		}
+		change.deleted = true
+	default:
+		return fileChange{}, false
+	}
+	return change, true
+}
+
+// lineDiff shows the lines removed from before with "-" and the lines
+// added in after with "+", unchanged lines are shown around the changes
+func lineDiff(before string, after string) string {
+	a := splitLines(before)
+	b := splitLines(after)
+	if len(a)*len(b) > maxDiffCells {
+		var out strings.Builder
+		for _, line := range a {
+			fmt.Fprintf(&out, "-%s\n", line)
+		}
+		for _, line := range b {
+			fmt.Fprintf(&out, "+%s\n", line)
+		}
+		return out.String()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type diffLine struct {
+		op   byte
+		text string
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+
+	// keep unchanged lines within diffContext lines of a change
+	const diffContext = 2
+	near := make([]bool, len(lines))
+	for k, line := range lines {
+		if line.op == ' ' {
+			continue
+		}
+		for c := max(0, k-diffContext); c <= min(len(lines)-1, k+diffContext); c++ {
+			near[c] = true
+		}
+	}
+	var out strings.Builder
+	var skipped bool
+	for k, line := range lines {
+		if !near[k] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			out.WriteString(" ...\n")
+			skipped = false
+		}
+		fmt.Fprintf(&out, "%c%s\n", line.op, line.text)
+	}
+	if skipped {
+		out.WriteString(" ...\n")
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
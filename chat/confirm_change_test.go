@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestConfirmFileChanges(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte("package main\n\nfunc old() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args, err := json.Marshal(map[string]string{"target_file": "main.go", "content": "package main\n\nfunc updated() {}\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := types.ToolCall{ID: "call_1", Name: "write_file", RawArgs: string(args), WorkingDir: dir}
+
+	var out strings.Builder
+	confirmer := &fileChangeConfirmer{
+		// an unknown decision is asked again
+		in:          bufio.NewReader(strings.NewReader("n\ne:keep the old name\nmaybe\ny\n")),
+		out:         &out,
+		interactive: true,
+	}
+	callback := confirmer.wrap(nil)
+
+	result, handled, err := callback(context.Background(), nil, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled || !strings.Contains(result.Error, "rejected") {
+		t.Errorf("expected the change rejected, got %v %+v", handled, result)
+	}
+	if !strings.Contains(out.String(), "-func old() {}\n+func updated() {}\n") {
+		t.Errorf("expected the diff shown, got %s", out.String())
+	}
+
+	result, handled, err = callback(context.Background(), nil, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled || !strings.HasSuffix(result.Error, ": keep the old name") {
+		t.Errorf("expected the change rejected with the instruction, got %v %+v", handled, result)
+	}
+
+	// accepted, the builtin tool applies it
+	result, handled, err = callback(context.Background(), nil, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled {
+		t.Fatalf("expected the accepted change left to the builtin tool, got %+v", result)
+	}
+	if _, err := tools.GetExecutor("write_file").Execute(context.Background(), call.RawArgs, tools.ExecuteOptions{DefaultWorkspaceRoot: dir}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "func updated") {
+		t.Errorf("expected the file written, got %s", data)
+	}
+
+	// without a terminal, the policy decides
+	rejecting := &fileChangeConfirmer{policy: FileChangePolicy_Reject}
+	result, handled, err = rejecting.wrap(nil)(context.Background(), nil, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled || result.Error == "" {
+		t.Errorf("expected the change rejected by the policy, got %v %+v", handled, result)
+	}
+
+	// tools not changing files are passed through
+	accepting := &fileChangeConfirmer{}
+	if _, handled, _ := accepting.wrap(handledToolCallback)(context.Background(), nil, types.ToolCall{Name: "read_file", RawArgs: `{"target_file":"main.go"}`}); !handled {
+		t.Errorf("expected read_file passed to the next callback")
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\n"
+	after := "a\nb\nc\nD\ne\nf\ng\n"
+	want := " ...\n b\n c\n-d\n+D\n e\n f\n ...\n"
+	if got := lineDiff(before, after); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+	if got := lineDiff("", "new\n"); got != "+new\n" {
+		t.Errorf("expected a new file all added, got %q", got)
+	}
+}
+
+func TestConfirmFileChangesRejectsBypassingResolution(t *testing.T) {
+	tests := []struct {
+		resolution types.ToolResolution
+		withServer bool
+		wantErr    bool
+	}{
+		{"", false, false},
+		{types.ToolResolution_CallbackFirst, false, false},
+		{types.ToolResolution_CallbackOnly, false, false},
+		{types.ToolResolution_BuiltinFirst, false, true},
+		{types.ToolResolution_BuiltinOnly, false, true},
+		{"", true, true},
+	}
+	for _, tt := range tests {
+		err := ValidateConfirmFileChanges(tt.resolution, tt.withServer)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolution %q with server %v: expected error %v, got %v", tt.resolution, tt.withServer, tt.wantErr, err)
+		}
+	}
+
+	client, err := NewClient(Config{Model: "gpt-4o", Token: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewCliHandler(client, CliOptions{ConfirmFileChanges: true})
+	err = handler.HandleCli(context.Background(), "Hello", WithTools("write_file"), WithToolResolution(types.ToolResolution_BuiltinFirst))
+	if err == nil || !strings.Contains(err.Error(), "builtin-first") {
+		t.Errorf("expected builtin-first rejected, got %v", err)
+	}
+}
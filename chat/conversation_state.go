@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// conversationState tracks the running conversation for get_conversation_summary
+type conversationState struct {
+	computeCost func(usage types.TokenUsage) (types.TokenCost, bool)
+
+	mutex  sync.Mutex
+	rounds int
+	// usage of the finished rounds
+	usage types.TokenUsage
+	// usage of the response whose tool calls are being executed
+	responseUsage types.TokenUsage
+	toolCalls     []string
+	// results of executed tool calls by toolCallKey, for OncePerTool
+	toolResults map[string]types.ToolResult
+}
+
+func newConversationState(computeCost func(usage types.TokenUsage) (types.TokenCost, bool)) *conversationState {
+	return &conversationState{computeCost: computeCost}
+}
+
+func (c *conversationState) startRound() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.rounds++
+}
+
+// respond counts the usage of a response before its tool calls are executed
+func (c *conversationState) respond(usage types.TokenUsage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.responseUsage = usage
+}
+
+// endRound replaces the usage of the response with roundUsage,
+// which also includes the usage of summarizing tool results
+func (c *conversationState) endRound(roundUsage types.TokenUsage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.usage = c.usage.Add(roundUsage)
+	c.responseUsage = types.TokenUsage{}
+}
+
+func (c *conversationState) toolCalled(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.toolCalls = append(c.toolCalls, name)
+}
+
+// cachedToolResult is the result of an earlier call with the same name and arguments
+func (c *conversationState) cachedToolResult(call types.ToolCall) (types.ToolResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result, ok := c.toolResults[toolCallKey(call)]
+	return result, ok
+}
+
+func (c *conversationState) cacheToolResult(call types.ToolCall, result types.ToolResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.toolResults == nil {
+		c.toolResults = make(map[string]types.ToolResult)
+	}
+	c.toolResults[toolCallKey(call)] = result
+}
+
+// toolCallKey hashes the name and arguments of call, the arguments are
+// re-marshaled so key order and spacing don't matter
+func toolCallKey(call types.ToolCall) string {
+	args := call.RawArgs
+	if call.Arguments != nil {
+		if data, err := json.Marshal(call.Arguments); err == nil {
+			args = string(data)
+		}
+	}
+	sum := sha256.Sum256([]byte(call.Name + "\x00" + args))
+	return hex.EncodeToString(sum[:])
+}
+
+// accessor is the read-only access of tools.ExecuteOptions.Conversation, nil without state
+func (c *conversationState) accessor() func() tools.ConversationSummary {
+	if c == nil {
+		return nil
+	}
+	return c.summary
+}
+
+func (c *conversationState) summary() tools.ConversationSummary {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	summary := tools.ConversationSummary{
+		Rounds:     c.rounds,
+		TokenUsage: c.usage.Add(c.responseUsage),
+		ToolCalls:  append([]string{}, c.toolCalls...),
+	}
+	if cost, ok := c.computeCost(summary.TokenUsage); ok {
+		summary.CostUSD = cost.TotalUSD
+	}
+	return summary
+}
@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestGetConversationSummary(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests <= 2 {
+			fmt.Fprintf(w, `{"id":"chatcmpl-%d","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_%d","type":"function","function":{"name":"get_conversation_summary","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":%d,"completion_tokens":5,"total_tokens":%d}}`, requests, requests, requests*100, requests*100+5)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-3","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"within budget"},"finish_reason":"stop"}],"usage":{"prompt_tokens":300,"completion_tokens":5,"total_tokens":305}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var summaries []tools.ConversationSummary
+	_, err = client.Chat(context.Background(), "how much did we spend?",
+		WithTools("get_conversation_summary"),
+		WithMaxRounds(3),
+		WithEventCallback(func(event types.Message) {
+			if event.Type != types.MsgType_ToolResult {
+				return
+			}
+			var summary tools.ConversationSummary
+			if err := json.Unmarshal([]byte(event.Content), &summary); err != nil {
+				t.Errorf("parse summary %s: %v", event.Content, err)
+			}
+			summaries = append(summaries, summary)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 summaries, got %d", len(summaries))
+	}
+
+	first := summaries[0]
+	if first.Rounds != 1 || first.TokenUsage.Input != 100 || first.TokenUsage.Total != 105 || len(first.ToolCalls) != 0 {
+		t.Errorf("unexpected first summary: %+v", first)
+	}
+	second := summaries[1]
+	if second.Rounds != 2 || second.TokenUsage.Input != 300 || second.TokenUsage.Output != 10 || second.TokenUsage.Total != 310 {
+		t.Errorf("unexpected second summary: %+v", second)
+	}
+	if len(second.ToolCalls) != 1 || second.ToolCalls[0] != "get_conversation_summary" {
+		t.Errorf("expected the first call in tool calls, got %v", second.ToolCalls)
+	}
+	if second.CostUSD == "" {
+		t.Errorf("expected cost of gpt-4o, got none")
+	}
+}
+
+func TestOncePerTool(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests <= 2 {
+			// the same call with the keys in another order
+			args := `{\"city\":\"Tokyo\",\"unit\":\"c\"}`
+			if requests == 2 {
+				args = `{\"unit\":\"c\", \"city\":\"Tokyo\"}`
+			}
+			fmt.Fprintf(w, `{"id":"chatcmpl-%d","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_%d","type":"function","function":{"name":"get_weather","arguments":"%s"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`, requests, requests, args)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-3","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var executed int
+	var results []string
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			executed++
+			return types.ToolResult{Content: "sunny"}, true, nil
+		}),
+		WithMaxRounds(5),
+		WithOncePerTool(true),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolResult {
+				results = append(results, event.Content)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if executed != 1 {
+		t.Errorf("expected the repeated call short-circuited, executed %d times", executed)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 tool results, got %v", results)
+	}
+	var repeated struct {
+		Warning string `json:"warning"`
+		Result  string `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(results[1]), &repeated); err != nil {
+		t.Fatalf("unmarshal repeated result %s: %v", results[1], err)
+	}
+	if repeated.Result != "sunny" || repeated.Warning == "" {
+		t.Errorf("expected the earlier result with a warning, got %s", results[1])
+	}
+}
@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// ConvertEntry describes what a history message became in the provider-native messages
+type ConvertEntry struct {
+	Index   int
+	Message types.Message
+	// Output is the index in ConvertResult.Messages, -1 if not converted to a message
+	Output int
+	// Pair is the index of the matching tool call or tool result, -1 if none
+	Pair int
+	// Note explains a message not converted, or a tool call or result without its pair
+	Note string
+}
+
+// ConvertResult is the provider-native representation of a history
+type ConvertResult struct {
+	APIShape      providers.APIShape
+	SystemPrompts []string
+	// Messages is []openai.ChatCompletionMessageParamUnion, []anthropic.MessageParam or []*genai.Content
+	Messages interface{}
+	Entries  []ConvertEntry
+}
+
+// ConvertMessages converts messages the way they are sent as history, reporting
+// for each message whether it was converted, dropped or moved to the system prompt,
+// and pairing tool calls with their results. Entries include the placeholder results
+// synthesized for tool calls without one
+func ConvertMessages(history []types.Message, apiShape providers.APIShape) (*ConvertResult, error) {
+	messages := Messages(history)
+	result := &ConvertResult{APIShape: apiShape}
+	var err error
+	switch apiShape {
+	case providers.APIShapeOpenAI:
+		result.Messages, result.SystemPrompts, err = messages.ToOpenAI(false)
+	case providers.APIShapeAnthropic:
+		result.Messages, result.SystemPrompts, err = messages.ToAnthropic()
+	case providers.APIShapeGemini:
+		result.Messages, result.SystemPrompts, err = messages.ToGemini()
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", apiShape)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// conversion is per message once tool calls are paired,
+	// so converting each alone tells where it went
+	dropped, placeholders := messages.toolCallFixes()
+	var output int
+	add := func(index int, msg types.Message, note string) error {
+		entry := ConvertEntry{Index: index, Message: msg, Output: -1, Pair: -1, Note: note}
+		if note == "" || index < 0 {
+			n, err := convertedCount(Messages{msg}, apiShape)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				entry.Output = output
+				output += n
+			} else {
+				entry.Note = droppedReason(msg)
+			}
+		}
+		result.Entries = append(result.Entries, entry)
+		return nil
+	}
+	addPlaceholders := func(at int) error {
+		for _, msg := range placeholders[at] {
+			if err := add(-1, msg, "synthesized: placeholder result for tool call without one"); err != nil {
+				return fmt.Errorf("placeholder for %s: %w", msg.ToolUseID, err)
+			}
+		}
+		return nil
+	}
+	for i, msg := range messages {
+		if err := addPlaceholders(i); err != nil {
+			return nil, err
+		}
+		var note string
+		if dropped[i] {
+			note = "dropped: tool result without tool call"
+		}
+		if err := add(i, msg, note); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+	}
+	if err := addPlaceholders(len(messages)); err != nil {
+		return nil, err
+	}
+	pairToolCalls(result.Entries)
+	return result, nil
+}
+
+func convertedCount(messages Messages, apiShape providers.APIShape) (int, error) {
+	switch apiShape {
+	case providers.APIShapeOpenAI:
+		msgs, _, err := messages.toOpenAI(false)
+		return len(msgs), err
+	case providers.APIShapeAnthropic:
+		msgs, _, err := messages.toAnthropic()
+		return len(msgs), err
+	case providers.APIShapeGemini:
+		msgs, _, err := messages.toGemini()
+		return len(msgs), err
+	}
+	return 0, fmt.Errorf("unsupported provider: %s", apiShape)
+}
+
+func droppedReason(msg types.Message) string {
+	switch {
+	case msg.IsPartial():
+		return "dropped: partial tool call preview"
+	case msg.Role == types.Role_System:
+		return "moved to system prompt"
+	case !msg.Type.HistorySendable():
+		return fmt.Sprintf("dropped: %s is not sent to the model", msg.Type)
+	}
+	return fmt.Sprintf("dropped: unsupported role %q", msg.Role)
+}
+
+// pairToolCalls links each converted tool result to the
+// first unpaired tool call with the same id before it
+func pairToolCalls(entries []ConvertEntry) {
+	var pending []int
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Output < 0 {
+			continue
+		}
+		switch entry.Message.Type {
+		case types.MsgType_ToolCall:
+			pending = append(pending, i)
+		case types.MsgType_ToolResult:
+			for j, call := range pending {
+				if entries[call].Message.ToolUseID == entry.Message.ToolUseID {
+					entry.Pair = call
+					entries[call].Pair = i
+					pending = append(pending[:j], pending[j+1:]...)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestConvertMessagesPairsToolCalls(t *testing.T) {
+	messages := []types.Message{
+		{Type: types.MsgType_Msg, Role: types.Role_System, Content: "be brief"},
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"relative_workspace_path":"."}`},
+		{Type: types.MsgType_Info, Content: "running list_dir"},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"files":["a.go"]}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_9", Content: `{"files":[]}`},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "a.go"},
+	}
+
+	result, err := ConvertMessages(messages, providers.APIShapeOpenAI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.SystemPrompts) != 1 {
+		t.Errorf("expected 1 system prompt, got %v", result.SystemPrompts)
+	}
+	wants := []struct {
+		output int
+		pair   int
+		note   string
+	}{
+		{-1, -1, "moved to system prompt"},
+		{0, -1, ""},
+		{1, 4, ""},
+		{-1, -1, "dropped: info is not sent to the model"},
+		{2, 2, ""},
+		{-1, -1, "dropped: tool result without tool call"},
+		{3, -1, ""},
+	}
+	for i, want := range wants {
+		entry := result.Entries[i]
+		if entry.Output != want.output || entry.Pair != want.pair || entry.Note != want.note {
+			t.Errorf("entry %d: expected output %d pair %d note %q, got %d %d %q", i, want.output, want.pair, want.note, entry.Output, entry.Pair, entry.Note)
+		}
+	}
+
+	// the tool call and its result share the id in the converted messages
+	data, err := json.Marshal(result.Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var converted []struct {
+		Role       string `json:"role"`
+		ToolCallID string `json:"tool_call_id"`
+		ToolCalls  []struct {
+			ID string `json:"id"`
+		} `json:"tool_calls"`
+	}
+	if err := json.Unmarshal(data, &converted); err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 4 {
+		t.Fatalf("expected 4 converted messages, got %d", len(converted))
+	}
+	if len(converted[1].ToolCalls) != 1 || converted[1].ToolCalls[0].ID != "call_1" {
+		t.Errorf("expected tool call call_1, got %+v", converted[1])
+	}
+	if converted[2].Role != "tool" || converted[2].ToolCallID != "call_1" {
+		t.Errorf("expected tool result of call_1, got %+v", converted[2])
+	}
+}
+
+func TestConvertMessagesToolCallWithoutResult(t *testing.T) {
+	messages := []types.Message{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: "toolu_1", Content: `{}`},
+	}
+	result, err := ConvertMessages(messages, providers.APIShapeAnthropic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(result.Entries))
+	}
+	if pair := result.Entries[1].Pair; pair != 2 {
+		t.Errorf("expected tool call paired with entry 2, got %d", pair)
+	}
+	placeholder := result.Entries[2]
+	if placeholder.Index != -1 || placeholder.Output != 2 || placeholder.Message.ToolUseID != "toolu_1" {
+		t.Errorf("expected placeholder result of toolu_1 at output 2, got %+v", placeholder)
+	}
+	if placeholder.Note != "synthesized: placeholder result for tool call without one" {
+		t.Errorf("expected synthesized note, got %q", placeholder.Note)
+	}
+}
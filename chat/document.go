@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+const (
+	mediaTypePDF  = "application/pdf"
+	mediaTypeText = "text/plain"
+)
+
+// loadDocuments reads the file of each document without data,
+// filling in its title and media type
+func loadDocuments(docs []types.Document) ([]types.Document, error) {
+	loaded := make([]types.Document, 0, len(docs))
+	for _, doc := range docs {
+		if len(doc.Data) == 0 {
+			if doc.File == "" {
+				return nil, fmt.Errorf("document requires file or data")
+			}
+			data, err := os.ReadFile(doc.File)
+			if err != nil {
+				return nil, fmt.Errorf("read document %s: %w", doc.File, err)
+			}
+			doc.Data = data
+		}
+		if doc.Title == "" && doc.File != "" {
+			doc.Title = filepath.Base(doc.File)
+		}
+		if doc.MediaType == "" {
+			doc.MediaType = detectDocumentMediaType(doc.File, doc.Data)
+		}
+		switch doc.MediaType {
+		case mediaTypePDF:
+		case mediaTypeText:
+			if !utf8.Valid(doc.Data) {
+				return nil, fmt.Errorf("document %s: neither PDF nor UTF-8 text", doc.Title)
+			}
+		default:
+			return nil, fmt.Errorf("document %s: unsupported media type %s, expect %s or %s", doc.Title, doc.MediaType, mediaTypePDF, mediaTypeText)
+		}
+		loaded = append(loaded, doc)
+	}
+	return loaded, nil
+}
+
+func detectDocumentMediaType(file string, data []byte) string {
+	if strings.EqualFold(filepath.Ext(file), ".pdf") || http.DetectContentType(data) == mediaTypePDF {
+		return mediaTypePDF
+	}
+	return mediaTypeText
+}
+
+// documentBlocksAnthropic converts docs to document blocks with citations enabled
+func documentBlocksAnthropic(docs []types.Document) []anthropic.ContentBlockParamUnion {
+	blocks := make([]anthropic.ContentBlockParamUnion, 0, len(docs))
+	for _, doc := range docs {
+		var block anthropic.ContentBlockParamUnion
+		if doc.MediaType == mediaTypePDF {
+			block = anthropic.NewDocumentBlock(anthropic.Base64PDFSourceParam{
+				Data: base64.StdEncoding.EncodeToString(doc.Data),
+			})
+		} else {
+			block = anthropic.NewDocumentBlock(anthropic.PlainTextSourceParam{
+				Data: string(doc.Data),
+			})
+		}
+		block.OfDocument.Citations = anthropic.CitationsConfigParam{Enabled: param.NewOpt(true)}
+		if doc.Title != "" {
+			block.OfDocument.Title = param.NewOpt(doc.Title)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// citationsMetadataAnthropic converts the citations of a text block, nil if there is none
+func citationsMetadataAnthropic(citations []anthropic.TextCitationUnion) *types.CitationsMetadata {
+	if len(citations) == 0 {
+		return nil
+	}
+	converted := make([]types.Citation, 0, len(citations))
+	for _, citation := range citations {
+		converted = append(converted, types.Citation{
+			Type:            citation.Type,
+			CitedText:       citation.CitedText,
+			DocumentIndex:   int(citation.DocumentIndex),
+			DocumentTitle:   citation.DocumentTitle,
+			StartCharIndex:  int(citation.StartCharIndex),
+			EndCharIndex:    int(citation.EndCharIndex),
+			StartPageNumber: int(citation.StartPageNumber),
+			EndPageNumber:   int(citation.EndPageNumber),
+			StartBlockIndex: int(citation.StartBlockIndex),
+			EndBlockIndex:   int(citation.EndBlockIndex),
+		})
+	}
+	return &types.CitationsMetadata{Citations: converted}
+}
+
+// citationSource formats the document and location a citation refers to
+func citationSource(citation types.Citation) string {
+	title := citation.DocumentTitle
+	if title == "" {
+		title = fmt.Sprintf("document %d", citation.DocumentIndex)
+	}
+	switch citation.Type {
+	case "page_location":
+		// the end page is exclusive
+		if citation.EndPageNumber-citation.StartPageNumber > 1 {
+			return fmt.Sprintf("%s, pages %d-%d", title, citation.StartPageNumber, citation.EndPageNumber-1)
+		}
+		return fmt.Sprintf("%s, page %d", title, citation.StartPageNumber)
+	case "char_location":
+		return fmt.Sprintf("%s, chars %d-%d", title, citation.StartCharIndex, citation.EndCharIndex)
+	}
+	return title
+}
@@ -0,0 +1,138 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestDocumentCitations(t *testing.T) {
+	var request struct {
+		Messages []struct {
+			Content []map[string]interface{} `json:"content"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &request)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-7-sonnet","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":"","citations":[]}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"citations_delta","citation":{"type":"char_location","cited_text":"The grass is green.","document_index":0,"document_title":"notes.txt","start_char_index":0,"end_char_index":19}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"the grass is green"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, event := range events {
+			var typ struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal([]byte(event), &typ)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ.Type, event)
+		}
+	}))
+	defer server.Close()
+
+	doc := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(doc, []byte("The grass is green. The sky is blue."), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(Config{
+		Model:   "claude-3-7-sonnet",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var msgs []types.Message
+	_, err = client.Chat(context.Background(), "what color is the grass?",
+		WithDocuments(doc),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_Msg {
+				msgs = append(msgs, event)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	if len(request.Messages) != 1 || len(request.Messages[0].Content) != 2 {
+		t.Fatalf("expected a user message with document and text blocks, got %+v", request.Messages)
+	}
+	document := request.Messages[0].Content[0]
+	if document["type"] != "document" || document["title"] != "notes.txt" {
+		t.Errorf("unexpected document block: %v", document)
+	}
+	if citations, _ := document["citations"].(map[string]interface{}); citations["enabled"] != true {
+		t.Errorf("expected citations enabled, got %v", document["citations"])
+	}
+	if source, _ := document["source"].(map[string]interface{}); source["type"] != "text" || source["data"] != "The grass is green. The sky is blue." {
+		t.Errorf("unexpected document source: %v", document["source"])
+	}
+
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 msg event, got %d", len(msgs))
+	}
+	citations := msgs[0].Metadata.Citations
+	if citations == nil || len(citations.Citations) != 1 {
+		t.Fatalf("expected 1 citation, got %+v", citations)
+	}
+	want := types.Citation{
+		Type:          "char_location",
+		CitedText:     "The grass is green.",
+		DocumentTitle: "notes.txt",
+		EndCharIndex:  19,
+	}
+	if citations.Citations[0] != want {
+		t.Errorf("expected %+v, got %+v", want, citations.Citations[0])
+	}
+}
+
+func TestDocumentsRequireAnthropic(t *testing.T) {
+	client, err := NewClient(Config{Model: "gpt-4o", Token: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Chat(context.Background(), "hello", WithDocuments("notes.txt"))
+	if err == nil {
+		t.Errorf("expected error for documents with OpenAI")
+	}
+}
+
+func TestLoadDocuments(t *testing.T) {
+	dir := t.TempDir()
+	pdf := filepath.Join(dir, "paper")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(dir, "image.bin")
+	if err := os.WriteFile(binary, []byte{0xff, 0xfe, 0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := loadDocuments([]types.Document{{File: pdf}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs[0].MediaType != mediaTypePDF || docs[0].Title != "paper" {
+		t.Errorf("expected PDF titled paper, got %s %s", docs[0].MediaType, docs[0].Title)
+	}
+	if _, err := loadDocuments([]types.Document{{File: binary}}); err == nil {
+		t.Errorf("expected error for binary document")
+	}
+}
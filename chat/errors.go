@@ -0,0 +1,188 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+	"github.com/xhd2015/kode-ai/providers"
+	"google.golang.org/genai"
+)
+
+// ErrorKind classifies a failed provider API call
+type ErrorKind string
+
+const (
+	ErrorKindUnknown       ErrorKind = "unknown"
+	ErrorKindAuth          ErrorKind = "auth"
+	ErrorKindRateLimit     ErrorKind = "rate_limit"
+	ErrorKindContextLength ErrorKind = "context_length"
+	ErrorKindServerError   ErrorKind = "server_error"
+	ErrorKindNetwork       ErrorKind = "network"
+)
+
+// ChatError is returned by ChatRequest when a provider API call fails,
+// use errors.As to inspect it
+type ChatError struct {
+	Kind       ErrorKind
+	APIShape   providers.APIShape
+	StatusCode int // 0 if no HTTP response was received
+	Err        error
+}
+
+func (e *ChatError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ChatError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether retrying the same request may succeed
+func (e *ChatError) Retryable() bool {
+	switch e.Kind {
+	case ErrorKindRateLimit, ErrorKindServerError, ErrorKindNetwork:
+		return true
+	}
+	return false
+}
+
+// ToolError is returned by ChatRequest when a tool fails with Request.AbortOnToolError set
+type ToolError struct {
+	ToolName  string
+	ToolUseID string
+	Err       string
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("tool %s failed: %s", e.ToolName, e.Err)
+}
+
+// messages providers use to report an exceeded context window
+var contextLengthMarkers = []string{
+	"context_length_exceeded",
+	"context length",
+	"context window",
+	"maximum context",
+	"prompt is too long",
+	"too many tokens",
+	"input token count",
+	"exceeds the maximum number of tokens",
+}
+
+// newChatError classifies err from the provider SDK, keeping err as the message
+func newChatError(apiShape providers.APIShape, err error) *ChatError {
+	statusCode := apiErrorStatusCode(err)
+	return &ChatError{
+		Kind:       classifyError(statusCode, err),
+		APIShape:   apiShape,
+		StatusCode: statusCode,
+		Err:        err,
+	}
+}
+
+// newChatError classifies err with the configured ErrorClassifier, falling back to the default classification
+func (c *Client) newChatError(err error) *ChatError {
+	if c.config.ErrorClassifier == nil {
+		return newChatError(c.apiShape, err)
+	}
+	classified := c.config.ErrorClassifier(apiErrorResponse(err), err)
+	if classified == nil {
+		return newChatError(c.apiShape, err)
+	}
+	var chatErr *ChatError
+	if !errors.As(classified, &chatErr) {
+		return newChatError(c.apiShape, classified)
+	}
+	if chatErr.Kind == "" {
+		chatErr.Kind = ErrorKindUnknown
+	}
+	if chatErr.APIShape == "" {
+		chatErr.APIShape = c.apiShape
+	}
+	if chatErr.StatusCode == 0 {
+		chatErr.StatusCode = apiErrorStatusCode(err)
+	}
+	if chatErr.Err == nil {
+		chatErr.Err = err
+	}
+	return chatErr
+}
+
+// apiErrorResponse returns the HTTP response of err, gemini errors do not carry one
+func apiErrorResponse(err error) *http.Response {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.Response
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.Response
+	}
+	return nil
+}
+
+func apiErrorStatusCode(err error) int {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode
+	}
+	var geminiErr genai.APIError
+	if errors.As(err, &geminiErr) {
+		return geminiErr.Code
+	}
+	var geminiErrPtr *genai.APIError
+	if errors.As(err, &geminiErrPtr) {
+		return geminiErrPtr.Code
+	}
+	return 0
+}
+
+func classifyError(statusCode int, err error) ErrorKind {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorKindAuth
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorKindRateLimit
+	case statusCode == http.StatusRequestEntityTooLarge:
+		return ErrorKindContextLength
+	case statusCode == http.StatusBadRequest:
+		if isContextLengthMessage(err.Error()) {
+			return ErrorKindContextLength
+		}
+		return ErrorKindUnknown
+	case statusCode >= 500:
+		// including 529 overloaded
+		return ErrorKindServerError
+	case statusCode != 0:
+		return ErrorKindUnknown
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorKindUnknown
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorKindNetwork
+	}
+	return ErrorKindUnknown
+}
+
+func isContextLengthMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, marker := range contextLengthMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestChatErrorClassification(t *testing.T) {
+	models := []string{"gpt-4o", "claude-3-7-sonnet", "gemini-2.5-pro"}
+	tests := []struct {
+		name       string
+		statusCode int
+		message    string
+		wantKind   ErrorKind
+	}{
+		{"Auth", http.StatusUnauthorized, "invalid api key", ErrorKindAuth},
+		{"RateLimit", http.StatusTooManyRequests, "rate limit exceeded", ErrorKindRateLimit},
+		{"ContextLength", http.StatusBadRequest, "This model's maximum context length is 128000 tokens", ErrorKindContextLength},
+		{"BadRequest", http.StatusBadRequest, "invalid parameter", ErrorKindUnknown},
+		{"ServerError", http.StatusInternalServerError, "internal error", ErrorKindServerError},
+		{"Overloaded", 529, "overloaded", ErrorKindServerError},
+	}
+	for _, model := range models {
+		for _, tt := range tests {
+			t.Run(model+"/"+tt.name, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					// disable SDK retries
+					w.Header().Set("x-should-retry", "false")
+					w.WriteHeader(tt.statusCode)
+					fmt.Fprintf(w, `{"error":{"code":%d,"type":"error","message":%q}}`, tt.statusCode, tt.message)
+				}))
+				defer server.Close()
+
+				client, err := NewClient(Config{
+					Model:   model,
+					Token:   "test-token",
+					BaseURL: server.URL,
+				})
+				if err != nil {
+					t.Fatalf("failed to create client: %v", err)
+				}
+				_, err = client.Chat(context.Background(), "Hello")
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				var chatErr *ChatError
+				if !errors.As(err, &chatErr) {
+					t.Fatalf("expected ChatError, got %T: %v", err, err)
+				}
+				if chatErr.Kind != tt.wantKind {
+					t.Errorf("expected kind %s, got %s: %v", tt.wantKind, chatErr.Kind, err)
+				}
+				if chatErr.StatusCode != tt.statusCode {
+					t.Errorf("expected status %d, got %d", tt.statusCode, chatErr.StatusCode)
+				}
+			})
+		}
+	}
+}
+
+func TestChatErrorNetwork(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	baseURL := server.URL
+	server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gemini-2.5-pro",
+		Token:   "test-token",
+		BaseURL: baseURL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.Chat(context.Background(), "Hello")
+	var chatErr *ChatError
+	if !errors.As(err, &chatErr) {
+		t.Fatalf("expected ChatError, got %T: %v", err, err)
+	}
+	if chatErr.Kind != ErrorKindNetwork {
+		t.Errorf("expected kind %s, got %s: %v", ErrorKindNetwork, chatErr.Kind, err)
+	}
+	if !chatErr.Retryable() {
+		t.Errorf("expected network error to be retryable")
+	}
+}
+
+func TestChatErrorCustomClassifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-should-retry", "false")
+		w.Header().Set("X-Gateway-Reason", "quota")
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, `{"error":{"code":418,"type":"error","message":"gateway quota exceeded"}}`)
+	}))
+	defer server.Close()
+
+	classifier := func(resp *http.Response, err error) error {
+		if resp != nil && resp.StatusCode == http.StatusTeapot && resp.Header.Get("X-Gateway-Reason") == "quota" {
+			return &ChatError{Kind: ErrorKindRateLimit, Err: err}
+		}
+		return nil
+	}
+
+	for _, model := range []string{"gpt-4o", "claude-3-7-sonnet"} {
+		t.Run(model, func(t *testing.T) {
+			client, err := NewClient(Config{
+				Model:           model,
+				Token:           "test-token",
+				BaseURL:         server.URL,
+				ErrorClassifier: classifier,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			_, err = client.Chat(context.Background(), "Hello")
+			var chatErr *ChatError
+			if !errors.As(err, &chatErr) {
+				t.Fatalf("expected ChatError, got %T: %v", err, err)
+			}
+			if chatErr.Kind != ErrorKindRateLimit {
+				t.Errorf("expected kind %s, got %s: %v", ErrorKindRateLimit, chatErr.Kind, err)
+			}
+			if chatErr.StatusCode != http.StatusTeapot {
+				t.Errorf("expected status %d, got %d", http.StatusTeapot, chatErr.StatusCode)
+			}
+			if chatErr.APIShape == "" {
+				t.Errorf("expected api shape to be filled")
+			}
+			if !chatErr.Retryable() {
+				t.Errorf("expected classified rate limit to be retryable")
+			}
+		})
+	}
+
+	// nil from the classifier keeps the default classification
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+		ErrorClassifier: func(resp *http.Response, err error) error {
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.Chat(context.Background(), "Hello")
+	var chatErr *ChatError
+	if !errors.As(err, &chatErr) || chatErr.Kind != ErrorKindUnknown {
+		t.Errorf("expected unknown ChatError, got %T: %v", err, err)
+	}
+}
+
+func TestAbortOnToolError(t *testing.T) {
+	failingTool := func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		return types.ToolResult{Error: "disk full"}, true, nil
+	}
+	for _, abort := range []bool{true, false} {
+		t.Run(fmt.Sprintf("abort=%v", abort), func(t *testing.T) {
+			var requests int
+			apiServer := startToolCallServer(t)
+			counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				apiServer.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer counting.Close()
+
+			client, err := NewClient(Config{
+				Model:   "gpt-4o",
+				Token:   "test-token",
+				BaseURL: counting.URL,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+				WithToolCallback(failingTool),
+				WithMaxRounds(2),
+				WithAbortOnToolError(abort),
+			)
+			if !abort {
+				if err != nil {
+					t.Fatalf("expected the error sent to the model, got %v", err)
+				}
+				if requests != 2 {
+					t.Errorf("expected 2 requests, got %d", requests)
+				}
+				return
+			}
+			var toolErr *ToolError
+			if !errors.As(err, &toolErr) {
+				t.Fatalf("expected ToolError, got %v", err)
+			}
+			if toolErr.ToolName != "get_weather" || toolErr.ToolUseID != "call_1" || toolErr.Err != "disk full" {
+				t.Errorf("unexpected tool error: %+v", toolErr)
+			}
+			if requests != 1 {
+				t.Errorf("expected no request after the tool failed, got %d requests", requests)
+			}
+		})
+	}
+}
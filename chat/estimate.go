@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+	"google.golang.org/genai"
+)
+
+// approxBytesPerToken is the rough size of a token in English text and JSON
+const approxBytesPerToken = 4
+
+// estimateRequest is the assembled request to estimate
+type estimateRequest struct {
+	messages *MessagesUnion
+
+	toolsOpenAI []openai.ChatCompletionToolParam
+
+	systemAnthropic []anthropic.TextBlockParam
+	toolsAnthropic  []anthropic.ToolUnionParam
+
+	systemGemini *genai.Content
+	toolsGemini  []*genai.Tool
+}
+
+// estimate counts the input tokens of the assembled request without calling the completion API.
+// Anthropic and Gemini count with their token counting API, OpenAI has none, so its
+// tokens are approximated from the request size
+func (c *Client) estimate(ctx context.Context, clients *ClientUnion, req estimateRequest) (*types.TokenEstimate, error) {
+	var inputTokens int64
+	var approximate bool
+	switch c.apiShape {
+	case providers.APIShapeOpenAI:
+		data, err := json.Marshal(struct {
+			Messages []openai.ChatCompletionMessageParamUnion `json:"messages"`
+			Tools    []openai.ChatCompletionToolParam         `json:"tools,omitempty"`
+		}{req.messages.OpenAI, req.toolsOpenAI})
+		if err != nil {
+			return nil, err
+		}
+		inputTokens = int64((len(data) + approxBytesPerToken - 1) / approxBytesPerToken)
+		approximate = true
+	case providers.APIShapeAnthropic:
+		tools := make([]anthropic.MessageCountTokensToolUnionParam, 0, len(req.toolsAnthropic))
+		for _, tool := range req.toolsAnthropic {
+			tools = append(tools, anthropic.MessageCountTokensToolUnionParam{OfTool: tool.OfTool})
+		}
+		result, err := clients.Anthropic.Messages.CountTokens(ctx, anthropic.MessageCountTokensParams{
+			Model:    anthropic.Model(c.config.Model),
+			Messages: req.messages.Anthropic,
+			System:   anthropic.MessageCountTokensParamsSystemUnion{OfTextBlockArray: req.systemAnthropic},
+			Tools:    tools,
+		})
+		if err != nil {
+			return nil, c.newChatError(fmt.Errorf("anthropic count tokens: %w", err))
+		}
+		inputTokens = result.InputTokens
+	case providers.APIShapeGemini:
+		result, err := clients.Gemini.Models.CountTokens(ctx, c.config.Model, req.messages.Gemini, &genai.CountTokensConfig{
+			HTTPOptions: &genai.HTTPOptions{
+				APIVersion: "v1",
+				Headers: http.Header{
+					"Authorization": []string{fmt.Sprintf("Bearer %s", c.config.Token)},
+				},
+			},
+			SystemInstruction: req.systemGemini,
+			Tools:             req.toolsGemini,
+		})
+		if err != nil {
+			return nil, c.newChatError(fmt.Errorf("Gemini count tokens: %w", err))
+		}
+		inputTokens = int64(result.TotalTokens)
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", c.apiShape)
+	}
+
+	estimate := &types.TokenEstimate{
+		InputTokens: inputTokens,
+		Approximate: approximate,
+	}
+	// no output, so only the input price counts
+	cost, ok := providers.ComputeCost(c.apiShape, c.config.Model, types.TokenUsage{
+		Input: inputTokens,
+		Total: inputTokens,
+		InputBreakdown: types.TokenUsageInputBreakdown{
+			NonCacheRead: inputTokens,
+		},
+	})
+	if ok {
+		estimate.CostUSD = cost.TotalUSD
+	}
+	return estimate, nil
+}
@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestEstimateDoesNotCallCompletion(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unexpected call", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	handler := NewCliHandler(client, CliOptions{})
+	var handleErr error
+	output := captureStdout(t, func() {
+		handleErr = handler.HandleCli(context.Background(), strings.Repeat("hello ", 100),
+			WithEstimateOnly(true),
+			WithTools("list_dir"),
+		)
+	})
+	if handleErr != nil {
+		t.Fatalf("estimate: %v", handleErr)
+	}
+	if calls != 0 {
+		t.Errorf("expected no API call, got %d", calls)
+	}
+	if !strings.Contains(output, "Estimated input tokens: ~") || !strings.Contains(output, "input cost: $") {
+		t.Errorf("expected estimate printed, got %q", output)
+	}
+	if strings.Contains(output, "Prompt cache") {
+		t.Errorf("expected no chat events, got %q", output)
+	}
+}
+
+func TestEstimateAnthropicCountTokens(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"input_tokens":1234}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "claude-3-7-sonnet",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	resp, err := client.Chat(context.Background(), "hello", WithEstimateOnly(true))
+	if err != nil {
+		t.Fatalf("estimate: %v", err)
+	}
+	if len(paths) != 1 || paths[0] != "/v1/messages/count_tokens" {
+		t.Errorf("expected only count_tokens called, got %v", paths)
+	}
+	if resp.Estimate == nil || resp.Estimate.InputTokens != 1234 || resp.Estimate.Approximate {
+		t.Errorf("expected exact estimate of 1234 tokens, got %+v", resp.Estimate)
+	}
+}
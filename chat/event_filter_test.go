@@ -0,0 +1,52 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestEventFilter(t *testing.T) {
+	apiServer := startToolCallServer(t)
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var emitted []types.Message
+	resp, err := client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(handledToolCallback),
+		WithMaxRounds(2),
+		WithEventFilter(types.MsgType_ToolCall, types.MsgType_Msg),
+		WithEventCallback(func(event types.Message) {
+			emitted = append(emitted, event)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	var toolCalls, msgs int
+	for _, event := range emitted {
+		switch event.Type {
+		case types.MsgType_ToolCall:
+			toolCalls++
+		case types.MsgType_Msg:
+			msgs++
+		default:
+			t.Errorf("expected %s filtered out, got %+v", event.Type, event)
+		}
+	}
+	if toolCalls != 1 || msgs == 0 {
+		t.Errorf("expected 1 tool call and some msgs, got %d tool calls and %d msgs", toolCalls, msgs)
+	}
+	// the filter only applies to events, not the response
+	if resp.TokenUsage.Total == 0 {
+		t.Errorf("expected token usage in response, got %+v", resp.TokenUsage)
+	}
+}
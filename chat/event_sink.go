@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// eventSinkBufferSize is the number of events queued before new ones are dropped
+const eventSinkBufferSize = 64
+
+const eventSinkTimeout = 10 * time.Second
+
+// eventSink POSTs each event as JSON to a URL in the background,
+// a slow or failing sink never blocks the chat
+type eventSink struct {
+	url        string
+	httpClient *http.Client
+	onError    func(err error)
+
+	events  chan types.Message
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func newEventSink(url string, onError func(err error)) *eventSink {
+	c := &eventSink{
+		url:        url,
+		httpClient: &http.Client{Timeout: eventSinkTimeout},
+		onError:    onError,
+		events:     make(chan types.Message, eventSinkBufferSize),
+		done:       make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Wrap returns a callback sending each event to the sink after calling callback
+func (c *eventSink) Wrap(callback types.EventCallback) types.EventCallback {
+	return func(event types.Message) {
+		if callback != nil {
+			callback(event)
+		}
+		select {
+		case c.events <- event:
+		default:
+			c.dropped.Add(1)
+		}
+	}
+}
+
+// Close waits for the queued events to be sent
+func (c *eventSink) Close() {
+	close(c.events)
+	<-c.done
+	if dropped := c.dropped.Load(); dropped > 0 {
+		c.onError(fmt.Errorf("dropped %d event(s), sink too slow", dropped))
+	}
+}
+
+func (c *eventSink) run() {
+	defer close(c.done)
+	for event := range c.events {
+		if err := c.post(event); err != nil {
+			c.onError(err)
+		}
+	}
+}
+
+func (c *eventSink) post(event types.Message) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Post(c.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post %s event: %s", event.Type, resp.Status)
+	}
+	return nil
+}
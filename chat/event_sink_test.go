@@ -0,0 +1,138 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func startToolCallServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mutex sync.Mutex
+	var n int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		n++
+		first := n == 1
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if first {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEventSink(t *testing.T) {
+	apiServer := startToolCallServer(t)
+
+	var mutex sync.Mutex
+	var received []types.Message
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var event types.Message
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		mutex.Lock()
+		received = append(received, event)
+		mutex.Unlock()
+	}))
+	defer sink.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var emitted []types.Message
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(handledToolCallback),
+		WithMaxRounds(2),
+		WithEventSink(sink.URL),
+		WithEventCallback(func(event types.Message) {
+			emitted = append(emitted, event)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	// all events are flushed when the chat returns
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != len(emitted) {
+		t.Fatalf("expected %d events posted, got %d", len(emitted), len(received))
+	}
+	for i, event := range received {
+		if event.Type != emitted[i].Type || event.Content != emitted[i].Content {
+			t.Errorf("event %d: expected %s %q, got %s %q", i, emitted[i].Type, emitted[i].Content, event.Type, event.Content)
+		}
+	}
+	for _, typ := range []types.MsgType{types.MsgType_ToolCall, types.MsgType_ToolResult, types.MsgType_Msg} {
+		var found bool
+		for _, event := range received {
+			if event.Type == typ {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected a %s event posted", typ)
+		}
+	}
+}
+
+func TestEventSinkFailureDoesNotAbort(t *testing.T) {
+	apiServer := startToolCallServer(t)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer sink.Close()
+
+	var mutex sync.Mutex
+	var sinkErrors int
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+		Logger: types.LoggerFunc(func(ctx context.Context, logType types.LogType, format string, args ...interface{}) {
+			if logType == types.LogType_Error {
+				mutex.Lock()
+				sinkErrors++
+				mutex.Unlock()
+			}
+		}),
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(handledToolCallback),
+		WithMaxRounds(2),
+		WithEventSink(sink.URL),
+	)
+	if err != nil {
+		t.Fatalf("expected chat to succeed despite sink failures, got %v", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if sinkErrors == 0 {
+		t.Errorf("expected sink failures to be logged")
+	}
+}
@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+// readGitDiff returns the git diff of dir against rev, wrapped in a
+// <git_diff> tag, or empty if there are no changes. An empty rev means
+// HEAD, so both staged and unstaged changes are included.
+// xgo/support/git has no diff, git is run through support/cmd
+func readGitDiff(dir string, rev string) (string, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	// rev may come from a server client, it must not be taken as an option of git diff
+	if strings.HasPrefix(rev, "-") {
+		return "", fmt.Errorf("invalid git diff rev %q, must not start with -", rev)
+	}
+	var stderr strings.Builder
+	diff, err := cmd.New().Dir(dir).Stderr(&stderr).Output("git", "diff", rev, "--")
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git diff %s: %s", rev, msg)
+		}
+		return "", fmt.Errorf("git diff %s: %w", rev, err)
+	}
+	if strings.TrimSpace(diff) == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("<git_diff rev=%q>\n%s\n</git_diff>", rev, strings.TrimRight(diff, "\n")), nil
+}
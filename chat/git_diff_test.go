@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestGitDiffContext(t *testing.T) {
+	dir := initGitDiffRepo(t)
+
+	var requestBody string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		requestBody = string(data)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"LGTM"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer apiServer.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.Chat(context.Background(), "review my changes", WithGitDiff(""), WithDefaultToolCwd(dir))
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	for _, want := range []string{`git_diff rev=\"HEAD\"`, `+func reviewMe() {}`, "review my changes"} {
+		if !strings.Contains(requestBody, want) {
+			t.Errorf("expected %s in request, got %s", want, requestBody)
+		}
+	}
+
+	if _, err := client.Chat(context.Background(), "review", WithGitDiff("no-such-rev"), WithDefaultToolCwd(dir)); err == nil {
+		t.Errorf("expected error diffing against an unknown rev")
+	}
+
+	output := filepath.Join(dir, "output.diff")
+	if _, err := client.Chat(context.Background(), "review", WithGitDiff("--output="+output), WithDefaultToolCwd(dir)); err == nil {
+		t.Errorf("expected error for a rev starting with -")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("expected no file written by git diff, got %v", err)
+	}
+}
+
+func TestGitDiffContextWithServer(t *testing.T) {
+	dir := initGitDiffRepo(t)
+
+	client, err := NewClient(Config{
+		Model: "gpt-4o",
+		Token: "test-token",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var serverReq types.Request
+	chatWithServer := func(ctx context.Context, server string, req types.Request) (*types.Response, error) {
+		serverReq = req
+		return &types.Response{}, nil
+	}
+	handler := NewCliHandler(client, CliOptions{})
+	err = handler.HandleCliWithServer(context.Background(), "review my changes", "ws://server", chatWithServer, WithGitDiff(""), WithDefaultToolCwd(dir))
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	// the diff is taken on the client, the server gets its content
+	if serverReq.GitDiff || serverReq.GitDiffRev != "" {
+		t.Errorf("expected no git diff left to the server, got %v %q", serverReq.GitDiff, serverReq.GitDiffRev)
+	}
+	if len(serverReq.History) != 1 || !strings.Contains(serverReq.History[0].Content, "+func reviewMe() {}") {
+		t.Errorf("expected the diff sent as history, got %+v", serverReq.History)
+	}
+}
+
+// initGitDiffRepo creates a git repo with an unstaged change to main.go
+func initGitDiffRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("requires git")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, output)
+		}
+	}
+	file := filepath.Join(dir, "main.go")
+	git("init", "-q")
+	if err := os.WriteFile(file, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "main.go")
+	git("commit", "-q", "-m", "init")
+	if err := os.WriteFile(file, []byte("package main\n\nfunc reviewMe() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCustomHeadersReachServer(t *testing.T) {
+	models := []string{"gpt-4o", "claude-3-7-sonnet", "gemini-2.5-pro"}
+	for _, model := range models {
+		t.Run(model, func(t *testing.T) {
+			var header http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Clone()
+				switch model {
+				case "claude-3-7-sonnet":
+					writeAnthropicSSE(w, `{"type":"text","text":""}`, `{"type":"text_delta","text":"done"}`, "end_turn")
+				case "gemini-2.5-pro":
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"done"}],"role":"model"},"finishReason":"STOP"}]}`)
+				default:
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClient(Config{
+				Model:   model,
+				Token:   "test-token",
+				BaseURL: server.URL,
+				Headers: map[string]string{
+					"X-Org-Id":      "org-1",
+					"X-Routing-Tag": "canary",
+				},
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			if _, err := client.Chat(context.Background(), "hello"); err != nil {
+				t.Fatalf("chat: %v", err)
+			}
+			if got := header.Get("X-Org-Id"); got != "org-1" {
+				t.Errorf("expected X-Org-Id org-1, got %q", got)
+			}
+			if got := header.Get("X-Routing-Tag"); got != "canary" {
+				t.Errorf("expected X-Routing-Tag canary, got %q", got)
+			}
+		})
+	}
+}
+
+func TestOpenAIOrganizationAndProjectHeaders(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:              "gpt-4o",
+		Token:              "test-token",
+		BaseURL:            server.URL,
+		OpenAIOrganization: "org-billing",
+		OpenAIProject:      "proj_1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.Chat(context.Background(), "hello"); err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if got := header.Get("OpenAI-Organization"); got != "org-billing" {
+		t.Errorf("expected OpenAI-Organization org-billing, got %q", got)
+	}
+	if got := header.Get("OpenAI-Project"); got != "proj_1" {
+		t.Errorf("expected OpenAI-Project proj_1, got %q", got)
+	}
+}
@@ -1,7 +1,6 @@
 package chat
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -25,25 +24,10 @@ func LoadHistory(filename string) ([]types.Message, error) {
 	}
 	defer file.Close()
 
-	var messages []types.Message
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		var msg types.Message
-		if err := json.Unmarshal([]byte(line), &msg); err != nil {
-			return nil, fmt.Errorf("parse history message: %w", err)
-		}
-		messages = append(messages, msg)
-	}
-
-	if err := scanner.Err(); err != nil {
+	messages, _, err := ReadRecord(file)
+	if err != nil {
 		return nil, fmt.Errorf("read history file: %w", err)
 	}
-
 	return messages, nil
 }
 
@@ -59,20 +43,7 @@ func SaveHistory(filename string, messages []types.Message) error {
 	}
 	defer file.Close()
 
-	for _, msg := range messages {
-		data, err := json.Marshal(msg)
-		if err != nil {
-			return fmt.Errorf("marshal message: %w", err)
-		}
-		if _, err := file.Write(data); err != nil {
-			return fmt.Errorf("write message: %w", err)
-		}
-		if _, err := file.WriteString("\n"); err != nil {
-			return fmt.Errorf("write newline: %w", err)
-		}
-	}
-
-	return nil
+	return WriteRecord(file, messages)
 }
 
 // AppendToHistory appends a single message to a history file
@@ -83,7 +54,7 @@ func AppendToHistory(filename string, message types.Message) error {
 
 	// Set timestamp if not already set
 	if message.Time == "" {
-		message.Time = time.Now().Format(time.RFC3339)
+		message.Time = types.Now().Format(time.RFC3339)
 	}
 
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
@@ -92,6 +63,17 @@ func AppendToHistory(filename string, message types.Message) error {
 	}
 	defer file.Close()
 
+	// a new record starts with the header
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat history file: %w", err)
+	}
+	if stat.Size() == 0 {
+		if err := writeRecordHeader(file); err != nil {
+			return err
+		}
+	}
+
 	data, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("marshal message: %w", err)
@@ -129,6 +111,31 @@ func GetLastUserMessage(messages []types.Message) *types.Message {
 	return nil
 }
 
+// LastTurns keeps the last n turns of messages, a turn starting at a user message
+// so tool calls stay paired with their results. System prompts before the
+// kept turns are kept too
+func LastTurns(messages []types.Message, n int) []types.Message {
+	start := len(messages)
+	for i := len(messages) - 1; i >= 0 && n > 0; i-- {
+		msg := messages[i]
+		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_User {
+			start = i
+			n--
+		}
+	}
+	if n > 0 {
+		// fewer turns than n
+		return messages
+	}
+	var kept []types.Message
+	for _, msg := range messages[:start] {
+		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_System {
+			kept = append(kept, msg)
+		}
+	}
+	return append(kept, messages[start:]...)
+}
+
 // GetSystemPrompts extracts all system prompts from message history
 func GetSystemPrompts(messages []types.Message) []string {
 	var prompts []string
@@ -144,7 +151,7 @@ func GetSystemPrompts(messages []types.Message) []string {
 func CreateMessage(msgType types.MsgType, role types.Role, model, content string) types.Message {
 	return types.Message{
 		Type:    msgType,
-		Time:    time.Now().Format(time.RFC3339),
+		Time:    types.Now().Format(time.RFC3339),
 		Role:    role,
 		Model:   model,
 		Content: content,
@@ -155,7 +162,7 @@ func CreateMessage(msgType types.MsgType, role types.Role, model, content string
 func CreateToolCallMessage(role types.Role, model, toolName, toolUseID, content string) types.Message {
 	return types.Message{
 		Type:      types.MsgType_ToolCall,
-		Time:      time.Now().Format(time.RFC3339),
+		Time:      types.Now().Format(time.RFC3339),
 		Role:      role,
 		Model:     model,
 		Content:   content,
@@ -168,11 +175,14 @@ func CreateToolCallMessage(role types.Role, model, toolName, toolUseID, content
 func CreateToolResultMessage(role types.Role, model, toolName, toolUseID, content string) types.Message {
 	return types.Message{
 		Type:      types.MsgType_ToolResult,
-		Time:      time.Now().Format(time.RFC3339),
+		Time:      types.Now().Format(time.RFC3339),
 		Role:      role,
 		Model:     model,
 		Content:   content,
 		ToolUseID: toolUseID,
 		ToolName:  toolName,
+		Metadata: types.Metadata{
+			Todos: todosMetadata(toolName, content),
+		},
 	}
 }
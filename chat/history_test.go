@@ -283,3 +283,20 @@ func TestAppendToHistoryEmptyFilename(t *testing.T) {
 		t.Errorf("expected no error for empty filename, got: %v", err)
 	}
 }
+
+func TestLastTurns(t *testing.T) {
+	messages := []types.Message{
+		{Type: types.MsgType_Msg, Role: types.Role_System, Content: "be brief"},
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "q1"},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "a1"},
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "q2"},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "a2"},
+	}
+	kept := LastTurns(messages, 1)
+	if len(kept) != 3 || kept[0].Content != "be brief" || kept[1].Content != "q2" {
+		t.Errorf("expected system prompt and the last turn, got %+v", kept)
+	}
+	if kept := LastTurns(messages, 5); len(kept) != len(messages) {
+		t.Errorf("expected all messages kept with fewer turns, got %+v", kept)
+	}
+}
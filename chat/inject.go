@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// injectedMsgQueue keeps the user messages injected by the client until
+// the next round boundary. They are taken from the stream as they arrive,
+// so the stream reader never blocks on them while a tool response is waited for
+type injectedMsgQueue struct {
+	reader types.StdinReader
+	stop   chan struct{}
+	done   chan struct{}
+
+	mutex sync.Mutex
+	msgs  []types.Message
+}
+
+// subscribeInjectedUserMsgs starts queueing the injected user messages,
+// reader being nil without a stream
+func subscribeInjectedUserMsgs(reader types.StdinReader) *injectedMsgQueue {
+	c := &injectedMsgQueue{
+		reader: reader,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if reader == nil {
+		close(c.done)
+		return c
+	}
+	ch := reader.Subscribe(types.InjectUserMsgStreamID)
+	go func() {
+		defer close(c.done)
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				if msg.Content == "" {
+					continue
+				}
+				msg.Type = types.MsgType_Msg
+				msg.Role = types.Role_User
+				msg.StreamID = ""
+				c.mutex.Lock()
+				c.msgs = append(c.msgs, msg)
+				c.mutex.Unlock()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// take returns the user messages injected so far without waiting
+func (c *injectedMsgQueue) take() []types.Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	msgs := c.msgs
+	c.msgs = nil
+	return msgs
+}
+
+// close stops queueing, not every reader closes the channel on Unsubscribe
+func (c *injectedMsgQueue) close() {
+	if c.reader == nil {
+		return
+	}
+	c.reader.Unsubscribe(types.InjectUserMsgStreamID)
+	close(c.stop)
+	<-c.done
+}
+
+// reportUnprocessedInjectedMsgs tells the user messages injected
+// after the chat stopped taking them were not sent to the model
+func reportUnprocessedInjectedMsgs(req types.Request, msgs []types.Message) {
+	if req.EventCallback == nil {
+		return
+	}
+	for _, msg := range msgs {
+		req.EventCallback(types.Message{
+			Type:    types.MsgType_Info,
+			Content: fmt.Sprintf("the chat ended before the injected message was processed: %s", msg.Content),
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/cli"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestCliInProcessLikeSubprocess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds kode")
+	}
+	kode := filepath.Join(t.TempDir(), "kode")
+	if out, err := exec.Command("go", "build", "-o", kode, "github.com/xhd2015/kode-ai/cmd/kode").CombinedOutput(); err != nil {
+		t.Fatalf("build kode: %v\n%s", err, out)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hi there"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer server.Close()
+
+	type outcome struct {
+		Events     []string
+		StopReason string
+		LastMsg    string
+	}
+	chat := func(opts ...cli.CliOption) outcome {
+		var result outcome
+		resp, err := cli.Chat(context.Background(), types.Request{
+			Model:        "gpt-4o",
+			Token:        "test-token",
+			BaseURL:      server.URL,
+			SystemPrompt: "be brief",
+			Message:      "Hello",
+			EventCallback: func(msg types.Message) {
+				if msg.Type == types.MsgType_Msg {
+					result.Events = append(result.Events, string(msg.Role)+": "+msg.Content)
+				}
+			},
+		}, opts...)
+		if err != nil {
+			t.Fatalf("chat: %v", err)
+		}
+		result.StopReason = resp.StopReason
+		result.LastMsg = resp.LastAssistantMsg
+		return result
+	}
+	subprocess := chat(cli.WithCli(kode))
+	inProcess := chat(cli.WithInProcess(Chat))
+	if subprocess.LastMsg == "" {
+		t.Fatalf("expected an answer, got %+v", subprocess)
+	}
+	if !reflect.DeepEqual(inProcess, subprocess) {
+		t.Errorf("expected in-process %+v to be the same as subprocess %+v", inProcess, subprocess)
+	}
+}
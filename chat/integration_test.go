@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,6 +16,12 @@ import (
 
 // startMockServer starts a mock server on a random available port and returns the base URL
 func startMockServer(t *testing.T, provider string) (string, func()) {
+	return startMockServerWithConfig(t, mock_server.Config{Provider: provider}, nil)
+}
+
+// startMockServerWithConfig is like startMockServer, with statusCallback observing each response status
+func startMockServerWithConfig(t *testing.T, config mock_server.Config, statusCallback func(status int)) (string, func()) {
+	provider := config.Provider
 	// Find an available port
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
@@ -24,10 +31,8 @@ func startMockServer(t *testing.T, provider string) (string, func()) {
 	listener.Close()
 
 	// Create mock server
-	mockServer := mock_server.NewMockServer(mock_server.Config{
-		Port:     port,
-		Provider: provider,
-	})
+	config.Port = port
+	mockServer := mock_server.NewMockServer(config)
 
 	// Create HTTP server
 	mux := http.NewServeMux()
@@ -49,9 +54,18 @@ func startMockServer(t *testing.T, provider string) (string, func()) {
 		mux.HandleFunc("/models/", mockServer.HandleGeminiMock)
 	}
 
+	var handler http.Handler = mux
+	if statusCallback != nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			mux.ServeHTTP(rec, r)
+			statusCallback(rec.status)
+		})
+	}
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Handler: handler,
 	}
 
 	// Start server in goroutine
@@ -85,6 +99,70 @@ func startMockServer(t *testing.T, provider string) (string, func()) {
 	return baseURL, cleanup
 }
 
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (c *statusRecorder) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func TestChatRetryInjectedFailures(t *testing.T) {
+	tests := []struct {
+		provider string
+		model    string
+	}{
+		{"openai", "gpt-4o"},
+		{"anthropic", "claude-3-7-sonnet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			var mutex sync.Mutex
+			var statuses []int
+			baseURL, cleanup := startMockServerWithConfig(t, mock_server.Config{
+				Provider:    tt.provider,
+				FailureRate: 0.5,
+				// seed 2 fails the first request, then succeeds within the SDK's default retries
+				Seed:    2,
+				Latency: 10 * time.Millisecond,
+			}, func(status int) {
+				mutex.Lock()
+				statuses = append(statuses, status)
+				mutex.Unlock()
+			})
+			defer cleanup()
+
+			client, err := NewClient(Config{
+				Model:   tt.model,
+				Token:   "test-token",
+				BaseURL: baseURL,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			_, err = client.Chat(context.Background(), "Hello")
+			if err != nil {
+				t.Fatalf("chat failed: %v, statuses: %v", err, statuses)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(statuses) < 2 {
+				t.Fatalf("expected at least one injected failure followed by a retry, statuses: %v", statuses)
+			}
+			if statuses[0] == http.StatusOK {
+				t.Errorf("expected first request to fail, statuses: %v", statuses)
+			}
+			if statuses[len(statuses)-1] != http.StatusOK {
+				t.Errorf("expected last request to succeed, statuses: %v", statuses)
+			}
+		})
+	}
+}
+
 func TestChatIntegrationOpenAI(t *testing.T) {
 	// Start mock server
 	baseURL, cleanup := startMockServer(t, "openai")
@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func logProbsOpenAI(logProbs bool) param.Opt[bool] {
+	if !logProbs {
+		return param.Opt[bool]{}
+	}
+	return param.NewOpt(true)
+}
+
+func topLogProbsOpenAI(logProbs bool, topLogProbs int) param.Opt[int64] {
+	if !logProbs || topLogProbs <= 0 {
+		return param.Opt[int64]{}
+	}
+	return param.NewOpt(int64(topLogProbs))
+}
+
+// logProbsMetadataOpenAI converts the content logprobs of a choice, nil if not requested
+func logProbsMetadataOpenAI(logprobs openai.ChatCompletionChoiceLogprobs) *types.LogProbsMetadata {
+	if len(logprobs.Content) == 0 {
+		return nil
+	}
+	tokens := make([]types.TokenLogProb, 0, len(logprobs.Content))
+	for _, content := range logprobs.Content {
+		token := types.TokenLogProb{
+			Token:   content.Token,
+			LogProb: content.Logprob,
+		}
+		for _, top := range content.TopLogprobs {
+			token.TopLogProbs = append(token.TopLogProbs, types.TokenLogProb{
+				Token:   top.Token,
+				LogProb: top.Logprob,
+			})
+		}
+		tokens = append(tokens, token)
+	}
+	return &types.LogProbsMetadata{Tokens: tokens}
+}
@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestLogProbsEvent(t *testing.T) {
+	baseURL, cleanup := startMockServer(t, "openai")
+	defer cleanup()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: baseURL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var msg *types.Message
+	_, err = client.Chat(context.Background(), "Hello",
+		WithLogProbs(2),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+				msg = &event
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if msg == nil {
+		t.Fatalf("expected assistant msg event")
+	}
+	logProbs := msg.Metadata.LogProbs
+	if logProbs == nil || len(logProbs.Tokens) == 0 {
+		t.Fatalf("expected log probs in metadata, got %+v", msg.Metadata)
+	}
+	first := logProbs.Tokens[0]
+	if first.Token == "" || first.LogProb >= 0 {
+		t.Errorf("unexpected first token: %+v", first)
+	}
+	if len(first.TopLogProbs) != 2 {
+		t.Errorf("expected 2 top log probs, got %d", len(first.TopLogProbs))
+	}
+
+	// not requested
+	msg = nil
+	_, err = client.Chat(context.Background(), "Hello",
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+				msg = &event
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if msg == nil || msg.Metadata.LogProbs != nil {
+		t.Errorf("expected no log probs, got %+v", msg)
+	}
+}
+
+func TestLogProbsRecorded(t *testing.T) {
+	baseURL, cleanup := startMockServer(t, "openai")
+	defer cleanup()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: baseURL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	for _, recordLogProbs := range []bool{true, false} {
+		recordFile := filepath.Join(t.TempDir(), "record.json")
+		handler := NewCliHandler(client, CliOptions{
+			RecordFile:     recordFile,
+			RecordLogProbs: recordLogProbs,
+		})
+		if err := handler.HandleCli(context.Background(), "Hello", WithLogProbs(0)); err != nil {
+			t.Fatalf("handle cli: %v", err)
+		}
+		messages, err := LoadHistory(recordFile)
+		if err != nil {
+			t.Fatalf("load history: %v", err)
+		}
+		var found bool
+		for _, msg := range messages {
+			if msg.Type == types.MsgType_Msg && msg.Role == types.Role_Assistant {
+				found = true
+				recorded := msg.Metadata.LogProbs != nil && len(msg.Metadata.LogProbs.Tokens) > 0
+				if recorded != recordLogProbs {
+					t.Errorf("record log probs %v: expected recorded %v, got %+v", recordLogProbs, recordLogProbs, msg.Metadata.LogProbs)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected assistant msg in record, got %+v", messages)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// maxRoundsFinalMessage ends the record of a chat stopped by MaxRounds, see types.MaxRoundsAction_FinalMessage
+const maxRoundsFinalMessage = "I stopped because the maximum number of rounds was reached before I could look at the last tool results."
+
+// endAtMaxRounds reports the chat stopped by MaxRounds with tool results unanswered,
+// returning the final assistant message if the request asks for it
+func (c *Client) endAtMaxRounds(req types.Request, maxRounds int) []types.Message {
+	var messages []types.Message
+	if req.OnMaxRounds == types.MaxRoundsAction_FinalMessage {
+		messages = append(messages, CreateMessage(types.MsgType_Msg, types.Role_Assistant, c.config.Model, maxRoundsFinalMessage))
+	}
+	if req.EventCallback == nil {
+		return messages
+	}
+	req.EventCallback(types.Message{
+		Type:    types.MsgType_Info,
+		Content: fmt.Sprintf("max rounds %d reached while the model still calls tools, the last tool results are not answered", maxRounds),
+	})
+	for _, msg := range messages {
+		req.EventCallback(msg)
+	}
+	req.EventCallback(types.Message{
+		Type:    types.MsgType_StopReason,
+		Content: types.StopReason_MaxRounds,
+	})
+	return messages
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestMaxRoundsWithPendingToolCalls(t *testing.T) {
+	// the model keeps calling tools until it is told to answer
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(data))
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(data), "now answer") {
+			fmt.Fprint(w, `{"id":"chatcmpl-0","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"chatcmpl-%d","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_%d","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`, len(requests), len(requests))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	toolCallback := WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		return types.ToolResult{Content: "sunny"}, true, nil
+	})
+
+	file := filepath.Join(t.TempDir(), "record.json")
+	handler := NewCliHandler(client, CliOptions{RecordFile: file})
+	err = handler.HandleCli(context.Background(), "What's the weather in Tokyo?",
+		WithMaxRounds(2),
+		WithOnMaxRounds(types.MaxRoundsAction_FinalMessage),
+		toolCallback,
+	)
+	if err != nil {
+		t.Fatalf("handle cli: %v", err)
+	}
+
+	messages, err := LoadHistory(file)
+	if err != nil {
+		t.Fatalf("load history: %v", err)
+	}
+	// each tool call is answered by its result, the final message ends the record
+	results := make(map[string]bool)
+	var last types.Message
+	var stopReasons []string
+	for _, msg := range messages {
+		switch msg.Type {
+		case types.MsgType_ToolResult:
+			results[msg.ToolUseID] = true
+		case types.MsgType_Msg:
+			last = msg
+		case types.MsgType_StopReason:
+			stopReasons = append(stopReasons, msg.Content)
+		}
+	}
+	if strings.Join(stopReasons, ",") != types.StopReason_MaxRounds {
+		t.Errorf("expected stop reason %s recorded, got %v", types.StopReason_MaxRounds, stopReasons)
+	}
+	for _, msg := range messages {
+		if msg.Type == types.MsgType_ToolCall && !results[msg.ToolUseID] {
+			t.Errorf("expected a result of tool call %s", msg.ToolUseID)
+		}
+	}
+	if last.Role != types.Role_Assistant || last.Content != maxRoundsFinalMessage {
+		t.Errorf("expected the final message to end the record, got %+v", last)
+	}
+
+	// the record resumes
+	requests = nil
+	err = handler.HandleCli(context.Background(), "now answer", WithMaxRounds(2), toolCallback)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if len(requests) != 1 || !strings.Contains(requests[0], "call_2") || !strings.Contains(requests[0], maxRoundsFinalMessage) {
+		t.Errorf("expected the resumed request to carry the record, got %v", requests)
+	}
+}
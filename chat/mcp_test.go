@@ -0,0 +1,175 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestMCPNamespace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both servers expose search, the results tell them apart
+	dir := t.TempDir()
+	var servers []string
+	for _, name := range []string{"alpha", "beta"} {
+		script := filepath.Join(dir, name+".sh")
+		content := fmt.Sprintf("#!/bin/sh\n%s=%s exec %q\n", testMCPServerEnv, name, exe)
+		if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, script)
+	}
+
+	var mutex sync.Mutex
+	var toolNames []string
+	var n int
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		n++
+		first := n == 1
+		if first {
+			var body struct {
+				Tools []struct {
+					Function struct {
+						Name string `json:"name"`
+					} `json:"function"`
+				} `json:"tools"`
+			}
+			data, _ := io.ReadAll(r.Body)
+			json.Unmarshal(data, &body)
+			for _, tool := range body.Tools {
+				toolNames = append(toolNames, tool.Function.Name)
+			}
+		}
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if first {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"alpha__search","arguments":"{}"}},{"id":"call_2","type":"function","function":{"name":"beta__search","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer apiServer.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// without namespacing the same-named tools collide
+	_, err = client.Chat(context.Background(), "search", WithMCPServers(servers...))
+	if err == nil || !strings.Contains(err.Error(), "duplicate tool") {
+		t.Fatalf("expected duplicate tool error, got %v", err)
+	}
+
+	results := make(map[string]string)
+	_, err = client.Chat(context.Background(), "search", WithMCPServers(servers...), WithMCPNamespace(), WithMaxRounds(2),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolResult {
+				mutex.Lock()
+				results[event.ToolName] = event.Content
+				mutex.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	for _, want := range []string{"alpha__echo", "alpha__search", "beta__echo", "beta__search"} {
+		var found bool
+		for _, name := range toolNames {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("expected tool %s sent to the model, got %v", want, toolNames)
+		}
+	}
+	for _, name := range []string{"alpha", "beta"} {
+		if result := results[name+"__search"]; !strings.Contains(result, "searched "+name) {
+			t.Errorf("expected %s__search routed to %s, got %q", name, name, result)
+		}
+	}
+}
+
+func TestMCPServerNames(t *testing.T) {
+	got := mcpServerNames([]string{"/usr/bin/search.sh", "./bin/search", "my server", "/"})
+	want := []string{"search", "search2", "my_server", "_"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMCPConcurrentInit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each server takes a second to start
+	const initDelay = time.Second
+	dir := t.TempDir()
+	var servers []string
+	for _, name := range []string{"alpha", "beta"} {
+		script := filepath.Join(dir, name+".sh")
+		content := fmt.Sprintf("#!/bin/sh\nsleep %d\n%s=%s exec %q\n", int(initDelay.Seconds()), testMCPServerEnv, name, exe)
+		if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, script)
+	}
+
+	client, err := NewClient(Config{
+		Model: "gpt-4o",
+		Token: "test-token",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	start := time.Now()
+	mapping, _, err := client.prepareTools(context.Background(), types.Request{MCPServers: servers, MCPNamespace: true})
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("prepare tools: %v", err)
+	}
+	defer mapping.Close()
+	if elapsed >= 2*initDelay {
+		t.Errorf("expected the servers initialized concurrently in less than %v, took %v", 2*initDelay, elapsed)
+	}
+	for _, name := range []string{"alpha__echo", "alpha__search", "beta__echo", "beta__search"} {
+		if mapping[name] == nil {
+			t.Errorf("expected tool %s", name)
+		}
+	}
+
+	// a failing server is reported by its name, the others are closed
+	missing := filepath.Join(dir, "missing.sh")
+	_, _, err = client.prepareTools(context.Background(), types.Request{MCPServers: []string{servers[0], missing}})
+	if err == nil || !strings.Contains(err.Error(), "MCP server "+missing) {
+		t.Errorf("expected the error of %s, got %v", missing, err)
+	}
+}
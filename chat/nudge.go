@@ -0,0 +1,20 @@
+package chat
+
+import (
+	"strings"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// nudgeMessage is sent once with NudgeOnEmpty when the model responds with neither content nor tool calls
+const nudgeMessage = "Your last response was empty, please continue."
+
+// isEmptyResponse reports the messages of a round have no assistant content
+func isEmptyResponse(messages []types.Message) bool {
+	for _, msg := range messages {
+		if msg.Role == types.Role_Assistant && strings.TrimSpace(msg.Content) != "" {
+			return false
+		}
+	}
+	return true
+}
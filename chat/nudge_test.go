@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestNudgeOnEmpty(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(data))
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"length"}],"usage":{"prompt_tokens":10,"completion_tokens":0,"total_tokens":10}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var events []string
+	_, err = client.Chat(context.Background(), "hello",
+		WithNudgeOnEmpty(true),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_Msg {
+				events = append(events, string(event.Role)+": "+event.Content)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected the empty response nudged in a second request, got %d requests", len(requests))
+	}
+	if !strings.Contains(requests[1], nudgeMessage) {
+		t.Errorf("expected the nudge sent, got %s", requests[1])
+	}
+	expected := "user: " + nudgeMessage + ",assistant: Hello"
+	if got := strings.Join(events, ","); got != expected {
+		t.Errorf("expected events %q, got %q", expected, got)
+	}
+
+	// without the option the empty response ends the chat
+	requests = nil
+	_, err = client.Chat(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("expected no nudge by default, got %d requests", len(requests))
+	}
+}
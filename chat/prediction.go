@@ -0,0 +1,18 @@
+package chat
+
+import (
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+)
+
+// predictionOpenAI sets prediction, omitted when predicted is empty
+func predictionOpenAI(predicted string) openai.ChatCompletionPredictionContentParam {
+	if predicted == "" {
+		return openai.ChatCompletionPredictionContentParam{}
+	}
+	return openai.ChatCompletionPredictionContentParam{
+		Content: openai.ChatCompletionPredictionContentContentUnionParam{
+			OfString: param.NewOpt(predicted),
+		},
+	}
+}
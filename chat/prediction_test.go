@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPredictedOutputForwarded(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string // JSON of the prediction field, empty if not sent
+	}{
+		{"gpt-4o", `{"content":"package main","type":"content"}`},
+		{"claude-3-7-sonnet", ""},
+		{"gemini-2.5-pro", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				json.Unmarshal(data, &body)
+				switch tt.model {
+				case "claude-3-7-sonnet":
+					writeAnthropicSSE(w, `{"type":"text","text":""}`, `{"type":"text_delta","text":"done"}`, "end_turn")
+				case "gemini-2.5-pro":
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"done"}],"role":"model"},"finishReason":"STOP"}]}`)
+				default:
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClient(Config{
+				Model:   tt.model,
+				Token:   "test-token",
+				BaseURL: server.URL,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			_, err = client.Chat(context.Background(), "add a main func", WithPredictedOutput("package main"))
+			if err != nil {
+				t.Fatalf("chat failed: %v", err)
+			}
+			got, ok := body["prediction"]
+			if tt.want == "" {
+				if ok {
+					t.Errorf("expected no prediction, got %s", got)
+				}
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected prediction %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/xhd2015/kode-ai/providers"
+)
+
+// checkAssistantPrefill validates an assistant prefill against the model,
+// only Moonshot continues a partial assistant message(partial mode)
+func checkAssistantPrefill(model string, prefill string) error {
+	if prefill == "" {
+		return nil
+	}
+	provider, err := providers.GetModelProvider(model)
+	if err != nil {
+		return fmt.Errorf("assistant prefill: %w", err)
+	}
+	if provider != providers.ProviderMoonshot {
+		return fmt.Errorf("assistant prefill: only supported by %s, got %s", providers.ProviderMoonshot, provider)
+	}
+	return nil
+}
+
+// prefillMessageOpenAI is the trailing assistant message Moonshot
+// continues in partial mode, its reply does not repeat the prefill
+func prefillMessageOpenAI(prefill string) openai.ChatCompletionMessageParamUnion {
+	msg := openai.ChatCompletionAssistantMessageParam{
+		Content: openai.ChatCompletionAssistantMessageParamContentUnion{
+			OfString: param.NewOpt(prefill),
+		},
+	}
+	msg.SetExtraFields(map[string]any{"partial": true})
+	return openai.ChatCompletionMessageParamUnion{OfAssistant: &msg}
+}
@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/providers"
+)
+
+func TestAssistantPrefillMoonshot(t *testing.T) {
+	var requestBody string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		requestBody = string(data)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"moonshot-v1-8k","choices":[{"index":0,"message":{"role":"assistant","content":" \"kimi\"}"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer apiServer.Close()
+
+	client, err := NewClient(Config{
+		Model:   providers.ModelMoonshotV1_8K,
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	resp, err := client.Chat(context.Background(), "your name as JSON", WithAssistantPrefill(`{"name":`))
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if !strings.Contains(requestBody, `{"content":"{\"name\":","role":"assistant","partial":true}`) {
+		t.Errorf("expected a partial assistant message last, got %s", requestBody)
+	}
+	if len(resp.Messages) != 1 || resp.Messages[0].Content != `{"name": "kimi"}` {
+		t.Errorf("expected the reply to include the prefill, got %+v", resp.Messages)
+	}
+
+	gptClient, err := NewClient(Config{
+		Model:   providers.ModelGPT4o,
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := gptClient.Chat(context.Background(), "hi", WithAssistantPrefill("{")); err == nil {
+		t.Errorf("expected error prefilling a model not supporting it")
+	}
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLimitPrintLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"Short", "hello", 10, "hello"},
+		{"Exact", "hello", 5, "hello"},
+		{"Disabled", "hello world", -1, "hello world"},
+		{"ASCII", "hello world", 5, "hello... (6 more bytes)"},
+		// each rune is 3 bytes, 4 bytes would split the second rune
+		{"RuneBoundary", "你好世界", 4, "你... (9 more bytes)"},
+		{"LineBoundary", "line one\nline two", 12, "line one... (9 more bytes)"},
+		// the line boundary would drop more than half
+		{"ShortLine", "a\nbcdefghijkl", 8, "a\nbcdefg... (5 more bytes)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LimitPrintLength(tt.s, tt.limit)
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("expected valid UTF-8, got %q", got)
+			}
+		})
+	}
+}
+
+func TestClientPrintLimit(t *testing.T) {
+	long := strings.Repeat("é", MAX_PRINT_LIMIT)
+
+	client, err := NewClient(Config{Model: "gpt-4o", Token: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := client.limitPrintLength(long)
+	kept, _, _ := strings.Cut(got, "...")
+	if len(kept) > MAX_PRINT_LIMIT || !utf8.ValidString(kept) {
+		t.Errorf("expected default limit %d on rune boundary, kept %d bytes", MAX_PRINT_LIMIT, len(kept))
+	}
+
+	client, err = NewClient(Config{Model: "gpt-4o", Token: "test-token", PrintLimit: 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = client.limitPrintLength(long)
+	want := strings.Repeat("é", 5) + "... (4086 more bytes)"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	client, err = NewClient(Config{Model: "gpt-4o", Token: "test-token", PrintLimit: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.limitPrintLength(long); got != long {
+		t.Errorf("expected no truncation with negative limit")
+	}
+}
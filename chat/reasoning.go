@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/shared"
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+	"google.golang.org/genai"
+)
+
+// thinking budgets in tokens for each effort, Anthropic's minimum is 1024
+// and the budget must stay below MaxTokens
+var (
+	thinkingBudgetsAnthropic = map[types.ReasoningEffort]int64{
+		types.ReasoningEffortLow:    1024,
+		types.ReasoningEffortMedium: 4096,
+		types.ReasoningEffortHigh:   16384,
+	}
+	thinkingBudgetsGemini = map[types.ReasoningEffort]int32{
+		types.ReasoningEffortLow:    1024,
+		types.ReasoningEffortMedium: 8192,
+		types.ReasoningEffortHigh:   24576,
+	}
+)
+
+// resolveReasoningEffort validates effort against the model and the tool choice
+func resolveReasoningEffort(apiShape providers.APIShape, model string, effort types.ReasoningEffort, toolChoice string) error {
+	if effort == "" {
+		return nil
+	}
+	if err := effort.Validate(); err != nil {
+		return err
+	}
+	if !providers.IsReasoningModel(model) {
+		return fmt.Errorf("reasoning effort: %s is not a reasoning model", model)
+	}
+	if apiShape == providers.APIShapeAnthropic {
+		switch toolChoice {
+		case "", types.ToolChoice_Auto, types.ToolChoice_None:
+		default:
+			// Anthropic rejects forced tool use while thinking
+			return fmt.Errorf("reasoning effort: tool choice %s not supported with thinking", toolChoice)
+		}
+	}
+	return nil
+}
+
+func reasoningEffortOpenAI(effort types.ReasoningEffort) shared.ReasoningEffort {
+	return shared.ReasoningEffort(effort)
+}
+
+func thinkingAnthropic(effort types.ReasoningEffort) anthropic.ThinkingConfigParamUnion {
+	budget, ok := thinkingBudgetsAnthropic[effort]
+	if !ok {
+		return anthropic.ThinkingConfigParamUnion{}
+	}
+	return anthropic.ThinkingConfigParamOfEnabled(budget)
+}
+
+func thinkingConfigGemini(effort types.ReasoningEffort) *genai.ThinkingConfig {
+	budget, ok := thinkingBudgetsGemini[effort]
+	if !ok {
+		return nil
+	}
+	return &genai.ThinkingConfig{ThinkingBudget: &budget}
+}
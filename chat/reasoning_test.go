@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestReasoningEffortForwarded(t *testing.T) {
+	tests := []struct {
+		model  string
+		effort types.ReasoningEffort
+		field  string
+		want   string // JSON of the forwarded field
+	}{
+		{"o3", types.ReasoningEffortHigh, "reasoning_effort", `"high"`},
+		{"claude-3-7-sonnet", types.ReasoningEffortLow, "thinking", `{"budget_tokens":1024,"type":"enabled"}`},
+		{"gemini-2.5-pro", types.ReasoningEffortMedium, "generationConfig", `{"candidateCount":1,"thinkingConfig":{"thinkingBudget":8192}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				json.Unmarshal(data, &body)
+				switch tt.model {
+				case "claude-3-7-sonnet":
+					writeAnthropicSSE(w, `{"type":"text","text":""}`, `{"type":"text_delta","text":"done"}`, "end_turn")
+				case "gemini-2.5-pro":
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"done"}],"role":"model"},"finishReason":"STOP"}]}`)
+				default:
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"o3","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClient(Config{
+				Model:   tt.model,
+				Token:   "test-token",
+				BaseURL: server.URL,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			if _, err := client.Chat(context.Background(), "hello", WithReasoningEffort(tt.effort)); err != nil {
+				t.Fatalf("chat: %v", err)
+			}
+			if got := string(body[tt.field]); got != tt.want {
+				t.Errorf("expected %s %s, got %s", tt.field, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReasoningEffortRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		model  string
+		effort types.ReasoningEffort
+		opts   []types.ChatOption
+	}{
+		{"non-reasoning model", "gpt-4o", types.ReasoningEffortHigh, nil},
+		{"invalid effort", "o3", "max", nil},
+		{"forced tool with thinking", "claude-3-7-sonnet", types.ReasoningEffortLow, []types.ChatOption{WithTools("list_dir"), WithToolChoice(types.ToolChoice_Required)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			defer server.Close()
+
+			client, err := NewClient(Config{
+				Model:   tt.model,
+				Token:   "test-token",
+				BaseURL: server.URL,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			opts := append([]types.ChatOption{WithReasoningEffort(tt.effort)}, tt.opts...)
+			if _, err := client.Chat(context.Background(), "hello", opts...); err == nil {
+				t.Errorf("expected error")
+			}
+			if called {
+				t.Errorf("expected no API call")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// RecordVersion is the schema version of the records written. A record starts
+// with a RecordHeader line followed by a message per line, a record without the
+// header is version 1
+const RecordVersion = 2
+
+// RecordHeader is the first line of a record
+type RecordHeader struct {
+	RecordVersion int `json:"record_version"`
+}
+
+// recordMigrations[i] upgrades a message of version i+1 to version i+2, working on
+// the raw JSON so renamed fields can be moved. Appending to a record does not change
+// its version, so a migration must keep messages already in a newer format intact
+var recordMigrations = []func(msg map[string]json.RawMessage) error{
+	// 1 -> 2: timestamp was not always recorded, derive it from time
+	migrateRecordTimestamp,
+}
+
+// ReadRecord reads the messages of a record upgraded to RecordVersion,
+// along with the version the record was at
+func ReadRecord(r io.Reader) ([]types.Message, int, error) {
+	version := 1
+	var messages []types.Message
+	decoder := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		var line json.RawMessage
+		if err := decoder.Decode(&line); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, 0, fmt.Errorf("parse message: %w", err)
+		}
+		if i == 0 {
+			header, ok, err := parseRecordHeader(line)
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				version = header.RecordVersion
+				continue
+			}
+		}
+		msg, err := decodeRecordMessage(line, version)
+		if err != nil {
+			return nil, 0, fmt.Errorf("parse message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, version, nil
+}
+
+// WriteRecord writes messages as a record of RecordVersion
+func WriteRecord(w io.Writer, messages []types.Message) error {
+	if err := writeRecordHeader(w); err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("marshal message: %w", err)
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("write message: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeRecordHeader(w io.Writer) error {
+	data, err := json.Marshal(RecordHeader{RecordVersion: RecordVersion})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write record header: %w", err)
+	}
+	return nil
+}
+
+func parseRecordHeader(line json.RawMessage) (RecordHeader, bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return RecordHeader{}, false, fmt.Errorf("parse message: %w", err)
+	}
+	if _, ok := fields["record_version"]; !ok {
+		return RecordHeader{}, false, nil
+	}
+	var header RecordHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return RecordHeader{}, false, fmt.Errorf("parse record header: %w", err)
+	}
+	if header.RecordVersion < 1 {
+		return RecordHeader{}, false, fmt.Errorf("invalid record version: %d", header.RecordVersion)
+	}
+	if header.RecordVersion > RecordVersion {
+		return RecordHeader{}, false, fmt.Errorf("record version %d is newer than %d supported, upgrade kode to read it", header.RecordVersion, RecordVersion)
+	}
+	return header, true, nil
+}
+
+func decodeRecordMessage(line json.RawMessage, version int) (types.Message, error) {
+	var msg types.Message
+	if version < RecordVersion {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return msg, err
+		}
+		for _, migrate := range recordMigrations[version-1:] {
+			if err := migrate(fields); err != nil {
+				return msg, err
+			}
+		}
+		var err error
+		line, err = json.Marshal(fields)
+		if err != nil {
+			return msg, err
+		}
+	}
+	err := json.Unmarshal(line, &msg)
+	return msg, err
+}
+
+func migrateRecordTimestamp(msg map[string]json.RawMessage) error {
+	var timestamp int64
+	if data, ok := msg["timestamp"]; ok {
+		if err := json.Unmarshal(data, &timestamp); err != nil {
+			return fmt.Errorf("timestamp: %w", err)
+		}
+	}
+	if timestamp != 0 {
+		return nil
+	}
+	var msgTime string
+	if data, ok := msg["time"]; ok {
+		if err := json.Unmarshal(data, &msgTime); err != nil {
+			return fmt.Errorf("time: %w", err)
+		}
+	}
+	// layouts of AppendToHistory and the former record writer of kode chat
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05-07:00"} {
+		if t, err := time.Parse(layout, msgTime); err == nil {
+			msg["timestamp"] = json.RawMessage(fmt.Sprint(t.Unix()))
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// oldRecord is a version 1 record: no header, and messages without timestamp
+const oldRecord = `{"type":"msg","time":"2025-06-01T10:00:00Z","role":"user","model":"gpt-4.1","content":"list files"}
+{"type":"tool_call","time":"2025-06-01 10:00:05+00:00","role":"assistant","model":"gpt-4.1","content":"{}","tool_use_id":"call_1","tool_name":"list_dir"}
+{"type":"msg","time":"2025-06-01T10:00:09Z","role":"assistant","model":"gpt-4.1","content":"done","timestamp":1748772010}
+`
+
+func TestReadOldRecord(t *testing.T) {
+	messages, version, err := ReadRecord(strings.NewReader(oldRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("expected version 1, got %d", version)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(messages))
+	}
+	base := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC).Unix()
+	expected := []int64{base, base + 5, base + 10}
+	for i, msg := range messages {
+		if msg.Timestamp != expected[i] {
+			t.Errorf("message %d: expected timestamp %d, got %d", i, expected[i], msg.Timestamp)
+		}
+	}
+	if messages[1].ToolUseID != "call_1" || messages[1].ToolName != "list_dir" {
+		t.Errorf("expected tool call kept, got %+v", messages[1])
+	}
+
+	// saved at the current version, read back unchanged
+	file := filepath.Join(t.TempDir(), "record.json")
+	if err := SaveHistory(file, messages); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"record_version":2}`+"\n") {
+		t.Errorf("expected record header, got %s", data)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reloaded, version, err := ReadRecord(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != RecordVersion || len(reloaded) != 3 || reloaded[1].Timestamp != base+5 {
+		t.Errorf("expected the upgraded messages at version %d, got version %d: %+v", RecordVersion, version, reloaded)
+	}
+}
+
+func TestReadRecordNewerVersion(t *testing.T) {
+	_, _, err := ReadRecord(strings.NewReader(`{"record_version":99}` + "\n"))
+	if err == nil || !strings.Contains(err.Error(), "upgrade kode") {
+		t.Errorf("expected error of newer version, got %v", err)
+	}
+}
+
+func TestAppendToHistoryWritesHeader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "record.json")
+	for _, content := range []string{"hello", "hi"} {
+		if err := AppendToHistory(file, types.Message{Type: types.MsgType_Msg, Role: types.Role_User, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "record_version") != 1 || !strings.HasPrefix(string(data), `{"record_version":2}`) {
+		t.Errorf("expected a single record header, got %s", data)
+	}
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// values of headers carrying credentials
+	secretHeaderPattern = regexp.MustCompile(`(?im)^((?:authorization|x-api-key|api-key|x-goog-api-key):[ \t]*)\S.*?(\r?)$`)
+	// secrets appearing elsewhere, e.g. echoed back in an error
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+		regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{8,}`),
+		regexp.MustCompile(`\bAIza[A-Za-z0-9_-]{20,}`),
+		regexp.MustCompile(`([?&]key=)[^&\s]+`),
+	}
+)
+
+// redactLog masks secrets in s, and the body of a dumped HTTP message if mode is body
+func redactLog(s string, mode types.LogRedact) string {
+	if mode == types.LogRedactNone {
+		return s
+	}
+	if mode == types.LogRedactBody {
+		if header, body, ok := strings.Cut(s, "\r\n\r\n"); ok && strings.TrimSpace(body) != "" {
+			s = header + "\r\n\r\n" + fmt.Sprintf("[REDACTED %d bytes]\n", len(body))
+		}
+	}
+	s = secretHeaderPattern.ReplaceAllString(s, "${1}"+redacted+"${2}")
+	for _, pattern := range secretPatterns {
+		if pattern.NumSubexp() > 0 {
+			s = pattern.ReplaceAllString(s, "${1}"+redacted)
+		} else {
+			s = pattern.ReplaceAllString(s, redacted)
+		}
+	}
+	return s
+}
+
+// redactWriter redacts each write, log.Logger writes a whole message at once
+type redactWriter struct {
+	w    io.Writer
+	mode types.LogRedact
+}
+
+func (c *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(c.w, redactLog(string(p), c.mode)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// newRequestLogger returns the logger of SDK debug logs, redacted by mode
+func newRequestLogger(w io.Writer, mode types.LogRedact) *log.Logger {
+	return log.New(&redactWriter{w: w, mode: mode}, "", log.LstdFlags)
+}
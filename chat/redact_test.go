@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+	openai_opt "github.com/openai/openai-go/option"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+const fakeKey = "sk-proj-abcdefghijklmnopqrstuvwxyz0123456789"
+
+// logRequest sends a request with fakeKey through the SDK debug logger
+func logRequest(t *testing.T, mode types.LogRedact) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	client := openai.NewClient(
+		openai_opt.WithBaseURL(server.URL),
+		openai_opt.WithAPIKey(fakeKey),
+		openai_opt.WithDebugLog(newRequestLogger(&buf, mode)),
+	)
+	_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("my password is " + fakeKey)},
+	})
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	return buf.String()
+}
+
+func TestRedactRequestLog(t *testing.T) {
+	logged := logRequest(t, types.LogRedactSecrets)
+	if strings.Contains(logged, fakeKey) {
+		t.Errorf("expected key masked, got:\n%s", logged)
+	}
+	if !strings.Contains(logged, "Authorization: "+redacted) {
+		t.Errorf("expected authorization header masked, got:\n%s", logged)
+	}
+	if !strings.Contains(logged, "my password is") {
+		t.Errorf("expected body kept, got:\n%s", logged)
+	}
+
+	logged = logRequest(t, types.LogRedactBody)
+	if strings.Contains(logged, fakeKey) || strings.Contains(logged, "my password is") || strings.Contains(logged, `"done"`) {
+		t.Errorf("expected key and bodies masked, got:\n%s", logged)
+	}
+
+	logged = logRequest(t, types.LogRedactNone)
+	if !strings.Contains(logged, "Bearer "+fakeKey) {
+		t.Errorf("expected key logged without redaction, got:\n%s", logged)
+	}
+}
+
+func TestRedactLog(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"Header", "X-Api-Key: sk-ant-api03-secret\r\nAccept: */*\r\n", "X-Api-Key: [REDACTED]\r\nAccept: */*\r\n"},
+		{"Bearer", "invalid token: Bearer abc.def", "invalid token: Bearer [REDACTED]"},
+		{"GoogleKey", "key AIzaSyA1234567890abcdefghij", "key [REDACTED]"},
+		{"QueryKey", "GET /v1/models?key=secret&alt=sse", "GET /v1/models?key=[REDACTED]&alt=sse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactLog(tt.s, types.LogRedactSecrets); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/jsonschema"
+)
+
+// ToolHandler executes a tool registered by RegisterTool
+type ToolHandler func(ctx context.Context, call types.ToolCall) (types.ToolResult, error)
+
+var (
+	registeredToolsMutex sync.RWMutex
+	registeredTools      = make(map[string]*tools.UnifiedTool)
+)
+
+// RegisterTool registers a Go tool run in-process, chats naming it in their tools,
+// e.g. WithTools(name), can call it like a builtin tool. def is the schema of the
+// arguments, its description tells the model what the tool does
+func RegisterTool(name string, def *jsonschema.JsonSchema, handler ToolHandler) error {
+	if name == "" {
+		return fmt.Errorf("requires tool name")
+	}
+	if handler == nil {
+		return fmt.Errorf("%s: requires handler", name)
+	}
+	if tools.GetExecutor(name) != nil {
+		return fmt.Errorf("%s: conflicts with the builtin tool", name)
+	}
+	tool := &tools.UnifiedTool{
+		Name:       name,
+		Parameters: def,
+		Handle: func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			result, err := handler(ctx, call)
+			return result, true, err
+		},
+	}
+	if def != nil {
+		tool.Description = def.Description
+	}
+
+	registeredToolsMutex.Lock()
+	defer registeredToolsMutex.Unlock()
+	if registeredTools[name] != nil {
+		return fmt.Errorf("%s: already registered", name)
+	}
+	registeredTools[name] = tool
+	return nil
+}
+
+// RegisterTypedTool is RegisterTool with the arguments decoded into A
+// and the result content of type R, see tools.TypedResult
+func RegisterTypedTool[A any, R any](name string, def *jsonschema.JsonSchema, fn func(ctx context.Context, args A) (tools.TypedResult[R], error)) error {
+	if fn == nil {
+		return fmt.Errorf("%s: requires handler", name)
+	}
+	return RegisterTool(name, def, tools.TypedHandler(fn))
+}
+
+// RegisteredToolNames returns the names of tools registered by RegisterTool, sorted
+func RegisteredToolNames() []string {
+	registeredToolsMutex.RLock()
+	defer registeredToolsMutex.RUnlock()
+	names := make([]string, 0, len(registeredTools))
+	for name := range registeredTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitRegisteredTools separates the tools registered by RegisterTool from builtin tool names
+func splitRegisteredTools(names []string) (registered []*tools.UnifiedTool, builtins []string) {
+	registeredToolsMutex.RLock()
+	defer registeredToolsMutex.RUnlock()
+	for _, name := range names {
+		if tool := registeredTools[name]; tool != nil {
+			registered = append(registered, tool)
+			continue
+		}
+		builtins = append(builtins, name)
+	}
+	return registered, builtins
+}
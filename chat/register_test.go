@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/jsonschema"
+)
+
+func TestRegisterTool(t *testing.T) {
+	apiServer := startToolCallServer(t)
+
+	var calledWith interface{}
+	err := RegisterTool("get_weather", &jsonschema.JsonSchema{
+		Type:        jsonschema.ParamTypeObject,
+		Description: "Get the weather of a city",
+		Properties: map[string]*jsonschema.JsonSchema{
+			"city": {Type: jsonschema.ParamTypeString},
+		},
+		Required: []string{"city"},
+	}, func(ctx context.Context, call types.ToolCall) (types.ToolResult, error) {
+		calledWith = call.Arguments["city"]
+		return types.ToolResult{Content: map[string]string{"forecast": "sunny"}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		registeredToolsMutex.Lock()
+		defer registeredToolsMutex.Unlock()
+		delete(registeredTools, "get_weather")
+	})
+	if err := RegisterTool("get_weather", nil, func(ctx context.Context, call types.ToolCall) (types.ToolResult, error) {
+		return types.ToolResult{}, nil
+	}); err == nil {
+		t.Errorf("expected error registering get_weather twice")
+	}
+	if err := RegisterTool("read_file", nil, func(ctx context.Context, call types.ToolCall) (types.ToolResult, error) {
+		return types.ToolResult{}, nil
+	}); err == nil {
+		t.Errorf("expected error registering a builtin tool name")
+	}
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var toolResult string
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithTools("get_weather"),
+		WithMaxRounds(2),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolResult {
+				toolResult = event.Content
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if calledWith != "Tokyo" {
+		t.Errorf("expected get_weather called with Tokyo, got %v", calledWith)
+	}
+	if !strings.Contains(toolResult, "sunny") {
+		t.Errorf("expected the handler result sent to the model, got %q", toolResult)
+	}
+}
+
+func TestTypedToolCallback(t *testing.T) {
+	apiServer := startToolCallServer(t)
+
+	type weatherArgs struct {
+		City string `json:"city"`
+	}
+	type weather struct {
+		City     string `json:"city"`
+		Forecast string `json:"forecast"`
+		Celsius  int    `json:"celsius"`
+	}
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var toolResult string
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(tools.TypedCallback("get_weather", func(ctx context.Context, args weatherArgs) (tools.TypedResult[weather], error) {
+			return tools.TypedResult[weather]{Content: weather{City: args.City, Forecast: "sunny", Celsius: 21}}, nil
+		})),
+		WithMaxRounds(2),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolResult {
+				toolResult = event.Content
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	want := `{"city":"Tokyo","forecast":"sunny","celsius":21}`
+	if toolResult != want {
+		t.Errorf("expected tool result %s, got %s", want, toolResult)
+	}
+}
@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// ReplayOptions configures the tools recorded tool calls are re-executed against
+type ReplayOptions struct {
+	Tools          []string // builtin tools
+	ToolFiles      []string
+	ToolJSONs      []string
+	MCPServers     []string
+	DefaultToolCwd string
+
+	// AllowDestructive re-executes destructive builtin tools like write_file
+	// and run_terminal_cmd, they are skipped otherwise to not repeat their side effects
+	AllowDestructive bool
+}
+
+// ReplayResult is the outcome of re-executing one recorded tool call
+type ReplayResult struct {
+	ToolUseID string
+	ToolName  string
+	Arguments string
+
+	Recorded    string
+	HasRecorded bool // false if the record has no result for the call
+	Current     string
+	Changed     bool
+
+	// Skipped is true if the call was not re-executed because its tool is destructive
+	Skipped bool
+}
+
+// ReplayTools re-executes each MsgType_ToolCall in messages against the
+// current tools and compares the new result with the recorded MsgType_ToolResult
+func ReplayTools(ctx context.Context, messages []types.Message, opts ReplayOptions) ([]ReplayResult, error) {
+	// tool callbacks and streams are not available when replaying
+	c := &requestState{Client: &Client{}, toolResolution: types.ToolResolution_BuiltinOnly}
+	toolInfoMapping, _, err := c.prepareTools(ctx, types.Request{
+		Tools:      opts.Tools,
+		ToolFiles:  opts.ToolFiles,
+		ToolJSONs:  opts.ToolJSONs,
+		MCPServers: opts.MCPServers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer toolInfoMapping.Close()
+
+	var results []ReplayResult
+	for i, msg := range messages {
+		if msg.Type != types.MsgType_ToolCall {
+			continue
+		}
+		if toolInfo := toolInfoMapping[msg.ToolName]; !opts.AllowDestructive && toolInfo != nil && toolInfo.Builtin && tools.IsDestructive(toolInfo.Name) {
+			results = append(results, ReplayResult{
+				ToolUseID: msg.ToolUseID,
+				ToolName:  msg.ToolName,
+				Arguments: msg.Content,
+				Skipped:   true,
+			})
+			continue
+		}
+		call, err := parseToolCall(msg.ToolName, msg.ToolUseID, msg.Content, opts.DefaultToolCwd)
+		if err != nil {
+			return nil, fmt.Errorf("replay %s: %w", msg.ToolName, err)
+		}
+		toolResult, err := c.executeToolWithCallback(ctx, nil, call, nil, nil, nil, opts.DefaultToolCwd, toolInfoMapping)
+		if err != nil {
+			return nil, fmt.Errorf("replay %s: %w", msg.ToolName, err)
+		}
+
+		result := ReplayResult{
+			ToolUseID: msg.ToolUseID,
+			ToolName:  msg.ToolName,
+			Arguments: msg.Content,
+			Current:   c.limitPrintLength(toolResultString(toolResult)),
+		}
+		if recorded, ok := findRecordedToolResult(messages[i+1:], msg); ok {
+			result.Recorded = recorded.Content
+			result.HasRecorded = true
+		}
+		result.Changed = !result.HasRecorded || result.Current != result.Recorded
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// findRecordedToolResult finds the result of call, matching by tool use id,
+// or by tool name if the provider did not assign ids
+func findRecordedToolResult(messages []types.Message, call types.Message) (types.Message, bool) {
+	for _, msg := range messages {
+		if msg.Type != types.MsgType_ToolResult {
+			continue
+		}
+		if call.ToolUseID != "" {
+			if msg.ToolUseID == call.ToolUseID {
+				return msg, true
+			}
+			continue
+		}
+		if msg.ToolName == call.ToolName {
+			return msg, true
+		}
+	}
+	return types.Message{}, false
+}
+
+// toolResultString formats toolResult the same way as the recorded tool result event
+func toolResultString(toolResult types.ToolResult) string {
+	if toolResult.Error != "" {
+		return fmt.Sprintf("Error: %v", toolResult.Error)
+	}
+	resultJSON, err := json.Marshal(toolResult.Content)
+	if err != nil {
+		return fmt.Sprintf("Error marshaling result: %v", err)
+	}
+	return string(resultJSON)
+}
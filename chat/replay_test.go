@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestReplayToolsListDirChanged(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := ReplayOptions{
+		Tools:          []string{"list_dir"},
+		DefaultToolCwd: dir,
+	}
+	call := types.Message{
+		Type:      types.MsgType_ToolCall,
+		Role:      types.Role_Assistant,
+		ToolUseID: "call_1",
+		ToolName:  "list_dir",
+		Content:   `{"relative_workspace_path":"."}`,
+	}
+
+	// record the result as the chat would have
+	results, err := ReplayTools(context.Background(), []types.Message{call}, opts)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(results) != 1 || results[0].HasRecorded {
+		t.Fatalf("expected 1 result without recorded, got %+v", results)
+	}
+	recorded := types.Message{
+		Type:      types.MsgType_ToolResult,
+		Role:      types.Role_User,
+		ToolUseID: "call_1",
+		ToolName:  "list_dir",
+		Content:   results[0].Current,
+	}
+	messages := []types.Message{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		call,
+		recorded,
+	}
+
+	results, err = ReplayTools(context.Background(), messages, opts)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(results) != 1 || results[0].Changed {
+		t.Fatalf("expected unchanged result, got %+v", results)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err = ReplayTools(context.Background(), messages, opts)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(results) != 1 || !results[0].Changed {
+		t.Fatalf("expected changed result, got %+v", results)
+	}
+	if strings.Contains(results[0].Recorded, "b.txt") || !strings.Contains(results[0].Current, "b.txt") {
+		t.Errorf("expected only current result to contain b.txt, recorded: %s, current: %s", results[0].Recorded, results[0].Current)
+	}
+}
+
+func TestReplayToolsSkipsDestructive(t *testing.T) {
+	dir := t.TempDir()
+	messages := []types.Message{
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolUseID: "call_1", ToolName: "write_file", Content: `{"target_file":"a.txt","content":"a"}`},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolUseID: "call_2", ToolName: "list_dir", Content: `{"relative_workspace_path":"."}`},
+	}
+	opts := ReplayOptions{
+		Tools:          []string{"write_file", "list_dir"},
+		DefaultToolCwd: dir,
+	}
+
+	results, err := ReplayTools(context.Background(), messages, opts)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(results) != 2 || !results[0].Skipped || results[1].Skipped {
+		t.Fatalf("expected only write_file skipped, got %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected a.txt not written by default, stat: %v", err)
+	}
+
+	opts.AllowDestructive = true
+	results, err = ReplayTools(context.Background(), messages, opts)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(results) != 2 || results[0].Skipped {
+		t.Fatalf("expected write_file replayed, got %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); err != nil {
+		t.Errorf("expected a.txt written with AllowDestructive: %v", err)
+	}
+}
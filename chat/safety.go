@@ -0,0 +1,20 @@
+package chat
+
+import (
+	"github.com/xhd2015/kode-ai/types"
+	"google.golang.org/genai"
+)
+
+func safetySettingsGemini(settings []types.SafetySetting) []*genai.SafetySetting {
+	if len(settings) == 0 {
+		return nil
+	}
+	geminiSettings := make([]*genai.SafetySetting, 0, len(settings))
+	for _, setting := range settings {
+		geminiSettings = append(geminiSettings, &genai.SafetySetting{
+			Category:  genai.HarmCategory(setting.Category),
+			Threshold: genai.HarmBlockThreshold(setting.Threshold),
+		})
+	}
+	return geminiSettings
+}
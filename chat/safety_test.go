@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestSafetySettingsForwarded(t *testing.T) {
+	var body struct {
+		SafetySettings []types.SafetySetting `json:"safetySettings"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"done"}],"role":"model"},"finishReason":"STOP"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gemini-2.5-pro",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	setting := types.SafetySetting{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"}
+	_, err = client.Chat(context.Background(), "delete the build dir", WithSafetySettings(setting))
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+	if len(body.SafetySettings) != 1 || body.SafetySettings[0] != setting {
+		t.Errorf("expected safety settings [%+v], got %+v", setting, body.SafetySettings)
+	}
+
+	_, err = client.Chat(context.Background(), "delete the build dir", WithSafetySettings(types.SafetySetting{
+		Category:  "HARM_CATEGORY_DANGEROUS_CONTENT",
+		Threshold: "BLOCK_SOMETIMES",
+	}))
+	if err == nil {
+		t.Errorf("expected error of invalid threshold")
+	}
+}
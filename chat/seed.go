@@ -0,0 +1,22 @@
+package chat
+
+import (
+	"github.com/openai/openai-go/packages/param"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func seedOpenAI(seed *int64) param.Opt[int64] {
+	if seed == nil {
+		return param.Opt[int64]{}
+	}
+	return param.NewOpt(*seed)
+}
+
+// fingerprintMetadata is only recorded for a seeded request, where
+// a changed fingerprint tells the same seed may sample differently
+func fingerprintMetadata(seed *int64, systemFingerprint string) *types.FingerprintMetadata {
+	if seed == nil || systemFingerprint == "" {
+		return nil
+	}
+	return &types.FingerprintMetadata{SystemFingerprint: systemFingerprint}
+}
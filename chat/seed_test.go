@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestSeedForwarded(t *testing.T) {
+	var body map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","system_fingerprint":"fp_test","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var fingerprint *types.FingerprintMetadata
+	_, err = client.Chat(context.Background(), "hello", WithSeed(42), WithEventCallback(func(event types.Message) {
+		if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+			fingerprint = event.Metadata.Fingerprint
+		}
+	}))
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+	if got := string(body["seed"]); got != "42" {
+		t.Errorf("expected seed 42, got %q", got)
+	}
+	if fingerprint == nil || fingerprint.SystemFingerprint != "fp_test" {
+		t.Errorf("expected system fingerprint fp_test, got %+v", fingerprint)
+	}
+
+	// without seed, neither is sent or recorded
+	body = nil
+	fingerprint = nil
+	_, err = client.Chat(context.Background(), "hello", WithEventCallback(func(event types.Message) {
+		if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+			fingerprint = event.Metadata.Fingerprint
+		}
+	}))
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+	if got, ok := body["seed"]; ok {
+		t.Errorf("expected no seed, got %s", got)
+	}
+	if fingerprint != nil {
+		t.Errorf("expected no fingerprint without seed, got %+v", fingerprint)
+	}
+}
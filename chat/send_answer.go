@@ -0,0 +1,24 @@
+package chat
+
+import (
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/tools/send_answer"
+)
+
+// sendAnswerToolName is the builtin tool ending the chat if Request.StopOnSendAnswer
+const sendAnswerToolName = "send_answer"
+
+// findSendAnswer returns the answer of the first valid send_answer call
+func findSendAnswer(calls []types.ToolCall) ([]string, bool) {
+	for _, call := range calls {
+		if call.Name != sendAnswerToolName {
+			continue
+		}
+		req, err := send_answer.ParseJSONRequest(call.RawArgs)
+		if err != nil {
+			continue
+		}
+		return req.Answer, true
+	}
+	return nil, false
+}
@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestStopOnSendAnswer(t *testing.T) {
+	var requests atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"send_answer","arguments":"{\"answer\":[\"42\"]}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"the answer is 42"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer apiServer.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// without the option the result is fed back to the model
+	resp, err := client.Chat(context.Background(), "what is the answer?", WithTools("send_answer"), WithMaxRounds(5))
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if n := requests.Load(); n != 2 || resp.StopReason != "" {
+		t.Fatalf("expected 2 requests without stop reason, got %d, %q", n, resp.StopReason)
+	}
+
+	requests.Store(0)
+	var events []types.MsgType
+	resp, err = client.Chat(context.Background(), "what is the answer?", WithTools("send_answer"), WithMaxRounds(5), WithStopOnSendAnswer(),
+		WithEventCallback(func(event types.Message) {
+			events = append(events, event.Type)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected the chat to stop after 1 request, got %d", n)
+	}
+	if resp.StopReason != types.StopReason_SendAnswer || strings.Join(resp.Answer, ",") != "42" {
+		t.Errorf("expected stopped by send_answer with answer 42, got %q %v", resp.StopReason, resp.Answer)
+	}
+	if len(events) == 0 || events[len(events)-1] != types.MsgType_StopReason {
+		t.Errorf("expected a stop_reason event last, got %v", events)
+	}
+}
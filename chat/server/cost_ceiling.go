@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// costCeiling accumulates the cost of a connection, reporting once it exceeds maxUSD
+type costCeiling struct {
+	maxUSD float64
+	cost   types.TokenCost
+}
+
+// check reports an error if the cost of model cannot be computed, the ceiling
+// could not be enforced for its chat
+func (c *costCeiling) check(model string) error {
+	_, err := c.computeCost(model, types.TokenUsage{})
+	return err
+}
+
+// add adds the cost of a round of model, returning an error if the total exceeds the ceiling
+// or the cost cannot be computed
+func (c *costCeiling) add(model string, usage types.TokenUsage) error {
+	cost, err := c.computeCost(model, usage)
+	if err != nil {
+		return err
+	}
+	c.cost = c.cost.Add(cost)
+	total, err := strconv.ParseFloat(c.cost.TotalUSD, 64)
+	if err != nil {
+		return fmt.Errorf("parse cost $%s: %w", c.cost.TotalUSD, err)
+	}
+	if total > c.maxUSD {
+		return fmt.Errorf("cost $%s exceeds the ceiling $%g of the connection", c.cost.TotalUSD, c.maxUSD)
+	}
+	return nil
+}
+
+func (c *costCeiling) computeCost(model string, usage types.TokenUsage) (types.TokenCost, error) {
+	model = providers.GetUnderlyingModel(model)
+	apiShape, err := providers.GetModelAPIShape(model)
+	if err != nil {
+		return types.TokenCost{}, fmt.Errorf("cost ceiling $%g of the connection: %w", c.maxUSD, err)
+	}
+	// a model registered without prices would count as free
+	pricing, ok := providers.GetModelCost(model)
+	if ok && (pricing.InputUSDPer1M == "" || pricing.OutputUSDPer1M == "") {
+		ok = false
+	}
+	var cost types.TokenCost
+	if ok {
+		cost, ok = providers.ComputeCost(apiShape, model, usage)
+	}
+	if !ok {
+		return types.TokenCost{}, fmt.Errorf("cost ceiling $%g of the connection: model %s has no known pricing", c.maxUSD, model)
+	}
+	return cost, nil
+}
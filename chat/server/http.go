@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// chatError is the body of a failed POST /chat
+type chatError struct {
+	Error string `json:"error"`
+}
+
+// handleChat runs a types.Request POSTed as JSON and responds with the types.Response.
+// With Accept: text/event-stream, the events are sent as they happen followed by an
+// "end" event with the response, or an "error" event
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, chatError{Error: "requires POST"})
+		return
+	}
+	release, reason := s.limiter.acquire(r.RemoteAddr)
+	if reason != "" {
+		writeJSON(w, http.StatusTooManyRequests, chatError{Error: reason})
+		return
+	}
+	defer release()
+	defer s.metrics.connect()()
+
+	body := r.Body
+	if s.opts.MaxMessageBytes > 0 {
+		body = http.MaxBytesReader(w, body, s.opts.MaxMessageBytes)
+	}
+	var req types.Request
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, chatError{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	if s.opts.Verbose {
+		log.Printf("Chat request from %s: model=%s, messageLen=%d, historyLen=%d", r.RemoteAddr, req.Model, len(req.Message), len(req.History))
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		resp, err := s.chat(r.Context(), req)
+		if err != nil {
+			log.Printf("Chat execution failed: %v", err)
+			writeJSON(w, http.StatusInternalServerError, chatError{Error: err.Error()})
+			return
+		}
+		fillLastAssistantMsg(resp)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, chatError{Error: "streaming not supported"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var mutex sync.Mutex
+	sendEvent := func(name string, data interface{}) {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("Failed to marshal %s event: %v", name, err)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if name != "" {
+			fmt.Fprintf(w, "event: %s\n", name)
+		}
+		fmt.Fprintf(w, "data: %s\n\n", payload)
+		flusher.Flush()
+	}
+	req.EventCallback = func(event types.Message) {
+		sendEvent("", event.TimeFilled())
+	}
+
+	resp, err := s.chat(r.Context(), req)
+	if err != nil {
+		log.Printf("Chat execution failed: %v", err)
+		sendEvent("error", chatError{Error: err.Error()})
+		return
+	}
+	fillLastAssistantMsg(resp)
+	sendEvent("end", resp)
+}
+
+// fillLastAssistantMsg sets resp.LastAssistantMsg from the messages, as the cli client does
+func fillLastAssistantMsg(resp *types.Response) {
+	for i := len(resp.Messages) - 1; i >= 0; i-- {
+		msg := resp.Messages[i]
+		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_Assistant {
+			resp.LastAssistantMsg = msg.Content
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
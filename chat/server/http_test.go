@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/run/mock_server"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func startChatHTTPServer(t *testing.T) (chatURL string, modelURL string) {
+	mockServer := mock_server.NewMockServer(mock_server.Config{Provider: "openai", Seed: 1})
+	modelServer := httptest.NewServer(http.HandlerFunc(mockServer.HandleOpenAIMock))
+	t.Cleanup(modelServer.Close)
+
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleChat))
+	t.Cleanup(httpServer.Close)
+	return httpServer.URL + "/chat", modelServer.URL
+}
+
+func newChatHTTPRequest(t *testing.T, chatURL string, modelURL string) *http.Request {
+	body, err := json.Marshal(types.Request{Model: "gpt-4.1", Token: "test", BaseURL: modelURL, Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, chatURL, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return httpReq
+}
+
+func TestChatHTTP(t *testing.T) {
+	chatURL, modelURL := startChatHTTPServer(t)
+
+	httpResp, err := http.DefaultClient.Do(newChatHTTPRequest(t, chatURL, modelURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %s", httpResp.Status)
+	}
+	var resp types.Response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.LastAssistantMsg == "" {
+		t.Errorf("expected the last assistant message, got %+v", resp)
+	}
+	if len(resp.Messages) == 0 || resp.Messages[len(resp.Messages)-1].Content != resp.LastAssistantMsg {
+		t.Errorf("expected the messages to end with the assistant message, got %+v", resp.Messages)
+	}
+	if resp.TokenUsage.Total == 0 {
+		t.Errorf("expected token usage, got %+v", resp.TokenUsage)
+	}
+
+	getResp, err := http.Get(chatURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getResp.Body.Close()
+	if getResp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %s", getResp.Status)
+	}
+}
+
+func TestChatHTTPEventStream(t *testing.T) {
+	chatURL, modelURL := startChatHTTPServer(t)
+
+	httpReq := newChatHTTPRequest(t, chatURL, modelURL)
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if ct := httpResp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+
+	var events []types.Message
+	var resp *types.Response
+	var eventName string
+	scanner := bufio.NewScanner(httpResp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventName = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			switch eventName {
+			case "":
+				var event types.Message
+				if err := json.Unmarshal(data, &event); err != nil {
+					t.Fatal(err)
+				}
+				events = append(events, event)
+			case "end":
+				resp = &types.Response{}
+				if err := json.Unmarshal(data, resp); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				t.Fatalf("unexpected %s event: %s", eventName, data)
+			}
+			eventName = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp == nil {
+		t.Fatal("expected the end event")
+	}
+	var assistantMsg string
+	for _, event := range events {
+		if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+			assistantMsg = event.Content
+		}
+	}
+	if assistantMsg == "" || assistantMsg != resp.LastAssistantMsg {
+		t.Errorf("expected the assistant message streamed and in the response, got %q and %q", assistantMsg, resp.LastAssistantMsg)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	mockServer := mock_server.NewMockServer(mock_server.Config{Provider: "openai", Seed: 1})
+	modelServer := httptest.NewServer(http.HandlerFunc(mockServer.HandleOpenAIMock))
+	defer modelServer.Close()
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat", s.handleChat)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	scrape := func() string {
+		resp, err := http.Get(httpServer.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	before := scrape()
+	for _, line := range []string{"kode_active_connections 0", "kode_chats_total 0", `kode_tokens_total{type="total"} 0`, "kode_cost_usd_total 0"} {
+		if !strings.Contains(before, line+"\n") {
+			t.Errorf("expected %q before any chat, got:\n%s", line, before)
+		}
+	}
+
+	httpResp, err := http.DefaultClient.Do(newChatHTTPRequest(t, httpServer.URL+"/chat", modelServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp types.Response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+
+	after := scrape()
+	for _, line := range []string{
+		"kode_active_connections 0",
+		"kode_chats_total 1",
+		"kode_chat_errors_total 0",
+		fmt.Sprintf(`kode_tokens_total{type="total"} %d`, resp.TokenUsage.Total),
+	} {
+		if !strings.Contains(after, line+"\n") {
+			t.Errorf("expected %q after a chat, got:\n%s", line, after)
+		}
+	}
+	if resp.TokenUsage.Total == 0 {
+		t.Errorf("expected the chat to use tokens")
+	}
+	if resp.Cost != nil && !strings.Contains(after, "kode_cost_usd_total "+resp.Cost.TotalUSD+"\n") {
+		t.Errorf("expected cost %s, got:\n%s", resp.Cost.TotalUSD, after)
+	}
+}
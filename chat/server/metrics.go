@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// serverMetrics accumulates the usage of all sessions, served at /metrics
+type serverMetrics struct {
+	mutex             sync.Mutex
+	activeConnections int64
+	chats             int64
+	chatErrors        int64
+	toolCalls         int64
+	tokenUsage        types.TokenUsage
+	cost              types.TokenCost
+}
+
+// connect counts an active connection until the returned func is called
+func (m *serverMetrics) connect() (disconnect func()) {
+	m.mutex.Lock()
+	m.activeConnections++
+	m.mutex.Unlock()
+	return func() {
+		m.mutex.Lock()
+		m.activeConnections--
+		m.mutex.Unlock()
+	}
+}
+
+// record adds the outcome of a chat
+func (m *serverMetrics) record(resp *types.Response, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.chats++
+	if err != nil {
+		m.chatErrors++
+		return
+	}
+	m.tokenUsage = m.tokenUsage.Add(resp.TokenUsage)
+	if resp.Cost != nil {
+		m.cost = m.cost.Add(*resp.Cost)
+	}
+	for _, msg := range resp.Messages {
+		if msg.Type == types.MsgType_ToolCall && !msg.IsPartial() {
+			m.toolCalls++
+		}
+	}
+}
+
+// chat runs req with chat.Chat, recording it in the metrics
+func (s *Server) chat(ctx context.Context, req types.Request) (*types.Response, error) {
+	resp, err := chat.Chat(ctx, req)
+	s.metrics.record(resp, err)
+	return resp, err
+}
+
+// handleMetrics serves the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := s.metrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cost := m.cost.TotalUSD
+	if cost == "" {
+		cost = "0"
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP kode_active_connections Connections of /stream and /chat being served.\n")
+	fmt.Fprintf(w, "# TYPE kode_active_connections gauge\n")
+	fmt.Fprintf(w, "kode_active_connections %d\n", m.activeConnections)
+	fmt.Fprintf(w, "# HELP kode_chats_total Chats run, including failed ones.\n")
+	fmt.Fprintf(w, "# TYPE kode_chats_total counter\n")
+	fmt.Fprintf(w, "kode_chats_total %d\n", m.chats)
+	fmt.Fprintf(w, "# HELP kode_chat_errors_total Chats failed.\n")
+	fmt.Fprintf(w, "# TYPE kode_chat_errors_total counter\n")
+	fmt.Fprintf(w, "kode_chat_errors_total %d\n", m.chatErrors)
+	fmt.Fprintf(w, "# HELP kode_tokens_total Tokens used by completed chats.\n")
+	fmt.Fprintf(w, "# TYPE kode_tokens_total counter\n")
+	fmt.Fprintf(w, "kode_tokens_total{type=\"input\"} %d\n", m.tokenUsage.Input)
+	fmt.Fprintf(w, "kode_tokens_total{type=\"output\"} %d\n", m.tokenUsage.Output)
+	fmt.Fprintf(w, "kode_tokens_total{type=\"total\"} %d\n", m.tokenUsage.Total)
+	fmt.Fprintf(w, "# HELP kode_cost_usd_total Cost in USD of completed chats with known pricing.\n")
+	fmt.Fprintf(w, "# TYPE kode_cost_usd_total counter\n")
+	fmt.Fprintf(w, "kode_cost_usd_total %s\n", cost)
+	fmt.Fprintf(w, "# HELP kode_tool_calls_total Tool calls made by completed chats.\n")
+	fmt.Fprintf(w, "# TYPE kode_tool_calls_total counter\n")
+	fmt.Fprintf(w, "kode_tool_calls_total %d\n", m.toolCalls)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// connLimiter limits the concurrent connections and the connection rate of each client IP,
+// the rate is a token bucket holding up to ratePerMinute tokens
+type connLimiter struct {
+	maxConnections int
+	ratePerMinute  int
+
+	mutex     sync.Mutex
+	clients   map[string]*clientLimit
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type clientLimit struct {
+	active int
+	tokens float64
+	last   time.Time
+}
+
+func newConnLimiter(maxConnections int, ratePerMinute int) *connLimiter {
+	if maxConnections <= 0 && ratePerMinute <= 0 {
+		return nil
+	}
+	return &connLimiter{
+		maxConnections: maxConnections,
+		ratePerMinute:  ratePerMinute,
+		clients:        make(map[string]*clientLimit),
+		now:            time.Now,
+	}
+}
+
+// acquire admits a connection from remoteAddr, the returned release must be
+// called when it ends. It returns the reason if the connection is rejected
+func (l *connLimiter) acquire(remoteAddr string) (release func(), reason string) {
+	if l == nil {
+		return func() {}, ""
+	}
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	client := l.clients[ip]
+	if client == nil {
+		client = &clientLimit{tokens: float64(l.ratePerMinute), last: now}
+		l.clients[ip] = client
+	}
+	if l.maxConnections > 0 && client.active >= l.maxConnections {
+		return nil, "too many connections"
+	}
+	if l.ratePerMinute > 0 {
+		l.refill(client, now)
+		if client.tokens < 1 {
+			return nil, "too many requests"
+		}
+		client.tokens--
+	}
+	client.active++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			client.active--
+		})
+	}, ""
+}
+
+func (l *connLimiter) refill(client *clientLimit, now time.Time) {
+	elapsed := now.Sub(client.last)
+	client.last = now
+	client.tokens += elapsed.Minutes() * float64(l.ratePerMinute)
+	if max := float64(l.ratePerMinute); client.tokens > max {
+		client.tokens = max
+	}
+}
+
+// sweep forgets idle clients whose bucket is full again, at most once a minute
+func (l *connLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for ip, client := range l.clients {
+		if client.active > 0 {
+			continue
+		}
+		l.refill(client, now)
+		if client.tokens >= float64(l.ratePerMinute) {
+			delete(l.clients, ip)
+		}
+	}
+}
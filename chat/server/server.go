@@ -3,35 +3,61 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/xhd2015/kode-ai/chat"
 	"github.com/xhd2015/kode-ai/types"
 )
 
 // ServerOptions represents the configuration options for the chat server
 type ServerOptions struct {
 	Verbose bool // Enable verbose logging
+
+	// IdleTimeout closes a WebSocket connection when no message is received
+	// or sent for the duration, 0 means no timeout. Model calls send nothing
+	// until they respond, so it should exceed the longest expected model call
+	IdleTimeout time.Duration
+
+	// MaxConnectionsPerIP limits the concurrent /stream connections of a client IP, 0 means no limit
+	MaxConnectionsPerIP int
+	// RatePerMinute limits the /stream connections a client IP opens per minute, 0 means no limit
+	RatePerMinute int
+
+	// MaxMessageBytes closes a WebSocket connection receiving a larger message, 0 means no limit
+	MaxMessageBytes int64
+
+	// MaxCostPerConnectionUSD stops the chat of a /stream connection with an error event once
+	// its cost exceeds the amount, 0 means no limit. Chats of models without known pricing are rejected
+	MaxCostPerConnectionUSD float64
 }
 
 // Server represents the chat server
 type Server struct {
-	port   int
-	opts   ServerOptions
-	server *http.Server
+	port    int
+	opts    ServerOptions
+	server  *http.Server
+	limiter *connLimiter
+	metrics *serverMetrics
+
+	sessionsMutex sync.Mutex
+	sessions      map[string]*streamSession
 }
 
 // NewServer creates a new chat server
 func NewServer(port int, opts ServerOptions) (*Server, error) {
 	server := &Server{
-		port: port,
-		opts: opts,
+		port:    port,
+		opts:    opts,
+		limiter: newConnLimiter(opts.MaxConnectionsPerIP, opts.RatePerMinute),
+		metrics: &serverMetrics{},
 	}
 	return server, nil
 }
@@ -48,7 +74,9 @@ func Start(port int, opts ServerOptions) error {
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/stream", s.handleWebSocket)
+	mux.HandleFunc("/chat", s.handleChat)
 	mux.HandleFunc("/shutdown", s.handleShutdown)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 
 	addr := fmt.Sprintf(":%d", s.port)
 	log.Printf("Starting chat server on %s", addr)
@@ -77,6 +105,8 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for now
 	},
+	// negotiate per-message deflate, histories compress well
+	EnableCompression: true,
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -84,7 +114,24 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		log.Printf("WebSocket connection request from %s", r.RemoteAddr)
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	release, reason := s.limiter.acquire(r.RemoteAddr)
+	if reason != "" {
+		if s.opts.Verbose {
+			log.Printf("Rejecting WebSocket connection from %s: %s", r.RemoteAddr, reason)
+		}
+		http.Error(w, reason, http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+	defer s.metrics.connect()()
+
+	if sessionID := r.URL.Query().Get("session"); sessionID != "" {
+		s.handleResume(w, r, sessionID)
+		return
+	}
+
+	sessionID := uuid.New().String()
+	conn, err := upgrader.Upgrade(w, r, http.Header{SessionHeader: {sessionID}})
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
@@ -96,6 +143,10 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		conn.Close()
 	}()
 
+	if s.opts.MaxMessageBytes > 0 {
+		conn.SetReadLimit(s.opts.MaxMessageBytes)
+	}
+
 	if s.opts.Verbose {
 		log.Printf("WebSocket connection established with %s", r.RemoteAddr)
 	}
@@ -121,11 +172,39 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			waitForStreamEvents, model, baseURL, token != "", len(msg), len(systemPrompt))
 	}
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Create WebSocket-based stream reader
 	wsReader := NewWebSocketReader(conn)
 	wsReader.verbose = s.opts.Verbose
+	sess := s.newStreamSession(sessionID, conn, wsReader)
+	defer s.finishStreamSession(sess)
+	touch := func() {}
+	if s.opts.IdleTimeout > 0 {
+		idle := newIdleWatcher(s.opts.IdleTimeout, func() {
+			log.Printf("Closing WebSocket connection from %s: idle for %v", r.RemoteAddr, s.opts.IdleTimeout)
+			sess.close(websocket.CloseGoingAway, "idle timeout")
+			cancel()
+			conn.Close()
+		})
+		defer idle.Stop()
+		touch = idle.Touch
+		wsReader.onMessage = touch
+	}
+	wsReader.onReadError = func(err error) {
+		if errors.Is(err, websocket.ErrReadLimit) {
+			log.Printf("Closing WebSocket connection from %s: message exceeds %d bytes", r.RemoteAddr, s.opts.MaxMessageBytes)
+			cancel()
+		}
+	}
+	// set by the reader, read after the chat returns
+	var cancelled atomic.Bool
+	wsReader.onCancel = func() {
+		log.Printf("Cancelling chat of %s: requested by the client", r.RemoteAddr)
+		cancelled.Store(true)
+		cancel()
+	}
 	wsReader.Start()
 	defer wsReader.Close()
 
@@ -150,7 +229,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		messages, err := s.loadInitialEventsFromWebSocket(ctx, wsReader, &req, 30*time.Second)
 		if err != nil {
 			log.Printf("Failed to load initial events: %v", err)
-			s.sendError(conn, fmt.Sprintf("Failed to load initial events: %v", err))
+			sess.send(s.errorEvent(fmt.Sprintf("Failed to load initial events: %v", err)))
 			return
 		}
 		req.History = append(req.History, s.convertMessagesToHistory(messages)...)
@@ -179,8 +258,26 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		Output: NewWebSocketWriter(onWrite, s.opts.Verbose),
 	}
 
+	var ceiling *costCeiling
+	if s.opts.MaxCostPerConnectionUSD > 0 {
+		ceiling = &costCeiling{maxUSD: s.opts.MaxCostPerConnectionUSD}
+		if err := ceiling.check(req.Model); err != nil {
+			log.Printf("Rejecting chat of %s: %v", r.RemoteAddr, err)
+			sess.send(s.errorEvent(err.Error()))
+			return
+		}
+	}
+	// set by the event callback, read after the chat returns
+	var costErr error
 	req.EventCallback = func(event types.Message) {
 		event = event.TimeFilled()
+		if ceiling != nil && costErr == nil && event.Type == types.MsgType_TokenUsage && event.TokenUsage != nil {
+			if err := ceiling.add(event.Model, *event.TokenUsage); err != nil {
+				costErr = err
+				// the next model call fails with the canceled context
+				cancel()
+			}
+		}
 		if s.opts.Verbose {
 			log.Printf("Sending event to %s: type=%s, role=%s, contentLen=%d", r.RemoteAddr, event.Type, event.Role, len(event.Content))
 		}
@@ -197,26 +294,36 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	go func() {
 		defer close(chanDone)
 		for msg := range msgChan {
+			touch()
 			if msg.isText {
-				if err := conn.WriteMessage(websocket.TextMessage, msg.textData); err != nil {
+				if err := sess.sendLine(msg.textData); err != nil {
 					log.Printf("Failed to send data: %v", err)
 				}
 			} else {
-				event := msg.event.TimeFilled()
-				if err := conn.WriteJSON(event); err != nil {
-					log.Printf("Failed to send event: %v", err)
-				}
+				sess.send(msg.event.TimeFilled())
 			}
 		}
 	}()
 
 	// Execute chat
-	_, err = chat.Chat(ctx, req)
+	_, err = s.chat(ctx, req)
 	close(msgChan)
 	<-chanDone
+	if cancelled.Load() {
+		sess.send(types.Message{
+			Type:  types.MsgType_StreamEnd,
+			Error: "cancelled by the client",
+		}.TimeFilled())
+		return
+	}
+	if costErr != nil {
+		log.Printf("Stopping chat of %s: %v", r.RemoteAddr, costErr)
+		sess.send(s.errorEvent(costErr.Error()))
+		return
+	}
 	if err != nil {
 		log.Printf("Chat execution failed: %v", err)
-		s.sendError(conn, fmt.Sprintf("Chat execution failed: %v", err))
+		sess.send(s.errorEvent(fmt.Sprintf("Chat execution failed: %v", err)))
 		return
 	}
 
@@ -233,16 +340,12 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Sending stream end event to %s", r.RemoteAddr)
 	}
 
-	if err := conn.WriteJSON(endEvent); err != nil {
-		log.Printf("Failed to send stream end event: %v", err)
-	}
+	sess.send(endEvent)
 
 	if s.opts.Verbose {
 		log.Printf("Sending close message to %s", r.RemoteAddr)
 	}
-
-	// Close the connection gracefully
-	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	// the deferred finishStreamSession closes the connection gracefully
 }
 
 func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
@@ -261,19 +364,20 @@ func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) sendError(conn *websocket.Conn, errorMsg string) {
+	if err := conn.WriteJSON(s.errorEvent(errorMsg)); err != nil {
+		log.Printf("Failed to send error message: %v", err)
+	}
+}
+
+func (s *Server) errorEvent(errorMsg string) types.Message {
 	if s.opts.Verbose {
 		log.Printf("Sending error message: %s", errorMsg)
 	}
-
-	errorEvent := types.Message{
+	return types.Message{
 		Type:    types.MsgType_Error,
 		Content: errorMsg,
 		Error:   errorMsg,
 	}.TimeFilled()
-
-	if err := conn.WriteJSON(errorEvent); err != nil {
-		log.Printf("Failed to send error message: %v", err)
-	}
 }
 
 func (s *Server) loadInitialEventsFromWebSocket(ctx context.Context, reader *WebSocketReader, req *types.Request, timeout time.Duration) ([]types.Message, error) {
@@ -329,6 +433,34 @@ func (s *Server) convertMessagesToHistory(messages []types.Message) []types.Mess
 	return history
 }
 
+// idleWatcher calls onIdle once if Touch is not called within timeout
+type idleWatcher struct {
+	timeout time.Duration
+
+	mutex sync.Mutex
+	timer *time.Timer
+}
+
+func newIdleWatcher(timeout time.Duration, onIdle func()) *idleWatcher {
+	return &idleWatcher{
+		timeout: timeout,
+		timer:   time.AfterFunc(timeout, onIdle),
+	}
+}
+
+// Touch restarts the timeout, it has no effect once onIdle is called
+func (w *idleWatcher) Touch() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timer.Stop() {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+func (w *idleWatcher) Stop() {
+	w.timer.Stop()
+}
+
 // WebSocketReader implements types.StdinReader for WebSocket connections
 type WebSocketReader struct {
 	conn     *websocket.Conn
@@ -337,6 +469,16 @@ type WebSocketReader struct {
 	done     chan struct{}
 	mutex    sync.RWMutex
 	verbose  bool
+
+	// onMessage is called for each message received, if set
+	onMessage func()
+	// onReadError is called with the error ending the read loop, if set
+	onReadError func(err error)
+	// onCancel is called when the client sends a stream cancel, if set
+	onCancel func()
+
+	// pending is the rest of the message line not yet returned by Read
+	pending []byte
 }
 
 // NewWebSocketReader creates a new WebSocket reader
@@ -350,7 +492,12 @@ func NewWebSocketReader(conn *websocket.Conn) *WebSocketReader {
 }
 
 func (wr *WebSocketReader) Start() {
-	go wr.readLoop()
+	go wr.readLoop(wr.conn)
+}
+
+// attach reads from conn, which replaces the connection of a resumed session
+func (wr *WebSocketReader) attach(conn *websocket.Conn) {
+	go wr.readLoop(conn)
 }
 
 func (wr *WebSocketReader) Close() {
@@ -361,7 +508,7 @@ func (wr *WebSocketReader) MessageChan() <-chan types.Message {
 	return wr.msgChan
 }
 
-func (wr *WebSocketReader) readLoop() {
+func (wr *WebSocketReader) readLoop(conn *websocket.Conn) {
 	if wr.verbose {
 		log.Printf("WebSocket reader loop started")
 	}
@@ -374,19 +521,31 @@ func (wr *WebSocketReader) readLoop() {
 			return
 		default:
 			var msg types.Message
-			err := wr.conn.ReadJSON(&msg)
+			err := conn.ReadJSON(&msg)
 			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					log.Printf("WebSocket error: %v", err)
 				} else if wr.verbose {
 					log.Printf("WebSocket read ended: %v", err)
 				}
+				if wr.onReadError != nil {
+					wr.onReadError(err)
+				}
 				return
 			}
 
 			if wr.verbose {
 				log.Printf("WebSocket received message: type=%s, streamID=%s, contentLen=%d", msg.Type, msg.StreamID, len(msg.Content))
 			}
+			if wr.onMessage != nil {
+				wr.onMessage()
+			}
+			if msg.Type == types.MsgType_StreamCancel {
+				if wr.onCancel != nil {
+					wr.onCancel()
+				}
+				continue
+			}
 
 			// Send to general message channel
 			select {
@@ -446,25 +605,25 @@ func (wr *WebSocketReader) Unsubscribe(id string) {
 	}
 }
 
-// Read implements io.Reader interface
+// Read implements io.Reader interface, each message is a JSON line.
+// A line longer than p is returned across multiple calls
 func (wr *WebSocketReader) Read(p []byte) (n int, err error) {
-	select {
-	case msg := <-wr.msgChan:
-		data, err := json.Marshal(msg)
-		if err != nil {
-			return 0, err
-		}
-		data = append(data, '\n') // Add newline to match stdin behavior
-
-		if len(data) > len(p) {
-			return 0, fmt.Errorf("buffer too small")
+	if len(wr.pending) == 0 {
+		select {
+		case msg := <-wr.msgChan:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return 0, err
+			}
+			wr.pending = append(data, '\n') // Add newline to match stdin behavior
+		case <-wr.done:
+			return 0, io.EOF
 		}
-
-		copy(p, data)
-		return len(data), nil
-	case <-wr.done:
-		return 0, io.EOF
 	}
+
+	n = copy(p, wr.pending)
+	wr.pending = wr.pending[n:]
+	return n, nil
 }
 
 // WebSocketWriter implements io.Writer for WebSocket connections
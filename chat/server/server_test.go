@@ -0,0 +1,745 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/cli"
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/run/mock_server"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestIdleConnectionClosed(t *testing.T) {
+	s, err := NewServer(0, ServerOptions{IdleTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	// the client connects but never sends the initial events
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatalf("expected going away close frame, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected close after the idle timeout, took %v", elapsed)
+	}
+}
+
+func TestConnectionsPerIPLimited(t *testing.T) {
+	s, err := NewServer(0, ServerOptions{MaxConnectionsPerIP: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	// connections waiting for the initial events stay open
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		defer conn.Close()
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatalf("expected the third connection rejected")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v %v", resp, err)
+	}
+}
+
+func TestConnLimiterRatePerMinute(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newConnLimiter(0, 2)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		release, reason := limiter.acquire("10.0.0.1:1234")
+		if reason != "" {
+			t.Fatalf("connection %d: expected admitted, got %s", i, reason)
+		}
+		release()
+	}
+	if _, reason := limiter.acquire("10.0.0.1:5678"); reason != "too many requests" {
+		t.Errorf("expected rate limited, got %q", reason)
+	}
+	if _, reason := limiter.acquire("10.0.0.2:1234"); reason != "" {
+		t.Errorf("expected another IP admitted, got %q", reason)
+	}
+
+	// a token is refilled every 30s
+	now = now.Add(30 * time.Second)
+	if _, reason := limiter.acquire("10.0.0.1:1234"); reason != "" {
+		t.Errorf("expected admitted after refill, got %q", reason)
+	}
+}
+
+func TestWebSocketReaderReadLargeMessage(t *testing.T) {
+	reader := NewWebSocketReader(nil)
+	msg := types.Message{Type: types.MsgType_ToolResult, Content: strings.Repeat("x", 1000)}
+	reader.msgChan <- msg
+
+	var data []byte
+	buf := make([]byte, 16)
+	for !bytes.HasSuffix(data, []byte("\n")) {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		data = append(data, buf[:n]...)
+	}
+	var got types.Message
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+	if got.Type != msg.Type || got.Content != msg.Content {
+		t.Errorf("expected the message read in full, got %+v", got)
+	}
+
+	reader.Close()
+	if _, err := reader.Read(buf); err != io.EOF {
+		t.Errorf("expected EOF after close, got %v", err)
+	}
+}
+
+func TestMessageOverLimitClosesConnection(t *testing.T) {
+	s, err := NewServer(0, ServerOptions{MaxMessageBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(types.Message{Type: types.MsgType_Msg, Content: strings.Repeat("x", 1000)}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Fatalf("expected message too big close frame, got %v", err)
+	}
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	conn, resp, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if ext := resp.Header.Get("Sec-Websocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Errorf("expected permessage-deflate negotiated, got %q", ext)
+	}
+}
+
+func TestResumeStreamAfterDisconnect(t *testing.T) {
+	mockServer := mock_server.NewMockServer(mock_server.Config{Provider: "openai", Seed: 1})
+	modelServer := httptest.NewServer(http.HandlerFunc(mockServer.HandleOpenAIMock))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	sessionID := resp.Header.Get(SessionHeader)
+	if sessionID == "" {
+		t.Fatalf("expected %s header", SessionHeader)
+	}
+
+	initReq, err := json.Marshal(types.Request{Model: "gpt-4.1", Token: "test", BaseURL: modelServer.URL, Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []types.Message{
+		{Type: types.MsgType_StreamInitRequest, Content: string(initReq)},
+		{Type: types.MsgType_StreamInitEventsFinished},
+	} {
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	var events []types.Message
+	receive := func(conn *websocket.Conn, event types.Message) {
+		events = append(events, event)
+		if event.Type == types.MsgType_StreamRequestUserMsg {
+			// no follow-up message
+			if err := conn.WriteJSON(types.Message{Type: types.MsgType_StreamEnd, StreamID: event.StreamID}); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(events) < 2 {
+		var event types.Message
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		receive(conn, event)
+	}
+	// drop the connection without a close frame
+	conn.UnderlyingConn().Close()
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"&session=unknown&resume_from=0", nil); err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 resuming an unknown session, got %v", err)
+	}
+
+	lastSeq := events[len(events)-1].Seq
+	resumeURL := fmt.Sprintf("%s&session=%s&resume_from=%d", wsURL, sessionID, lastSeq)
+	conn, _, err = websocket.DefaultDialer.Dial(resumeURL, nil)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event types.Message
+		if err := conn.ReadJSON(&event); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("expected normal close after the stream end, got %v", err)
+			}
+			break
+		}
+		receive(conn, event)
+	}
+
+	for i, event := range events {
+		if event.Seq != int64(i+1) {
+			t.Fatalf("expected event %d to have seq %d, got %d: %+v", i, i+1, event.Seq, event)
+		}
+	}
+	if last := events[len(events)-1]; last.Type != types.MsgType_StreamEnd {
+		t.Errorf("expected the stream end last, got %s", last.Type)
+	}
+}
+
+func TestConnectionCutOverCostCeiling(t *testing.T) {
+	// each round calls a tool, costing $2.5 for 1M input tokens of gpt-4o
+	var requests int32
+	modelServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"chatcmpl-%d","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_%d","type":"function","function":{"name":"list_dir","arguments":"{\"relative_workspace_path\":\".\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":1000000,"completion_tokens":10,"total_tokens":1000010}}`, n, n)
+	}))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{MaxCostPerConnectionUSD: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	initReq, err := json.Marshal(types.Request{Model: "gpt-4o", Token: "test", BaseURL: modelServer.URL, Message: "list", Tools: []string{"list_dir"}, DefaultToolCwd: t.TempDir(), MaxRounds: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []types.Message{
+		{Type: types.MsgType_StreamInitRequest, Content: string(initReq)},
+		{Type: types.MsgType_StreamInitEventsFinished},
+	} {
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	var errorEvent *types.Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event types.Message
+		if err := conn.ReadJSON(&event); err != nil {
+			break
+		}
+		if event.Type == types.MsgType_Error {
+			errorEvent = &event
+		}
+	}
+	if errorEvent == nil || !strings.Contains(errorEvent.Error, "exceeds the ceiling") {
+		t.Fatalf("expected the cost ceiling error, got %+v", errorEvent)
+	}
+	// the second round reaches $5, no more rounds follow
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected the chat cut after 2 requests, got %d", n)
+	}
+}
+
+func TestCostCeilingRejectsUnpricedModel(t *testing.T) {
+	const model = "unpriced-gateway-model"
+	if err := providers.RegisterModel(types.ModelInfo{Name: model, Provider: providers.ProviderOpenAI, APIShape: providers.APIShapeOpenAI}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		delete(types.AllModelInfos, model)
+	})
+
+	var requests int32
+	modelServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"unpriced-gateway-model","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1000000,"completion_tokens":10,"total_tokens":1000010}}`)
+	}))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{MaxCostPerConnectionUSD: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	initReq, err := json.Marshal(types.Request{Model: model, Token: "test", BaseURL: modelServer.URL, Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []types.Message{
+		{Type: types.MsgType_StreamInitRequest, Content: string(initReq)},
+		{Type: types.MsgType_StreamInitEventsFinished},
+	} {
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	var errorEvent *types.Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event types.Message
+		if err := conn.ReadJSON(&event); err != nil {
+			break
+		}
+		if event.Type == types.MsgType_Error {
+			errorEvent = &event
+		}
+	}
+	if errorEvent == nil || !strings.Contains(errorEvent.Error, "no known pricing") {
+		t.Fatalf("expected the unpriced model rejected, got %+v", errorEvent)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("expected no model request, got %d", n)
+	}
+}
+
+func TestStreamCancelDuringToolCall(t *testing.T) {
+	modelServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"slow_tool","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream?wait_for_stream_events=true"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// the client executes slow_tool
+	initReq, err := json.Marshal(types.Request{Model: "gpt-4o", Token: "test", BaseURL: modelServer.URL, Message: "run", MaxRounds: 3,
+		ToolDefinitions: []*types.UnifiedTool{{Name: "slow_tool", Description: "takes long"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []types.Message{
+		{Type: types.MsgType_StreamInitRequest, Content: string(initReq)},
+		{Type: types.MsgType_StreamInitEventsFinished},
+	} {
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	var cancelledAt time.Time
+	var end *types.Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for end == nil {
+		var event types.Message
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		switch event.Type {
+		case types.MsgType_StreamRequestTool:
+			// acknowledge the tool call but never respond, then cancel
+			for _, msg := range []types.Message{
+				{Type: types.MsgType_StreamHandleAck, StreamID: event.StreamID},
+				{Type: types.MsgType_StreamCancel},
+			} {
+				if err := conn.WriteJSON(msg); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+			}
+			cancelledAt = time.Now()
+		case types.MsgType_StreamEnd:
+			end = &event
+		}
+	}
+	if cancelledAt.IsZero() {
+		t.Fatal("expected the tool requested before the stream end")
+	}
+	if elapsed := time.Since(cancelledAt); elapsed > time.Second {
+		t.Errorf("expected the stream ended promptly after the cancel, took %v", elapsed)
+	}
+	if end.Error != "cancelled by the client" {
+		t.Errorf("expected the stream end to report the cancel, got %q", end.Error)
+	}
+}
+
+func TestInjectUserMsgDuringChat(t *testing.T) {
+	testInjectUserMsg(t, 3)
+}
+
+// the message injected in the last round gets one more round
+func TestInjectUserMsgOnFinalRound(t *testing.T) {
+	testInjectUserMsg(t, 1)
+}
+
+func testInjectUserMsg(t *testing.T, maxRounds int) {
+	var mutex sync.Mutex
+	var requests []string
+	modelServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, string(data))
+		n := len(requests)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"slow_tool","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the user steers the chat while the tool runs
+	injectUserMsgs := make(chan string, 1)
+	var events []types.Message
+	resp, err := cli.ChatWithServer(ctx, httpServer.URL, types.Request{
+		Model:           "gpt-4o",
+		Token:           "test",
+		BaseURL:         modelServer.URL,
+		Message:         "run",
+		MaxRounds:       maxRounds,
+		ToolDefinitions: []*types.UnifiedTool{{Name: "slow_tool", Description: "takes long"}},
+		InjectUserMsgs:  injectUserMsgs,
+		ToolCallback: func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			injectUserMsgs <- "focus on the tests"
+			time.Sleep(100 * time.Millisecond)
+			return types.ToolResult{Content: "ok"}, true, nil
+		},
+		EventCallback: func(event types.Message) {
+			events = append(events, event)
+		},
+	})
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 model requests, got %d", len(requests))
+	}
+	if !strings.Contains(requests[1], "focus on the tests") {
+		t.Errorf("expected the injected message sent to the model in the next round, got %s", requests[1])
+	}
+	var answered bool
+	for _, event := range events {
+		answered = answered || (event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant && event.Content == "done")
+	}
+	if !answered {
+		t.Errorf("expected the answer after the injected message, got %+v", events)
+	}
+	if resp.StopReason == types.StopReason_MaxRounds {
+		t.Errorf("expected the chat to end with the answer, got stop reason %s", resp.StopReason)
+	}
+}
+
+func TestCommandToolSameThroughServerClient(t *testing.T) {
+	modelServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(data), `"role":"tool"`) {
+			fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"}}]},"finish_reason":"tool_calls"}]}`)
+	}))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	t.Setenv("KODE_TEST_UNIT", "celsius")
+	dir := t.TempDir()
+	toolDef := &types.UnifiedTool{
+		Name:    "get_weather",
+		Command: []string{"sh", "-c", `echo "$0 $1 $2 $KODE_TEST_FORECAST $KODE_TEST_WIND"`, "${city}", "${env.KODE_TEST_UNIT}", "${cwd}"},
+		Env:     map[string]string{"KODE_TEST_FORECAST": "sunny", "KODE_TEST_WIND": "calm"},
+	}
+	toolResult := func(events []types.Message) string {
+		for _, event := range events {
+			if event.Type == types.MsgType_ToolResult {
+				return event.Content
+			}
+		}
+		return ""
+	}
+	newRequest := func(events *[]types.Message) types.Request {
+		return types.Request{
+			Model:           "gpt-4o",
+			Token:           "test",
+			BaseURL:         modelServer.URL,
+			Message:         "weather?",
+			MaxRounds:       2,
+			DefaultToolCwd:  dir,
+			ToolDefinitions: []*types.UnifiedTool{toolDef},
+			EventCallback: func(event types.Message) {
+				*events = append(*events, event)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the chat runs the command itself
+	var chatEvents []types.Message
+	if _, err := chat.Chat(ctx, newRequest(&chatEvents)); err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	// the server asks the client to run the command
+	var clientEvents []types.Message
+	req := newRequest(&clientEvents)
+	req.ToolResolution = types.ToolResolution_CallbackOnly
+	if _, err := cli.ChatWithServer(ctx, httpServer.URL, req); err != nil {
+		t.Fatalf("chat with server: %v", err)
+	}
+
+	want := `{"output":"Tokyo celsius ` + dir + ` sunny calm\n"}`
+	if got := toolResult(chatEvents); got != want {
+		t.Errorf("expected chat tool result %s, got %s", want, got)
+	}
+	if got := toolResult(clientEvents); got != want {
+		t.Errorf("expected client tool result %s, got %s", want, got)
+	}
+}
+
+func TestInjectUserMsgExtraRoundOnce(t *testing.T) {
+	var requests int32
+	modelServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		// the model keeps calling the tool
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"slow_tool","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the user injects in each round
+	injectUserMsgs := make(chan string, 1)
+	var events []types.Message
+	resp, err := cli.ChatWithServer(ctx, httpServer.URL, types.Request{
+		Model:           "gpt-4o",
+		Token:           "test",
+		BaseURL:         modelServer.URL,
+		Message:         "run",
+		MaxRounds:       1,
+		ToolDefinitions: []*types.UnifiedTool{{Name: "slow_tool", Description: "takes long"}},
+		InjectUserMsgs:  injectUserMsgs,
+		ToolCallback: func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			injectUserMsgs <- "keep going"
+			time.Sleep(100 * time.Millisecond)
+			return types.ToolResult{Content: "ok"}, true, nil
+		},
+		EventCallback: func(event types.Message) {
+			events = append(events, event)
+		},
+	})
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected a single extra round, got %d model requests", n)
+	}
+	if resp.StopReason != types.StopReason_MaxRounds {
+		t.Errorf("expected stop reason %s, got %s", types.StopReason_MaxRounds, resp.StopReason)
+	}
+	var reported bool
+	for _, event := range events {
+		reported = reported || (event.Type == types.MsgType_Info && strings.Contains(event.Content, "before the injected message was processed: keep going"))
+	}
+	if !reported {
+		t.Errorf("expected the unprocessed injected message reported, got %+v", events)
+	}
+}
+
+func TestInjectManyUserMsgsDuringToolCall(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	modelServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, string(data))
+		n := len(requests)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"slow_tool","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer modelServer.Close()
+
+	s, err := NewServer(0, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// more messages than a stream subscription buffers, injected while the tool runs
+	const n = 25
+	injectUserMsgs := make(chan string, 1)
+	_, err = cli.ChatWithServer(ctx, httpServer.URL, types.Request{
+		Model:           "gpt-4o",
+		Token:           "test",
+		BaseURL:         modelServer.URL,
+		Message:         "run",
+		MaxRounds:       3,
+		ToolDefinitions: []*types.UnifiedTool{{Name: "slow_tool", Description: "takes long"}},
+		InjectUserMsgs:  injectUserMsgs,
+		ToolCallback: func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			for i := 0; i < n; i++ {
+				injectUserMsgs <- fmt.Sprintf("note %d", i)
+			}
+			time.Sleep(300 * time.Millisecond)
+			return types.ToolResult{Content: "ok"}, true, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 model requests, got %d", len(requests))
+	}
+	for i := 0; i < n; i++ {
+		if note := fmt.Sprintf("note %d", i); !strings.Contains(requests[1], note) {
+			t.Errorf("expected %q sent to the model in the next round", note)
+		}
+	}
+}
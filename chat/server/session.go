@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// SessionHeader is the response header of the /stream upgrade carrying the session ID,
+// a client reconnecting with ?session=ID&resume_from=SEQ receives the events after SEQ
+const SessionHeader = "X-Kode-Session-Id"
+
+const (
+	// replayBufferSize is the number of latest events kept for replay
+	replayBufferSize = 1024
+	// sessionRetention is how long a finished session can still be resumed,
+	// so that a client disconnected right before the end receives it
+	sessionRetention = time.Minute
+)
+
+// streamSession is a chat over /stream that outlives its WebSocket connection.
+// Events are numbered from 1 and the latest are kept, so a reconnecting client
+// can resume without losing or duplicating events
+type streamSession struct {
+	id     string
+	reader *WebSocketReader
+
+	mutex   sync.Mutex
+	conn    *websocket.Conn // nil while disconnected
+	lastSeq int64
+	events  []types.Message // the latest events, ordered by Seq
+	done    chan struct{}
+}
+
+func (s *Server) newStreamSession(id string, conn *websocket.Conn, reader *WebSocketReader) *streamSession {
+	sess := &streamSession{
+		id:     id,
+		reader: reader,
+		conn:   conn,
+		done:   make(chan struct{}),
+	}
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*streamSession)
+	}
+	s.sessions[id] = sess
+	return sess
+}
+
+func (s *Server) getStreamSession(id string) *streamSession {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	return s.sessions[id]
+}
+
+// finishStreamSession closes the current connection of sess and forgets it after sessionRetention
+func (s *Server) finishStreamSession(sess *streamSession) {
+	sess.close(websocket.CloseNormalClosure, "")
+	time.AfterFunc(sessionRetention, func() {
+		s.sessionsMutex.Lock()
+		defer s.sessionsMutex.Unlock()
+		delete(s.sessions, sess.id)
+	})
+}
+
+// send numbers event and writes it to the current connection, if any.
+// A failed write detaches the connection, the event is replayed on resume
+func (c *streamSession) send(event types.Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastSeq++
+	event.Seq = c.lastSeq
+	c.events = append(c.events, event)
+	if len(c.events) > replayBufferSize {
+		c.events = c.events[len(c.events)-replayBufferSize:]
+	}
+	if c.conn == nil {
+		return
+	}
+	if err := c.conn.WriteJSON(event); err != nil {
+		log.Printf("Failed to send event %d, waiting for the client to resume: %v", event.Seq, err)
+		c.conn = nil
+	}
+}
+
+// sendLine sends a JSON line written by the chat to the stream output as an event
+func (c *streamSession) sendLine(data []byte) error {
+	var event types.Message
+	if err := json.Unmarshal(data, &event); err != nil {
+		return fmt.Errorf("decode stream output: %w", err)
+	}
+	c.send(event)
+	return nil
+}
+
+// resume replays the events after afterSeq to conn, which then receives the following events
+func (c *streamSession) resume(conn *websocket.Conn, afterSeq int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.events) > 0 && afterSeq+1 < c.events[0].Seq {
+		return fmt.Errorf("events after %d are no longer available, the oldest is %d", afterSeq, c.events[0].Seq)
+	}
+	for _, event := range c.events {
+		if event.Seq <= afterSeq {
+			continue
+		}
+		if err := conn.WriteJSON(event); err != nil {
+			return fmt.Errorf("replay event %d: %w", event.Seq, err)
+		}
+	}
+	select {
+	case <-c.done:
+		// finished while disconnected, nothing more to receive
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		return nil
+	default:
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+	c.reader.attach(conn)
+	return nil
+}
+
+// close sends a close frame to the current connection and marks the session done
+func (c *streamSession) close(code int, text string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+	}
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+// handleResume reattaches a client to the session it was disconnected from
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request, sessionID string) {
+	sess := s.getStreamSession(sessionID)
+	if sess == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	afterSeq, err := strconv.ParseInt(r.URL.Query().Get("resume_from"), 10, 64)
+	if err != nil || afterSeq < 0 {
+		http.Error(w, "invalid resume_from", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, http.Header{SessionHeader: {sessionID}})
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	if s.opts.MaxMessageBytes > 0 {
+		conn.SetReadLimit(s.opts.MaxMessageBytes)
+	}
+	if s.opts.Verbose {
+		log.Printf("Resuming session %s from %s after event %d", sessionID, r.RemoteAddr, afterSeq)
+	}
+
+	if err := sess.resume(conn, afterSeq); err != nil {
+		log.Printf("Failed to resume session %s: %v", sessionID, err)
+		s.sendError(conn, fmt.Sprintf("Failed to resume: %v", err))
+		return
+	}
+	// the connection is served by the session until the chat ends
+	<-sess.done
+}
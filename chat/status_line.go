@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const statusLineInterval = 100 * time.Millisecond
+
+var statusLineFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// statusLine redraws "thinking..." with the elapsed time and round on a
+// terminal while waiting for the model, it must be paused before other output
+type statusLine struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	round  int
+	start  time.Time
+	frame  int
+	active bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newStatusLine(w io.Writer) *statusLine {
+	c := &statusLine{
+		w:     w,
+		round: 1,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *statusLine) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(statusLineInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.active {
+				c.draw()
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Resume shows the status line, the elapsed time restarts
+func (c *statusLine) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = true
+	c.start = time.Now()
+	c.draw()
+}
+
+// Pause clears the status line, it reports whether the line was shown
+func (c *statusLine) Pause() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.active {
+		return false
+	}
+	c.active = false
+	fmt.Fprint(c.w, "\r\033[K")
+	return true
+}
+
+// NextRound advances the round shown
+func (c *statusLine) NextRound() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.round++
+}
+
+// Close clears the status line and stops redrawing
+func (c *statusLine) Close() {
+	c.Pause()
+	close(c.stop)
+	<-c.done
+}
+
+func (c *statusLine) draw() {
+	elapsed := time.Since(c.start).Truncate(time.Second)
+	fmt.Fprintf(c.w, "\r\033[K%s thinking... %s (round %d)", statusLineFrames[c.frame%len(statusLineFrames)], elapsed, c.round)
+	c.frame++
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStderr returns what fn prints to stderr
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestStatusLine(t *testing.T) {
+	var buf bytes.Buffer
+	status := newStatusLine(&buf)
+	status.Resume()
+	time.Sleep(3 * statusLineInterval)
+	status.NextRound()
+	if !status.Pause() {
+		t.Errorf("expected status line shown")
+	}
+	if status.Pause() {
+		t.Errorf("expected status line already cleared")
+	}
+	status.Close()
+
+	output := buf.String()
+	if !strings.Contains(output, "thinking... 0s (round 1)") {
+		t.Errorf("expected thinking status, got %q", output)
+	}
+	if !strings.HasSuffix(output, "\r\033[K") {
+		t.Errorf("expected status line cleared at last, got %q", output)
+	}
+}
+
+func TestStatusLineNotShownWhenPiped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// slow enough for a status line to be drawn
+		time.Sleep(3 * statusLineInterval)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewCliHandler(client, CliOptions{})
+
+	var stdout string
+	var handleErr error
+	stderr := captureStderr(t, func() {
+		stdout = captureStdout(t, func() {
+			handleErr = handler.HandleCli(context.Background(), "hello")
+		})
+	})
+	if handleErr != nil {
+		t.Fatalf("chat: %v", handleErr)
+	}
+	if !strings.Contains(stdout, "done") {
+		t.Errorf("expected response in stdout, got %q", stdout)
+	}
+	for name, output := range map[string]string{"stdout": stdout, "stderr": stderr} {
+		if strings.Contains(output, "\r") || strings.Contains(output, "\033[") || strings.Contains(output, "thinking...") {
+			t.Errorf("expected no status line in piped %s, got %q", name, output)
+		}
+	}
+}
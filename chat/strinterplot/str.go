@@ -3,6 +3,7 @@ package strinterplot
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strconv"
 
 	var_template "github.com/xhd2015/go-var-template"
@@ -29,6 +30,29 @@ func InterplotList(list []string, args map[string]any) ([]string, error) {
 	return res, nil
 }
 
+// CwdVar is replaced with the working dir of a command, unless a tool argument has the same name
+const CwdVar = "cwd"
+
+// envVarPattern matches ${env.NAME}
+var envVarPattern = regexp.MustCompile(`\$\{\s*env\.([A-Za-z_][A-Za-z0-9_]*)\s*\}`)
+
+// InterplotCommand is InterplotList with ${env.NAME} replaced by getenv(NAME) and
+// ${cwd} by cwd. Env vars are replaced first, so argument values never expand them
+func InterplotCommand(list []string, args map[string]any, cwd string, getenv func(name string) string) ([]string, error) {
+	withCwd := make(map[string]any, len(args)+1)
+	withCwd[CwdVar] = cwd
+	for k, v := range args {
+		withCwd[k] = v
+	}
+	expanded := make([]string, len(list))
+	for i, v := range list {
+		expanded[i] = envVarPattern.ReplaceAllStringFunc(v, func(s string) string {
+			return getenv(envVarPattern.FindStringSubmatch(s)[1])
+		})
+	}
+	return InterplotList(expanded, withCwd)
+}
+
 func interplot(tpl string, args map[string]string) (string, error) {
 	ctpl := var_template.Compile(tpl)
 	return ctpl.Execute(args)
@@ -0,0 +1,35 @@
+package strinterplot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterplotCommand(t *testing.T) {
+	getenv := func(name string) string {
+		if name == "GREETING" {
+			return "hello"
+		}
+		return ""
+	}
+	got, err := InterplotCommand([]string{"echo", "${env.GREETING} ${name}", "${cwd}", "${ env.MISSING }", "${note}"}, map[string]any{
+		"name": "kode",
+		"note": "${env.GREETING}",
+	}, "/work", getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"echo", "hello kode", "/work", "", "${env.GREETING}"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// a tool argument named cwd takes precedence
+	got, err = InterplotCommand([]string{"${cwd}"}, map[string]any{"cwd": "/arg"}, "/work", getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "/arg" {
+		t.Errorf("expected /arg, got %q", got[0])
+	}
+}
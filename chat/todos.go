@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+const todoWriteTool = "todo_write"
+
+// todosMetadata extracts the plan from the result of a todo_write call,
+// nil for other tools and failed writes
+func todosMetadata(toolName string, result string) *types.TodosMetadata {
+	if toolName != todoWriteTool {
+		return nil
+	}
+	var resp struct {
+		Success bool             `json:"success"`
+		Todos   []types.TodoItem `json:"todos"`
+	}
+	if err := json.Unmarshal([]byte(result), &resp); err != nil || !resp.Success {
+		return nil
+	}
+	return &types.TodosMetadata{Todos: resp.Todos}
+}
+
+// LastTodos returns the latest plan written in messages, nil if none
+func LastTodos(messages []types.Message) *types.TodosMetadata {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Metadata.Todos != nil {
+			return messages[i].Metadata.Todos
+		}
+	}
+	return nil
+}
+
+// FormatTodos renders the plan as a checkbox list, one item per line
+func FormatTodos(todos *types.TodosMetadata) string {
+	var b strings.Builder
+	for _, todo := range todos.Todos {
+		var box string
+		switch todo.Status {
+		case "completed":
+			box = "[x]"
+		case "in_progress":
+			box = "[>]"
+		case "cancelled":
+			box = "[-]"
+		default:
+			box = "[ ]"
+		}
+		fmt.Fprintf(&b, "%s %s\n", box, todo.Content)
+	}
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestTodosMetadata(t *testing.T) {
+	result := `{"success":true,"todos_written":2,"todos":[{"id":"1","content":"Write code","status":"in_progress"},{"id":"2","content":"Drop it","status":"cancelled"}]}`
+	todos := todosMetadata("todo_write", result)
+	if todos == nil || len(todos.Todos) != 2 {
+		t.Fatalf("expected 2 todos, got %+v", todos)
+	}
+	if got, want := FormatTodos(todos), "[>] Write code\n[-] Drop it\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if todosMetadata("list_dir", result) != nil {
+		t.Errorf("expected no todos for other tools")
+	}
+	if todosMetadata("todo_write", `{"success":false,"message":"TODO item 1 is missing ID"}`) != nil {
+		t.Errorf("expected no todos for failed writes")
+	}
+
+	history := []types.Message{
+		{Type: types.MsgType_ToolResult, ToolName: "todo_write", Metadata: types.Metadata{Todos: todos}},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "working on it"},
+	}
+	if LastTodos(history) != todos {
+		t.Errorf("expected the last plan of history")
+	}
+}
@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// toolArgsPreview turns streamed tool_use blocks into partial tool call events,
+// the complete tool call event is emitted once the response is processed
+type toolArgsPreview struct {
+	model         string
+	eventCallback types.EventCallback
+
+	// content block index -> tool use block being streamed
+	blocks map[int64]*toolArgsPreviewBlock
+}
+
+type toolArgsPreviewBlock struct {
+	id   string
+	name string
+	args strings.Builder
+}
+
+func newToolArgsPreview(model string, eventCallback types.EventCallback) *toolArgsPreview {
+	return &toolArgsPreview{
+		model:         model,
+		eventCallback: eventCallback,
+		blocks:        make(map[int64]*toolArgsPreviewBlock),
+	}
+}
+
+func (c *toolArgsPreview) onAnthropicEvent(event anthropic.MessageStreamEventUnion) {
+	switch ev := event.AsAny().(type) {
+	case anthropic.ContentBlockStartEvent:
+		if ev.ContentBlock.Type != "tool_use" {
+			return
+		}
+		toolUse := ev.ContentBlock.AsToolUse()
+		c.blocks[ev.Index] = &toolArgsPreviewBlock{
+			id:   toolUse.ID,
+			name: toolUse.Name,
+		}
+	case anthropic.ContentBlockDeltaEvent:
+		block := c.blocks[ev.Index]
+		if block == nil || ev.Delta.Type != "input_json_delta" {
+			return
+		}
+		partialJSON := ev.Delta.AsInputJSONDelta().PartialJSON
+		if partialJSON == "" {
+			return
+		}
+		block.args.WriteString(partialJSON)
+		c.eventCallback(types.Message{
+			Type:      types.MsgType_ToolCall,
+			Content:   block.args.String(),
+			ToolUseID: block.id,
+			ToolName:  block.name,
+			Model:     c.model,
+			Role:      types.Role_Assistant,
+			Metadata: types.Metadata{
+				ToolCall: &types.ToolCallMetadata{Partial: true},
+			},
+			Timestamp: types.Now().Unix(),
+		})
+	case anthropic.ContentBlockStopEvent:
+		delete(c.blocks, ev.Index)
+	}
+}
@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestStreamToolArgsPreview(t *testing.T) {
+	var mutex sync.Mutex
+	var n int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		n++
+		first := n == 1
+		mutex.Unlock()
+
+		if !first {
+			writeAnthropicSSE(w, `{"type":"text","text":""}`, `{"type":"text_delta","text":"done"}`, "end_turn")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-7-sonnet","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"list_dir","input":{}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"relative_"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"workspace_path\":"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"\".\"}"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":5}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, event := range events {
+			var typ struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal([]byte(event), &typ)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ.Type, event)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "claude-3-7-sonnet",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var toolCalls []types.Message
+	_, err = client.Chat(context.Background(), "list files",
+		WithTools("list_dir"),
+		WithMaxRounds(2),
+		WithStreamToolArgs(true),
+		WithToolCallback(handledToolCallback),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolCall {
+				toolCalls = append(toolCalls, event)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	wantPartials := []string{
+		`{"relative_`,
+		`{"relative_workspace_path":`,
+		`{"relative_workspace_path":"."}`,
+	}
+	if len(toolCalls) != len(wantPartials)+1 {
+		t.Fatalf("expected %d tool call events, got %d: %+v", len(wantPartials)+1, len(toolCalls), toolCalls)
+	}
+	for i, want := range wantPartials {
+		event := toolCalls[i]
+		if !event.IsPartial() {
+			t.Errorf("event %d: expected partial", i)
+		}
+		if event.Content != want || event.ToolUseID != "toolu_1" || event.ToolName != "list_dir" {
+			t.Errorf("event %d: unexpected %+v", i, event)
+		}
+	}
+	final := toolCalls[len(toolCalls)-1]
+	if final.IsPartial() {
+		t.Errorf("expected final event to be complete")
+	}
+	if final.Content != `{"relative_workspace_path":"."}` || final.ToolUseID != "toolu_1" {
+		t.Errorf("unexpected final event: %+v", final)
+	}
+}
+
+func TestStreamToolArgsPreviewDisabled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAnthropicSSE(w, `{"type":"tool_use","id":"toolu_1","name":"list_dir","input":{}}`, `{"type":"input_json_delta","partial_json":"{}"}`, "tool_use")
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "claude-3-7-sonnet",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.Chat(context.Background(), "list files",
+		WithTools("list_dir"),
+		WithToolCallback(handledToolCallback),
+		WithEventCallback(func(event types.Message) {
+			if event.IsPartial() {
+				t.Errorf("unexpected partial event: %+v", event)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+}
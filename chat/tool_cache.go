@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/tools/read_file"
+)
+
+// toolCache keeps results of deterministic builtin tools in dir across runs,
+// keyed by the tool name, arguments and the mtime of the files the tool reads
+type toolCache struct {
+	dir string
+}
+
+func newToolCache(dir string) *toolCache {
+	if dir == "" {
+		return nil
+	}
+	return &toolCache{dir: dir}
+}
+
+// key is empty when call is not cacheable, or its input files cannot be stat'ed.
+// sandbox is part of the key as it may reject the same call
+func (c *toolCache) key(call types.ToolCall, defaultWorkingDir string, sandbox bool) string {
+	if c == nil {
+		return ""
+	}
+	files := toolCacheInputs(call, defaultWorkingDir)
+	if len(files) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%t\x00", toolCallKey(call), defaultWorkingDir, sandbox)
+	for _, file := range files {
+		stat, err := os.Stat(file)
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", file, stat.ModTime().UnixNano(), stat.Size())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *toolCache) get(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, key+".json"))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// put writes to a temp file first, so a concurrent run never reads a partial result
+func (c *toolCache) put(key string, result string) error {
+	if key == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	file := filepath.Join(c.dir, key+".json")
+	tmpFile := file + ".tmp" + strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(tmpFile, []byte(result), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// toolCacheInputs are the files the result of call depends on,
+// nil for tools whose result is not decided by files alone
+func toolCacheInputs(call types.ToolCall, defaultWorkingDir string) []string {
+	switch call.Name {
+	case "read_file":
+		req, err := read_file.ParseJSONRequest(call.RawArgs)
+		if err != nil || req.TargetFile == "" {
+			return nil
+		}
+		workspaceRoot := req.WorkspaceRoot
+		if workspaceRoot == "" {
+			workspaceRoot = defaultWorkingDir
+		}
+		file := req.TargetFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(workspaceRoot, file)
+		}
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return nil
+		}
+		return []string{absFile}
+	}
+	return nil
+}
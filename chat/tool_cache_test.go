@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestToolCacheReadFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	cacheDir := filepath.Join(dir, "cache")
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests%2 == 1 {
+			fmt.Fprintf(w, `{"id":"chatcmpl-%d","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_%d","type":"function","function":{"name":"read_file","arguments":"{\"target_file\":\"a.txt\",\"should_read_entire_file\":true}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`, requests, requests)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer server.Close()
+
+	// each run uses a new client, like separate kode invocations
+	readFile := func() string {
+		client, err := NewClient(Config{
+			Model:   "gpt-4o",
+			Token:   "test-token",
+			BaseURL: server.URL,
+		})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		var result string
+		_, err = client.Chat(context.Background(), "read a.txt",
+			WithTools("read_file"),
+			WithDefaultToolCwd(dir),
+			WithToolCacheDir(cacheDir),
+			WithMaxRounds(3),
+			WithEventCallback(func(event types.Message) {
+				if event.Type == types.MsgType_ToolResult {
+					result = event.Content
+				}
+			}),
+		)
+		if err != nil {
+			t.Fatalf("chat: %v", err)
+		}
+		return result
+	}
+
+	mtime := time.Now().Add(-time.Hour)
+	writeFile := func(content string, mtime time.Time) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("old", mtime)
+	if result := readFile(); !strings.Contains(result, "old") {
+		t.Fatalf("expected the file read, got %s", result)
+	}
+
+	// same mtime and size, so the file counts as unchanged and the cached result is reused
+	writeFile("new", mtime)
+	if result := readFile(); !strings.Contains(result, "old") {
+		t.Errorf("expected the cached result, got %s", result)
+	}
+
+	writeFile("new", mtime.Add(time.Minute))
+	if result := readFile(); !strings.Contains(result, "new") {
+		t.Errorf("expected the changed file read again, got %s", result)
+	}
+}
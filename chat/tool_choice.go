@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/xhd2015/kode-ai/types"
+	"google.golang.org/genai"
+)
+
+// resolveToolChoice validates choice against available tools,
+// returns empty if no tool is available so that nothing is sent
+func resolveToolChoice(choice string, toolInfoMapping ToolInfoMapping) (string, error) {
+	if choice == "" {
+		return "", nil
+	}
+	switch choice {
+	case types.ToolChoice_Auto, types.ToolChoice_None:
+		if len(toolInfoMapping) == 0 {
+			return "", nil
+		}
+		return choice, nil
+	case types.ToolChoice_Required:
+		if len(toolInfoMapping) == 0 {
+			return "", fmt.Errorf("tool choice %s requires tools", choice)
+		}
+		return choice, nil
+	}
+	if toolInfoMapping[choice] == nil {
+		return "", fmt.Errorf("tool choice: unknown tool %s", choice)
+	}
+	return choice, nil
+}
+
+// roundToolChoice forces a required or named choice on the first round only,
+// later rounds use auto so that the model can answer the tool results in text
+func roundToolChoice(choice string, round int) string {
+	if round == 0 {
+		return choice
+	}
+	switch choice {
+	case "", types.ToolChoice_Auto, types.ToolChoice_None:
+		return choice
+	}
+	return types.ToolChoice_Auto
+}
+
+func toolChoiceOpenAI(choice string) openai.ChatCompletionToolChoiceOptionUnionParam {
+	switch choice {
+	case "":
+		return openai.ChatCompletionToolChoiceOptionUnionParam{}
+	case types.ToolChoice_Auto, types.ToolChoice_None, types.ToolChoice_Required:
+		return openai.ChatCompletionToolChoiceOptionUnionParam{
+			OfAuto: param.NewOpt(choice),
+		}
+	}
+	return openai.ChatCompletionToolChoiceOptionUnionParam{
+		OfChatCompletionNamedToolChoice: &openai.ChatCompletionNamedToolChoiceParam{
+			Function: openai.ChatCompletionNamedToolChoiceFunctionParam{
+				Name: choice,
+			},
+		},
+	}
+}
+
+func toolChoiceAnthropic(choice string) anthropic.ToolChoiceUnionParam {
+	switch choice {
+	case "":
+		return anthropic.ToolChoiceUnionParam{}
+	case types.ToolChoice_Auto:
+		return anthropic.ToolChoiceUnionParam{OfAuto: &anthropic.ToolChoiceAutoParam{}}
+	case types.ToolChoice_None:
+		return anthropic.ToolChoiceUnionParam{OfNone: &anthropic.ToolChoiceNoneParam{}}
+	case types.ToolChoice_Required:
+		return anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{}}
+	}
+	return anthropic.ToolChoiceUnionParam{OfTool: &anthropic.ToolChoiceToolParam{Name: choice}}
+}
+
+func toolConfigGemini(choice string) *genai.ToolConfig {
+	var config genai.FunctionCallingConfig
+	switch choice {
+	case "":
+		return nil
+	case types.ToolChoice_Auto:
+		config.Mode = genai.FunctionCallingConfigModeAuto
+	case types.ToolChoice_None:
+		config.Mode = genai.FunctionCallingConfigModeNone
+	case types.ToolChoice_Required:
+		config.Mode = genai.FunctionCallingConfigModeAny
+	default:
+		config.Mode = genai.FunctionCallingConfigModeAny
+		config.AllowedFunctionNames = []string{choice}
+	}
+	return &genai.ToolConfig{FunctionCallingConfig: &config}
+}
@@ -0,0 +1,182 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func handledToolCallback(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+	return types.ToolResult{Content: "ok"}, true, nil
+}
+
+func TestToolChoiceForwarded(t *testing.T) {
+	tests := []struct {
+		model  string
+		choice string
+		want   string // JSON of the forwarded field
+	}{
+		{"gpt-4o", "required", `"required"`},
+		{"gpt-4o", "none", `"none"`},
+		{"gpt-4o", "list_dir", `{"function":{"name":"list_dir"},"type":"function"}`},
+		{"claude-3-7-sonnet", "required", `{"type":"any"}`},
+		{"claude-3-7-sonnet", "none", `{"type":"none"}`},
+		{"claude-3-7-sonnet", "list_dir", `{"name":"list_dir","type":"tool"}`},
+		{"gemini-2.5-pro", "required", `{"functionCallingConfig":{"mode":"ANY"}}`},
+		{"gemini-2.5-pro", "none", `{"functionCallingConfig":{"mode":"NONE"}}`},
+		{"gemini-2.5-pro", "list_dir", `{"functionCallingConfig":{"allowedFunctionNames":["list_dir"],"mode":"ANY"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model+"/"+tt.choice, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				json.Unmarshal(data, &body)
+				switch tt.model {
+				case "claude-3-7-sonnet":
+					writeAnthropicSSE(w, `{"type":"text","text":""}`, `{"type":"text_delta","text":"done"}`, "end_turn")
+				case "gemini-2.5-pro":
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"done"}],"role":"model"},"finishReason":"STOP"}]}`)
+				default:
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClient(Config{
+				Model:   tt.model,
+				Token:   "test-token",
+				BaseURL: server.URL,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			_, err = client.Chat(context.Background(), "Hello",
+				WithTools("list_dir", "read_file"),
+				WithToolCallback(handledToolCallback),
+				WithToolChoice(tt.choice),
+			)
+			if err != nil {
+				t.Fatalf("chat failed: %v", err)
+			}
+
+			field := "tool_choice"
+			if tt.model == "gemini-2.5-pro" {
+				field = "toolConfig"
+			}
+			var got, want interface{}
+			json.Unmarshal(body[field], &got)
+			json.Unmarshal([]byte(tt.want), &want)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("expected %s %s, got %s", field, tt.want, string(body[field]))
+			}
+		})
+	}
+}
+
+func TestToolChoiceNoneDisablesToolCalls(t *testing.T) {
+	baseURL, cleanup := startMockServer(t, "openai")
+	defer cleanup()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: baseURL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	countToolCalls := func(choice string) int {
+		var n int
+		for i := 0; i < 5; i++ {
+			_, err := client.Chat(context.Background(), "Hello",
+				WithTools("list_dir", "read_file"),
+				WithToolCallback(handledToolCallback),
+				WithToolChoice(choice),
+				WithEventCallback(func(msg types.Message) {
+					if msg.Type == types.MsgType_ToolCall {
+						n++
+					}
+				}),
+			)
+			if err != nil {
+				t.Fatalf("chat failed: %v", err)
+			}
+		}
+		return n
+	}
+	if n := countToolCalls(types.ToolChoice_None); n != 0 {
+		t.Errorf("expected no tool calls with tool choice none, got %d", n)
+	}
+	if n := countToolCalls("list_dir"); n != 5 {
+		t.Errorf("expected a tool call per chat with tool choice list_dir, got %d", n)
+	}
+}
+
+func TestToolChoiceUnknownTool(t *testing.T) {
+	client, err := NewClient(Config{Model: "gpt-4o", Token: "test-token"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.Chat(context.Background(), "Hello", WithTools("list_dir"), WithToolChoice("no_such_tool"))
+	if err == nil {
+		t.Errorf("expected error for unknown tool choice")
+	}
+	_, err = client.Chat(context.Background(), "Hello", WithToolChoice(types.ToolChoice_Required))
+	if err == nil {
+		t.Errorf("expected error for required tool choice without tools")
+	}
+}
+
+func TestToolChoiceForcedOnFirstRoundOnly(t *testing.T) {
+	var choices []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]json.RawMessage
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		choices = append(choices, string(body["tool_choice"]))
+		w.Header().Set("Content-Type", "application/json")
+		if len(choices) == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"list_dir","arguments":"{\"relative_workspace_path\":\".\"}"}}]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	resp, err := client.Chat(context.Background(), "Hello",
+		WithTools("list_dir", "read_file"),
+		WithToolCallback(handledToolCallback),
+		WithToolChoice(types.ToolChoice_Required),
+		WithMaxRounds(5),
+	)
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+	if len(choices) != 2 || resp.RoundsUsed != 2 {
+		t.Fatalf("expected the chat to end after 2 rounds, got %d requests and %d rounds", len(choices), resp.RoundsUsed)
+	}
+	if choices[0] != `"required"` {
+		t.Errorf("expected the first round forced with required, got %s", choices[0])
+	}
+	if choices[1] != `"auto"` {
+		t.Errorf("expected the second round with auto, got %s", choices[1])
+	}
+}
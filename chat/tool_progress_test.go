@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestToolProgress(t *testing.T) {
+	apiServer := startToolCallServer(t)
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var events []types.Message
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			for i := 1; i <= 3; i++ {
+				if err := stream.Write(types.Message{Type: types.MsgType_Info, Content: fmt.Sprintf("step %d/3", i)}); err != nil {
+					return types.ToolResult{}, true, err
+				}
+			}
+			return types.ToolResult{Content: map[string]string{"weather": "sunny"}}, true, nil
+		}),
+		WithMaxRounds(2),
+		WithEventCallback(func(event types.Message) {
+			events = append(events, event)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	var progress []string
+	var toolResults int
+	for _, event := range events {
+		switch event.Type {
+		case types.MsgType_Info:
+			if event.ToolUseID != "call_1" || event.ToolName != "get_weather" {
+				t.Errorf("expected progress of call_1 get_weather, got %+v", event)
+			}
+			if toolResults > 0 {
+				t.Errorf("expected progress before the tool result, got %q after it", event.Content)
+			}
+			progress = append(progress, event.Content)
+		case types.MsgType_ToolResult:
+			toolResults++
+			if event.Content != `{"weather":"sunny"}` {
+				t.Errorf("expected the final result only, got %s", event.Content)
+			}
+		}
+	}
+	if fmt.Sprint(progress) != "[step 1/3 step 2/3 step 3/3]" {
+		t.Errorf("expected 3 progress events, got %v", progress)
+	}
+	if toolResults != 1 {
+		t.Errorf("expected 1 tool result, got %d", toolResults)
+	}
+}
@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// startMalformedToolCallServer serves a get_weather call with broken JSON
+// arguments, then a valid one, then the answer
+func startMalformedToolCallServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		switch n {
+		case 1:
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+		case 2:
+			fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+		default:
+			fmt.Fprint(w, `{"id":"chatcmpl-3","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestMaxToolRetries(t *testing.T) {
+	apiServer, requests := startMalformedToolCallServer(t)
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var executed []string
+	toolCallback := func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		executed = append(executed, call.ID+":"+fmt.Sprint(call.Arguments["city"]))
+		return types.ToolResult{Content: "sunny"}, true, nil
+	}
+	var results []string
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(toolCallback),
+		WithMaxRounds(5),
+		WithMaxToolRetries(1),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolResult {
+				results = append(results, event.Content)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if strings.Join(executed, ",") != "call_2:Tokyo" {
+		t.Errorf("expected only the retried call executed, got %v", executed)
+	}
+	if len(results) != 2 || !strings.Contains(results[0], "malformed arguments of get_weather") {
+		t.Errorf("expected the parse error as the first tool result, got %v", results)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("expected the chat to finish after 3 requests, got %d", n)
+	}
+
+	// without retries the malformed call fails the chat
+	requests.Store(0)
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(toolCallback),
+		WithMaxRounds(5),
+	)
+	if err == nil || !strings.Contains(err.Error(), "malformed arguments of get_weather") {
+		t.Errorf("expected malformed arguments error, got %v", err)
+	}
+}
@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -16,8 +22,14 @@ import (
 	"github.com/xhd2015/kode-ai/types"
 )
 
+// mcpNamespaceSeparator joins the server and tool names of a namespaced MCP tool,
+// not ':' since providers only allow letters, digits, '_' and '-' in tool names
+const mcpNamespaceSeparator = "__"
+
 // ToolInfo represents information about a tool
 type ToolInfo struct {
+	// Name is the name known by the tool itself, for a namespaced
+	// MCP tool it is the name without the server
 	Name           string
 	Builtin        bool
 	ToolDefinition *tools.UnifiedTool
@@ -37,6 +49,22 @@ func (c ToolInfoMapping) AddTool(toolName string, toolInfo *ToolInfo) error {
 	return nil
 }
 
+// Close stops the MCP clients of the mapping
+func (c ToolInfoMapping) Close() error {
+	closed := make(map[*client.Client]bool)
+	var errs []error
+	for _, toolInfo := range c {
+		if toolInfo.MCPClient == nil || closed[toolInfo.MCPClient] {
+			continue
+		}
+		closed[toolInfo.MCPClient] = true
+		if err := toolInfo.MCPClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close mcp %s: %w", toolInfo.MCPServer, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // String returns a string representation of the tool info
 func (c *ToolInfo) String() string {
 	if c.MCPServer != "" {
@@ -45,6 +73,34 @@ func (c *ToolInfo) String() string {
 	return c.Name
 }
 
+// mcpServerNames names each MCP server by the base name of its command,
+// without extension and with characters not allowed in tool names replaced
+// by '_'. A name already taken gets a numeric suffix
+func mcpServerNames(specs []string) []string {
+	names := make([]string, len(specs))
+	taken := make(map[string]bool, len(specs))
+	for i, spec := range specs {
+		base := filepath.Base(spec)
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+		name := strings.Map(func(r rune) rune {
+			if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return '_'
+		}, base)
+		if name == "" {
+			name = "mcp"
+		}
+		unique := name
+		for n := 2; taken[unique]; n++ {
+			unique = fmt.Sprintf("%s%d", name, n)
+		}
+		taken[unique] = true
+		names[i] = unique
+	}
+	return names
+}
+
 // ExecuteBuiltinTool executes a builtin tool with the given call
 func ExecuteBuiltinTool(ctx context.Context, call types.ToolCall) (types.ToolResult, error) {
 	executor := tools.GetExecutor(call.Name)
@@ -55,7 +111,7 @@ func ExecuteBuiltinTool(ctx context.Context, call types.ToolCall) (types.ToolRes
 	}
 
 	// Execute the tool with compile-time type safety
-	res, err := executor.Execute(call.RawArgs, tools.ExecuteOptions{
+	res, err := executor.Execute(ctx, call.RawArgs, tools.ExecuteOptions{
 		DefaultWorkspaceRoot: "", // This would need to be passed in
 	})
 	if err != nil {
@@ -70,7 +126,7 @@ func ExecuteBuiltinTool(ctx context.Context, call types.ToolCall) (types.ToolRes
 }
 
 // executeTool executes a tool using the tool info mapping
-func executeTool(ctx context.Context, stream types.StreamContext, call types.ToolCall, toolName string, arguments string, defaultWorkingDir string, toolInfoMapping ToolInfoMapping, eventCallback types.EventCallback) (string, bool) {
+func executeTool(ctx context.Context, stream types.StreamContext, call types.ToolCall, toolName string, arguments string, defaultWorkingDir string, sandbox bool, conversation func() tools.ConversationSummary, toolInfoMapping ToolInfoMapping, eventCallback types.EventCallback) (string, bool) {
 	toolInfo, ok := toolInfoMapping[toolName]
 	if !ok {
 		return fmt.Sprintf("Unknown tool: %s", toolName), false
@@ -85,9 +141,11 @@ func executeTool(ctx context.Context, stream types.StreamContext, call types.Too
 		}
 
 		// Execute the tool with compile-time type safety
-		res, err = executor.Execute(arguments, tools.ExecuteOptions{
+		res, err = executor.Execute(ctx, arguments, tools.ExecuteOptions{
 			DefaultWorkspaceRoot: defaultWorkingDir,
 			EventCallback:        eventCallback,
+			Sandbox:              sandbox,
+			Conversation:         conversation,
 		})
 		if err != nil {
 			return fmt.Sprintf("execute %s: %v", toolName, err), true
@@ -96,7 +154,7 @@ func executeTool(ctx context.Context, stream types.StreamContext, call types.Too
 		res, err = toolInfo.MCPClient.CallTool(ctx, mcp.CallToolRequest{
 			Request: mcp.Request{},
 			Params: mcp.CallToolParams{
-				Name:      toolName,
+				Name:      toolInfo.Name,
 				Arguments: json.RawMessage(arguments),
 			},
 		})
@@ -130,7 +188,7 @@ func executeTool(ctx context.Context, stream types.StreamContext, call types.Too
 				return fmt.Sprintf("parse args %s: %v", toolName, err), true
 			}
 			// execute the command
-			strRes, err := executeCommand(ctx, toolInfo.ToolDefinition.Command, m)
+			strRes, err := executeCommand(ctx, toolInfo.ToolDefinition.Command, toolInfo.ToolDefinition.Env, defaultWorkingDir, m)
 			if err != nil {
 				return fmt.Sprintf("execute command %s: %v", toolName, err), true
 			}
@@ -150,12 +208,25 @@ func executeTool(ctx context.Context, stream types.StreamContext, call types.Too
 	return string(jsonRes), true
 }
 
-func executeCommand(ctx context.Context, command []string, args map[string]any) (string, error) {
-	command, err := strinterplot.InterplotList(command, args)
+// executeCommand runs a command tool in dir, with env added to its environment.
+// The command can reference the arguments, ${env.NAME} and ${cwd}
+func executeCommand(ctx context.Context, command []string, env map[string]string, dir string, args map[string]any) (string, error) {
+	cwd := dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	command, err := strinterplot.InterplotCommand(command, args, cwd, func(name string) string {
+		if value, ok := env[name]; ok {
+			return value
+		}
+		return os.Getenv(name)
+	})
 	if err != nil {
 		return "", fmt.Errorf("interplot command %s: %v", command, err)
 	}
 	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = commandEnv(env)
 	var stderrBuf bytes.Buffer
 	cmd.Stderr = &stderrBuf
 	output, err := cmd.Output()
@@ -165,6 +236,24 @@ func executeCommand(ctx context.Context, command []string, args map[string]any)
 	return string(output), nil
 }
 
+// commandEnv is the environment of a command tool with env added,
+// nil to inherit the environment unchanged
+func commandEnv(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	environ := os.Environ()
+	for _, name := range names {
+		environ = append(environ, name+"="+env[name])
+	}
+	return environ
+}
+
 // parseToolCall parses a tool call from provider-specific format to our unified format
 func parseToolCall(toolName, toolID, arguments string, defaultWorkingDir string) (types.ToolCall, error) {
 	var args map[string]interface{}
@@ -183,50 +272,186 @@ func parseToolCall(toolName, toolID, arguments string, defaultWorkingDir string)
 	}, nil
 }
 
-// executeToolWithCallback executes a tool using either custom callback, stream communication, or built-in execution
-func (c *Client) executeToolWithCallback(ctx context.Context, stream types.StreamContext, call types.ToolCall, callback types.ToolCallback, eventCallback types.EventCallback, stdout io.Writer, defaultWorkingDir string, toolInfoMapping ToolInfoMapping) (types.ToolResult, error) {
-	// If custom callback is provided, use it first
-	if callback != nil {
-		result, handled, err := callback(ctx, stream, call)
-		if err != nil {
-			return result, err
+// checkToolCall parses a tool call, in strict mode also validating its arguments.
+// A malformed call is not executed, its error is returned as malformed to be sent
+// as the tool result while tool retries are left, so the model can call it again.
+// Once they are spent, the error is returned. Without MaxToolRetries, invalid
+// arguments in strict mode are always sent back, unparsable ones always fail
+func (c *requestState) checkToolCall(toolName, toolID, arguments string, defaultWorkingDir string, toolInfoMapping ToolInfoMapping) (call types.ToolCall, malformed string, err error) {
+	call, err = parseToolCall(toolName, toolID, arguments, defaultWorkingDir)
+	if err != nil {
+		call = types.ToolCall{ID: toolID, Name: toolName, RawArgs: arguments, WorkingDir: defaultWorkingDir}
+		err = fmt.Errorf("malformed arguments of %s: %w, fix the JSON and call again", toolName, err)
+	} else if c.strictToolArgs {
+		err = validateToolCallArgs(call, toolInfoMapping)
+		if err != nil && c.maxToolRetries == 0 {
+			return call, err.Error(), nil
 		}
-		// If callback handled the tool (regardless of result), use it
-		if handled {
-			return result, nil
+	}
+	if err == nil {
+		return call, "", nil
+	}
+	if c.toolRetriesLeft <= 0 {
+		if c.maxToolRetries > 0 {
+			return call, "", fmt.Errorf("%w, gave up after %d retries", err, c.maxToolRetries)
 		}
-		// If callback didn't handle the tool (handled=false), fall through to built-in execution
+		return call, "", err
 	}
+	c.toolRetriesLeft--
+	return call, err.Error(), nil
+}
 
-	// Fall back to built-in tool execution
-	resultStr, ok := executeTool(ctx, stream, call, call.Name, call.RawArgs, defaultWorkingDir, toolInfoMapping, eventCallback)
-	if !ok {
-		// If streams are provided, use bidirectional stream communication
-		if c.stdinReader != nil {
-			result, handled, err := executeToolWithStream(ctx, call, stdout, c.stdinReader, defaultWorkingDir)
-			if err != nil {
-				return result, err
-			}
-			if handled {
-				return result, nil
+// toolCallMetadata carries the parsed arguments of call in its tool_call event
+func toolCallMetadata(call types.ToolCall) *types.ToolCallMetadata {
+	if call.Arguments == nil {
+		return nil
+	}
+	return &types.ToolCallMetadata{Arguments: call.Arguments}
+}
+
+// repeatedToolResult is the earlier result of a repeated call, with a warning
+// telling the model to stop repeating it
+func repeatedToolResult(call types.ToolCall, cached types.ToolResult) types.ToolResult {
+	warning := fmt.Sprintf("%s was already called with the same arguments, this is the earlier result, don't repeat the call", call.Name)
+	if cached.Error != "" {
+		return types.ToolResult{Error: cached.Error + " (" + warning + ")"}
+	}
+	return types.ToolResult{
+		Content: map[string]interface{}{
+			"warning": warning,
+			"result":  cached.Content,
+		},
+	}
+}
+
+// executeToolWithCallback executes a tool using either custom callback, stream communication, or built-in execution,
+// the order is decided by c.toolResolution. A tool running longer than its timeout gets a timeout result,
+// its ctx is cancelled but a tool not checking ctx keeps running in the background.
+// Each execution is appended to the audit log if set, with OncePerTool a repeated call gets the earlier result without executing
+func (c *requestState) executeToolWithCallback(ctx context.Context, stream types.StreamContext, call types.ToolCall, callback types.ToolCallback, eventCallback types.EventCallback, stdout io.Writer, defaultWorkingDir string, toolInfoMapping ToolInfoMapping) (result types.ToolResult, err error) {
+	if c.oncePerTool && c.conversation != nil {
+		if cached, ok := c.conversation.cachedToolResult(call); ok {
+			return repeatedToolResult(call, cached), nil
+		}
+		defer func() {
+			if err == nil {
+				c.conversation.cacheToolResult(call, result)
 			}
+		}()
+	}
+	if c.conversation != nil {
+		defer c.conversation.toolCalled(call.Name)
+	}
+	if c.auditLog != nil {
+		workingDir := call.WorkingDir
+		if workingDir == "" {
+			workingDir = defaultWorkingDir
 		}
+		start := time.Now()
+		defer func() {
+			c.auditLog.record(call, workingDir, start, result, err)
+		}()
+	}
+	timeout, ok := c.toolTimeouts[call.Name]
+	if !ok {
+		timeout = c.toolTimeout
+	}
+	if timeout <= 0 {
+		return c.executeToolInOrder(ctx, stream, call, callback, eventCallback, stdout, defaultWorkingDir, toolInfoMapping)
+	}
 
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type outcome struct {
+		result types.ToolResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := c.executeToolInOrder(timeoutCtx, stream, call, callback, eventCallback, stdout, defaultWorkingDir, toolInfoMapping)
+		done <- outcome{result: result, err: err}
+	}()
+	var res outcome
+	select {
+	case res = <-done:
+	case <-timeoutCtx.Done():
+	}
+	if ctx.Err() != nil {
+		return types.ToolResult{}, ctx.Err()
+	}
+	if timeoutCtx.Err() != nil {
 		return types.ToolResult{
-			Error: fmt.Sprintf("tool execution failed: %s", call.Name),
+			Error: fmt.Sprintf("tool %s timed out after %v", call.Name, timeout),
 		}, nil
 	}
+	return res.result, res.err
+}
 
-	// Try to parse as JSON, otherwise return as string
-	var content interface{}
-	if err := json.Unmarshal([]byte(resultStr), &content); err != nil {
-		// If not valid JSON, return as string content
-		content = map[string]interface{}{
-			"output": resultStr,
+func (c *requestState) executeToolInOrder(ctx context.Context, stream types.StreamContext, call types.ToolCall, callback types.ToolCallback, eventCallback types.EventCallback, stdout io.Writer, defaultWorkingDir string, toolInfoMapping ToolInfoMapping) (types.ToolResult, error) {
+	// progress the tool writes to the stream reaches eventCallback while it runs
+	stream = types.NewToolStreamContext(stream, call, eventCallback)
+	tryCallback := func() (types.ToolResult, bool, error) {
+		if callback == nil {
+			return types.ToolResult{}, false, nil
+		}
+		// If callback handled the tool (regardless of result), use it
+		return callback(ctx, stream, call)
+	}
+	tryBuiltin := func() (types.ToolResult, bool, error) {
+		cacheKey := c.toolCache.key(call, defaultWorkingDir, c.sandbox)
+		resultStr, ok := c.toolCache.get(cacheKey)
+		if !ok {
+			resultStr, ok = executeTool(ctx, stream, call, call.Name, call.RawArgs, defaultWorkingDir, c.sandbox, c.conversation.accessor(), toolInfoMapping, eventCallback)
+			if !ok {
+				return types.ToolResult{}, false, nil
+			}
+			if err := c.toolCache.put(cacheKey, resultStr); err != nil {
+				c.logger.Log(ctx, types.LogType_Error, "tool cache of %s: %v", call.Name, err)
+			}
+		}
+		// Try to parse as JSON, otherwise return as string
+		var content interface{}
+		if err := json.Unmarshal([]byte(resultStr), &content); err != nil {
+			// If not valid JSON, return as string content
+			content = map[string]interface{}{
+				"output": resultStr,
+			}
+		}
+		return types.ToolResult{
+			Content: content,
+		}, true, nil
+	}
+	tryStream := func() (types.ToolResult, bool, error) {
+		// If streams are provided, use bidirectional stream communication
+		if c.stdinReader == nil {
+			return types.ToolResult{}, false, nil
+		}
+		return executeToolWithStream(ctx, call, stdout, c.stdinReader, defaultWorkingDir)
+	}
+
+	var steps []func() (types.ToolResult, bool, error)
+	switch c.toolResolution {
+	case types.ToolResolution_BuiltinFirst:
+		steps = append(steps, tryBuiltin, tryCallback, tryStream)
+	case types.ToolResolution_CallbackOnly:
+		steps = append(steps, tryCallback, tryStream)
+	case types.ToolResolution_BuiltinOnly:
+		steps = append(steps, tryBuiltin)
+	default:
+		steps = append(steps, tryCallback, tryBuiltin, tryStream)
+	}
+
+	for _, step := range steps {
+		result, handled, err := step()
+		if err != nil {
+			return result, err
+		}
+		if handled {
+			return result, nil
 		}
 	}
 
 	return types.ToolResult{
-		Content: content,
+		Error: fmt.Sprintf("tool execution failed: %s", call.Name),
 	}, nil
 }
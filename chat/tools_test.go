@@ -2,7 +2,12 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/xhd2015/kode-ai/tools"
 	"github.com/xhd2015/kode-ai/types"
@@ -170,8 +175,8 @@ func TestToolCallbackWithFallback(t *testing.T) {
 		RawArgs: `{"param": "value"}`,
 	}
 
-	client := &Client{}
-	result, err := client.executeToolWithCallback(context.Background(), nil, call, customCallback, nil, "", mapping)
+	client := &requestState{Client: &Client{}}
+	result, err := client.executeToolWithCallback(context.Background(), nil, call, customCallback, nil, nil, "", mapping)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -193,7 +198,7 @@ func TestToolCallbackWithFallback(t *testing.T) {
 		RawArgs: `{}`,
 	}
 
-	_, err = client.executeToolWithCallback(context.Background(), nil, builtinCall, customCallback, nil, "", mapping)
+	_, err = client.executeToolWithCallback(context.Background(), nil, builtinCall, customCallback, nil, nil, "", mapping)
 	// We expect this to fail since we don't have real tool executors in test
 	if err == nil {
 		t.Logf("Note: builtin tool execution would normally fail in test environment")
@@ -215,3 +220,158 @@ func TestExecuteBuiltinTool(t *testing.T) {
 		t.Errorf("expected result.Error to be set for non-existent tool")
 	}
 }
+
+func TestToolResolution(t *testing.T) {
+	mapping := make(ToolInfoMapping)
+	mapping.AddTool("echo", &ToolInfo{
+		Name: "echo",
+		ToolDefinition: &tools.UnifiedTool{
+			Name: "echo",
+			Handle: func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+				return types.ToolResult{Content: "from-builtin"}, true, nil
+			},
+		},
+	})
+	handlingCallback := func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		return types.ToolResult{Content: "from-callback"}, true, nil
+	}
+	passingCallback := func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		return types.ToolResult{}, false, nil
+	}
+
+	tests := []struct {
+		resolution types.ToolResolution
+		tool       string
+		callback   types.ToolCallback
+		want       interface{} // expected content, nil means an error result
+	}{
+		{"", "echo", handlingCallback, "from-callback"},
+		{"", "echo", passingCallback, map[string]interface{}{"output": "from-builtin"}},
+		{types.ToolResolution_CallbackFirst, "echo", handlingCallback, "from-callback"},
+		{types.ToolResolution_BuiltinFirst, "echo", handlingCallback, map[string]interface{}{"output": "from-builtin"}},
+		{types.ToolResolution_BuiltinFirst, "unknown", handlingCallback, "from-callback"},
+		{types.ToolResolution_CallbackOnly, "echo", handlingCallback, "from-callback"},
+		{types.ToolResolution_CallbackOnly, "echo", passingCallback, nil},
+		{types.ToolResolution_BuiltinOnly, "echo", handlingCallback, map[string]interface{}{"output": "from-builtin"}},
+		{types.ToolResolution_BuiltinOnly, "unknown", handlingCallback, nil},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.resolution)+"/"+tt.tool, func(t *testing.T) {
+			client := &requestState{Client: &Client{}, toolResolution: tt.resolution}
+			call := types.ToolCall{ID: "call_1", Name: tt.tool, RawArgs: "{}"}
+			result, err := client.executeToolWithCallback(context.Background(), nil, call, tt.callback, nil, nil, "", mapping)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want == nil {
+				if result.Error == "" {
+					t.Errorf("expected error result, got content %v", result.Content)
+				}
+				return
+			}
+			if fmt.Sprint(result.Content) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, result.Content)
+			}
+		})
+	}
+}
+
+func TestToolResolutionValidate(t *testing.T) {
+	if err := types.ToolResolution("callback-last").Validate(); err == nil {
+		t.Errorf("expected error for unknown resolution")
+	}
+	client, err := NewClient(Config{Model: "gpt-4o", Token: "test-token"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.Chat(context.Background(), "hello", WithToolResolution("callback-last"))
+	if err == nil {
+		t.Errorf("expected error for unknown resolution")
+	}
+}
+
+func TestToolTimeoutPerName(t *testing.T) {
+	callback := func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		switch call.Name {
+		case "web_search":
+			// runs past its timeout, ignoring ctx
+			time.Sleep(time.Second)
+		case "sleep_tool":
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-ctx.Done():
+				return types.ToolResult{}, true, ctx.Err()
+			}
+		}
+		return types.ToolResult{Content: "done"}, true, nil
+	}
+	client := &requestState{
+		Client:       &Client{},
+		toolTimeout:  50 * time.Millisecond,
+		toolTimeouts: map[string]time.Duration{"sleep_tool": time.Second},
+	}
+
+	start := time.Now()
+	result, err := client.executeToolWithCallback(context.Background(), nil, types.ToolCall{Name: "web_search"}, callback, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Error, "timed out after 50ms") {
+		t.Errorf("expected web_search to time out, got %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the timeout result without waiting for the tool, took %v", elapsed)
+	}
+
+	// longer than the default timeout, within its own
+	result, err = client.executeToolWithCallback(context.Background(), nil, types.ToolCall{Name: "sleep_tool"}, callback, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != "" || result.Content != "done" {
+		t.Errorf("expected sleep_tool to complete, got %+v", result)
+	}
+}
+
+func TestToolCallEventArguments(t *testing.T) {
+	apiServer, _ := startMalformedToolCallServer(t)
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var toolCalls []types.Message
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			return types.ToolResult{Content: "sunny"}, true, nil
+		}),
+		WithMaxRounds(5),
+		WithMaxToolRetries(1),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolCall {
+				toolCalls = append(toolCalls, event)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if len(toolCalls) != 2 {
+		t.Fatalf("expected 2 tool call events, got %d", len(toolCalls))
+	}
+	if toolCalls[0].Metadata.ToolCall != nil {
+		t.Errorf("expected no arguments for the malformed call, got %+v", toolCalls[0].Metadata.ToolCall)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(toolCalls[1].Content), &raw); err != nil {
+		t.Fatalf("unmarshal content: %v", err)
+	}
+	meta := toolCalls[1].Metadata.ToolCall
+	if meta == nil || !reflect.DeepEqual(meta.Arguments, raw) {
+		t.Errorf("expected arguments %v, got %+v", raw, meta)
+	}
+}
@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// traceRecord is one line of the trace file, a request and its response
+type traceRecord struct {
+	Seq        int         `json:"seq"`
+	Time       string      `json:"time"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Request    interface{} `json:"request,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Response   interface{} `json:"response,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// traceTransport intercepts provider HTTP calls and appends
+// each request and response as a JSON line to file
+type traceTransport struct {
+	file string
+	next http.RoundTripper
+
+	mutex sync.Mutex
+	seq   int
+}
+
+func newTraceHTTPClient(file string) *http.Client {
+	return &http.Client{
+		Transport: &traceTransport{
+			file: file,
+			next: http.DefaultTransport,
+		},
+	}
+}
+
+func (c *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record := traceRecord{
+		Time:   time.Now().Format(time.RFC3339Nano),
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+	if req.Body != nil && req.GetBody != nil {
+		// read a copy, leaving req.Body intact for the next transport
+		body, err := req.GetBody()
+		if err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			record.Request = traceBody(data)
+		}
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		record.Error = err.Error()
+		c.write(record)
+		return nil, err
+	}
+
+	record.StatusCode = resp.StatusCode
+	// record the body as the caller reads it, reading it all here
+	// would hold back a streamed response until it ends
+	resp.Body = &traceBodyReader{
+		ReadCloser: resp.Body,
+		record:     record,
+		write:      c.write,
+	}
+	return resp, nil
+}
+
+// traceBodyReader keeps what is read from the response body,
+// writes the record once the body is closed
+type traceBodyReader struct {
+	io.ReadCloser
+	record traceRecord
+	write  func(record traceRecord)
+
+	data      bytes.Buffer
+	readErr   error
+	closeOnce sync.Once
+}
+
+func (c *traceBodyReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.data.Write(p[:n])
+	if err != nil && err != io.EOF {
+		c.readErr = err
+	}
+	return n, err
+}
+
+func (c *traceBodyReader) Close() error {
+	err := c.ReadCloser.Close()
+	c.closeOnce.Do(func() {
+		record := c.record
+		record.Response = traceBody(c.data.Bytes())
+		if c.readErr != nil {
+			record.Error = c.readErr.Error()
+		}
+		c.write(record)
+	})
+	return err
+}
+
+func (c *traceTransport) write(record traceRecord) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	record.Seq = c.seq
+
+	f, err := os.OpenFile(c.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open trace file: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(record); err != nil {
+		fmt.Fprintf(os.Stderr, "write trace file: %v\n", err)
+	}
+}
+
+// traceBody keeps JSON bodies as is, others(e.g. event streams) as string
+func traceBody(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	return string(data)
+}
@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestTraceFileOnePairPerRound(t *testing.T) {
+	var n int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	traceFile := filepath.Join(t.TempDir(), "trace.jsonl")
+	toolCallback := func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		return types.ToolResult{Content: "sunny"}, true, nil
+	}
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithToolCallback(toolCallback),
+		WithTraceFile(traceFile),
+		WithMaxRounds(2),
+	)
+	if err != nil {
+		t.Fatalf("chat failed: %v", err)
+	}
+
+	f, err := os.Open(traceFile)
+	if err != nil {
+		t.Fatalf("open trace file: %v", err)
+	}
+	defer f.Close()
+
+	var records []traceRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var record traceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("decode trace line: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 trace records, got %d", len(records))
+	}
+	for i, record := range records {
+		if record.Seq != i+1 {
+			t.Errorf("record %d: expected seq %d, got %d", i, i+1, record.Seq)
+		}
+		if record.StatusCode != http.StatusOK {
+			t.Errorf("record %d: expected status 200, got %d", i, record.StatusCode)
+		}
+		req, _ := record.Request.(map[string]interface{})
+		if req["model"] != "gpt-4o" {
+			t.Errorf("record %d: expected request JSON with model, got %v", i, record.Request)
+		}
+		resp, _ := record.Response.(map[string]interface{})
+		if resp["id"] != fmt.Sprintf("chatcmpl-%d", i+1) {
+			t.Errorf("record %d: expected response JSON, got %v", i, record.Response)
+		}
+	}
+}
+
+func TestTraceStreamedResponse(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	traceFile := filepath.Join(t.TempDir(), "trace.jsonl")
+	resp, err := newTraceHTTPClient(traceFile).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first event is read while the server holds back the second
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "data: first\n" {
+		t.Fatalf("expected the first event, got %q", line)
+	}
+	if _, err := os.Stat(traceFile); !os.IsNotExist(err) {
+		t.Errorf("expected no trace record before the body is closed, got %v", err)
+	}
+
+	release <- struct{}{}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	data, err := os.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record traceRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("decode trace line: %v", err)
+	}
+	if record.Seq != 1 || record.StatusCode != http.StatusOK {
+		t.Errorf("expected seq 1 with status 200, got %d %d", record.Seq, record.StatusCode)
+	}
+	if record.Response != "data: first\n\ndata: second\n\n" {
+		t.Errorf("expected the whole event stream traced, got %q", record.Response)
+	}
+}
@@ -2,6 +2,7 @@ package chat
 
 import (
 	"encoding/json"
+	"net/http"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/openai/openai-go"
@@ -21,9 +22,25 @@ type Config struct {
 	Token    string             // Required: API token
 	BaseURL  string             // Optional: Custom API base URL
 	Provider providers.Provider // Optional: Auto-detected from model if not specified
-	LogLevel types.LogLevel     // Optional: None, Request, Response, Debug
+	// Optional: extra HTTP headers sent with each API request, e.g. org IDs or routing tags of a gateway
+	Headers map[string]string
+	// Optional: OpenAI only, sent as the OpenAI-Organization and OpenAI-Project headers for billing attribution
+	OpenAIOrganization string
+	OpenAIProject      string
+	LogLevel           types.LogLevel // Optional: None, Request, Response, Debug
+	// Optional: what to mask in logged requests, default secrets
+	LogRedact types.LogRedact
+
+	// Optional: max bytes of tool results shown in events, default MAX_PRINT_LIMIT, negative disables truncation.
+	// Only affects display, the model always receives the full result
+	PrintLimit int
 
 	Logger types.Logger
+
+	// Optional: maps a failed API call to an error, for gateways returning
+	// non-standard status codes. resp is nil if no HTTP response is available.
+	// Return a *ChatError to decide its Kind, or nil to use the default classification
+	ErrorClassifier func(resp *http.Response, err error) error
 }
 
 // Provider-specific message unions for internal use
@@ -31,6 +48,17 @@ type ClientUnion struct {
 	OpenAI    *openai.Client
 	Anthropic *anthropic.Client
 	Gemini    *genai.Client
+
+	// genai.Client has no Close, its connections are closed via the HTTP client
+	geminiHTTPClient *http.Client
+}
+
+// Close closes idle connections of the Gemini client
+func (c *ClientUnion) Close() error {
+	if c.geminiHTTPClient != nil {
+		c.geminiHTTPClient.CloseIdleConnections()
+	}
+	return nil
 }
 
 type MessageHistoryUnion struct {
@@ -50,23 +78,115 @@ type MessagesUnion struct {
 
 // Convert Messages to provider-specific formats (reuse existing logic)
 
-// ToOpenAI converts unified messages to OpenAI format
+// orphanToolResult is the content of the result synthesized for a tool call without one
+const orphanToolResult = `{"error":"no result recorded for this tool call, it may have been interrupted"}`
+
+// toolCallFixes finds what breaks tool call and result pairing, which providers reject:
+// tool results without a preceding tool call are dropped, and tool calls without a result
+// get a placeholder error result. Results follow their calls before the next msg, so the
+// placeholders are keyed by the index they are inserted before, len(messages) for the end
+func (messages Messages) toolCallFixes() (dropped map[int]bool, placeholders map[int]Messages) {
+	dropped = make(map[int]bool)
+	placeholders = make(map[int]Messages)
+	var pending Messages
+	flush := func(at int) {
+		for _, call := range pending {
+			placeholders[at] = append(placeholders[at], types.Message{
+				Type:      types.MsgType_ToolResult,
+				Role:      types.Role_User,
+				Model:     call.Model,
+				ToolName:  call.ToolName,
+				ToolUseID: call.ToolUseID,
+				Content:   orphanToolResult,
+			})
+		}
+		pending = nil
+	}
+	for i, msg := range messages {
+		if msg.IsPartial() {
+			continue
+		}
+		switch msg.Type {
+		case types.MsgType_ToolCall:
+			pending = append(pending, msg)
+		case types.MsgType_ToolResult:
+			matched := -1
+			for j, call := range pending {
+				if call.ToolUseID == msg.ToolUseID {
+					matched = j
+					break
+				}
+			}
+			if matched < 0 {
+				dropped[i] = true
+				continue
+			}
+			pending = append(pending[:matched], pending[matched+1:]...)
+		case types.MsgType_Msg:
+			flush(i)
+		}
+	}
+	flush(len(messages))
+	return dropped, placeholders
+}
+
+// normalizeToolCalls applies toolCallFixes
+func (messages Messages) normalizeToolCalls() Messages {
+	dropped, placeholders := messages.toolCallFixes()
+	if len(dropped) == 0 && len(placeholders) == 0 {
+		return messages
+	}
+	normalized := make(Messages, 0, len(messages))
+	for i, msg := range messages {
+		normalized = append(normalized, placeholders[i]...)
+		if !dropped[i] {
+			normalized = append(normalized, msg)
+		}
+	}
+	return append(normalized, placeholders[len(messages)]...)
+}
+
+// ToOpenAI converts unified messages to OpenAI format, tool calls and results are paired first
 func (messages Messages) ToOpenAI(keepSystemPrompts bool) (msgs []openai.ChatCompletionMessageParamUnion, systemPrompts []string, err error) {
+	return messages.normalizeToolCalls().toOpenAI(keepSystemPrompts)
+}
+
+// ToAnthropic converts unified messages to Anthropic format, tool calls and results are paired first
+func (messages Messages) ToAnthropic() (msgs []anthropic.MessageParam, systemPrompts []string, err error) {
+	return messages.normalizeToolCalls().toAnthropic()
+}
+
+// ToGemini converts unified messages to Gemini format, tool calls and results are paired first
+func (messages Messages) ToGemini() (msgs []*genai.Content, systemPrompts []string, err error) {
+	return messages.normalizeToolCalls().toGemini()
+}
+
+// toOpenAI converts each message as is, except that a tool call joins the assistant
+// message right before it, OpenAI requiring the results of the tool calls of an
+// assistant message to follow it with no other assistant message between
+func (messages Messages) toOpenAI(keepSystemPrompts bool) (msgs []openai.ChatCompletionMessageParamUnion, systemPrompts []string, err error) {
 	for _, msg := range messages {
+		if msg.IsPartial() {
+			continue
+		}
 		var msgUnion openai.ChatCompletionMessageParamUnion
 		switch msg.Type {
 		case types.MsgType_ToolCall:
-			msgUnion.OfAssistant = &openai.ChatCompletionAssistantMessageParam{
-				ToolCalls: []openai.ChatCompletionMessageToolCallParam{
-					{
-						ID: msg.ToolUseID,
-						Function: openai.ChatCompletionMessageToolCallFunctionParam{
-							Name:      msg.ToolName,
-							Arguments: msg.Content,
-						},
-					},
+			call := openai.ChatCompletionMessageToolCallParam{
+				ID: msg.ToolUseID,
+				Function: openai.ChatCompletionMessageToolCallFunctionParam{
+					Name:      msg.ToolName,
+					Arguments: msg.Content,
 				},
 			}
+			if len(msgs) > 0 && msgs[len(msgs)-1].OfAssistant != nil {
+				last := msgs[len(msgs)-1].OfAssistant
+				last.ToolCalls = append(last.ToolCalls, call)
+				continue
+			}
+			msgUnion.OfAssistant = &openai.ChatCompletionAssistantMessageParam{
+				ToolCalls: []openai.ChatCompletionMessageToolCallParam{call},
+			}
 		case types.MsgType_ToolResult:
 			msgUnion.OfTool = &openai.ChatCompletionToolMessageParam{
 				ToolCallID: msg.ToolUseID,
@@ -112,9 +232,12 @@ func (messages Messages) ToOpenAI(keepSystemPrompts bool) (msgs []openai.ChatCom
 	return msgs, systemPrompts, nil
 }
 
-// ToAnthropic converts unified messages to Anthropic format
-func (messages Messages) ToAnthropic() (msgs []anthropic.MessageParam, systemPrompts []string, err error) {
+// toAnthropic converts each message as is
+func (messages Messages) toAnthropic() (msgs []anthropic.MessageParam, systemPrompts []string, err error) {
 	for _, msg := range messages {
+		if msg.IsPartial() {
+			continue
+		}
 		var blocks []anthropic.ContentBlockParamUnion
 		var msgRole anthropic.MessageParamRole
 		switch msg.Type {
@@ -153,9 +276,12 @@ func (messages Messages) ToAnthropic() (msgs []anthropic.MessageParam, systemPro
 	return msgs, systemPrompts, nil
 }
 
-// ToGemini converts unified messages to Gemini format
-func (messages Messages) ToGemini() (msgs []*genai.Content, systemPrompts []string, err error) {
+// toGemini converts each message as is
+func (messages Messages) toGemini() (msgs []*genai.Content, systemPrompts []string, err error) {
 	for _, msg := range messages {
+		if msg.IsPartial() {
+			continue
+		}
 		if msg.Role == types.Role_System {
 			systemPrompts = append(systemPrompts, msg.Content)
 			continue
@@ -0,0 +1,237 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestToAnthropicToolCallWithoutResult(t *testing.T) {
+	messages := Messages{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: "toolu_1", Content: `{}`},
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "go on"},
+	}
+	msgs, _, err := messages.ToAnthropic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var converted []struct {
+		Role    string `json:"role"`
+		Content []struct {
+			Type      string `json:"type"`
+			ID        string `json:"id"`
+			ToolUseID string `json:"tool_use_id"`
+			IsError   bool   `json:"is_error"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(data, &converted); err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 4 {
+		t.Fatalf("expected 4 messages, got %d: %s", len(converted), data)
+	}
+	result := converted[2]
+	if result.Role != "user" || len(result.Content) != 1 || result.Content[0].Type != "tool_result" || result.Content[0].ToolUseID != "toolu_1" {
+		t.Errorf("expected placeholder result of toolu_1 before the next message, got %+v", result)
+	}
+}
+
+func TestToOpenAIToolResultWithoutCall(t *testing.T) {
+	messages := Messages{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_9", Content: `{"files":[]}`},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: "call_1", Content: `{}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"files":["a.go"]}`},
+	}
+	msgs, _, err := messages.ToOpenAI(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var converted []struct {
+		Role       string `json:"role"`
+		ToolCallID string `json:"tool_call_id"`
+	}
+	if err := json.Unmarshal(data, &converted); err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 3 {
+		t.Fatalf("expected 3 messages, got %d: %s", len(converted), data)
+	}
+	if converted[2].Role != "tool" || converted[2].ToolCallID != "call_1" {
+		t.Errorf("expected only the result of call_1, got %+v", converted)
+	}
+}
+
+func TestToGeminiToolCallWithoutResult(t *testing.T) {
+	messages := Messages{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: "call_1", Content: `{}`},
+	}
+	msgs, _, err := messages.ToGemini()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(msgs))
+	}
+	last := msgs[2]
+	if len(last.Parts) != 1 || last.Parts[0].FunctionResponse == nil || last.Parts[0].FunctionResponse.Name != "list_dir" {
+		t.Errorf("expected placeholder function response of list_dir, got %+v", last)
+	}
+}
+
+// checkOpenAIToolOrder checks each tool message answers a call of the assistant
+// message before it, with only tool messages between, as OpenAI requires
+func checkOpenAIToolOrder(t *testing.T, msgs []openai.ChatCompletionMessageParamUnion) {
+	t.Helper()
+	var calls map[string]bool
+	for i, msg := range msgs {
+		switch {
+		case msg.OfTool != nil:
+			if !calls[msg.OfTool.ToolCallID] {
+				t.Errorf("tool message %d answers %s, not a call of the assistant message before it", i, msg.OfTool.ToolCallID)
+			}
+			delete(calls, msg.OfTool.ToolCallID)
+		case msg.OfAssistant != nil:
+			if len(calls) > 0 {
+				t.Errorf("assistant message %d follows unanswered tool calls %v", i, calls)
+			}
+			calls = make(map[string]bool)
+			for _, call := range msg.OfAssistant.ToolCalls {
+				calls[call.ID] = true
+			}
+		default:
+			calls = nil
+		}
+	}
+}
+
+func TestToOpenAIToolCallOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages Messages
+		// number of converted messages
+		want int
+	}{
+		{
+			name: "results follow all calls",
+			messages: Messages{
+				{Type: types.MsgType_Msg, Role: types.Role_User, Content: "weather?"},
+				{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "checking"},
+				{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "get_weather", ToolUseID: "call_1", Content: `{"city":"Tokyo"}`},
+				{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "get_weather", ToolUseID: "call_2", Content: `{"city":"Paris"}`},
+				{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "get_weather", ToolUseID: "call_2", Content: `"rainy"`},
+				{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "get_weather", ToolUseID: "call_1", Content: `"sunny"`},
+				{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "sunny and rainy"},
+			},
+			want: 5,
+		},
+		{
+			name: "results interleaved with calls",
+			messages: Messages{
+				{Type: types.MsgType_Msg, Role: types.Role_User, Content: "weather?"},
+				{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "get_weather", ToolUseID: "call_1", Content: `{"city":"Tokyo"}`},
+				{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "get_weather", ToolUseID: "call_1", Content: `"sunny"`},
+				{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "get_weather", ToolUseID: "call_2", Content: `{"city":"Paris"}`},
+				{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "get_weather", ToolUseID: "call_2", Content: `"rainy"`},
+			},
+			want: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, _, err := tt.messages.ToOpenAI(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != tt.want {
+				t.Errorf("expected %d messages, got %d", tt.want, len(msgs))
+			}
+			checkOpenAIToolOrder(t, msgs)
+		})
+	}
+}
+
+func TestOpenAIMultipleToolCallsOrder(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(data))
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"checking","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"}},{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.Chat(context.Background(), "weather?", WithMaxRounds(2),
+		WithToolCallback(func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+			return types.ToolResult{Content: "sunny"}, true, nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+
+	var body struct {
+		Messages []struct {
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				ID string `json:"id"`
+			} `json:"tool_calls"`
+			ToolCallID string `json:"tool_call_id"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(requests[1]), &body); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, msg := range body.Messages {
+		entry := msg.Role
+		if msg.Content != "" && msg.Role == "assistant" {
+			entry += ":" + msg.Content
+		}
+		for _, call := range msg.ToolCalls {
+			entry += "+" + call.ID
+		}
+		if msg.ToolCallID != "" {
+			entry += "=" + msg.ToolCallID
+		}
+		got = append(got, entry)
+	}
+	want := "user,assistant:checking+call_1+call_2,tool=call_1,tool=call_2"
+	if strings.Join(got, ",") != want {
+		t.Errorf("expected messages %s, got %s", want, strings.Join(got, ","))
+	}
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestUserMetadataRecordedNotSent(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	recordFile := filepath.Join(t.TempDir(), "record.json")
+	handler := NewCliHandler(client, CliOptions{RecordFile: recordFile})
+
+	// the second chat sends the first user msg as history
+	for _, msg := range []string{"fix the bug", "and add a test"} {
+		err := handler.HandleCli(context.Background(), msg, WithUserMetadata(map[string]interface{}{"task_id": "T-42"}))
+		if err != nil {
+			t.Fatalf("handle cli: %v", err)
+		}
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(bodies))
+	}
+	for _, body := range bodies {
+		if strings.Contains(body, "T-42") || strings.Contains(body, "task_id") {
+			t.Errorf("expected user metadata not sent, got %s", body)
+		}
+	}
+	if !strings.Contains(bodies[1], "fix the bug") {
+		t.Errorf("expected the first user msg in history, got %s", bodies[1])
+	}
+
+	messages, err := LoadHistory(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userMsgs int
+	for _, msg := range messages {
+		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_User {
+			userMsgs++
+			if msg.UserMetadata["task_id"] != "T-42" {
+				t.Errorf("expected task_id T-42 recorded, got %+v", msg.UserMetadata)
+			}
+		}
+	}
+	if userMsgs != 2 {
+		t.Errorf("expected 2 recorded user msgs, got %d", userMsgs)
+	}
+}
@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/jsonschema"
+)
+
+// validateToolCallArgs checks the arguments of call against the parameters
+// schema of the tool, tools without a schema are not checked. The error lists
+// every violation, so the model can fix them all in its retry
+func validateToolCallArgs(call types.ToolCall, toolInfoMapping ToolInfoMapping) error {
+	toolInfo := toolInfoMapping[call.Name]
+	if toolInfo == nil || toolInfo.ToolDefinition == nil || toolInfo.ToolDefinition.Parameters == nil {
+		return nil
+	}
+	var args interface{} = call.Arguments
+	if call.Arguments == nil {
+		args = map[string]interface{}{}
+	}
+	var violations []string
+	validateArgs(toolInfo.ToolDefinition.Parameters, args, "$", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid arguments of %s, fix them and call again:\n- %s", call.Name, strings.Join(violations, "\n- "))
+}
+
+// validateArgs appends a violation for each mismatch of value with schema
+func validateArgs(schema *jsonschema.JsonSchema, value interface{}, path string, violations *[]string) {
+	if schema == nil {
+		return
+	}
+	mismatch := func() {
+		*violations = append(*violations, fmt.Sprintf("%s: expected %s, got %s", path, schema.Type, jsonTypeOf(value)))
+	}
+	switch schema.Type {
+	case jsonschema.ParamTypeObject:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+		for _, name := range schema.Required {
+			if obj[name] == nil {
+				*violations = append(*violations, fmt.Sprintf("%s.%s: required", path, name))
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				if len(schema.Properties) > 0 {
+					*violations = append(*violations, fmt.Sprintf("%s.%s: unknown property", path, name))
+				}
+				continue
+			}
+			if obj[name] == nil {
+				// null is treated as absent
+				continue
+			}
+			validateArgs(prop, obj[name], path+"."+name, violations)
+		}
+	case jsonschema.ParamTypeArray:
+		arr, ok := value.([]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+		for i, item := range arr {
+			validateArgs(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	case jsonschema.ParamTypeString:
+		if _, ok := value.(string); !ok {
+			mismatch()
+		}
+	case jsonschema.ParamTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			mismatch()
+		}
+	case jsonschema.ParamTypeNumber:
+		if jsonTypeOf(value) != "number" {
+			mismatch()
+		}
+	case "integer":
+		num, ok := value.(json.Number)
+		if !ok {
+			mismatch()
+			return
+		}
+		if _, err := num.Int64(); err != nil {
+			*violations = append(*violations, fmt.Sprintf("%s: expected integer, got %s", path, num))
+		}
+	}
+}
+
+// jsonTypeOf names the JSON type of a decoded value
+func jsonTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number, float64, int, int64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
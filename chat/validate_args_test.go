@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/jsonschema"
+)
+
+func TestStrictToolArgs(t *testing.T) {
+	var requests atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_forecast","arguments":"{\"city\":123,\"days\":[\"one\"]}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	}))
+	defer apiServer.Close()
+
+	var executed atomic.Int32
+	err := RegisterTool("get_forecast", &jsonschema.JsonSchema{
+		Type: jsonschema.ParamTypeObject,
+		Properties: map[string]*jsonschema.JsonSchema{
+			"city": {Type: jsonschema.ParamTypeString},
+			"days": {Type: jsonschema.ParamTypeArray, Items: &jsonschema.JsonSchema{Type: jsonschema.ParamTypeNumber}},
+		},
+		Required: []string{"city"},
+	}, func(ctx context.Context, call types.ToolCall) (types.ToolResult, error) {
+		executed.Add(1)
+		return types.ToolResult{Content: "sunny"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		registeredToolsMutex.Lock()
+		defer registeredToolsMutex.Unlock()
+		delete(registeredTools, "get_forecast")
+	})
+
+	client, err := NewClient(Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: apiServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var toolResult string
+	_, err = client.Chat(context.Background(), "What's the weather in Tokyo?",
+		WithTools("get_forecast"),
+		WithMaxRounds(2),
+		WithStrictToolArgs(true),
+		WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_ToolResult {
+				toolResult = event.Content
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if n := executed.Load(); n != 0 {
+		t.Errorf("expected the tool not executed with invalid arguments, executed %d times", n)
+	}
+	for _, expect := range []string{"invalid arguments of get_forecast", "$.city: expected string, got number", "$.days[0]: expected number, got string"} {
+		if !strings.Contains(toolResult, expect) {
+			t.Errorf("expected tool result to contain %q, got %s", expect, toolResult)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected the validation error sent back to the model, got %d requests", n)
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	schema := &jsonschema.JsonSchema{
+		Type: jsonschema.ParamTypeObject,
+		Properties: map[string]*jsonschema.JsonSchema{
+			"path":  {Type: jsonschema.ParamTypeString},
+			"limit": {Type: "integer"},
+			"force": {Type: jsonschema.ParamTypeBoolean},
+		},
+		Required: []string{"path"},
+	}
+	tests := []struct {
+		args   string
+		expect []string
+	}{
+		{`{"path":"a.go","limit":10,"force":true}`, nil},
+		{`{"path":"a.go","force":null}`, nil},
+		{`{"limit":1.5}`, []string{"$.path: required", "$.limit: expected integer, got 1.5"}},
+		{`{"path":"a.go","force":"yes","extra":1}`, []string{"$.extra: unknown property", "$.force: expected boolean, got string"}},
+	}
+	for _, tt := range tests {
+		call, err := parseToolCall("read", "call_1", tt.args, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var violations []string
+		validateArgs(schema, call.Arguments, "$", &violations)
+		if strings.Join(violations, "\n") != strings.Join(tt.expect, "\n") {
+			t.Errorf("%s: expected %q, got %q", tt.args, tt.expect, violations)
+		}
+	}
+}
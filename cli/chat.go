@@ -87,15 +87,16 @@ package cli
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/xhd2015/kode-ai/types"
 )
@@ -103,10 +104,16 @@ import (
 type CliOption func(cfg *CliOptionConfig)
 
 type CliOptionConfig struct {
-	cli     string
-	cliArgs []string
-	envs    []string
-	dir     string
+	cli       string
+	cliArgs   []string
+	extraArgs []string
+	envs      []string
+	dir       string
+
+	maxConcurrency  int
+	exitGracePeriod time.Duration
+
+	inProcess ChatFunc
 }
 
 func WithCli(cli string, args ...string) CliOption {
@@ -116,6 +123,33 @@ func WithCli(cli string, args ...string) CliOption {
 	}
 }
 
+// WithExtraArgs appends args to the chat command, after the ones built from the request.
+// A flag in args overrides the same flag built from the request, or adds to a
+// repeatable one like --tool. The flags of the stream protocol cannot be passed
+func WithExtraArgs(args ...string) CliOption {
+	return func(cfg *CliOptionConfig) {
+		cfg.extraArgs = append(cfg.extraArgs, args...)
+	}
+}
+
+// reservedArgs are the flags cli.Chat talks to the subprocess with
+var reservedArgs = []string{"--std-stream", "--wait-for-stream-events"}
+
+func checkExtraArgs(args []string) error {
+	for _, arg := range args {
+		name := arg
+		if i := strings.Index(arg, "="); i >= 0 {
+			name = arg[:i]
+		}
+		for _, reserved := range reservedArgs {
+			if name == reserved {
+				return fmt.Errorf("extra args: %s is reserved", arg)
+			}
+		}
+	}
+	return nil
+}
+
 func WithEnv(envs ...string) CliOption {
 	return func(cfg *CliOptionConfig) {
 		cfg.envs = append(cfg.envs, envs...)
@@ -128,6 +162,23 @@ func WithDir(dir string) CliOption {
 	}
 }
 
+// WithMaxConcurrency bounds the kode subprocesses running at the same time to max,
+// the limit is shared by all calls made with the same max. Calls over the limit
+// wait for a running one to exit, or fail once ctx is done
+func WithMaxConcurrency(max int) CliOption {
+	return func(cfg *CliOptionConfig) {
+		cfg.maxConcurrency = max
+	}
+}
+
+// WithExitGracePeriod sets how long the kode subprocess may keep running
+// after its output ended before it is killed, defaults to 5s
+func WithExitGracePeriod(d time.Duration) CliOption {
+	return func(cfg *CliOptionConfig) {
+		cfg.exitGracePeriod = d
+	}
+}
+
 func Chat(ctx context.Context, req types.Request, opts ...CliOption) (*types.Response, error) {
 	sess := &session{}
 	return sess.chat(ctx, req, opts...)
@@ -148,11 +199,17 @@ func (c *session) chat(ctx context.Context, req types.Request, opts ...CliOption
 		opt(cfg)
 	}
 
-	c.eventCallback = req.EventCallback
+	c.eventCallback = types.FilterEvents(req.EventCallback, req.EventFilter)
 	c.logger = getLogger(req.Logger)
 	if req.StreamPair != nil {
 		return nil, fmt.Errorf("stream pair is not supported")
 	}
+	if cfg.inProcess != nil {
+		return c.chatInProcess(ctx, req, cfg.inProcess)
+	}
+	if err := checkExtraArgs(cfg.extraArgs); err != nil {
+		return nil, err
+	}
 
 	// Build command arguments
 	args := []string{"chat", "--std-stream", "--wait-for-stream-events"}
@@ -166,11 +223,61 @@ func (c *session) chat(ctx context.Context, req types.Request, opts ...CliOption
 	if req.BaseURL != "" {
 		args = append(args, "--base-url", req.BaseURL)
 	}
+	headerKeys := make([]string, 0, len(req.Headers))
+	for key := range req.Headers {
+		headerKeys = append(headerKeys, key)
+	}
+	sort.Strings(headerKeys)
+	for _, key := range headerKeys {
+		args = append(args, "--header", key+"="+req.Headers[key])
+	}
+	if req.OpenAIOrganization != "" {
+		args = append(args, "--openai-org", req.OpenAIOrganization)
+	}
+	if req.OpenAIProject != "" {
+		args = append(args, "--openai-project", req.OpenAIProject)
+	}
 
 	if req.MaxRounds > 0 {
 		args = append(args, "--max-round", strconv.Itoa(req.MaxRounds))
 	}
 
+	for _, contextFile := range req.ContextFiles {
+		args = append(args, "--context-file", contextFile)
+	}
+	if req.GitDiff {
+		if req.GitDiffRev != "" {
+			args = append(args, "--git-diff="+req.GitDiffRev)
+		} else {
+			args = append(args, "--git-diff")
+		}
+	}
+
+	for _, doc := range req.Documents {
+		if doc.File == "" {
+			return nil, fmt.Errorf("document %s: only files can be passed to the cli", doc.Title)
+		}
+		args = append(args, "--document", doc.File)
+	}
+
+	if req.TraceFile != "" {
+		args = append(args, "--trace-file", req.TraceFile)
+	}
+	if req.AuditLog != "" {
+		args = append(args, "--audit-log", req.AuditLog)
+	}
+	if req.ToolCacheDir != "" {
+		args = append(args, "--tool-cache", req.ToolCacheDir)
+	}
+
+	if req.EventSinkURL != "" {
+		args = append(args, "--event-sink", req.EventSinkURL)
+	}
+
+	if req.EstimateOnly {
+		args = append(args, "--estimate")
+	}
+
 	for _, tool := range req.Tools {
 		args = append(args, "--tool", tool)
 	}
@@ -195,14 +302,103 @@ func (c *session) chat(ctx context.Context, req types.Request, opts ...CliOption
 		args = append(args, "--tool-default-cwd", req.DefaultToolCwd)
 	}
 
+	if req.Sandbox {
+		args = append(args, "--sandbox")
+	}
+	if req.AbortOnToolError {
+		args = append(args, "--abort-on-tool-error")
+	}
+	if req.StrictToolArgs {
+		args = append(args, "--strict-tool-args")
+	}
+	if req.OncePerTool {
+		args = append(args, "--once-per-tool")
+	}
+	if req.NudgeOnEmpty {
+		args = append(args, "--nudge-on-empty")
+	}
+	if req.MaxToolRetries > 0 {
+		args = append(args, "--max-tool-retries", strconv.Itoa(req.MaxToolRetries))
+	}
+	if req.CompactToolResults > 0 {
+		args = append(args, "--compact-tool-results", strconv.Itoa(req.CompactToolResults))
+		if req.CompactModel != "" {
+			args = append(args, "--compact-model", req.CompactModel)
+		}
+	}
+	if req.StopOnSendAnswer {
+		args = append(args, "--stop-on-send-answer")
+	}
+	if req.ToolTimeout > 0 {
+		args = append(args, "--tool-timeout", req.ToolTimeout.String())
+	}
+	toolTimeoutNames := make([]string, 0, len(req.ToolTimeouts))
+	for name := range req.ToolTimeouts {
+		toolTimeoutNames = append(toolTimeoutNames, name)
+	}
+	sort.Strings(toolTimeoutNames)
+	for _, name := range toolTimeoutNames {
+		args = append(args, "--tool-timeout", name+"="+req.ToolTimeouts[name].String())
+	}
+
+	if req.ToolChoice != "" {
+		args = append(args, "--tool-choice", req.ToolChoice)
+	}
+
+	if req.ReasoningEffort != "" {
+		args = append(args, "--reasoning-effort", string(req.ReasoningEffort))
+	}
+	for _, setting := range req.SafetySettings {
+		args = append(args, "--safety-setting", setting.Category+"="+setting.Threshold)
+	}
+	if req.AssistantPrefill != "" {
+		args = append(args, "--assistant-prefill", req.AssistantPrefill)
+	}
+	if req.PredictedOutput != "" {
+		args = append(args, "--prediction", req.PredictedOutput)
+	}
+	if req.Seed != nil {
+		args = append(args, "--seed", strconv.FormatInt(*req.Seed, 10))
+	}
+
+	if req.LogProbs {
+		args = append(args, "--logprobs")
+		if req.TopLogProbs > 0 {
+			args = append(args, "--top-logprobs", strconv.Itoa(req.TopLogProbs))
+		}
+	}
+
+	if req.StreamToolArgs {
+		args = append(args, "--stream-tool-args")
+	}
+
+	if req.OnMaxRounds != "" {
+		args = append(args, "--on-max-rounds", string(req.OnMaxRounds))
+	}
+	if req.ToolResolution != "" {
+		args = append(args, "--tool-resolution", string(req.ToolResolution))
+	}
+
 	for _, mcpServer := range req.MCPServers {
 		args = append(args, "--mcp", mcpServer)
 	}
+	if req.MCPNamespace {
+		args = append(args, "--mcp-namespace")
+	}
+	if req.MCPConcurrency > 0 {
+		args = append(args, "--mcp-concurrency", strconv.Itoa(req.MCPConcurrency))
+	}
 
 	if req.NoCache {
 		args = append(args, "--no-cache")
 	}
 
+	if req.PromptCacheKey != "" {
+		args = append(args, "--prompt-cache-key", req.PromptCacheKey)
+	}
+
+	args = append(args, cfg.extraArgs...)
+
 	cli := "kode"
 	if cfg.cli != "" {
 		cli = cfg.cli
@@ -215,6 +411,12 @@ func (c *session) chat(ctx context.Context, req types.Request, opts ...CliOption
 		args = combinedArgs
 	}
 
+	release, err := acquireSubprocess(ctx, cfg.maxConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Create command
 	cmd := exec.CommandContext(ctx, cli, args...)
 	if len(cfg.envs) > 0 {
@@ -255,9 +457,10 @@ func (c *session) chat(ctx context.Context, req types.Request, opts ...CliOption
 	}
 	if req.Message != "" {
 		if err := c.writeEventNoLock(types.Message{
-			Type:    types.MsgType_Msg,
-			Role:    types.Role_User,
-			Content: req.Message,
+			Type:         types.MsgType_Msg,
+			Role:         types.Role_User,
+			Content:      req.Message,
+			UserMetadata: req.UserMetadata,
 		}); err != nil {
 			return nil, fmt.Errorf("failed to write message: %w", err)
 		}
@@ -285,13 +488,15 @@ func (c *session) chat(ctx context.Context, req types.Request, opts ...CliOption
 		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
 
-	// Handle stderr
+	// Handle stderr, the last lines are reported if the command fails
+	var tail stderrTail
 	done := make(chan struct{})
 	go func() {
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
 			// Log stderr output if needed
 			c.logger.Log(ctx, types.LogType_Info, "%s\n", scanner.Text())
+			tail.add(scanner.Text())
 		}
 		close(done)
 	}()
@@ -303,10 +508,14 @@ func (c *session) chat(ctx context.Context, req types.Request, opts ...CliOption
 	}
 
 	// Wait for command to finish
-	err = cmd.Wait()
+	gracePeriod := cfg.exitGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = defaultExitGracePeriod
+	}
+	err = waitSubprocess(cmd, done, gracePeriod)
 	<-done
 	if err != nil {
-		return nil, fmt.Errorf("command failed: %w", err)
+		return nil, tail.wrap(err)
 	}
 
 	return response, nil
@@ -351,7 +560,8 @@ func (c *session) handleSingleToolCallback(ctx context.Context, toolCallRequest
 	}
 
 	// Execute the tool callback
-	result, handled, err := toolCallback(ctx, c.stream, call)
+	// progress written by the tool reaches the event callback, not the subprocess
+	result, handled, err := toolCallback(ctx, types.NewToolStreamContext(c.stream, call, c.eventCallback), call)
 
 	var toolError string
 
@@ -408,6 +618,7 @@ func (c *session) processStdoutWithToolCallbacks(ctx context.Context, stdin io.W
 
 	scanner := bufio.NewScanner(stdout)
 	var response types.Response
+	var lastSendAnswer string
 
 	for scanner.Scan() {
 		select {
@@ -431,6 +642,14 @@ func (c *session) processStdoutWithToolCallbacks(ctx context.Context, stdin io.W
 		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_Assistant {
 			c.lastAssistantMsg = msg.Content
 		}
+		applyStopReason(&response, msg, &lastSendAnswer)
+		if msg.Type == types.MsgType_Summary && msg.Metadata.Summary != nil {
+			summary := msg.Metadata.Summary
+			response.TokenUsage = summary.TokenUsage
+			response.Cost = summary.Cost
+			response.RoundsUsed = summary.Rounds
+			response.NumToolCalls = summary.NumToolCalls
+		}
 
 		if c.eventCallback != nil {
 			c.eventCallback(msg)
@@ -516,6 +735,30 @@ func (c *session) processStdoutWithToolCallbacks(ctx context.Context, stdin io.W
 	return &response, nil
 }
 
+// applyStopReason sets the stop reason of response from a stop_reason event, with
+// the answer of the last send_answer call seen, which is kept in lastSendAnswer
+func applyStopReason(response *types.Response, msg types.Message, lastSendAnswer *string) {
+	switch msg.Type {
+	case types.MsgType_ToolCall:
+		if msg.ToolName == "send_answer" && !msg.IsPartial() {
+			*lastSendAnswer = msg.Content
+		}
+	case types.MsgType_StopReason:
+		if msg.Content == "" {
+			return
+		}
+		response.StopReason = msg.Content
+		if msg.Content == types.StopReason_SendAnswer {
+			var args struct {
+				Answer []string `json:"answer"`
+			}
+			if err := unmarshalSafe([]byte(*lastSendAnswer), &args); err == nil {
+				response.Answer = args.Answer
+			}
+		}
+	}
+}
+
 func (c *session) writeEvent(event types.Message) error {
 	return c.writeEventOpts(event)
 }
@@ -523,27 +766,3 @@ func (c *session) writeEvent(event types.Message) error {
 func (c *session) writeEventNoLock(event types.Message) error {
 	return c.writeEventOpts(event)
 }
-
-func makeCmdToolCallback(toolDef *types.UnifiedTool) func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
-	return func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
-		toolResult := types.ToolResult{}
-
-		var stdout bytes.Buffer
-
-		cmd := exec.CommandContext(ctx, toolDef.Command[0], toolDef.Command[1:]...)
-		cmd.Stdout = &stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = call.WorkingDir
-
-		if err := cmd.Start(); err != nil {
-			return types.ToolResult{}, true, fmt.Errorf("failed to start command: %w", err)
-		}
-
-		if err := cmd.Wait(); err != nil {
-			return types.ToolResult{}, true, fmt.Errorf("failed to wait for command: %w", err)
-		}
-
-		toolResult.Content = stdout
-		return toolResult, true, nil
-	}
-}
@@ -3,10 +3,13 @@ package cli
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,12 +17,30 @@ import (
 	"github.com/xhd2015/kode-ai/types/providers"
 )
 
+// sessionHeader is the response header of the server carrying the ID to resume the stream with
+const sessionHeader = "X-Kode-Session-Id"
+
+const (
+	// maxResumeAttempts is how many times a dropped stream is resumed in a row
+	maxResumeAttempts = 3
+	resumeDelay       = 500 * time.Millisecond
+)
+
 // serverSession handles WebSocket server communication
 type serverSession struct {
 	stream        types.StreamContext
 	eventCallback types.EventCallback
 
+	// for resuming a dropped stream
+	reconnect types.ReconnectPolicy
+	wsURL     *url.URL
+	dialer    *websocket.Dialer
+	sessionID string
+	wsStream  *websocketStreamContext
+
 	eventBuf chan types.Message
+	// user messages to send while chatting
+	injectUserMsgs <-chan string
 
 	logger types.Logger
 
@@ -29,9 +50,11 @@ type serverSession struct {
 // ChatWithServer connects to a WebSocket chat server and streams events until finished
 func ChatWithServer(ctx context.Context, server string, req types.Request) (*types.Response, error) {
 	sess := &serverSession{
-		eventCallback: req.EventCallback,
+		eventCallback: types.FilterEvents(req.EventCallback, req.EventFilter),
 		logger:        getLogger(req.Logger),
 		eventBuf:      make(chan types.Message, 10),
+
+		injectUserMsgs: req.InjectUserMsgs,
 	}
 	return sess.chatWithServer(ctx, server, req)
 }
@@ -62,22 +85,35 @@ func (c *serverSession) chatWithServer(ctx context.Context, server string, req t
 	wsURL.RawQuery = query.Encode()
 
 	// Connect to WebSocket with handshake timeout
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 30 * time.Second,
+	dialer := &websocket.Dialer{
+		HandshakeTimeout:  30 * time.Second,
+		EnableCompression: true,
+	}
+	c.reconnect = types.ReconnectPolicy{MaxAttempts: maxResumeAttempts, Delay: resumeDelay}
+	dialAttempts := 0
+	if req.Reconnect != nil {
+		c.reconnect = *req.Reconnect
+		dialAttempts = req.Reconnect.MaxAttempts
 	}
-	conn, _, err := dialer.DialContext(ctx, wsURL.String(), nil)
+	conn, resp, err := c.dial(ctx, dialer, wsURL.String(), dialAttempts, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to WebSocket server: %w", err)
 	}
-	defer conn.Close()
 
 	// Set up ping/pong handler for connection health
 	conn.SetPongHandler(func(string) error {
 		return nil
 	})
 
-	c.stream = &websocketStreamContext{conn: conn}
+	c.wsURL = wsURL
+	c.dialer = dialer
+	c.sessionID = resp.Header.Get(sessionHeader)
+	c.wsStream = &websocketStreamContext{conn: conn}
+	c.stream = c.wsStream
+	defer c.wsStream.close()
 
+	// filtered locally, the response is computed from all events
+	req.EventFilter = nil
 	initReq, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal init request: %w", err)
@@ -122,9 +158,20 @@ func (c *serverSession) writeEvent(msg types.Message) error {
 	return fmt.Errorf("no stream context available")
 }
 
+// injectUserMsg sends a user message the server adds to the chat at the next round boundary
+func (c *serverSession) injectUserMsg(content string) error {
+	return c.writeEvent(types.Message{
+		Type:     types.MsgType_Msg,
+		Role:     types.Role_User,
+		Content:  content,
+		StreamID: types.InjectUserMsgStreamID,
+	})
+}
+
 // processWebSocketMessages processes messages from the WebSocket connection
 func (c *serverSession) processWebSocketMessages(ctx context.Context, conn *websocket.Conn, model string, toolCallback types.ToolCallback, followUpCallback types.FollowUpCallback, toolDefs []*types.UnifiedTool) (*types.Response, error) {
 	var response types.Response
+	var lastSendAnswer string
 
 	// ping every 10s
 	pingTicker := time.NewTicker(10 * time.Second)
@@ -138,16 +185,35 @@ func (c *serverSession) processWebSocketMessages(ctx context.Context, conn *webs
 	go func() {
 		defer close(msgChan)
 		defer close(errChan)
+		// events are numbered by the server, the ones
+		// replayed after resuming may have been received
+		var lastSeq int64
 		for {
 			var msg types.Message
 			err := conn.ReadJSON(&msg)
 			if err != nil {
+				if c.sessionID != "" && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					var resumeErr error
+					conn, resumeErr = c.resume(ctx, done, lastSeq)
+					if resumeErr == nil {
+						c.logger.Log(ctx, types.LogType_Info, "resumed stream after event %d: %v\n", lastSeq, err)
+						continue
+					}
+					err = fmt.Errorf("%w, resume: %v", err, resumeErr)
+				}
 				select {
 				case errChan <- err:
 				case <-done:
 				}
 				return
 			}
+			if msg.Seq > 0 {
+				if msg.Seq <= lastSeq {
+					continue
+				}
+				lastSeq = msg.Seq
+				msg.Seq = 0
+			}
 			select {
 			case msgChan <- msg:
 			case <-done:
@@ -168,8 +234,17 @@ func (c *serverSession) processWebSocketMessages(ctx context.Context, conn *webs
 				return nil, fmt.Errorf("failed to write event: %w", err)
 			}
 			continue
+		case content, ok := <-c.injectUserMsgs:
+			if !ok {
+				c.injectUserMsgs = nil
+				continue
+			}
+			if err := c.injectUserMsg(content); err != nil {
+				c.logger.Log(ctx, types.LogType_Error, "failed to inject user msg: %v\n", err)
+			}
+			continue
 		case <-pingTicker.C:
-			err := conn.WriteMessage(websocket.PingMessage, nil)
+			err := c.wsStream.ping()
 			if err != nil {
 				c.logger.Log(ctx, types.LogType_Error, "failed to ping: %v\n", err)
 			}
@@ -192,6 +267,7 @@ func (c *serverSession) processWebSocketMessages(ctx context.Context, conn *webs
 		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_Assistant {
 			c.lastAssistantMsg = msg.Content
 		}
+		applyStopReason(&response, msg, &lastSendAnswer)
 		if msg.Type == types.MsgType_TokenUsage && msg.TokenUsage != nil {
 			tokenUsage := msg.TokenUsage
 			if msg.TokenCost == nil {
@@ -314,6 +390,92 @@ func (c *serverSession) processWebSocketMessages(ctx context.Context, conn *webs
 	return &response, nil
 }
 
+// resume reconnects to the server session, which replays the events after lastSeq.
+// Events written meanwhile are sent once reconnected
+func (c *serverSession) resume(ctx context.Context, done <-chan struct{}, lastSeq int64) (*websocket.Conn, error) {
+	c.wsStream.detach()
+
+	resumeURL := *c.wsURL
+	query := resumeURL.Query()
+	query.Set("session", c.sessionID)
+	query.Set("resume_from", fmt.Sprint(lastSeq))
+	resumeURL.RawQuery = query.Encode()
+
+	var lastErr error
+	for attempt := 1; attempt <= c.reconnect.MaxAttempts; attempt++ {
+		if err := c.waitRetry(ctx, done, attempt); err != nil {
+			return nil, err
+		}
+		conn, _, err := c.dial(ctx, c.dialer, resumeURL.String(), 0, done)
+		if err == nil {
+			err = c.wsStream.attach(conn)
+			if err == nil {
+				return conn, nil
+			}
+			conn.Close()
+		}
+		if !isTransientDialError(err) {
+			// the session is gone, retrying does not help
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// dial connects to wsURL, retrying transient failures up to retries times.
+// A closed done stops waiting for a retry
+func (c *serverSession) dial(ctx context.Context, dialer *websocket.Dialer, wsURL string, retries int, done <-chan struct{}) (*websocket.Conn, *http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		conn, resp, err := dialer.DialContext(ctx, wsURL, nil)
+		if err == nil {
+			return conn, resp, nil
+		}
+		if resp != nil {
+			err = &dialError{err: err, statusCode: resp.StatusCode, status: resp.Status}
+		}
+		if attempt > retries || !isTransientDialError(err) {
+			return nil, resp, err
+		}
+		c.logger.Log(ctx, types.LogType_Info, "retrying to connect to %s: %v\n", wsURL, err)
+		if err := c.waitRetry(ctx, done, attempt); err != nil {
+			return nil, resp, err
+		}
+	}
+}
+
+// waitRetry waits before the retry following attempt
+func (c *serverSession) waitRetry(ctx context.Context, done <-chan struct{}, attempt int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return fmt.Errorf("stream finished")
+	case <-time.After(time.Duration(attempt) * c.reconnect.Delay):
+		return nil
+	}
+}
+
+// dialError is a handshake rejected by the server with an HTTP status
+type dialError struct {
+	err        error
+	statusCode int
+	status     string
+}
+
+func (e *dialError) Error() string { return fmt.Sprintf("%v: %s", e.err, e.status) }
+func (e *dialError) Unwrap() error { return e.err }
+
+// isTransientDialError reports whether retrying may succeed. A handshake rejected with
+// a 4xx status other than 429, like an unknown session, fails again
+func isTransientDialError(err error) bool {
+	var dialErr *dialError
+	if errors.As(err, &dialErr) {
+		return dialErr.statusCode == http.StatusTooManyRequests || dialErr.statusCode < 400 || dialErr.statusCode >= 500
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
 // handleSingleToolCallbackAsync handles a single tool callback request using the WebSocket stream protocol
 func (c *serverSession) handleSingleToolCallbackAsync(ctx context.Context, streamID string, toolCallRequest types.Message, toolCallback types.ToolCallback) {
 	toolName := toolCallRequest.ToolName
@@ -415,7 +577,51 @@ func (c *serverSession) handleSingleToolCallbackAsync(ctx context.Context, strea
 
 // websocketStreamContext implements types.StreamContext for WebSocket connections
 type websocketStreamContext struct {
-	conn *websocket.Conn
+	mutex sync.Mutex
+	conn  *websocket.Conn // nil while resuming
+	// unsent are the messages written while resuming
+	unsent []types.Message
+}
+
+// detach drops the broken connection, messages are kept until attach
+func (w *websocketStreamContext) detach() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// attach switches to conn of the resumed stream and sends the messages written meanwhile
+func (w *websocketStreamContext) attach(conn *websocket.Conn) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for len(w.unsent) > 0 {
+		if err := conn.WriteJSON(w.unsent[0]); err != nil {
+			return err
+		}
+		w.unsent = w.unsent[1:]
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *websocketStreamContext) ping() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (w *websocketStreamContext) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn != nil {
+		w.conn.Close()
+	}
 }
 
 func (w *websocketStreamContext) ACK(id string) error {
@@ -427,5 +633,17 @@ func (w *websocketStreamContext) ACK(id string) error {
 
 func (w *websocketStreamContext) Write(msg types.Message) error {
 	msg = msg.TimeFilled()
-	return w.conn.WriteJSON(msg)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		w.unsent = append(w.unsent, msg)
+		return nil
+	}
+	if err := w.conn.WriteJSON(msg); err != nil {
+		// the read loop fails as well, and resumes the stream if it can
+		w.unsent = append(w.unsent, msg)
+		w.conn.Close()
+		w.conn = nil
+	}
+	return nil
 }
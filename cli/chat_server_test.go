@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -486,3 +487,57 @@ func TestChatWithServerQueryParamsURLLogic(t *testing.T) {
 		t.Error("expected wait_for_stream_events to be 'true'")
 	}
 }
+
+func TestChatWithServerReconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server is not ready for the first connection
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			var msg types.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Errorf("Failed to read message: %v", err)
+				return
+			}
+			if msg.Type == types.MsgType_StreamInitEventsFinished {
+				break
+			}
+		}
+		conn.WriteJSON(types.Message{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "ready"})
+		conn.WriteJSON(types.Message{Type: types.MsgType_StreamEnd})
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// without reconnect the rejected connection fails
+	_, err := ChatWithServer(ctx, server.URL, types.Request{Message: "hello"})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected the rejected connection to fail, got %v", err)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	req := types.Request{Message: "hello"}
+	WithReconnect(3, 10*time.Millisecond)(&req)
+	response, err := ChatWithServer(ctx, server.URL, req)
+	if err != nil {
+		t.Fatalf("ChatWithServer failed: %v", err)
+	}
+	if response.LastAssistantMsg != "ready" {
+		t.Errorf("Expected last assistant message 'ready', got '%s'", response.LastAssistantMsg)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("Expected 2 connection attempts, got %d", n)
+	}
+}
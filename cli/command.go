@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+
+	var_template "github.com/xhd2015/go-var-template"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// the same as chat/strinterplot, which cli cannot import
+
+// cwdVar is replaced with the working dir of a command, unless a tool argument has the same name
+const cwdVar = "cwd"
+
+// envVarPattern matches ${env.NAME}
+var envVarPattern = regexp.MustCompile(`\$\{\s*env\.([A-Za-z_][A-Za-z0-9_]*)\s*\}`)
+
+func makeCmdToolCallback(toolDef *types.UnifiedTool) func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+	return func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		output, err := executeCommand(ctx, toolDef.Command, toolDef.Env, call.WorkingDir, call.Arguments)
+		if err != nil {
+			return types.ToolResult{}, true, err
+		}
+		// same as the builtin execution of command tools by chat
+		var content interface{}
+		if err := json.Unmarshal([]byte(output), &content); err != nil {
+			content = map[string]interface{}{
+				"output": output,
+			}
+		}
+		return types.ToolResult{Content: content}, true, nil
+	}
+}
+
+// executeCommand runs a command tool in dir, with env added to its environment.
+// The command can reference the arguments, ${env.NAME} and ${cwd}
+func executeCommand(ctx context.Context, command []string, env map[string]string, dir string, args map[string]interface{}) (string, error) {
+	cwd := dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	command, err := interplotCommand(command, args, cwd, func(name string) string {
+		if value, ok := env[name]; ok {
+			return value
+		}
+		return os.Getenv(name)
+	})
+	if err != nil {
+		return "", fmt.Errorf("interplot command %s: %v", command, err)
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = commandEnv(env)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("execute command %s: %v\n%s", command, err, stderrBuf.String())
+	}
+	return string(output), nil
+}
+
+// commandEnv is the environment of a command tool with env added,
+// nil to inherit the environment unchanged
+func commandEnv(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	environ := os.Environ()
+	for _, name := range names {
+		environ = append(environ, name+"="+env[name])
+	}
+	return environ
+}
+
+// interplotCommand replaces ${env.NAME} by getenv(NAME), ${cwd} by cwd and ${ARG}
+// by the tool argument ARG. Env vars are replaced first, so argument values never expand them
+func interplotCommand(list []string, args map[string]interface{}, cwd string, getenv func(name string) string) ([]string, error) {
+	argsStr := make(map[string]string, len(args)+1)
+	argsStr[cwdVar] = cwd
+	for k, v := range args {
+		str, err := interplotArgStr(v)
+		if err != nil {
+			return nil, fmt.Errorf("get str %s: %v", k, err)
+		}
+		argsStr[k] = str
+	}
+	res := make([]string, len(list))
+	for i, v := range list {
+		expanded := envVarPattern.ReplaceAllStringFunc(v, func(s string) string {
+			return getenv(envVarPattern.FindStringSubmatch(s)[1])
+		})
+		str, err := var_template.Compile(expanded).Execute(argsStr)
+		if err != nil {
+			return nil, fmt.Errorf("interplot %s: %v", expanded, err)
+		}
+		res[i] = str
+	}
+	return res, nil
+}
+
+func interplotArgStr(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	jsonRes, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonRes), nil
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// ChatFunc chats within the current process, like chat.Chat of github.com/xhd2015/kode-ai/chat
+type ChatFunc func(ctx context.Context, req types.Request) (*types.Response, error)
+
+// WithInProcess runs the chat with chatFunc instead of spawning kode, saving the
+// process spawn. Callers depending on the chat package pass chat.Chat, cli itself
+// cannot import it as cli supports older go versions. The options about the
+// subprocess, like WithCli, WithExtraArgs, WithEnv and WithDir, don't apply
+func WithInProcess(chatFunc ChatFunc) CliOption {
+	return func(cfg *CliOptionConfig) {
+		cfg.inProcess = chatFunc
+	}
+}
+
+// chatInProcess is chat with chatFunc, emitting the same events
+// and filling the last assistant message as with the kode subprocess
+func (c *session) chatInProcess(ctx context.Context, req types.Request, chatFunc ChatFunc) (*types.Response, error) {
+	// the input is echoed as written to the subprocess
+	for _, msg := range req.History {
+		if !msg.Type.HistorySendable() {
+			continue
+		}
+		c.emit(msg)
+	}
+	if req.SystemPrompt != "" {
+		c.emit(types.Message{
+			Type:    types.MsgType_Msg,
+			Role:    types.Role_System,
+			Content: req.SystemPrompt,
+		})
+	}
+	if req.Message != "" {
+		c.emit(types.Message{
+			Type:         types.MsgType_Msg,
+			Role:         types.Role_User,
+			Content:      req.Message,
+			UserMetadata: req.UserMetadata,
+		})
+	}
+
+	req.EventFilter = nil
+	req.EventCallback = func(msg types.Message) {
+		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_Assistant {
+			c.lastAssistantMsg = msg.Content
+		}
+		c.emit(msg)
+	}
+	response, err := chatFunc(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	response.LastAssistantMsg = c.lastAssistantMsg
+	return response, nil
+}
+
+func (c *session) emit(msg types.Message) {
+	if c.eventCallback != nil {
+		c.eventCallback(msg.TimeFilled())
+	}
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	subprocessLimitsMutex sync.Mutex
+	// subprocessLimits are the semaphores of WithMaxConcurrency, by max
+	subprocessLimits = make(map[int]chan struct{})
+)
+
+// acquireSubprocess waits until fewer than max subprocesses limited by max are running,
+// max <= 0 means no limit. The returned release must be called once the subprocess exits
+func acquireSubprocess(ctx context.Context, max int) (release func(), err error) {
+	if max <= 0 {
+		return func() {}, nil
+	}
+	subprocessLimitsMutex.Lock()
+	sem, ok := subprocessLimits[max]
+	if !ok {
+		sem = make(chan struct{}, max)
+		subprocessLimits[max] = sem
+	}
+	subprocessLimitsMutex.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
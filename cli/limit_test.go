@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestMaxConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	running := filepath.Join(dir, "running")
+	if err := os.Mkdir(running, 0755); err != nil {
+		t.Fatal(err)
+	}
+	counts := filepath.Join(dir, "counts")
+	// records how many are running along with itself
+	kode := writeFakeKode(t, `touch "`+running+`/$$"
+ls "`+running+`" | wc -l >> "`+counts+`"
+sleep 0.2
+rm "`+running+`/$$"
+`)
+
+	const max = 2
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Chat(context.Background(), types.Request{Message: "hello"}, WithCli(kode), WithMaxConcurrency(max))
+			if err != nil {
+				t.Errorf("chat: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) != 8 {
+		t.Fatalf("expected 8 subprocesses, got %d", len(lines))
+	}
+	for _, line := range lines {
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > max {
+			t.Errorf("expected at most %d subprocesses running, got %d", max, n)
+		}
+	}
+}
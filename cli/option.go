@@ -2,6 +2,7 @@ package cli
 
 import (
 	"io"
+	"time"
 
 	"github.com/xhd2015/kode-ai/types"
 )
@@ -35,6 +36,144 @@ func WithToolDefinitions(tool ...*types.UnifiedTool) types.ChatOption {
 	return types.WithToolDefinitions(tool...)
 }
 
+// WithOnMaxRounds sets how a chat ends when MaxRounds is reached while the model still calls tools
+func WithOnMaxRounds(action types.MaxRoundsAction) types.ChatOption {
+	return types.WithOnMaxRounds(action)
+}
+
+// WithToolResolution sets the precedence of the tool callback and builtin tools
+func WithToolResolution(resolution types.ToolResolution) types.ChatOption {
+	return types.WithToolResolution(resolution)
+}
+
+// WithStreamToolArgs emits partial tool call events while arguments are streamed
+func WithStreamToolArgs(enable bool) types.ChatOption {
+	return types.WithStreamToolArgs(enable)
+}
+
+// WithLogProbs returns token log probabilities with topLogProbs most likely
+// tokens at each position, only supported by OpenAI
+func WithLogProbs(topLogProbs int) types.ChatOption {
+	return types.WithLogProbs(topLogProbs)
+}
+
+// WithReasoningEffort sets how much a reasoning model thinks: low, medium or high
+func WithReasoningEffort(effort types.ReasoningEffort) types.ChatOption {
+	return types.WithReasoningEffort(effort)
+}
+
+// WithSandbox confines builtin file tools to the default tool working directory
+func WithSandbox(sandbox bool) types.ChatOption {
+	return types.WithSandbox(sandbox)
+}
+
+// WithAbortOnToolError makes the chat fail as soon as a tool fails
+func WithAbortOnToolError(abort bool) types.ChatOption {
+	return types.WithAbortOnToolError(abort)
+}
+
+// WithStrictToolArgs validates tool call arguments against the tool's parameters schema
+func WithStrictToolArgs(strict bool) types.ChatOption {
+	return types.WithStrictToolArgs(strict)
+}
+
+// WithOncePerTool answers a repeated identical tool call with the earlier result instead of executing it again
+func WithOncePerTool(once bool) types.ChatOption {
+	return types.WithOncePerTool(once)
+}
+
+// WithNudgeOnEmpty asks the model to continue once when it responds with neither content nor tool calls
+func WithNudgeOnEmpty(nudge bool) types.ChatOption {
+	return types.WithNudgeOnEmpty(nudge)
+}
+
+// WithMaxToolRetries lets the model retry a malformed tool call up to retries times
+func WithMaxToolRetries(retries int) types.ChatOption {
+	return types.WithMaxToolRetries(retries)
+}
+
+// WithCompactToolResults sends a tool result longer than threshold bytes as a summary generated by model
+func WithCompactToolResults(threshold int, model string) types.ChatOption {
+	return types.WithCompactToolResults(threshold, model)
+}
+
+// WithToolTimeout gives a tool running longer than timeout a timeout result
+func WithToolTimeout(timeout time.Duration) types.ChatOption {
+	return types.WithToolTimeout(timeout)
+}
+
+// WithToolTimeouts sets the timeouts of the named tools, overriding WithToolTimeout
+func WithToolTimeouts(timeouts map[string]time.Duration) types.ChatOption {
+	return types.WithToolTimeouts(timeouts)
+}
+
+// WithEventFilter passes only events of msgTypes to the event callback
+func WithEventFilter(msgTypes ...types.MsgType) types.ChatOption {
+	return types.WithEventFilter(msgTypes...)
+}
+
+// WithMCPNamespace names MCP tools server__tool, so same-named tools of different servers coexist
+func WithMCPNamespace() types.ChatOption {
+	return types.WithMCPNamespace()
+}
+
+// WithReconnect retries dialing a chat server and resuming its dropped stream up
+// to maxAttempts times, waiting delay times the attempt before each
+func WithReconnect(maxAttempts int, delay time.Duration) types.ChatOption {
+	return types.WithReconnect(maxAttempts, delay)
+}
+
+// WithMCPConcurrency starts at most n MCP servers at a time, 0 means 4
+func WithMCPConcurrency(n int) types.ChatOption {
+	return types.WithMCPConcurrency(n)
+}
+
+// WithGitDiff injects the git diff against rev(default: HEAD) as context
+func WithGitDiff(rev string) types.ChatOption {
+	return types.WithGitDiff(rev)
+}
+
+// WithStopOnSendAnswer ends the chat once the model calls send_answer
+func WithStopOnSendAnswer() types.ChatOption {
+	return types.WithStopOnSendAnswer()
+}
+
+// WithAssistantPrefill makes the model continue its reply from prefill, only supported by Moonshot
+func WithAssistantPrefill(prefill string) types.ChatOption {
+	return types.WithAssistantPrefill(prefill)
+}
+
+// WithPredictedOutput sends predicted as the content the reply mostly repeats, only supported by OpenAI
+func WithPredictedOutput(predicted string) types.ChatOption {
+	return types.WithPredictedOutput(predicted)
+}
+
+// WithSeed samples deterministically as far as the backend can, only supported by OpenAI(-compatible) APIs
+func WithSeed(seed int64) types.ChatOption {
+	return types.WithSeed(seed)
+}
+
+// WithSafetySettings sets the thresholds of the Gemini safety filters
+func WithSafetySettings(settings ...types.SafetySetting) types.ChatOption {
+	return types.WithSafetySettings(settings...)
+}
+
+// WithUserMetadata attaches opaque tags like task or trace IDs to the recorded user message, they are not sent to the model
+func WithUserMetadata(metadata map[string]interface{}) types.ChatOption {
+	return types.WithUserMetadata(metadata)
+}
+
+// WithPromptCacheKey pins requests with the same key to the same prompt cache,
+// only supported by OpenAI
+func WithPromptCacheKey(key string) types.ChatOption {
+	return types.WithPromptCacheKey(key)
+}
+
+// WithToolChoice controls whether the model calls tools: auto, none, required, or a tool name
+func WithToolChoice(choice string) types.ChatOption {
+	return types.WithToolChoice(choice)
+}
+
 // WithDefaultToolCwd sets the default working directory for tool execution
 func WithDefaultToolCwd(cwd string) types.ChatOption {
 	return types.WithDefaultToolCwd(cwd)
@@ -45,6 +184,42 @@ func WithHistory(messages []types.Message) types.ChatOption {
 	return types.WithHistory(messages)
 }
 
+// WithDocuments attaches PDF or plain text files as documents the model can cite,
+// only supported by Anthropic
+func WithDocuments(files ...string) types.ChatOption {
+	return types.WithDocuments(files...)
+}
+
+// WithContextFiles injects the content of files as context before the user message
+func WithContextFiles(files ...string) types.ChatOption {
+	return types.WithContextFiles(files...)
+}
+
+// WithTraceFile appends the request and response of each API call to file as JSON lines
+func WithTraceFile(file string) types.ChatOption {
+	return types.WithTraceFile(file)
+}
+
+// WithToolCacheDir keeps results of deterministic builtin tools like read_file in dir across runs
+func WithToolCacheDir(dir string) types.ChatOption {
+	return types.WithToolCacheDir(dir)
+}
+
+// WithAuditLog appends each tool execution and its outcome to file as JSON lines
+func WithAuditLog(file string) types.ChatOption {
+	return types.WithAuditLog(file)
+}
+
+// WithEventSink POSTs each event as JSON to url, failures do not abort the chat
+func WithEventSink(url string) types.ChatOption {
+	return types.WithEventSink(url)
+}
+
+// WithEstimateOnly counts the input tokens of the request instead of sending it
+func WithEstimateOnly(estimateOnly bool) types.ChatOption {
+	return types.WithEstimateOnly(estimateOnly)
+}
+
 // WithCache controls whether caching is enabled (default: true)
 func WithCache(enabled bool) types.ChatOption {
 	return types.WithCache(enabled)
@@ -65,6 +240,12 @@ func WithEventCallback(callback types.EventCallback) types.ChatOption {
 	return types.WithEventCallback(callback)
 }
 
+// WithInjectUserMsgs sends each user message received from msgs to the server while
+// chatting, joining the chat at the next round boundary
+func WithInjectUserMsgs(msgs <-chan string) types.ChatOption {
+	return types.WithInjectUserMsgs(msgs)
+}
+
 // WithFollowUpCallback sets a callback for follow-up tool execution
 func WithFollowUpCallback(callback types.FollowUpCallback) types.ChatOption {
 	return types.WithFollowUpCallback(callback)
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultExitGracePeriod is how long the kode subprocess may keep
+	// running after its output ended before it is killed
+	defaultExitGracePeriod = 5 * time.Second
+	// maxStderrLines is how many of the last stderr lines a failed command reports
+	maxStderrLines = 20
+)
+
+// waitSubprocess waits for cmd, whose stdout has ended, to exit. It kills cmd if
+// still running after gracePeriod. stderrDone is closed once the stderr pipe is read to
+// the end, as the pipe is closed by cmd.Wait
+func waitSubprocess(cmd *exec.Cmd, stderrDone <-chan struct{}, gracePeriod time.Duration) error {
+	killed := make(chan struct{})
+	exited := make(chan error, 1)
+	go func() {
+		select {
+		case <-stderrDone:
+		case <-killed:
+		}
+		exited <- cmd.Wait()
+	}()
+	timer := time.NewTimer(gracePeriod)
+	defer timer.Stop()
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("command failed: %w", err)
+		}
+		return nil
+	case <-timer.C:
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		return fmt.Errorf("kill command not exiting %v after output ended: %w", gracePeriod, err)
+	}
+	close(killed)
+	err := <-exited
+	return fmt.Errorf("command not exiting %v after output ended, killed: %w", gracePeriod, err)
+}
+
+// stderrTail keeps the last maxStderrLines lines of stderr
+type stderrTail struct {
+	lines []string
+}
+
+func (s *stderrTail) add(line string) {
+	if len(s.lines) == maxStderrLines {
+		s.lines = s.lines[1:]
+	}
+	s.lines = append(s.lines, line)
+}
+
+// wrap adds the kept stderr lines to err
+func (s *stderrTail) wrap(err error) error {
+	if len(s.lines) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\nstderr:\n%s", err, strings.Join(s.lines, "\n"))
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// writeFakeKode writes a sh script standing for the kode binary
+func writeFakeKode(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	file := filepath.Join(t.TempDir(), "kode")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSubprocessKilledAfterOutput(t *testing.T) {
+	// closes stdout after the answer, but never exits
+	kode := writeFakeKode(t, `echo '{"type":"msg","role":"assistant","content":"done"}'
+exec 1>&-
+exec sleep 30
+`)
+	var events []types.Message
+	start := time.Now()
+	_, err := Chat(context.Background(), types.Request{
+		Message: "hello",
+		EventCallback: func(msg types.Message) {
+			events = append(events, msg)
+		},
+	}, WithCli(kode), WithExitGracePeriod(200*time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Fatalf("expected the hanging subprocess killed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected killed after the grace period, took %v", elapsed)
+	}
+	var answered bool
+	for _, event := range events {
+		if event.Role == types.Role_Assistant && event.Content == "done" {
+			answered = true
+		}
+	}
+	if !answered {
+		t.Errorf("expected the output before hanging, got %+v", events)
+	}
+}
+
+func TestSubprocessErrorWithStderr(t *testing.T) {
+	kode := writeFakeKode(t, `echo "starting" >&2
+echo "error: invalid token" >&2
+exit 1
+`)
+	_, err := Chat(context.Background(), types.Request{Message: "hello"}, WithCli(kode))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "exit status 1") || !strings.Contains(msg, "starting\nerror: invalid token") {
+		t.Errorf("expected exit status and stderr in error, got %q", msg)
+	}
+}
+
+func TestStderrTail(t *testing.T) {
+	var tail stderrTail
+	for i := 1; i <= maxStderrLines+5; i++ {
+		tail.add(strconv.Itoa(i))
+	}
+	if len(tail.lines) != maxStderrLines || tail.lines[0] != "6" {
+		t.Errorf("expected the last %d lines, got %v", maxStderrLines, tail.lines)
+	}
+}
+
+func TestExtraArgs(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	kode := writeFakeKode(t, `for arg in "$@"; do echo "$arg"; done > "`+argsFile+`"
+`)
+	_, err := Chat(context.Background(), types.Request{Message: "hello", Model: "gpt-4o"}, WithCli(kode), WithExtraArgs("--no-cache", "--model", "gpt-4.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Join(strings.Fields(string(data)), " ")
+	expected := "chat --std-stream --wait-for-stream-events --model gpt-4o --no-cache --model gpt-4.1"
+	if args != expected {
+		t.Errorf("expected args %q, got %q", expected, args)
+	}
+
+	_, err = Chat(context.Background(), types.Request{Message: "hello"}, WithCli(kode), WithExtraArgs("--std-stream=false"))
+	if err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("expected reserved flag rejected, got %v", err)
+	}
+}
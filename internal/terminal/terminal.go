@@ -16,6 +16,10 @@ func IsStdoutTerminal() bool {
 	return term.IsTerminal(int(os.Stdout.Fd()))
 }
 
+func IsStderrTerminal() bool {
+	return term.IsTerminal(int(os.Stderr.Fd()))
+}
+
 func isStdinTTYOld() bool {
 	fileInfo, err := os.Stdin.Stat()
 	if err != nil {
@@ -21,6 +21,11 @@ func Chat(ctx context.Context, client *anthropic.Client, params anthropic.Messag
 
 // stream
 func Stream(ctx context.Context, client *anthropic.Client, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	return StreamWithCallback(ctx, client, params, nil)
+}
+
+// StreamWithCallback is Stream, calling onEvent with each event as soon as it is accumulated
+func StreamWithCallback(ctx context.Context, client *anthropic.Client, params anthropic.MessageNewParams, onEvent func(event anthropic.MessageStreamEventUnion)) (*anthropic.Message, error) {
 	stream := client.Messages.NewStreaming(ctx, params)
 	message := anthropic.Message{}
 	for stream.Next() {
@@ -29,17 +34,8 @@ func Stream(ctx context.Context, client *anthropic.Client, params anthropic.Mess
 		if err != nil {
 			return nil, fmt.Errorf("accumulate event: %w", err)
 		}
-		// TODO: add callback to send as soon as possible
-		if false {
-			eventAny := event.AsAny()
-			fmt.Printf("Received event: %T\n", eventAny)
-			switch eventVariant := eventAny.(type) {
-			case anthropic.ContentBlockDeltaEvent:
-				switch deltaVariant := eventVariant.Delta.AsAny().(type) {
-				case anthropic.TextDelta:
-					fmt.Printf("Streaming received: %s\n", deltaVariant.Text)
-				}
-			}
+		if onEvent != nil {
+			onEvent(event)
 		}
 	}
 	if err := stream.Err(); err != nil {
@@ -28,6 +28,9 @@ const (
 	ModelKimiK2                   = types.ModelKimiK2
 	ModelKimiK2_0711_Preview      = types.ModelKimiK2_0711_Preview
 	ModelOpenRouterKimiK2         = types.ModelOpenRouterKimiK2
+	ModelMoonshotV1_8K            = types.ModelMoonshotV1_8K
+	ModelMoonshotV1_32K           = types.ModelMoonshotV1_32K
+	ModelMoonshotV1_128K          = types.ModelMoonshotV1_128K
 	ModelDeepSeekR1               = types.ModelDeepSeekR1
 	ModelQwen25VL72BInstruct      = types.ModelQwen25VL72BInstruct
 )
@@ -47,3 +50,17 @@ func GetUnderlyingModel(model string) string {
 func GetModelCost(model string) (ModelCost, bool) {
 	return providers.GetModelCost(model)
 }
+
+// RegisterModelPricing sets the price of model in USD per 1M tokens, taking precedence over the built-in price
+func RegisterModelPricing(model string, inputPerM string, outputPerM string, cacheRead string, cacheWrite string) error {
+	return providers.RegisterModelPricing(model, inputPerM, outputPerM, cacheRead, cacheWrite)
+}
+
+// RegisterModel adds a model not built in, it must be called before any chat
+func RegisterModel(info types.ModelInfo) error {
+	return providers.RegisterModel(info)
+}
+
+func IsReasoningModel(model string) bool {
+	return providers.IsReasoningModel(model)
+}
@@ -0,0 +1,271 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const batchHelp = `
+batch - Run each prompt of a JSONL file through the model
+
+Usage: kode batch <prompts.jsonl> [OPTIONS]
+
+Each line of the input is a {"message": "...", "system": "..."} object, system is optional.
+Each output line has the index, response, token usage and cost of an input line,
+in input order.
+
+Options:
+  --model MODEL              the model(default: resolved from available API key env)
+  --token TOKEN              the token
+  --base-url BASE_URL        the base url
+  --system PROMPT            system prompt for lines without one, PROMPT can also be a file
+  --tool NAME                builtin tool, repeatable
+  --max-round N              maximum number of chat rounds per line
+  -j,--workers N             number of lines processed concurrently(default: 1)
+  -o,--output FILE           write results to FILE(default: stdout)
+  -h, --help                 show this help message
+
+Examples:
+  kode batch prompts.jsonl --model gpt-4.1 -j 4 -o results.jsonl
+`
+
+// batchInput is a line of the batch input
+type batchInput struct {
+	Message string `json:"message"`
+	System  string `json:"system,omitempty"`
+}
+
+// batchOutput is a line of the batch output
+type batchOutput struct {
+	Index      int              `json:"index"`
+	Response   string           `json:"response"`
+	Error      string           `json:"error,omitempty"`
+	TokenUsage types.TokenUsage `json:"token_usage"`
+	CostUSD    string           `json:"cost_usd,omitempty"`
+}
+
+type batchOptions struct {
+	model        string
+	systemPrompt string
+	tools        []string
+	maxRound     int
+	workers      int
+}
+
+func handleBatch(args []string, defaultBaseURL string) error {
+	var model string
+	var token string
+	var baseUrl string
+	var systemPrompt string
+	var builtinTools []string
+	var maxRound int
+	var workers int
+	var output string
+	args, err := flags.String("--model", &model).
+		String("--token", &token).
+		String("--base-url", &baseUrl).
+		String("--system", &systemPrompt).
+		StringSlice("--tool", &builtinTools).
+		Int("--max-round", &maxRound).
+		Int("-j,--workers", &workers).
+		String("-o,--output", &output).
+		Help("-h,--help", strings.TrimPrefix(batchHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("requires prompts file, try `kode batch --help`")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("unrecognized extra: %s", strings.Join(args[1:], ","))
+	}
+	if workers < 0 {
+		return fmt.Errorf("invalid --workers: %d", workers)
+	}
+
+	inputs, err := readBatchInputs(args[0])
+	if err != nil {
+		return err
+	}
+
+	if model == "" {
+		model = ResolveDefaultModel("", os.Getenv)
+	}
+	model = providers.GetUnderlyingModel(model)
+	apiShape, err := providers.GetModelAPIShape(model)
+	if err != nil {
+		return err
+	}
+	provider, err := providers.GetModelProvider(model)
+	if err != nil {
+		return err
+	}
+	resolvedOpts, err := ResolveProviderDefaultEnvOptions(apiShape, provider, "", token, baseUrl, defaultBaseURL)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	config := chat.Config{
+		Model:   model,
+		Token:   resolvedOpts.Token,
+		BaseURL: resolvedOpts.BaseUrl,
+	}
+	failed, err := runBatch(context.Background(), config, inputs, batchOptions{
+		model:        model,
+		systemPrompt: systemPrompt,
+		tools:        builtinTools,
+		maxRound:     maxRound,
+		workers:      workers,
+	}, w)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d line(s) failed", failed, len(inputs))
+	}
+	return nil
+}
+
+func readBatchInputs(file string) ([]batchInput, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var inputs []batchInput
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var input batchInput
+		if err := json.Unmarshal([]byte(line), &input); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, lineNum, err)
+		}
+		if input.Message == "" {
+			return nil, fmt.Errorf("%s:%d: requires message", file, lineNum)
+		}
+		inputs = append(inputs, input)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+// runBatch runs inputs with opts.workers concurrent chats, writing one output
+// line per input in input order. It returns the number of failed inputs
+func runBatch(ctx context.Context, config chat.Config, inputs []batchInput, opts batchOptions, w io.Writer) (int, error) {
+	workers := opts.workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// a client holds per-request state, so each worker has its own
+	clients := make([]*chat.Client, workers)
+	for i := range clients {
+		client, err := chat.NewClient(config)
+		if err != nil {
+			return 0, fmt.Errorf("create client: %w", err)
+		}
+		defer client.Close()
+		clients[i] = client
+	}
+
+	outputs := make([]batchOutput, len(inputs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *chat.Client) {
+			defer wg.Done()
+			for index := range indexes {
+				outputs[index] = runBatchInput(ctx, client, index, inputs[index], opts)
+			}
+		}(client)
+	}
+	for i := range inputs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var failed int
+	encoder := json.NewEncoder(w)
+	for _, output := range outputs {
+		if output.Error != "" {
+			failed++
+		}
+		if err := encoder.Encode(output); err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+func runBatchInput(ctx context.Context, client *chat.Client, index int, input batchInput, opts batchOptions) batchOutput {
+	systemPrompt := input.System
+	if systemPrompt == "" {
+		systemPrompt = opts.systemPrompt
+	}
+	// the last assistant msg is the response
+	var response string
+	chatOpts := []types.ChatOption{
+		chat.WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+				response = event.Content
+			}
+		}),
+	}
+	if systemPrompt != "" {
+		chatOpts = append(chatOpts, chat.WithSystemPrompt(systemPrompt))
+	}
+	if len(opts.tools) > 0 {
+		chatOpts = append(chatOpts, chat.WithTools(opts.tools...))
+	}
+	if opts.maxRound > 0 {
+		chatOpts = append(chatOpts, chat.WithMaxRounds(opts.maxRound))
+	}
+
+	output := batchOutput{Index: index}
+	resp, err := client.Chat(ctx, input.Message, chatOpts...)
+	if err != nil {
+		output.Error = err.Error()
+		return output
+	}
+	output.Response = response
+	output.TokenUsage = resp.TokenUsage
+	if apiShape, err := providers.GetModelAPIShape(opts.model); err == nil {
+		if cost, ok := providers.ComputeCost(apiShape, opts.model, resp.TokenUsage); ok {
+			output.CostUSD = cost.TotalUSD
+		}
+	}
+	return output
+}
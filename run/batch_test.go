@@ -0,0 +1,84 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/run/mock_server"
+)
+
+func TestRunBatch(t *testing.T) {
+	mockServer := mock_server.NewMockServer(mock_server.Config{Provider: "openai", Seed: 1})
+	server := httptest.NewServer(http.HandlerFunc(mockServer.HandleOpenAIMock))
+	defer server.Close()
+
+	input := filepath.Join(t.TempDir(), "prompts.jsonl")
+	content := `{"message":"hello"}
+{"message":"what is 1+1?","system":"you are a calculator"}
+
+{"message":"bye"}
+`
+	if err := os.WriteFile(input, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := readBatchInputs(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := chat.Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	}
+	var buf bytes.Buffer
+	failed, err := runBatch(context.Background(), config, inputs, batchOptions{model: "gpt-4o", workers: 2}, &buf)
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	if failed != 0 {
+		t.Errorf("expected no failure, got %d", failed)
+	}
+
+	var outputs []batchOutput
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var output batchOutput
+		if err := json.Unmarshal(scanner.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, output)
+	}
+	if len(outputs) != 3 {
+		t.Fatalf("expected 3 output lines, got %d: %s", len(outputs), buf.String())
+	}
+	for i, output := range outputs {
+		if output.Index != i {
+			t.Errorf("line %d: expected index %d, got %d", i, i, output.Index)
+		}
+		if output.Response == "" || output.Error != "" {
+			t.Errorf("line %d: expected response, got %+v", i, output)
+		}
+		if output.TokenUsage.Total == 0 {
+			t.Errorf("line %d: expected token usage", i)
+		}
+	}
+}
+
+func TestReadBatchInputsRequiresMessage(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "prompts.jsonl")
+	if err := os.WriteFile(input, []byte(`{"system":"no message"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBatchInputs(input); err == nil {
+		t.Errorf("expected error for line without message")
+	}
+}
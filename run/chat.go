@@ -13,27 +13,79 @@ import (
 
 type ChatOptions struct {
 	maxRound int
+	headers  map[string]string
+
+	openAIOrg     string
+	openAIProject string
 
 	systemPrompt string
+	contextFiles []string
+	userMetadata map[string]interface{}
+	gitDiff      bool
+	gitDiffRev   string
+	documents    []string
 	toolBuiltins []string
 	toolFiles    []string
 	toolJSONs    []string
 	recordFile   string
 
-	toolDefaultCwd string
+	autoSaveInterval    time.Duration
+	noIncrementalRecord bool
+	historyLast         int
+	saveOnInterrupt     bool
+	diffApplyConfirm    bool
+	diffApplyPolicy     string
+	recordLogProbs      bool
+
+	toolDefaultCwd   string
+	sandbox          bool
+	toolResolution   types.ToolResolution
+	onMaxRounds      types.MaxRoundsAction
+	toolChoice       string
+	reasoningEffort  types.ReasoningEffort
+	safetySettings   []types.SafetySetting
+	assistantPrefill string
+	prediction       string
+	seed             *int64
+	streamToolArgs   bool
+	logProbs         bool
+	topLogProbs      int
+
+	abortOnToolError bool
+	strictToolArgs   bool
+	oncePerTool      bool
+	nudgeOnEmpty     bool
+	maxToolRetries   int
+	stopOnSendAnswer bool
+	toolTimeout      time.Duration
+	toolTimeouts     map[string]time.Duration
+
+	compactToolResults int
+	compactModel       string
 
 	ignoreDuplicateMsg bool
 	noCache            bool
+	promptCacheKey     string
 
 	logRequest          bool
+	logRedact           types.LogRedact
+	traceFile           string
+	auditLog            string
+	toolCacheDir        string
+	eventSink           string
+	estimate            bool
 	verbose             bool
 	logChat             bool
 	jsonOutput          bool
+	showRoles           bool
+	roundSeparator      string
 	stdStream           bool
 	waitForStreamEvents bool
 
 	// MCP server configuration
-	mcpServers []string
+	mcpServers     []string
+	mcpNamespace   bool
+	mcpConcurrency int
 
 	withServer       string
 	chatWithServerFn func(ctx context.Context, server string, req types.Request) (*types.Response, error)
@@ -44,6 +96,10 @@ func (c ChatOptions) SystemPrompt() string {
 	return c.systemPrompt
 }
 
+func (c ChatOptions) ContextFiles() []string {
+	return c.contextFiles
+}
+
 func (c ChatOptions) MaxRound() int {
 	return c.maxRound
 }
@@ -95,11 +151,16 @@ func (c *ChatHandler) Handle(model string, baseUrl string, token string, msg str
 		Model:   model,
 		Token:   token,
 		BaseURL: baseUrl,
+		Headers: opts.headers,
+
+		OpenAIOrganization: opts.openAIOrg,
+		OpenAIProject:      opts.openAIProject,
 	}
 
 	// Set log level based on existing options
 	if opts.logRequest {
 		config.LogLevel = types.LogLevelRequest
+		config.LogRedact = opts.logRedact
 	}
 
 	// Convert existing options to new library options
@@ -107,6 +168,18 @@ func (c *ChatHandler) Handle(model string, baseUrl string, token string, msg str
 	if opts.systemPrompt != "" {
 		coreOpts = append(coreOpts, chat.WithSystemPrompt(opts.systemPrompt))
 	}
+	if len(opts.userMetadata) > 0 {
+		coreOpts = append(coreOpts, chat.WithUserMetadata(opts.userMetadata))
+	}
+	if len(opts.contextFiles) > 0 {
+		coreOpts = append(coreOpts, chat.WithContextFiles(opts.contextFiles...))
+	}
+	if opts.gitDiff {
+		coreOpts = append(coreOpts, chat.WithGitDiff(opts.gitDiffRev))
+	}
+	if len(opts.documents) > 0 {
+		coreOpts = append(coreOpts, chat.WithDocuments(opts.documents...))
+	}
 	if opts.maxRound > 0 {
 		coreOpts = append(coreOpts, chat.WithMaxRounds(opts.maxRound))
 	}
@@ -122,12 +195,96 @@ func (c *ChatHandler) Handle(model string, baseUrl string, token string, msg str
 	if opts.toolDefaultCwd != "" {
 		coreOpts = append(coreOpts, chat.WithDefaultToolCwd(opts.toolDefaultCwd))
 	}
+	if opts.sandbox {
+		coreOpts = append(coreOpts, chat.WithSandbox(true))
+	}
+	if opts.abortOnToolError {
+		coreOpts = append(coreOpts, chat.WithAbortOnToolError(true))
+	}
+	if opts.strictToolArgs {
+		coreOpts = append(coreOpts, chat.WithStrictToolArgs(true))
+	}
+	if opts.oncePerTool {
+		coreOpts = append(coreOpts, chat.WithOncePerTool(true))
+	}
+	if opts.nudgeOnEmpty {
+		coreOpts = append(coreOpts, chat.WithNudgeOnEmpty(true))
+	}
+	if opts.maxToolRetries > 0 {
+		coreOpts = append(coreOpts, chat.WithMaxToolRetries(opts.maxToolRetries))
+	}
+	if opts.compactToolResults > 0 {
+		coreOpts = append(coreOpts, chat.WithCompactToolResults(opts.compactToolResults, opts.compactModel))
+	}
+	if opts.stopOnSendAnswer {
+		coreOpts = append(coreOpts, chat.WithStopOnSendAnswer())
+	}
+	if opts.toolTimeout > 0 {
+		coreOpts = append(coreOpts, chat.WithToolTimeout(opts.toolTimeout))
+	}
+	if len(opts.toolTimeouts) > 0 {
+		coreOpts = append(coreOpts, chat.WithToolTimeouts(opts.toolTimeouts))
+	}
+	if opts.onMaxRounds != "" {
+		coreOpts = append(coreOpts, chat.WithOnMaxRounds(opts.onMaxRounds))
+	}
+	if opts.toolResolution != "" {
+		coreOpts = append(coreOpts, chat.WithToolResolution(opts.toolResolution))
+	}
+	if opts.toolChoice != "" {
+		coreOpts = append(coreOpts, chat.WithToolChoice(opts.toolChoice))
+	}
+	if opts.reasoningEffort != "" {
+		coreOpts = append(coreOpts, chat.WithReasoningEffort(opts.reasoningEffort))
+	}
+	if len(opts.safetySettings) > 0 {
+		coreOpts = append(coreOpts, chat.WithSafetySettings(opts.safetySettings...))
+	}
+	if opts.assistantPrefill != "" {
+		coreOpts = append(coreOpts, chat.WithAssistantPrefill(opts.assistantPrefill))
+	}
+	if opts.prediction != "" {
+		coreOpts = append(coreOpts, chat.WithPredictedOutput(opts.prediction))
+	}
+	if opts.seed != nil {
+		coreOpts = append(coreOpts, chat.WithSeed(*opts.seed))
+	}
+	if opts.logProbs {
+		coreOpts = append(coreOpts, chat.WithLogProbs(opts.topLogProbs))
+	}
+	if opts.streamToolArgs {
+		coreOpts = append(coreOpts, chat.WithStreamToolArgs(true))
+	}
 	if opts.noCache {
 		coreOpts = append(coreOpts, chat.WithCache(false))
 	}
+	if opts.promptCacheKey != "" {
+		coreOpts = append(coreOpts, chat.WithPromptCacheKey(opts.promptCacheKey))
+	}
 	if len(opts.mcpServers) > 0 {
 		coreOpts = append(coreOpts, chat.WithMCPServers(opts.mcpServers...))
 	}
+	if opts.mcpNamespace {
+		coreOpts = append(coreOpts, chat.WithMCPNamespace())
+	}
+	if opts.mcpConcurrency > 0 {
+		coreOpts = append(coreOpts, chat.WithMCPConcurrency(opts.mcpConcurrency))
+	}
+	if opts.traceFile != "" {
+		coreOpts = append(coreOpts, chat.WithTraceFile(opts.traceFile))
+	}
+	if opts.auditLog != "" {
+		coreOpts = append(coreOpts, chat.WithAuditLog(opts.auditLog))
+	}
+	if opts.toolCacheDir != "" {
+		coreOpts = append(coreOpts, chat.WithToolCacheDir(opts.toolCacheDir))
+	}
+	if opts.eventSink != "" {
+		coreOpts = append(coreOpts, chat.WithEventSink(opts.eventSink))
+	}
+	if opts.estimate {
+		coreOpts = append(coreOpts, chat.WithEstimateOnly(true))
+	}
 
 	// Add stdin/stdout streams for bidirectional tool callback communication
 	if opts.stdStream {
@@ -155,12 +312,21 @@ func (c *ChatHandler) Handle(model string, baseUrl string, token string, msg str
 
 	// Create CLI handler with existing CLI-specific options
 	cliHandler := chat.NewCliHandler(client, chat.CliOptions{
-		RecordFile:         opts.recordFile,
-		IgnoreDuplicateMsg: opts.ignoreDuplicateMsg,
-		LogRequest:         opts.logRequest,
-		LogChat:            opts.logChat,
-		Verbose:            opts.verbose,
-		JSONOutput:         opts.jsonOutput || opts.stdStream,
+		RecordFile:          opts.recordFile,
+		AutoSaveInterval:    opts.autoSaveInterval,
+		NoIncrementalRecord: opts.noIncrementalRecord,
+		HistoryLast:         opts.historyLast,
+		SaveOnInterrupt:     opts.saveOnInterrupt,
+		ConfirmFileChanges:  opts.diffApplyConfirm,
+		FileChangePolicy:    opts.diffApplyPolicy,
+		RecordLogProbs:      opts.recordLogProbs,
+		IgnoreDuplicateMsg:  opts.ignoreDuplicateMsg,
+		LogRequest:          opts.logRequest,
+		LogChat:             opts.logChat,
+		Verbose:             opts.verbose,
+		JSONOutput:          opts.jsonOutput || opts.stdStream,
+		ShowRoles:           opts.showRoles,
+		RoundSeparator:      opts.roundSeparator,
 	})
 
 	withServer := opts.withServer
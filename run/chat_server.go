@@ -2,6 +2,8 @@ package run
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/xhd2015/kode-ai/chat/server"
 	"github.com/xhd2015/less-gen/flags"
@@ -13,10 +15,27 @@ Usage: kode chat-server [OPTIONS]
 
 Options:
   --listen PORT          port to listen on (default: 8080)
+  --max-idle DURATION    close connections with no message received or sent for DURATION, e.g. 5m(default: no limit)
+  --max-conn-per-ip N    maximum concurrent connections of a client IP, more are rejected with 429(default: no limit)
+  --rate-per-minute N    maximum connections a client IP opens per minute, more are rejected with 429(default: no limit)
+  --max-message-bytes N  close connections receiving a larger message(default: no limit)
+  --max-cost-per-conn USD
+                         stop the chat of a connection with an error once its cost exceeds USD, e.g. 0.5(default: no limit),
+                         chats of models without known pricing are rejected
   -v,--verbose           show verbose info
   -h,--help              show this help message
 
 The server exposes a WebSocket endpoint at /stream that supports all events from types.Message.
+Events are numbered by seq, a client dropped mid-stream reconnects to
+/stream?session=ID&resume_from=SEQ, with ID from the X-Kode-Session-Id
+response header, to receive the events after SEQ.
+
+POST /chat runs the types.Request in the JSON body and responds with the
+types.Response, including the messages. With Accept: text/event-stream, each
+event is sent as SSE data, followed by an "end" event with the response.
+
+GET /metrics serves, in the Prometheus text format, the active connections and
+the chats, tokens, cost and tool calls accumulated across sessions.
 
 Examples:
   kode chat-server --listen 8080
@@ -27,9 +46,19 @@ Examples:
 func handleChatServer(args []string) error {
 	var verbose bool
 	var listen int = 8080
+	var maxIdle time.Duration
+	var maxConnPerIP int
+	var ratePerMinute int
+	var maxMessageBytes int
+	var maxCostPerConn string
 
 	flagsParser := flags.Bool("-v,--verbose", &verbose).
 		Int("--listen", &listen).
+		Duration("--max-idle", &maxIdle).
+		Int("--max-conn-per-ip", &maxConnPerIP).
+		Int("--rate-per-minute", &ratePerMinute).
+		Int("--max-message-bytes", &maxMessageBytes).
+		String("--max-cost-per-conn", &maxCostPerConn).
 		Help("-h,--help", helpChatServer)
 
 	args, err := flagsParser.Parse(args)
@@ -40,10 +69,36 @@ func handleChatServer(args []string) error {
 	if len(args) > 0 {
 		return fmt.Errorf("unexpected arguments: %v", args)
 	}
+	if maxIdle < 0 {
+		return fmt.Errorf("invalid --max-idle: %v", maxIdle)
+	}
+	if maxConnPerIP < 0 {
+		return fmt.Errorf("invalid --max-conn-per-ip: %d", maxConnPerIP)
+	}
+	if ratePerMinute < 0 {
+		return fmt.Errorf("invalid --rate-per-minute: %d", ratePerMinute)
+	}
+	if maxMessageBytes < 0 {
+		return fmt.Errorf("invalid --max-message-bytes: %d", maxMessageBytes)
+	}
+
+	var maxCostPerConnUSD float64
+	if maxCostPerConn != "" {
+		maxCostPerConnUSD, err = strconv.ParseFloat(maxCostPerConn, 64)
+		if err != nil || maxCostPerConnUSD <= 0 {
+			return fmt.Errorf("invalid --max-cost-per-conn: %s", maxCostPerConn)
+		}
+	}
 
 	// Create server options (only server-level configuration)
 	serverOpts := server.ServerOptions{
-		Verbose: verbose,
+		Verbose:             verbose,
+		IdleTimeout:         maxIdle,
+		MaxConnectionsPerIP: maxConnPerIP,
+		RatePerMinute:       ratePerMinute,
+		MaxMessageBytes:     int64(maxMessageBytes),
+
+		MaxCostPerConnectionUSD: maxCostPerConnUSD,
 	}
 
 	// Start the server
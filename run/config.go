@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	var_template "github.com/xhd2015/go-var-template"
@@ -21,14 +22,23 @@ var ConfigDef string
 type FullConfig struct {
 	Config
 	RecordFile         string `json:"record_file,omitempty"`
+	DefaultModel       string `json:"default_model,omitempty"`  // used when model is not specified
+	OpenAIOrg          string `json:"openai_org,omitempty"`     // used by OpenAI models when --openai-org is not specified
+	OpenAIProject      string `json:"openai_project,omitempty"` // used by OpenAI models when --openai-project is not specified
 	NoCache            bool   `json:"no_cache,omitempty"`
 	ShowUsage          bool   `json:"show_usage,omitempty"`
 	IgnoreDuplicateMsg bool   `json:"ignore_duplicate_msg,omitempty"`
 	LogRequest         bool   `json:"log_request,omitempty"`
 	LogChat            *bool  `json:"log_chat,omitempty"`
 	Verbose            bool   `json:"verbose,omitempty"`
+
+	// Profiles are named overrides of the config, like {"dev": {"model": "..."}}, selected by --profile or $KODE_PROFILE
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty"`
 }
 
+// profileEnvKey selects the config profile when --profile is not specified
+const profileEnvKey = "KODE_PROFILE"
+
 // LoadConfig loads configuration from a JSON file
 func LoadConfig(configFile string) (*FullConfig, error) {
 	if configFile == "" {
@@ -58,11 +68,17 @@ func LoadConfig(configFile string) (*FullConfig, error) {
 	return &config, nil
 }
 
-// ApplyConfig applies configuration values to the provided variables, giving precedence to command line arguments
-func ApplyConfig(config *FullConfig, token *string, maxRound *int, baseUrl *string, model *string, systemPrompt *string, tools *[]string, toolCustomFiles *[]string, toolCustomJSONs *[]string, toolDefaultCwd *string, recordFile *string, noCache *bool, showUsage *bool, ignoreDuplicateMsg *bool, logRequest *bool, logChatFlag **bool, verbose *bool, mcpServers *[]string) error {
+// ApplyConfig applies configuration values to the provided variables, giving precedence to command line arguments.
+// A non-empty profile is merged over the config first, its fields replacing those of the config
+func ApplyConfig(config *FullConfig, profile string, token *string, maxRound *int, baseUrl *string, model *string, systemPrompt *string, tools *[]string, toolCustomFiles *[]string, toolCustomJSONs *[]string, toolDefaultCwd *string, recordFile *string, noCache *bool, showUsage *bool, ignoreDuplicateMsg *bool, logRequest *bool, logChatFlag **bool, verbose *bool, mcpServers *[]string) error {
 	if config == nil {
 		return nil
 	}
+	if profile != "" {
+		if err := config.mergeProfile(profile); err != nil {
+			return err
+		}
+	}
 
 	// Apply config values only if command line arguments are not set
 	if *token == "" && config.Token != "" {
@@ -136,6 +152,23 @@ func ApplyConfig(config *FullConfig, token *string, maxRound *int, baseUrl *stri
 	return nil
 }
 
+// mergeProfile unmarshals the profile over c, so only the fields it sets are replaced
+func (c *FullConfig) mergeProfile(profile string) error {
+	content, ok := c.Profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("config profile %q not found, available: %s", profile, strings.Join(names, ", "))
+	}
+	if err := json.Unmarshal(content, c); err != nil {
+		return fmt.Errorf("parse config profile %s: %v", profile, err)
+	}
+	return nil
+}
+
 func getStrOrStrLines(v interface{}) (string, error) {
 	if v == nil {
 		return "", nil
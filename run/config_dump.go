@@ -0,0 +1,203 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const configHelp = `
+config - Inspect the kode configuration
+
+Usage: kode config dump [OPTIONS]
+
+Prints the effective configuration of kode chat as JSON, after merging
+the flags, the config file and its profile, env and ~/.kode/credentials.json,
+to debug why a model, base url or token is used. The token is redacted.
+
+Options:
+  --model MODEL          llm model
+  --default-model MODEL  the model to use when --model is not specified
+  --token TOKEN          the token
+  --base-url BASE_URL    the base url
+  --max-round N          maximum number of chat rounds
+  --system PROMPT        the system prompt
+  --tool NAME            predefined tool, repeatable
+  --record FILE          record file
+  --mcp SERVER           MCP server, repeatable
+  -c,--config FILE       config file
+  --profile NAME         config profile(default: $KODE_PROFILE)
+  -h, --help             show this help message
+
+Examples:
+  kode config dump
+  kode config dump -c kode.json --profile dev
+`
+
+// effectiveConfig is the configuration kode chat resolves, printed by kode config dump
+type effectiveConfig struct {
+	ConfigFile     string   `json:"config_file,omitempty"`
+	Profile        string   `json:"profile,omitempty"`
+	Model          string   `json:"model"`
+	APIShape       string   `json:"api_shape"`
+	Provider       string   `json:"provider"`
+	BaseURL        string   `json:"base_url"`
+	Token          string   `json:"token"`
+	MaxRound       int      `json:"max_round,omitempty"`
+	SystemPrompt   string   `json:"system_prompt,omitempty"`
+	Tools          []string `json:"tools,omitempty"`
+	ToolFiles      []string `json:"tool_custom_files,omitempty"`
+	ToolDefaultCwd string   `json:"tool_default_cwd,omitempty"`
+	RecordFile     string   `json:"record_file,omitempty"`
+	MCPServers     []string `json:"mcp_servers,omitempty"`
+	NoCache        bool     `json:"no_cache,omitempty"`
+	LogRequest     bool     `json:"log_request,omitempty"`
+	LogChat        bool     `json:"log_chat"`
+	Verbose        bool     `json:"verbose,omitempty"`
+}
+
+// configDumpOptions are the chat flags affecting the configuration
+type configDumpOptions struct {
+	model        string
+	defaultModel string
+	token        string
+	baseUrl      string
+	maxRound     int
+	systemPrompt string
+	tools        []string
+	recordFile   string
+	mcpServers   []string
+	configFile   string
+	profile      string
+}
+
+func handleConfig(args []string, defaultBaseURL string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("requires sub command: dump, try `kode config --help`")
+	}
+	if args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(strings.TrimPrefix(configHelp, "\n"))
+		return nil
+	}
+	if args[0] != "dump" {
+		return fmt.Errorf("unrecognized: config %s, try `kode config --help`", args[0])
+	}
+
+	var opts configDumpOptions
+	args, err := flags.String("--model", &opts.model).
+		String("--default-model", &opts.defaultModel).
+		String("--token", &opts.token).
+		String("--base-url", &opts.baseUrl).
+		Int("--max-round", &opts.maxRound).
+		String("--system", &opts.systemPrompt).
+		StringSlice("--tool", &opts.tools).
+		String("--record", &opts.recordFile).
+		StringSlice("--mcp", &opts.mcpServers).
+		String("-c,--config", &opts.configFile).
+		String("--profile", &opts.profile).
+		Help("-h,--help", strings.TrimPrefix(configHelp, "\n")).
+		Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unrecognized extra: %s", strings.Join(args, ","))
+	}
+	config, err := resolveEffectiveConfig(opts, defaultBaseURL)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// resolveEffectiveConfig resolves the configuration the same way as kode chat
+func resolveEffectiveConfig(opts configDumpOptions, defaultBaseURL string) (*effectiveConfig, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadConfig(opts.configFile)
+	if err != nil {
+		return nil, err
+	}
+	profile := opts.profile
+	if profile == "" && opts.configFile != "" {
+		profile = os.Getenv(profileEnvKey)
+	}
+
+	token, baseUrl, model := opts.token, opts.baseUrl, opts.model
+	maxRound, systemPrompt := opts.maxRound, opts.systemPrompt
+	tools, recordFile, mcpServers := opts.tools, opts.recordFile, opts.mcpServers
+	var toolCustomFiles, toolCustomJSONs []string
+	var toolDefaultCwd string
+	var noCache, showUsage, ignoreDuplicateMsg, logRequest, verbose bool
+	var logChatFlag *bool
+	err = ApplyConfig(config, profile, &token, &maxRound, &baseUrl, &model, &systemPrompt, &tools, &toolCustomFiles, &toolCustomJSONs, &toolDefaultCwd, &recordFile, &noCache, &showUsage, &ignoreDuplicateMsg, &logRequest, &logChatFlag, &verbose, &mcpServers)
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		defaultModel := opts.defaultModel
+		if defaultModel == "" {
+			defaultModel = config.DefaultModel
+		}
+		model = ResolveDefaultModel(defaultModel, os.Getenv)
+	}
+	model = providers.GetUnderlyingModel(model)
+	apiShape, err := providers.GetModelAPIShape(model)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := providers.GetModelProvider(model)
+	if err != nil {
+		return nil, err
+	}
+	resolvedOpts, err := ResolveProviderDefaultEnvOptions(apiShape, provider, resolveToolDefaultCwd(toolDefaultCwd, cwd), token, baseUrl, defaultBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	logChat := true
+	if logChatFlag != nil {
+		logChat = *logChatFlag
+	}
+	return &effectiveConfig{
+		ConfigFile:     opts.configFile,
+		Profile:        profile,
+		Model:          model,
+		APIShape:       string(apiShape),
+		Provider:       string(provider),
+		BaseURL:        resolvedOpts.BaseUrl,
+		Token:          redactToken(resolvedOpts.Token),
+		MaxRound:       maxRound,
+		SystemPrompt:   systemPrompt,
+		Tools:          tools,
+		ToolFiles:      toolCustomFiles,
+		ToolDefaultCwd: resolvedOpts.AbsDefaultToolCwd,
+		RecordFile:     recordFile,
+		MCPServers:     mcpServers,
+		NoCache:        noCache,
+		LogRequest:     logRequest,
+		LogChat:        logChat,
+		Verbose:        verbose,
+	}, nil
+}
+
+// redactToken keeps only the last 4 characters of a long enough token, enough to tell tokens apart
+func redactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	if len(token) < 12 {
+		return "***"
+	}
+	return "***" + token[len(token)-4:]
+}
@@ -0,0 +1,40 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveEffectiveConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"model": "gpt-4o", "token": "sk-secret-token-1234"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPENAI_BASE_URL", "http://localhost:9090/v1")
+	t.Setenv(profileEnvKey, "")
+
+	config, err := resolveEffectiveConfig(configDumpOptions{configFile: file}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Model != "gpt-4o" || config.Provider != "openai" {
+		t.Errorf("expected model gpt-4o of openai, got %s of %s", config.Model, config.Provider)
+	}
+	if config.BaseURL != "http://localhost:9090/v1" {
+		t.Errorf("expected the base url from env, got %q", config.BaseURL)
+	}
+	if strings.Contains(config.Token, "secret") || config.Token != "***1234" {
+		t.Errorf("expected the token redacted, got %q", config.Token)
+	}
+
+	// flags take precedence over the config and env
+	config, err = resolveEffectiveConfig(configDumpOptions{configFile: file, baseUrl: "http://flag/v1"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.BaseURL != "http://flag/v1" {
+		t.Errorf("expected the base url from flag, got %q", config.BaseURL)
+	}
+}
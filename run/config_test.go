@@ -0,0 +1,67 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigProfile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+		"model": "gpt-4o",
+		"max_round": 10,
+		"profiles": {
+			"dev": {"model": "gpt-4o-mini", "base_url": "http://localhost:8080"},
+			"prod": {"model": "claude-3-7-sonnet", "max_round": 40}
+		}
+	}`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type applied struct {
+		model    string
+		baseUrl  string
+		maxRound int
+	}
+	apply := func(profile string) (applied, error) {
+		config, err := LoadConfig(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var token, baseUrl, model, systemPrompt, toolDefaultCwd, recordFile string
+		var maxRound int
+		var tools, toolCustomFiles, toolCustomJSONs, mcpServers []string
+		var noCache, showUsage, ignoreDuplicateMsg, logRequest, verbose bool
+		var logChat *bool
+		err = ApplyConfig(config, profile, &token, &maxRound, &baseUrl, &model, &systemPrompt, &tools, &toolCustomFiles, &toolCustomJSONs, &toolDefaultCwd, &recordFile, &noCache, &showUsage, &ignoreDuplicateMsg, &logRequest, &logChat, &verbose, &mcpServers)
+		return applied{model: model, baseUrl: baseUrl, maxRound: maxRound}, err
+	}
+
+	tests := []struct {
+		profile string
+		want    applied
+	}{
+		{"", applied{"gpt-4o", "", 10}},
+		{"dev", applied{"gpt-4o-mini", "http://localhost:8080", 10}},
+		{"prod", applied{"claude-3-7-sonnet", "", 40}},
+	}
+	for _, tt := range tests {
+		t.Run("profile="+tt.profile, func(t *testing.T) {
+			got, err := apply(tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	_, err := apply("staging")
+	if err == nil || !strings.Contains(err.Error(), "available: dev, prod") {
+		t.Errorf("expected the unknown profile rejected listing the profiles, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/xhd2015/kode-ai/providers"
+)
+
+// credentialsFileEnvKey overrides the default ~/.kode/credentials.json
+const credentialsFileEnvKey = "KODE_CREDENTIALS_FILE"
+
+// providerCredentials is an entry of the credentials file, which is keyed by provider:
+//
+//	{
+//	  "openai": {"token": "sk-..."},
+//	  "anthropic": {"token": "sk-ant-...", "base_url": "https://..."}
+//	}
+type providerCredentials struct {
+	Token   string `json:"token"`
+	BaseURL string `json:"base_url,omitempty"`
+}
+
+func credentialsFile() (string, error) {
+	if file := os.Getenv(credentialsFileEnvKey); file != "" {
+		return file, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".kode", "credentials.json"), nil
+}
+
+// loadProviderCredentials reads the credentials of provider from file,
+// a missing file or provider yields empty credentials
+func loadProviderCredentials(file string, provider providers.Provider) (providerCredentials, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return providerCredentials{}, nil
+		}
+		return providerCredentials{}, err
+	}
+	var all map[providers.Provider]providerCredentials
+	if err := json.Unmarshal(data, &all); err != nil {
+		return providerCredentials{}, fmt.Errorf("parse credentials file %s: %w", file, err)
+	}
+	return all[provider], nil
+}
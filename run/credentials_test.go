@@ -0,0 +1,84 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/providers"
+)
+
+func TestResolveCredentialsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	content := `{"openai": {"token": "file-token", "base_url": "https://file.example.com"}}`
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(credentialsFileEnvKey, file)
+	t.Setenv("KODE_DEFAULT_BASE_URL", "")
+
+	tests := []struct {
+		name        string
+		token       string
+		baseUrl     string
+		env         map[string]string
+		wantToken   string
+		wantBaseUrl string
+	}{
+		{"Flag", "flag-token", "https://flag.example.com", map[string]string{"OPENAI_API_KEY": "env-token", "OPENAI_BASE_URL": "https://env.example.com"}, "flag-token", "https://flag.example.com"},
+		{"Env", "", "", map[string]string{"OPENAI_API_KEY": "env-token", "OPENAI_BASE_URL": "https://env.example.com"}, "env-token", "https://env.example.com"},
+		{"DefaultBaseURLEnv", "", "", map[string]string{"KODE_DEFAULT_BASE_URL": "https://default.example.com"}, "file-token", "https://default.example.com"},
+		{"File", "", "", nil, "file-token", "https://file.example.com"},
+		// the file base url never receives a token from elsewhere
+		{"EnvTokenFileBaseURL", "", "", map[string]string{"OPENAI_API_KEY": "env-token"}, "env-token", ""},
+		{"FlagTokenFileBaseURL", "flag-token", "", nil, "flag-token", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"OPENAI_API_KEY", "OPENAI_BASE_URL"} {
+				t.Setenv(key, "")
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			resolved, err := ResolveProviderDefaultEnvOptions(providers.APIShapeOpenAI, providers.ProviderOpenAI, "", tt.token, tt.baseUrl, "")
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if resolved.Token != tt.wantToken || resolved.BaseUrl != tt.wantBaseUrl {
+				t.Errorf("expected %s %s, got %s %s", tt.wantToken, tt.wantBaseUrl, resolved.Token, resolved.BaseUrl)
+			}
+		})
+	}
+}
+
+func TestResolveCredentialsFileLastResort(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(file, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(credentialsFileEnvKey, file)
+	t.Setenv("ANTHROPIC_API_KEY", "env-token")
+	t.Setenv("ANTHROPIC_BASE_URL", "https://env.example.com")
+
+	// the broken file is never read
+	resolved, err := ResolveProviderDefaultEnvOptions(providers.APIShapeAnthropic, providers.ProviderAnthropic, "", "", "", "")
+	if err != nil {
+		t.Fatalf("expected credentials file unused, got %v", err)
+	}
+	if resolved.Token != "env-token" {
+		t.Errorf("expected env token, got %s", resolved.Token)
+	}
+
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	if _, err := ResolveProviderDefaultEnvOptions(providers.APIShapeAnthropic, providers.ProviderAnthropic, "", "", "", ""); err == nil {
+		t.Errorf("expected error reading the broken credentials file")
+	}
+
+	// a missing file is not an error, the token is just missing
+	t.Setenv(credentialsFileEnvKey, filepath.Join(t.TempDir(), "missing.json"))
+	_, err = ResolveProviderDefaultEnvOptions(providers.APIShapeAnthropic, providers.ProviderAnthropic, "", "", "", "")
+	if err == nil || err.Error() != "requires --token or ANTHROPIC_API_KEY" {
+		t.Errorf("expected missing token error, got %v", err)
+	}
+}
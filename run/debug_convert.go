@@ -0,0 +1,99 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const debugConvertHelp = `
+debug-convert - Print the provider-native messages a record is converted to
+
+Usage: kode debug-convert <record> [OPTIONS]
+
+For each recorded message, shows the index of the provider message it became,
+or why it was dropped, and pairs tool calls with their results. Tool calls
+without a result get a synthesized placeholder result, shown as [+].
+
+Options:
+  --model MODEL              the model whose provider format is shown(default: resolved from available API key env)
+  -h, --help                 show this help message
+
+Examples:
+  kode debug-convert record.json --model claude-sonnet-4
+`
+
+func handleDebugConvert(args []string) error {
+	var model string
+	args, err := flags.String("--model", &model).
+		Help("-h,--help", strings.TrimPrefix(debugConvertHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("requires record file, try `kode debug-convert --help`")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("unrecognized extra: %s", strings.Join(args[1:], ","))
+	}
+	if model == "" {
+		model = ResolveDefaultModel("", os.Getenv)
+	}
+	apiShape, err := providers.GetModelAPIShape(providers.GetUnderlyingModel(model))
+	if err != nil {
+		return err
+	}
+
+	messages, err := loadHistoricalMessages(args[0])
+	if err != nil {
+		return err
+	}
+	result, err := chat.ConvertMessages(messages, apiShape)
+	if err != nil {
+		return err
+	}
+	return printConvertResult(os.Stdout, result)
+}
+
+// printConvertResult prints what each message became, then the provider-native messages
+func printConvertResult(w io.Writer, result *chat.ConvertResult) error {
+	fmt.Fprintf(w, "# %s: %d message(s), %d system prompt(s)\n", result.APIShape, len(result.Entries), len(result.SystemPrompts))
+	for i, entry := range result.Entries {
+		msg := entry.Message
+		index := "+"
+		if entry.Index >= 0 {
+			index = fmt.Sprint(entry.Index)
+		}
+		desc := fmt.Sprintf("%d. [%s] %s", i, index, msg.Type)
+		if msg.Role != "" {
+			desc += "/" + string(msg.Role)
+		}
+		if msg.ToolName != "" {
+			desc += fmt.Sprintf(" %s(%s)", msg.ToolName, msg.ToolUseID)
+		}
+		if entry.Output >= 0 {
+			desc += fmt.Sprintf(" -> #%d", entry.Output)
+		}
+		if entry.Pair >= 0 {
+			desc += fmt.Sprintf(", paired with %d.", entry.Pair)
+		}
+		if entry.Note != "" {
+			desc += ", " + entry.Note
+		}
+		fmt.Fprintln(w, desc)
+	}
+
+	data, err := json.MarshalIndent(result.Messages, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# %s messages\n%s\n", result.APIShape, data)
+	return nil
+}
@@ -0,0 +1,217 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const diffRecordsHelp = `
+diff-records - Compare two recorded chats turn by turn
+
+Usage: kode diff-records <a.json> <b.json> [OPTIONS]
+
+Turns start at each user message and are aligned in order. A turn diverges
+if its user message, assistant responses, tool calls or token usage differ,
+e.g. the same task run with two system prompts.
+
+Options:
+  -v,--verbose               also show turns that are the same
+  -h, --help                 show this help message
+
+Examples:
+  kode diff-records before.json after.json
+`
+
+func handleDiffRecords(args []string) error {
+	var verbose bool
+	args, err := flags.Bool("-v,--verbose", &verbose).
+		Help("-h,--help", strings.TrimPrefix(diffRecordsHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("requires two record files, try `kode diff-records --help`")
+	}
+	a, err := loadHistoricalMessages(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := loadHistoricalMessages(args[1])
+	if err != nil {
+		return err
+	}
+	printRecordsDiff(os.Stdout, args[0], args[1], diffRecords(a, b), verbose)
+	return nil
+}
+
+// recordTurn is a user message and what followed it, up to the next user message.
+// Messages before the first user message, like the system prompt, are a turn without user message
+type recordTurn struct {
+	user       *types.Message
+	assistant  []string
+	toolCalls  []string
+	tokenUsage types.TokenUsage
+}
+
+// turnDiff compares the turns at the same index, A or B is nil if the record has fewer turns
+type turnDiff struct {
+	Index int
+	A     *recordTurn
+	B     *recordTurn
+
+	UserDiffers bool
+	// Assistant is the index of the first differing assistant response, -1 if same
+	Assistant int
+	// ToolCall is the index of the first differing tool call, -1 if same
+	ToolCall     int
+	TokensDiffer bool
+}
+
+func (d turnDiff) diverged() bool {
+	return d.A == nil || d.B == nil || d.UserDiffers || d.Assistant >= 0 || d.ToolCall >= 0 || d.TokensDiffer
+}
+
+func splitTurns(messages types.Messages) []*recordTurn {
+	var turns []*recordTurn
+	var turn *recordTurn
+	for i := range messages {
+		msg := &messages[i]
+		if msg.IsPartial() {
+			continue
+		}
+		if msg.Type == types.MsgType_Msg && msg.Role == types.Role_User {
+			turn = &recordTurn{user: msg}
+			turns = append(turns, turn)
+			continue
+		}
+		if turn == nil {
+			turn = &recordTurn{}
+			turns = append(turns, turn)
+		}
+		switch msg.Type {
+		case types.MsgType_Msg:
+			if msg.Role == types.Role_Assistant {
+				turn.assistant = append(turn.assistant, msg.Content)
+			}
+		case types.MsgType_ToolCall:
+			turn.toolCalls = append(turn.toolCalls, fmt.Sprintf("%s(%s)", msg.ToolName, msg.Content))
+		case types.MsgType_TokenUsage:
+			if msg.TokenUsage != nil {
+				turn.tokenUsage = turn.tokenUsage.Add(*msg.TokenUsage)
+			}
+		}
+	}
+	return turns
+}
+
+// diffRecords aligns the turns of a and b by index
+func diffRecords(a, b types.Messages) []turnDiff {
+	turnsA := splitTurns(a)
+	turnsB := splitTurns(b)
+	n := len(turnsA)
+	if len(turnsB) > n {
+		n = len(turnsB)
+	}
+	diffs := make([]turnDiff, 0, n)
+	for i := 0; i < n; i++ {
+		diff := turnDiff{Index: i, Assistant: -1, ToolCall: -1}
+		if i < len(turnsA) {
+			diff.A = turnsA[i]
+		}
+		if i < len(turnsB) {
+			diff.B = turnsB[i]
+		}
+		if diff.A != nil && diff.B != nil {
+			diff.UserDiffers = userContent(diff.A) != userContent(diff.B)
+			diff.Assistant = firstDifference(diff.A.assistant, diff.B.assistant)
+			diff.ToolCall = firstDifference(diff.A.toolCalls, diff.B.toolCalls)
+			diff.TokensDiffer = diff.A.tokenUsage.Total != diff.B.tokenUsage.Total
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+func userContent(turn *recordTurn) string {
+	if turn.user == nil {
+		return ""
+	}
+	return turn.user.Content
+}
+
+// firstDifference returns the first index where a and b differ, -1 if they are the same
+func firstDifference(a, b []string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) || i >= len(b) || a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// printRecordsDiff prints the diverged turns, and the same ones if verbose
+func printRecordsDiff(w io.Writer, nameA string, nameB string, diffs []turnDiff, verbose bool) {
+	var diverged int
+	for _, diff := range diffs {
+		if diff.diverged() {
+			diverged++
+		} else if !verbose {
+			continue
+		}
+		status := "SAME"
+		if diff.diverged() {
+			status = "DIFF"
+		}
+		turn := diff.A
+		if turn == nil {
+			turn = diff.B
+		}
+		title := "(before the first user message)"
+		if turn.user != nil {
+			title = limitPrintLength(turn.user.Content)
+		}
+		fmt.Fprintf(w, "[%s] turn %d: %s\n", status, diff.Index, title)
+		switch {
+		case diff.A == nil:
+			fmt.Fprintf(w, "  only in %s\n", nameB)
+			continue
+		case diff.B == nil:
+			fmt.Fprintf(w, "  only in %s\n", nameA)
+			continue
+		}
+		if diff.UserDiffers {
+			fmt.Fprintf(w, "  user message differs:\n")
+			fmt.Fprintf(w, "    %s: %s\n", nameA, limitPrintLength(userContent(diff.A)))
+			fmt.Fprintf(w, "    %s: %s\n", nameB, limitPrintLength(userContent(diff.B)))
+		}
+		if diff.Assistant >= 0 {
+			fmt.Fprintf(w, "  assistant response #%d differs:\n", diff.Assistant+1)
+			fmt.Fprintf(w, "    %s: %s\n", nameA, itemAt(diff.A.assistant, diff.Assistant))
+			fmt.Fprintf(w, "    %s: %s\n", nameB, itemAt(diff.B.assistant, diff.Assistant))
+		}
+		if diff.ToolCall >= 0 {
+			fmt.Fprintf(w, "  tool call #%d differs:\n", diff.ToolCall+1)
+			fmt.Fprintf(w, "    %s: %s\n", nameA, itemAt(diff.A.toolCalls, diff.ToolCall))
+			fmt.Fprintf(w, "    %s: %s\n", nameB, itemAt(diff.B.toolCalls, diff.ToolCall))
+		}
+		if diff.TokensDiffer {
+			totalA := diff.A.tokenUsage.Total
+			totalB := diff.B.tokenUsage.Total
+			fmt.Fprintf(w, "  tokens: %s %d, %s %d (%+d)\n", nameA, totalA, nameB, totalB, totalB-totalA)
+		}
+	}
+	fmt.Fprintf(w, "%d turn(s) compared, %d diverged\n", len(diffs), diverged)
+}
+
+func itemAt(items []string, i int) string {
+	if i >= len(items) {
+		return "(none)"
+	}
+	return limitPrintLength(items[i])
+}
@@ -0,0 +1,69 @@
+package run
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestDiffRecords(t *testing.T) {
+	session := func(answer string, tokens int64) []types.Message {
+		return []types.Message{
+			{Type: types.MsgType_Msg, Role: types.Role_System, Content: "be brief"},
+			{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+			{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"dir":"."}`},
+			{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"files":["a.go"]}`},
+			{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "a.go"},
+			{Type: types.MsgType_TokenUsage, TokenUsage: &types.TokenUsage{Input: 10, Output: 5, Total: 15}},
+			{Type: types.MsgType_Msg, Role: types.Role_User, Content: "what is in a.go?"},
+			{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: answer},
+			{Type: types.MsgType_TokenUsage, TokenUsage: &types.TokenUsage{Input: 20, Output: tokens - 20, Total: tokens}},
+		}
+	}
+	dir := t.TempDir()
+	recordA := filepath.Join(dir, "a.json")
+	recordB := filepath.Join(dir, "b.json")
+	if err := chat.SaveHistory(recordA, session("package main", 30)); err != nil {
+		t.Fatal(err)
+	}
+	if err := chat.SaveHistory(recordB, session("a Go file declaring package main", 42)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := loadHistoricalMessages(recordA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadHistoricalMessages(recordB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := diffRecords(a, b)
+	if len(diffs) != 3 {
+		t.Fatalf("expected 3 turns, got %d", len(diffs))
+	}
+	for i, diff := range diffs {
+		if diff.diverged() != (i == 2) {
+			t.Errorf("turn %d: expected diverged=%v, got %+v", i, i == 2, diff)
+		}
+	}
+	if diffs[2].Assistant != 0 || diffs[2].ToolCall != -1 || !diffs[2].TokensDiffer || diffs[2].UserDiffers {
+		t.Errorf("expected the assistant response and tokens of turn 2 to differ, got %+v", diffs[2])
+	}
+
+	var out strings.Builder
+	printRecordsDiff(&out, "a.json", "b.json", diffs, false)
+	expected := `[DIFF] turn 2: what is in a.go?
+  assistant response #1 differs:
+    a.json: package main
+    b.json: a Go file declaring package main
+  tokens: a.json 30, b.json 42 (+12)
+3 turn(s) compared, 1 diverged
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
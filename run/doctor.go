@@ -0,0 +1,272 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const doctorHelp = `
+doctor - Check environment and provider connectivity
+
+Usage: kode doctor [OPTIONS]
+
+Options:
+  --model MODEL          check the model is supported and its provider token is set
+  --network PROVIDER     check connectivity to the provider's base url, repeatable
+                         PROVIDER: openai, anthropic, gemini, moonshot, openrouter, all
+  --timeout DURATION     timeout of each network check(default: 5s)
+  -h, --help             show this help message
+
+Examples:
+  kode doctor
+  kode doctor --model claude-sonnet-4 --network anthropic
+  kode doctor --network all
+`
+
+// doctorProviders are the providers checked by doctor, in report order
+var doctorProviders = []providers.Provider{
+	providers.ProviderOpenAI,
+	providers.ProviderAnthropic,
+	providers.ProviderGemini,
+	providers.ProviderMoonshot,
+	providers.ProviderOpenRouter,
+}
+
+// providerDefaultBaseURLs are used by network checks when no base url is configured
+var providerDefaultBaseURLs = map[providers.Provider]string{
+	providers.ProviderOpenAI:     "https://api.openai.com/v1",
+	providers.ProviderAnthropic:  "https://api.anthropic.com",
+	providers.ProviderGemini:     "https://generativelanguage.googleapis.com",
+	providers.ProviderMoonshot:   "https://api.moonshot.cn/v1",
+	providers.ProviderOpenRouter: "https://openrouter.ai/api/v1",
+}
+
+type doctorStatus string
+
+const (
+	doctorStatus_Pass doctorStatus = "PASS"
+	doctorStatus_Warn doctorStatus = "WARN"
+	doctorStatus_Fail doctorStatus = "FAIL"
+)
+
+type doctorCheck struct {
+	Status doctorStatus
+	Name   string
+	Detail string
+	Hint   string
+}
+
+type doctorOptions struct {
+	model          string
+	network        []string
+	timeout        time.Duration
+	defaultBaseURL string
+
+	getenv     func(key string) string
+	httpClient *http.Client
+}
+
+func handleDoctor(args []string, defaultBaseURL string) error {
+	var model string
+	var network []string
+	var timeout time.Duration
+	args, err := flags.String("--model", &model).
+		StringSlice("--network", &network).
+		Duration("--timeout", &timeout).
+		Help("-h,--help", strings.TrimPrefix(doctorHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unrecognized extra: %s", strings.Join(args, ","))
+	}
+
+	checks := runDoctor(doctorOptions{
+		model:          model,
+		network:        network,
+		timeout:        timeout,
+		defaultBaseURL: defaultBaseURL,
+		getenv:         os.Getenv,
+	})
+	failed := printDoctorReport(os.Stdout, checks)
+	if failed > 0 {
+		return fmt.Errorf("doctor found %d problem(s)", failed)
+	}
+	return nil
+}
+
+// printDoctorReport prints checks and returns the number of failed checks
+func printDoctorReport(w io.Writer, checks []doctorCheck) int {
+	var failed int
+	for _, check := range checks {
+		fmt.Fprintf(w, "[%s] %s: %s\n", check.Status, check.Name, check.Detail)
+		if check.Hint != "" && check.Status != doctorStatus_Pass {
+			fmt.Fprintf(w, "       hint: %s\n", check.Hint)
+		}
+		if check.Status == doctorStatus_Fail {
+			failed++
+		}
+	}
+	return failed
+}
+
+func runDoctor(opts doctorOptions) []doctorCheck {
+	getenv := opts.getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+
+	var checks []doctorCheck
+
+	// model
+	var modelProvider providers.Provider
+	if opts.model != "" {
+		model := providers.GetUnderlyingModel(opts.model)
+		provider, err := providers.GetModelProvider(model)
+		if err != nil {
+			checks = append(checks, doctorCheck{
+				Status: doctorStatus_Fail,
+				Name:   "model",
+				Detail: err.Error(),
+				Hint:   "run 'kode chat --model list' to see supported models",
+			})
+		} else {
+			modelProvider = provider
+			checks = append(checks, doctorCheck{
+				Status: doctorStatus_Pass,
+				Name:   "model",
+				Detail: fmt.Sprintf("%s is served by %s", opts.model, provider),
+			})
+		}
+	}
+
+	// tokens
+	var anyToken bool
+	for _, provider := range doctorProviders {
+		tokenEnvKey, _, _ := providerEnvKeys(provider)
+		if getenv(tokenEnvKey) != "" {
+			anyToken = true
+			checks = append(checks, doctorCheck{
+				Status: doctorStatus_Pass,
+				Name:   fmt.Sprintf("%s token", provider),
+				Detail: fmt.Sprintf("%s is set", tokenEnvKey),
+			})
+			continue
+		}
+		status := doctorStatus_Warn
+		if provider == modelProvider {
+			status = doctorStatus_Fail
+		}
+		checks = append(checks, doctorCheck{
+			Status: status,
+			Name:   fmt.Sprintf("%s token", provider),
+			Detail: fmt.Sprintf("%s is not set", tokenEnvKey),
+			Hint:   fmt.Sprintf("export %s=<your key>, or pass --token to kode chat", tokenEnvKey),
+		})
+	}
+	if !anyToken && modelProvider == "" {
+		checks = append(checks, doctorCheck{
+			Status: doctorStatus_Fail,
+			Name:   "tokens",
+			Detail: "no provider token found",
+			Hint:   "set at least one provider API key env, e.g. OPENAI_API_KEY",
+		})
+	}
+
+	// network, opt-in
+	networkProviders, err := parseDoctorNetwork(opts.network)
+	if err != nil {
+		checks = append(checks, doctorCheck{
+			Status: doctorStatus_Fail,
+			Name:   "network",
+			Detail: err.Error(),
+		})
+	}
+	for _, provider := range networkProviders {
+		checks = append(checks, checkProviderNetwork(provider, opts, getenv))
+	}
+	return checks
+}
+
+func parseDoctorNetwork(network []string) ([]providers.Provider, error) {
+	var result []providers.Provider
+	for _, name := range network {
+		if name == "all" {
+			return doctorProviders, nil
+		}
+		provider := providers.Provider(name)
+		if _, _, ok := providerEnvKeys(provider); !ok {
+			return result, fmt.Errorf("unsupported provider: %s", name)
+		}
+		result = append(result, provider)
+	}
+	return result, nil
+}
+
+// resolveDoctorBaseURL mirrors the base url resolution of kode chat
+func resolveDoctorBaseURL(provider providers.Provider, defaultBaseURL string, getenv func(key string) string) string {
+	_, baseUrlEnvKey, _ := providerEnvKeys(provider)
+	if baseURL := getenv(baseUrlEnvKey); baseURL != "" {
+		return baseURL
+	}
+	if baseURL := getenv("KODE_DEFAULT_BASE_URL"); baseURL != "" {
+		return baseURL
+	}
+	if defaultBaseURL != "" {
+		return defaultBaseURL
+	}
+	return providerDefaultBaseURLs[provider]
+}
+
+// checkProviderNetwork sends a HEAD request to the base url, any HTTP response means reachable
+func checkProviderNetwork(provider providers.Provider, opts doctorOptions, getenv func(key string) string) doctorCheck {
+	name := fmt.Sprintf("%s network", provider)
+	_, baseUrlEnvKey, _ := providerEnvKeys(provider)
+	baseURL := resolveDoctorBaseURL(provider, opts.defaultBaseURL, getenv)
+
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	client := opts.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, baseURL, nil)
+	if err != nil {
+		return doctorCheck{
+			Status: doctorStatus_Fail,
+			Name:   name,
+			Detail: fmt.Sprintf("invalid base url %s: %v", baseURL, err),
+			Hint:   fmt.Sprintf("check %s or --base-url", baseUrlEnvKey),
+		}
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return doctorCheck{
+			Status: doctorStatus_Fail,
+			Name:   name,
+			Detail: fmt.Sprintf("%s unreachable: %v", baseURL, err),
+			Hint:   fmt.Sprintf("check your network, proxy, or %s", baseUrlEnvKey),
+		}
+	}
+	resp.Body.Close()
+	return doctorCheck{
+		Status: doctorStatus_Pass,
+		Name:   name,
+		Detail: fmt.Sprintf("%s reachable, status %d in %v", baseURL, resp.StatusCode, time.Since(start).Round(time.Millisecond)),
+	}
+}
@@ -0,0 +1,116 @@
+package run
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func findCheck(checks []doctorCheck, name string) *doctorCheck {
+	for i := range checks {
+		if checks[i].Name == name {
+			return &checks[i]
+		}
+	}
+	return nil
+}
+
+func TestDoctorTokens(t *testing.T) {
+	env := map[string]string{"ANTHROPIC_API_KEY": "x"}
+	checks := runDoctor(doctorOptions{
+		getenv: func(key string) string { return env[key] },
+	})
+	if c := findCheck(checks, "anthropic token"); c == nil || c.Status != doctorStatus_Pass {
+		t.Errorf("expected anthropic token to pass, got %+v", c)
+	}
+	if c := findCheck(checks, "openai token"); c == nil || c.Status != doctorStatus_Warn {
+		t.Errorf("expected openai token to warn, got %+v", c)
+	}
+	if c := findCheck(checks, "tokens"); c != nil {
+		t.Errorf("expected no missing-tokens failure, got %+v", c)
+	}
+	// network checks are opt-in
+	for _, c := range checks {
+		if strings.HasSuffix(c.Name, "network") {
+			t.Errorf("unexpected network check without --network: %+v", c)
+		}
+	}
+}
+
+func TestDoctorNoTokens(t *testing.T) {
+	checks := runDoctor(doctorOptions{
+		getenv: func(key string) string { return "" },
+	})
+	if c := findCheck(checks, "tokens"); c == nil || c.Status != doctorStatus_Fail {
+		t.Errorf("expected missing tokens failure, got %+v", c)
+	}
+}
+
+func TestDoctorModel(t *testing.T) {
+	env := map[string]string{"OPENAI_API_KEY": "x"}
+	getenv := func(key string) string { return env[key] }
+
+	checks := runDoctor(doctorOptions{model: "claude-sonnet-4", getenv: getenv})
+	if c := findCheck(checks, "model"); c == nil || c.Status != doctorStatus_Pass {
+		t.Errorf("expected model to pass, got %+v", c)
+	}
+	if c := findCheck(checks, "anthropic token"); c == nil || c.Status != doctorStatus_Fail {
+		t.Errorf("expected token of the model's provider to fail, got %+v", c)
+	}
+
+	checks = runDoctor(doctorOptions{model: "no-such-model", getenv: getenv})
+	if c := findCheck(checks, "model"); c == nil || c.Status != doctorStatus_Fail {
+		t.Errorf("expected unknown model to fail, got %+v", c)
+	}
+}
+
+func TestDoctorNetwork(t *testing.T) {
+	var method string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	env := map[string]string{
+		"OPENAI_API_KEY":     "x",
+		"OPENAI_BASE_URL":    server.URL,
+		"ANTHROPIC_BASE_URL": closedURL,
+	}
+	checks := runDoctor(doctorOptions{
+		network: []string{"openai", "anthropic"},
+		getenv:  func(key string) string { return env[key] },
+	})
+	if c := findCheck(checks, "openai network"); c == nil || c.Status != doctorStatus_Pass {
+		t.Errorf("expected openai network to pass, got %+v", c)
+	}
+	if method != http.MethodHead {
+		t.Errorf("expected HEAD request, got %s", method)
+	}
+	if c := findCheck(checks, "anthropic network"); c == nil || c.Status != doctorStatus_Fail {
+		t.Errorf("expected anthropic network to fail, got %+v", c)
+	}
+
+	var buf bytes.Buffer
+	failed := printDoctorReport(&buf, checks)
+	if failed != 1 {
+		t.Errorf("expected 1 failure, got %d:\n%s", failed, buf.String())
+	}
+	if !strings.Contains(buf.String(), "hint: check your network, proxy, or ANTHROPIC_BASE_URL") {
+		t.Errorf("expected remediation hint, got:\n%s", buf.String())
+	}
+
+	checks = runDoctor(doctorOptions{
+		network: []string{"nowhere"},
+		getenv:  func(key string) string { return env[key] },
+	})
+	if c := findCheck(checks, "network"); c == nil || c.Status != doctorStatus_Fail {
+		t.Errorf("expected unsupported provider to fail, got %+v", c)
+	}
+}
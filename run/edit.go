@@ -0,0 +1,55 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/internal/ioread"
+)
+
+// defaultEditor is used when neither $VISUAL nor $EDITOR is set
+const defaultEditor = "vi"
+
+// editContent opens initial in $VISUAL or $EDITOR, which may have args like
+// 'code --wait', and returns the saved content. An empty save aborts with an error
+func editContent(what string, initial string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = defaultEditor
+	}
+	editorArgs := strings.Fields(editor)
+
+	file, err := os.CreateTemp("", "kode-"+what+"-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(initial)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command(editorArgs[0], append(editorArgs[1:], file.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("edit %s with %s: %w", what, editor, err)
+	}
+	content, err := ioread.ReadOrContent(file.Name())
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(content) == "" {
+		return "", fmt.Errorf("empty %s saved, aborted", what)
+	}
+	return content, nil
+}
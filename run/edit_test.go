@@ -0,0 +1,46 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// stubEditor sets $EDITOR to a script running body with the file to edit as $1
+func stubEditor(t *testing.T, body string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor stub is a shell script")
+	}
+	script := filepath.Join(t.TempDir(), "editor.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", script)
+}
+
+func TestEditContent(t *testing.T) {
+	stubEditor(t, `echo "$(cat "$1") and the tests" > "$1"`)
+	msg, err := editContent("msg", "fix the bug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "fix the bug and the tests\n" {
+		t.Errorf("expected edited msg, got %q", msg)
+	}
+}
+
+func TestEditContentEmpty(t *testing.T) {
+	stubEditor(t, `: > "$1"`)
+	if _, err := editContent("msg", "fix the bug"); err == nil {
+		t.Errorf("expected error of empty save")
+	}
+}
+
+func TestEditContentEditorFailed(t *testing.T) {
+	stubEditor(t, `exit 1`)
+	if _, err := editContent("msg", "fix the bug"); err == nil {
+		t.Errorf("expected error of failed editor")
+	}
+}
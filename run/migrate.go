@@ -0,0 +1,71 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const migrateHelp = `
+migrate - Upgrade a record file to the current record version
+
+Usage: kode migrate <record> [OPTIONS]
+
+Records are upgraded when loaded, migrate writes the upgraded messages
+back so the record is read as is, and by tools not aware of older versions.
+A record already at the current version is left unchanged.
+
+Options:
+  -o, --output FILE          write the upgraded record to FILE instead of rewriting the record
+  -h, --help                 show this help message
+
+Examples:
+  kode migrate record.json
+`
+
+func handleMigrate(args []string) error {
+	var output string
+	args, err := flags.String("-o,--output", &output).
+		Help("-h,--help", strings.TrimPrefix(migrateHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("requires record file, try `kode migrate --help`")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("unrecognized extra: %s", strings.Join(args[1:], ","))
+	}
+	return migrateRecord(os.Stdout, args[0], output)
+}
+
+// migrateRecord upgrades recordFile to chat.RecordVersion, writing it to output
+// if given, otherwise rewriting recordFile if not at the version already
+func migrateRecord(w io.Writer, recordFile string, output string) error {
+	file, err := os.Open(recordFile)
+	if err != nil {
+		return err
+	}
+	messages, version, err := chat.ReadRecord(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", recordFile, err)
+	}
+	if version == chat.RecordVersion && output == "" {
+		fmt.Fprintf(w, "%s is already at version %d\n", recordFile, version)
+		return nil
+	}
+	if output == "" {
+		output = recordFile
+	}
+	if err := chat.SaveHistory(output, messages); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "migrated %d message(s) of %s from version %d to %d\n", len(messages), recordFile, version, chat.RecordVersion)
+	return nil
+}
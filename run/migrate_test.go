@@ -0,0 +1,41 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateRecord(t *testing.T) {
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record.json")
+	old := `{"type":"msg","time":"2025-06-01T10:00:00Z","role":"user","content":"list files"}` + "\n"
+	if err := os.WriteFile(record, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := migrateRecord(&out, record, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "from version 1 to 2") {
+		t.Errorf("expected migrated report, got %q", out.String())
+	}
+	data, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != `{"record_version":2}` || !strings.Contains(lines[1], `"timestamp":1748772000`) {
+		t.Errorf("expected upgraded record, got %s", data)
+	}
+
+	out.Reset()
+	if err := migrateRecord(&out, record, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "already at version 2") {
+		t.Errorf("expected already migrated, got %q", out.String())
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
@@ -21,8 +23,9 @@ import (
 // GeminiAPIRequest represents the minimal Gemini API request structure for parsing
 // (SDK doesn't provide request parsing types, only response types)
 type GeminiAPIRequest struct {
-	Contents []*genai.Content `json:"contents"`
-	Tools    []*genai.Tool    `json:"tools,omitempty"`
+	Contents   []*genai.Content  `json:"contents"`
+	Tools      []*genai.Tool     `json:"tools,omitempty"`
+	ToolConfig *genai.ToolConfig `json:"toolConfig,omitempty"`
 }
 
 // Config holds the configuration for the mock server
@@ -30,21 +33,109 @@ type Config struct {
 	Port             int
 	Provider         string // "openai", "anthropic", "gemini", "all"
 	FirstMsgToolCall bool   // if true, always respond with tool call instead of random
+
+	Latency     time.Duration // artificial delay before each response
+	FailureRate float64       // probability in [0,1] of responding with 429, 500 or 529
+	Seed        int64         // seed of the random generator, 0 means time based
+
+	Stateful bool // remember user turns per conversation and reference them in text responses
 }
 
+// ConversationIDHeader identifies the conversation in stateful mode
+const ConversationIDHeader = "X-Conversation-Id"
+
+type conversationIDKey struct{}
+
+// injected failure status codes, 529 is Anthropic's overloaded status
+var failureStatusCodes = []int{http.StatusTooManyRequests, http.StatusInternalServerError, 529}
+
 type MockServer struct {
+	// generates responses, safe for concurrent handlers
 	rand   *rand.Rand
 	config Config
+
+	// fault injection has its own source, so the failures of a seed
+	// do not depend on the responses generated in between
+	faultMutex sync.Mutex
+	faultRand  *rand.Rand
+
+	stateMutex    sync.Mutex
+	conversations map[string][]string // conversation ID -> user turns
 }
 
 func NewMockServer(config Config) *MockServer {
-	rd := rand.New(rand.NewSource(time.Now().UnixMicro()))
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixMicro()
+	}
 	return &MockServer{
-		rand:   rd,
-		config: config,
+		rand:          rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)}),
+		faultRand:     rand.New(rand.NewSource(seed)),
+		config:        config,
+		conversations: make(map[string][]string),
 	}
 }
 
+// lockedSource guards a rand.Source shared by concurrent handlers
+type lockedSource struct {
+	mutex sync.Mutex
+	src   rand.Source64
+}
+
+func (c *lockedSource) Int63() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.src.Int63()
+}
+
+func (c *lockedSource) Uint64() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.src.Uint64()
+}
+
+func (c *lockedSource) Seed(seed int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.src.Seed(seed)
+}
+
+// injectFault applies the configured latency and failure rate,
+// returns true if an error response has been written
+func (m *MockServer) injectFault(w http.ResponseWriter, r *http.Request) bool {
+	if m.config.Latency > 0 {
+		select {
+		case <-time.After(m.config.Latency):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	if m.config.FailureRate <= 0 {
+		return false
+	}
+	m.faultMutex.Lock()
+	fail := m.faultRand.Float64() < m.config.FailureRate
+	var status int
+	if fail {
+		status = failureStatusCodes[m.faultRand.Intn(len(failureStatusCodes))]
+	}
+	m.faultMutex.Unlock()
+	if !fail {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"type":    "mock_injected_failure",
+			"message": fmt.Sprintf("mock injected failure: %d", status),
+		},
+	})
+	return true
+}
+
 // Start starts the mock HTTP server
 func Start(config Config) error {
 	m := NewMockServer(config)
@@ -77,11 +168,112 @@ func Start(config Config) error {
 	} else {
 		fmt.Printf("Provider: all (OpenAI, Anthropic, Gemini)\n")
 	}
+	if config.Latency > 0 {
+		fmt.Printf("Latency: %v\n", config.Latency)
+	}
+	if config.FailureRate > 0 {
+		fmt.Printf("Failure rate: %v\n", config.FailureRate)
+	}
+	if config.Stateful {
+		fmt.Printf("Stateful: conversations keyed by %s header\n", ConversationIDHeader)
+	}
 	fmt.Printf("Test with: kode chat --base-url http://localhost%s \"Hello world\"\n", addr)
 
 	return http.ListenAndServe(addr, mux)
 }
 
+var namePattern = regexp.MustCompile(`(?i)my name is (\w+)`)
+
+// responseText returns a random response, or in stateful mode a
+// deterministic one referencing the earlier turns of the conversation
+func (m *MockServer) responseText(ctx context.Context, userText string) string {
+	if !m.config.Stateful {
+		return GetRandomResponse()
+	}
+	conversationID, _ := ctx.Value(conversationIDKey{}).(string)
+
+	m.stateMutex.Lock()
+	previous := append([]string(nil), m.conversations[conversationID]...)
+	if userText != "" {
+		m.conversations[conversationID] = append(m.conversations[conversationID], userText)
+	}
+	m.stateMutex.Unlock()
+
+	var b strings.Builder
+	for i := len(previous) - 1; i >= 0; i-- {
+		if match := namePattern.FindStringSubmatch(previous[i]); match != nil {
+			fmt.Fprintf(&b, "Your name is %s. ", match[1])
+			break
+		}
+	}
+	fmt.Fprintf(&b, "Turn %d. You said: %q.", len(previous)+1, userText)
+	if len(previous) > 0 {
+		quoted := make([]string, 0, len(previous))
+		for _, p := range previous {
+			quoted = append(quoted, strconv.Quote(p))
+		}
+		fmt.Fprintf(&b, " Earlier you said: %s.", strings.Join(quoted, ", "))
+	}
+	return b.String()
+}
+
+// lastUserTextOpenAI returns the text of the last message if it is a user message
+func lastUserTextOpenAI(messages []openai.ChatCompletionMessageParamUnion) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	user := messages[len(messages)-1].OfUser
+	if user == nil {
+		return ""
+	}
+	if user.Content.OfString.Valid() {
+		return user.Content.OfString.Value
+	}
+	var texts []string
+	for _, part := range user.Content.OfArrayOfContentParts {
+		if part.OfText != nil {
+			texts = append(texts, part.OfText.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// lastUserTextAnthropic returns the text of the last message if it is a user message
+func lastUserTextAnthropic(messages []anthropic.MessageParam) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	msg := messages[len(messages)-1]
+	if msg.Role != anthropic.MessageParamRoleUser {
+		return ""
+	}
+	var texts []string
+	for _, block := range msg.Content {
+		if block.OfText != nil {
+			texts = append(texts, block.OfText.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// lastUserTextGemini returns the text of the last content if it is from the user
+func lastUserTextGemini(contents []*genai.Content) string {
+	if len(contents) == 0 {
+		return ""
+	}
+	content := contents[len(contents)-1]
+	if content == nil || content.Role != genai.RoleUser {
+		return ""
+	}
+	var texts []string
+	for _, part := range content.Parts {
+		if part != nil && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
 // handleOpenAIMockTyped handles OpenAI API mock responses with typed request and response
 func (m *MockServer) handleOpenAIMockTyped(ctx context.Context, request openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
 	rd := m.rand
@@ -142,6 +334,7 @@ func (m *MockServer) handleOpenAIMockTyped(ctx context.Context, request openai.C
 	if (len(request.Messages)+1)%6 == 0 {
 		callTool = false
 	}
+	callTool, availableTools = applyToolChoice(toolChoiceOpenAI(request.ToolChoice), callTool, availableTools)
 	fmt.Fprintf(os.Stderr, "DEBUG shouldCallTool: %d, %v\n", len(request.Messages), callTool)
 
 	// Generate response using OpenAI SDK types
@@ -183,6 +376,11 @@ func (m *MockServer) handleOpenAIMockTyped(ctx context.Context, request openai.C
 		return response, nil
 	} else {
 		// Regular text response
+		text := m.responseText(ctx, lastUserTextOpenAI(request.Messages))
+		var logprobs openai.ChatCompletionChoiceLogprobs
+		if request.Logprobs.Value {
+			logprobs = mockLogprobs(text, request.TopLogprobs.Value)
+		}
 		response := &openai.ChatCompletion{
 			ID:      fmt.Sprintf("chatcmpl-mock-%d", rd.Int31()),
 			Object:  "chat.completion",
@@ -193,8 +391,9 @@ func (m *MockServer) handleOpenAIMockTyped(ctx context.Context, request openai.C
 					Index: 0,
 					Message: openai.ChatCompletionMessage{
 						Role:    "assistant",
-						Content: GetRandomResponse(),
+						Content: text,
 					},
+					Logprobs:     logprobs,
 					FinishReason: "stop",
 				},
 			},
@@ -208,6 +407,26 @@ func (m *MockServer) handleOpenAIMockTyped(ctx context.Context, request openai.C
 	}
 }
 
+// mockLogprobs splits text into whitespace separated tokens with
+// decreasing log probabilities, each having topLogprobs alternatives
+func mockLogprobs(text string, topLogprobs int64) openai.ChatCompletionChoiceLogprobs {
+	var logprobs openai.ChatCompletionChoiceLogprobs
+	for i, token := range strings.Fields(text) {
+		logprob := openai.ChatCompletionTokenLogprob{
+			Token:   token,
+			Logprob: -0.1 * float64(i+1),
+		}
+		for j := int64(0); j < topLogprobs; j++ {
+			logprob.TopLogprobs = append(logprob.TopLogprobs, openai.ChatCompletionTokenLogprobTopLogprob{
+				Token:   fmt.Sprintf("%s_%d", token, j),
+				Logprob: logprob.Logprob - float64(j+1),
+			})
+		}
+		logprobs.Content = append(logprobs.Content, logprob)
+	}
+	return logprobs
+}
+
 // handleAnthropicMockTyped handles Anthropic API mock responses with typed request and response
 func (m *MockServer) handleAnthropicMockTyped(ctx context.Context, request anthropic.MessageNewParams) (*anthropic.Message, error) {
 	rd := m.rand
@@ -235,7 +454,8 @@ func (m *MockServer) handleAnthropicMockTyped(ctx context.Context, request anthr
 
 	// For mock purposes, use map format since SDK types are complex for response construction
 	// This follows the same pattern as the original handleAnthropicMock
-	if randomCallTool(m, availableTools) {
+	callTool, availableTools := applyToolChoice(toolChoiceAnthropic(request.ToolChoice), randomCallTool(m, availableTools), availableTools)
+	if callTool {
 		toolName := GetRandomToolFromUserTools(availableTools)
 		toolArgs := GetRandomToolArgsFromUserTools(availableTools)
 
@@ -284,7 +504,7 @@ func (m *MockServer) handleAnthropicMockTyped(ctx context.Context, request anthr
 			"content": []map[string]interface{}{
 				{
 					"type": "text",
-					"text": GetRandomResponse(),
+					"text": m.responseText(ctx, lastUserTextAnthropic(request.Messages)),
 				},
 			},
 			"stop_reason": "end_turn",
@@ -304,6 +524,64 @@ func (m *MockServer) handleAnthropicMockTyped(ctx context.Context, request anthr
 	}
 }
 
+// applyToolChoice overrides the random decision with the requested tool choice:
+// "" or auto keeps it, none never calls, required always calls, a tool name always calls that tool
+func applyToolChoice(choice string, callTool bool, availableTools []*tools.UnifiedTool) (bool, []*tools.UnifiedTool) {
+	if len(availableTools) == 0 {
+		return false, availableTools
+	}
+	switch choice {
+	case "", "auto":
+		return callTool, availableTools
+	case "none":
+		return false, availableTools
+	case "required":
+		return true, availableTools
+	}
+	for _, tool := range availableTools {
+		if tool.Name == choice {
+			return true, []*tools.UnifiedTool{tool}
+		}
+	}
+	return callTool, availableTools
+}
+
+func toolChoiceOpenAI(choice openai.ChatCompletionToolChoiceOptionUnionParam) string {
+	if choice.OfChatCompletionNamedToolChoice != nil {
+		return choice.OfChatCompletionNamedToolChoice.Function.Name
+	}
+	return choice.OfAuto.Value
+}
+
+func toolChoiceAnthropic(choice anthropic.ToolChoiceUnionParam) string {
+	switch {
+	case choice.OfNone != nil:
+		return "none"
+	case choice.OfAny != nil:
+		return "required"
+	case choice.OfTool != nil:
+		return choice.OfTool.Name
+	}
+	return ""
+}
+
+func toolChoiceGemini(toolConfig *genai.ToolConfig) string {
+	if toolConfig == nil || toolConfig.FunctionCallingConfig == nil {
+		return ""
+	}
+	config := toolConfig.FunctionCallingConfig
+	switch config.Mode {
+	case genai.FunctionCallingConfigModeNone:
+		return "none"
+	case genai.FunctionCallingConfigModeAny:
+		if len(config.AllowedFunctionNames) == 1 {
+			return config.AllowedFunctionNames[0]
+		}
+		return "required"
+	}
+	return ""
+}
+
 func randomCallTool(m *MockServer, availableTools []*tools.UnifiedTool) bool {
 	if len(availableTools) == 0 {
 		return false
@@ -340,8 +618,14 @@ func (m *MockServer) handleGeminiMockTyped(ctx context.Context, model string, co
 		}
 	}
 
+	var toolConfig *genai.ToolConfig
+	if config != nil {
+		toolConfig = config.ToolConfig
+	}
+	callTool, availableTools := applyToolChoice(toolChoiceGemini(toolConfig), randomCallTool(m, availableTools), availableTools)
+
 	// Generate response using Gemini SDK types
-	if randomCallTool(m, availableTools) {
+	if callTool {
 		toolName := GetRandomToolFromUserTools(availableTools)
 		toolArgs := GetRandomToolArgsFromUserTools(availableTools)
 
@@ -383,7 +667,7 @@ func (m *MockServer) handleGeminiMockTyped(ctx context.Context, model string, co
 					Content: &genai.Content{
 						Parts: []*genai.Part{
 							{
-								Text: GetRandomResponse(),
+								Text: m.responseText(ctx, lastUserTextGemini(contents)),
 							},
 						},
 						Role: "model",
@@ -401,12 +685,19 @@ func (m *MockServer) handleGeminiMockTyped(ctx context.Context, model string, co
 	}
 }
 
+func withConversationID(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), conversationIDKey{}, r.Header.Get(ConversationIDHeader))
+}
+
 // HandleOpenAIMock handles OpenAI API mock responses
 func (m *MockServer) HandleOpenAIMock(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if m.injectFault(w, r) {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
@@ -418,7 +709,7 @@ func (m *MockServer) HandleOpenAIMock(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Use the typed handler
-	response, err := m.handleOpenAIMockTyped(r.Context(), request)
+	response, err := m.handleOpenAIMockTyped(withConversationID(r), request)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Internal server error: %v", err), http.StatusInternalServerError)
 		return
@@ -437,6 +728,9 @@ func (m *MockServer) HandleAnthropicMock(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if m.injectFault(w, r) {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
@@ -448,7 +742,7 @@ func (m *MockServer) HandleAnthropicMock(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Use the typed handler
-	response, err := m.handleAnthropicMockTyped(r.Context(), request)
+	response, err := m.handleAnthropicMockTyped(withConversationID(r), request)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Internal server error: %v", err), http.StatusInternalServerError)
 		return
@@ -467,6 +761,9 @@ func (m *MockServer) HandleGeminiMock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if m.injectFault(w, r) {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
@@ -480,11 +777,12 @@ func (m *MockServer) HandleGeminiMock(w http.ResponseWriter, r *http.Request) {
 	// Extract contents and tools directly (already in SDK format)
 	contents := request.Contents
 	config := &genai.GenerateContentConfig{
-		Tools: request.Tools,
+		Tools:      request.Tools,
+		ToolConfig: request.ToolConfig,
 	}
 
 	// Use the typed handler
-	response, err := m.handleGeminiMockTyped(r.Context(), "gemini-pro", contents, config)
+	response, err := m.handleGeminiMockTyped(withConversationID(r), "gemini-pro", contents, config)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Internal server error: %v", err), http.StatusInternalServerError)
 		return
@@ -1,9 +1,16 @@
 package mock_server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
 )
 
 func TestMockServerBasicFunctionality(t *testing.T) {
@@ -115,6 +122,117 @@ func TestAlwaysCallToolFlag(t *testing.T) {
 	})
 }
 
+func TestMockServerFaultInjection(t *testing.T) {
+	t.Run("AlwaysFail", func(t *testing.T) {
+		m := NewMockServer(Config{FailureRate: 1, Seed: 1})
+		for i := 0; i < 10; i++ {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/chat/completions", strings.NewReader("{}"))
+			m.HandleOpenAIMock(rec, req)
+			switch rec.Code {
+			case http.StatusTooManyRequests, http.StatusInternalServerError, 529:
+			default:
+				t.Fatalf("expected injected failure status, got %d", rec.Code)
+			}
+		}
+	})
+
+	t.Run("SameSeedSameFailures", func(t *testing.T) {
+		// responseDraws simulates responses drawing more random numbers
+		statuses := func(responseDraws int) []int {
+			m := NewMockServer(Config{FailureRate: 0.5, Seed: 42})
+			var codes []int
+			for i := 0; i < 10; i++ {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader("{}"))
+				m.HandleAnthropicMock(rec, req)
+				codes = append(codes, rec.Code)
+				for j := 0; j < responseDraws; j++ {
+					m.rand.Int63()
+				}
+			}
+			return codes
+		}
+		a := fmt.Sprint(statuses(0))
+		b := fmt.Sprint(statuses(0))
+		if a != b {
+			t.Errorf("expected same statuses with same seed, got %s and %s", a, b)
+		}
+		if c := fmt.Sprint(statuses(3)); a != c {
+			t.Errorf("expected the statuses independent of responses, got %s and %s", a, c)
+		}
+	})
+
+	t.Run("ConcurrentHandlers", func(t *testing.T) {
+		m := NewMockServer(Config{FailureRate: 0.5, Seed: 1})
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					rec := httptest.NewRecorder()
+					req := httptest.NewRequest("POST", "/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+					m.HandleOpenAIMock(rec, req)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		m := NewMockServer(Config{Latency: 50 * time.Millisecond})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/models/gemini-pro:generateContent", strings.NewReader("{}"))
+		start := time.Now()
+		m.HandleGeminiMock(rec, req)
+		if cost := time.Since(start); cost < 50*time.Millisecond {
+			t.Errorf("expected latency at least 50ms, got %v", cost)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", rec.Code)
+		}
+	})
+}
+
+func TestMockServerStatefulRecall(t *testing.T) {
+	m := NewMockServer(Config{Stateful: true})
+
+	chat := func(conversationID string, msg string) string {
+		body := fmt.Sprintf(`{"model":"gpt-4o","messages":[{"role":"user","content":%q}]}`, msg)
+		req := httptest.NewRequest("POST", "/chat/completions", strings.NewReader(body))
+		req.Header.Set(ConversationIDHeader, conversationID)
+		rec := httptest.NewRecorder()
+		m.HandleOpenAIMock(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp openai.ChatCompletion
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp.Choices[0].Message.Content
+	}
+
+	first := chat("conv-1", "Hi, my name is Alice")
+	if strings.Contains(first, "Your name is") {
+		t.Errorf("expected no recall on first turn, got: %s", first)
+	}
+
+	second := chat("conv-1", "What is my name?")
+	if !strings.Contains(second, "Your name is Alice") {
+		t.Errorf("expected recall of name, got: %s", second)
+	}
+	if !strings.Contains(second, "Hi, my name is Alice") {
+		t.Errorf("expected earlier turn referenced, got: %s", second)
+	}
+
+	other := chat("conv-2", "What is my name?")
+	if strings.Contains(other, "Alice") {
+		t.Errorf("expected conversations isolated, got: %s", other)
+	}
+}
+
 // Example test showing how to use the mock server for integration testing
 func Example_mockServerUsage() {
 	// This example shows how you would use the mock server for testing
@@ -0,0 +1,257 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/internal/ioread"
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const pipelineHelp = `
+pipeline - Run agents in order, passing the output of each as the input of the next
+
+Usage: kode pipeline <config.json> [MSG] [OPTIONS]
+
+The config is:
+  {
+    "model": "gpt-4.1",
+    "token_budget": 100000,
+    "stages": [
+      {"name": "planner", "system": "make a plan", "message": "plan for: ${input}"},
+      {"name": "coder", "model": "claude-sonnet-4", "system": "CODER.md", "tools": ["read_file"], "max_round": 10}
+    ]
+  }
+
+Each stage is its own agent with its own model(default: the top level model),
+system prompt(can also be a file), tools and rounds. The input of the first stage
+is MSG, or the top level "input" of the config. ${input} in the message of a
+stage is replaced with its input, the message defaults to the input itself.
+token_budget is shared by all stages, no stage starts once it is spent.
+
+Options:
+  --token TOKEN              the token of all stages
+  --base-url BASE_URL        the base url of all stages
+  --json                     print the output and token usage of every stage as JSON
+  -h, --help                 show this help message
+
+Examples:
+  kode pipeline team.json "add a --dry-run flag"
+`
+
+// pipelineInputPlaceholder is replaced with the input of a stage in its message
+const pipelineInputPlaceholder = "${input}"
+
+// pipelineConfig is the config of kode pipeline
+type pipelineConfig struct {
+	Model string `json:"model,omitempty"`
+	Input string `json:"input,omitempty"`
+	// TokenBudget is the total tokens all stages may use, 0 means no limit
+	TokenBudget int64           `json:"token_budget,omitempty"`
+	Stages      []pipelineStage `json:"stages"`
+}
+
+type pipelineStage struct {
+	Name     string   `json:"name,omitempty"`
+	Model    string   `json:"model,omitempty"`
+	System   string   `json:"system,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Tools    []string `json:"tools,omitempty"`
+	MaxRound int      `json:"max_round,omitempty"`
+}
+
+// pipelineStageResult is the outcome of a stage
+type pipelineStageResult struct {
+	Name       string           `json:"name"`
+	Model      string           `json:"model"`
+	Input      string           `json:"input"`
+	Output     string           `json:"output"`
+	TokenUsage types.TokenUsage `json:"token_usage"`
+}
+
+// pipelineResult is the outcome of a pipeline, Output is the output of the last stage
+type pipelineResult struct {
+	Output     string                `json:"output"`
+	Stages     []pipelineStageResult `json:"stages"`
+	TokenUsage types.TokenUsage      `json:"token_usage"`
+}
+
+func handlePipeline(args []string, defaultBaseURL string) error {
+	var token string
+	var baseUrl string
+	var jsonOutput bool
+	args, err := flags.String("--token", &token).
+		String("--base-url", &baseUrl).
+		Bool("--json", &jsonOutput).
+		Help("-h,--help", strings.TrimPrefix(pipelineHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("requires config file, try `kode pipeline --help`")
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("unrecognized extra: %s", strings.Join(args[2:], ","))
+	}
+
+	config, err := loadPipelineConfig(args[0])
+	if err != nil {
+		return err
+	}
+	input := config.Input
+	if len(args) > 1 {
+		input, err = ioread.ReadOrContent(args[1])
+		if err != nil {
+			return err
+		}
+	}
+	if input == "" {
+		return fmt.Errorf("requires MSG or input in %s", args[0])
+	}
+
+	clientConfigs := make([]chat.Config, len(config.Stages))
+	for i, stage := range config.Stages {
+		model := stage.Model
+		if model == "" {
+			model = config.Model
+		}
+		if model == "" {
+			model = ResolveDefaultModel("", os.Getenv)
+		}
+		model = providers.GetUnderlyingModel(model)
+		apiShape, err := providers.GetModelAPIShape(model)
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		provider, err := providers.GetModelProvider(model)
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		resolvedOpts, err := ResolveProviderDefaultEnvOptions(apiShape, provider, "", token, baseUrl, defaultBaseURL)
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		clientConfigs[i] = chat.Config{
+			Model:   model,
+			Token:   resolvedOpts.Token,
+			BaseURL: resolvedOpts.BaseUrl,
+		}
+	}
+
+	result, err := runPipeline(context.Background(), config, clientConfigs, input)
+	if result != nil && jsonOutput {
+		data, jsonErr := json.MarshalIndent(result, "", "  ")
+		if jsonErr != nil {
+			return jsonErr
+		}
+		fmt.Println(string(data))
+	} else if err == nil {
+		fmt.Println(result.Output)
+	}
+	return err
+}
+
+func loadPipelineConfig(file string) (*pipelineConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var config pipelineConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	if len(config.Stages) == 0 {
+		return nil, fmt.Errorf("%s: requires stages", file)
+	}
+	if config.TokenBudget < 0 {
+		return nil, fmt.Errorf("%s: invalid token_budget: %d", file, config.TokenBudget)
+	}
+	for i := range config.Stages {
+		stage := &config.Stages[i]
+		if stage.Name == "" {
+			stage.Name = fmt.Sprintf("stage-%d", i+1)
+		}
+		if stage.MaxRound < 0 {
+			return nil, fmt.Errorf("%s: stage %s: invalid max_round: %d", file, stage.Name, stage.MaxRound)
+		}
+		if stage.System != "" {
+			stage.System, err = ioread.ReadOrContent(stage.System)
+			if err != nil {
+				return nil, fmt.Errorf("%s: stage %s: %w", file, stage.Name, err)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// runPipeline runs the stages in order, each with a client of clientConfigs at the
+// same index. It returns the result of the stages run so far along with any error
+func runPipeline(ctx context.Context, config *pipelineConfig, clientConfigs []chat.Config, input string) (*pipelineResult, error) {
+	if len(clientConfigs) != len(config.Stages) {
+		return nil, fmt.Errorf("expect %d client configs, got %d", len(config.Stages), len(clientConfigs))
+	}
+	result := &pipelineResult{}
+	for i, stage := range config.Stages {
+		if config.TokenBudget > 0 && result.TokenUsage.Total >= config.TokenBudget {
+			return result, fmt.Errorf("token budget %d spent before stage %s, used %d", config.TokenBudget, stage.Name, result.TokenUsage.Total)
+		}
+		stageResult, err := runPipelineStage(ctx, clientConfigs[i], stage, input)
+		if err != nil {
+			return result, fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		result.Stages = append(result.Stages, *stageResult)
+		result.TokenUsage = result.TokenUsage.Add(stageResult.TokenUsage)
+		result.Output = stageResult.Output
+		input = stageResult.Output
+	}
+	return result, nil
+}
+
+func runPipelineStage(ctx context.Context, config chat.Config, stage pipelineStage, input string) (*pipelineStageResult, error) {
+	client, err := chat.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	defer client.Close()
+
+	msg := input
+	if stage.Message != "" {
+		msg = strings.ReplaceAll(stage.Message, pipelineInputPlaceholder, input)
+	}
+	// the last assistant msg is the output
+	var output string
+	chatOpts := []types.ChatOption{
+		chat.WithEventCallback(func(event types.Message) {
+			if event.Type == types.MsgType_Msg && event.Role == types.Role_Assistant {
+				output = event.Content
+			}
+		}),
+	}
+	if stage.System != "" {
+		chatOpts = append(chatOpts, chat.WithSystemPrompt(stage.System))
+	}
+	if len(stage.Tools) > 0 {
+		chatOpts = append(chatOpts, chat.WithTools(stage.Tools...))
+	}
+	if stage.MaxRound > 0 {
+		chatOpts = append(chatOpts, chat.WithMaxRounds(stage.MaxRound))
+	}
+	resp, err := client.Chat(ctx, msg, chatOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &pipelineStageResult{
+		Name:       stage.Name,
+		Model:      config.Model,
+		Input:      msg,
+		Output:     output,
+		TokenUsage: resp.TokenUsage,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/run/mock_server"
+)
+
+func TestRunPipelinePassesOutput(t *testing.T) {
+	mockServer := mock_server.NewMockServer(mock_server.Config{Provider: "openai", Seed: 1})
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		mockServer.HandleOpenAIMock(w, r)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "pipeline.json")
+	content := `{"stages":[{"name":"planner","system":"make a plan"},{"name":"coder","message":"implement: ${input}"}]}`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadPipelineConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig := chat.Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	}
+	result, err := runPipeline(context.Background(), config, []chat.Config{clientConfig, clientConfig}, "add a flag")
+	if err != nil {
+		t.Fatalf("pipeline: %v", err)
+	}
+	if len(result.Stages) != 2 || len(bodies) != 2 {
+		t.Fatalf("expected 2 stages and 2 requests, got %d and %d", len(result.Stages), len(bodies))
+	}
+	planned := result.Stages[0].Output
+	if planned == "" {
+		t.Fatalf("expected output of the first stage")
+	}
+	if want := "implement: " + planned; result.Stages[1].Input != want {
+		t.Errorf("expected second stage input %q, got %q", want, result.Stages[1].Input)
+	}
+	// the second request carries the first output, not the first system prompt
+	var request struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(bodies[1]), &request); err != nil {
+		t.Fatal(err)
+	}
+	last := request.Messages[len(request.Messages)-1]
+	if last.Role != "user" || last.Content != "implement: "+planned {
+		t.Errorf("expected second stage to receive the first output, got %+v", request.Messages)
+	}
+	if strings.Contains(bodies[1], "make a plan") {
+		t.Errorf("expected the second stage not to share the first system prompt")
+	}
+	if result.Output != result.Stages[1].Output {
+		t.Errorf("expected the pipeline output to be the last stage output")
+	}
+	if want := result.Stages[0].TokenUsage.Total + result.Stages[1].TokenUsage.Total; result.TokenUsage.Total != want {
+		t.Errorf("expected total usage %d, got %d", want, result.TokenUsage.Total)
+	}
+}
+
+func TestRunPipelineTokenBudget(t *testing.T) {
+	mockServer := mock_server.NewMockServer(mock_server.Config{Provider: "openai", Seed: 1})
+	server := httptest.NewServer(http.HandlerFunc(mockServer.HandleOpenAIMock))
+	defer server.Close()
+
+	clientConfig := chat.Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	}
+	config := &pipelineConfig{
+		TokenBudget: 1,
+		Stages:      []pipelineStage{{Name: "first"}, {Name: "second"}},
+	}
+	result, err := runPipeline(context.Background(), config, []chat.Config{clientConfig, clientConfig}, "hello")
+	if err == nil || !strings.Contains(err.Error(), "before stage second") {
+		t.Fatalf("expected budget error before the second stage, got %v", err)
+	}
+	if len(result.Stages) != 1 {
+		t.Errorf("expected only the first stage to run, got %d", len(result.Stages))
+	}
+}
@@ -0,0 +1,62 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// modelPricing is an entry of the --pricing file, prices are USD per 1M tokens.
+// APIShape and Provider register a model not built in, e.g. a self-hosted one
+type modelPricing struct {
+	Input      json.Number `json:"input"`
+	Output     json.Number `json:"output"`
+	CacheRead  json.Number `json:"cache_read,omitempty"`
+	CacheWrite json.Number `json:"cache_write,omitempty"`
+
+	APIShape providers.APIShape `json:"api_shape,omitempty"`
+	Provider providers.Provider `json:"provider,omitempty"`
+}
+
+// loadPricing registers the prices of a --pricing file, which maps models to modelPricing:
+//
+//	{"my-llama": {"input": 0.2, "output": 0.6, "api_shape": "openai", "provider": "openai"}}
+func loadPricing(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var pricing map[string]modelPricing
+	if err := json.Unmarshal(data, &pricing); err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	models := make([]string, 0, len(pricing))
+	for model := range pricing {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		price := pricing[model]
+		if price.APIShape != "" || price.Provider != "" {
+			if _, err := providers.GetModelAPIShape(model); err != nil {
+				err := providers.RegisterModel(types.ModelInfo{
+					Name:     model,
+					APIShape: price.APIShape,
+					Provider: price.Provider,
+				})
+				if err != nil {
+					return fmt.Errorf("%s: %w", file, err)
+				}
+			}
+		}
+		err := providers.RegisterModelPricing(model, price.Input.String(), price.Output.String(), price.CacheRead.String(), price.CacheWrite.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestLoadPricingRegistersModel(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pricing.json")
+	content := `{"test-self-hosted": {"input": 0.2, "output": "0.6", "api_shape": "openai", "provider": "openai"}}`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadPricing(file); err != nil {
+		t.Fatal(err)
+	}
+	apiShape, err := providers.GetModelAPIShape("test-self-hosted")
+	if err != nil || apiShape != providers.APIShapeOpenAI {
+		t.Fatalf("expected registered openai model, got %q %v", apiShape, err)
+	}
+	cost, ok := providers.ComputeCost(apiShape, "test-self-hosted", types.TokenUsage{Input: 1_000_000, Output: 1_000_000})
+	if !ok || cost.TotalUSD != "0.8" {
+		t.Errorf("expected total 0.8, got %+v", cost)
+	}
+}
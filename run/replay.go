@@ -0,0 +1,134 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/tools"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const replayHelp = `
+replay - Re-execute tool calls from a recorded chat and report changed results
+
+Usage: kode replay <record> [OPTIONS]
+
+Options:
+  --tool TOOL                builtin tool to replay against, repeatable(default: all builtin tools)
+  --tool-custom FILE         custom tool to replay against
+  --tool-custom-json JSON    custom tool to replay against, in json
+  --mcp SERVER               MCP server to replay against
+  --tool-default-cwd DIR     the default working directory for tools, default current dir
+  --allow-destructive        also re-execute destructive tools like write_file and run_terminal_cmd,
+                             skipped by default to not repeat their side effects
+  -v,--verbose               also show unchanged results
+  -h, --help                 show this help message
+
+Examples:
+  kode replay record.json
+  kode replay record.json --tool list_dir --tool-default-cwd ./project
+`
+
+func handleReplay(args []string) error {
+	var builtinTools []string
+	var toolCustomFiles []string
+	var toolCustomJSONs []string
+	var mcpServers []string
+	var toolDefaultCwd string
+	var allowDestructive bool
+	var verbose bool
+	args, err := flags.StringSlice("--tool", &builtinTools).
+		StringSlice("--tool-custom", &toolCustomFiles).
+		StringSlice("--tool-custom-json", &toolCustomJSONs).
+		StringSlice("--mcp", &mcpServers).
+		String("--tool-default-cwd", &toolDefaultCwd).
+		Bool("--allow-destructive", &allowDestructive).
+		Bool("-v,--verbose", &verbose).
+		Help("-h,--help", strings.TrimPrefix(replayHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("requires record file, try `kode replay --help`")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("unrecognized extra: %s", strings.Join(args[1:], ","))
+	}
+	recordFile := args[0]
+
+	if len(builtinTools) == 0 && len(toolCustomFiles) == 0 && len(toolCustomJSONs) == 0 && len(mcpServers) == 0 {
+		allTools, err := tools.GetAllBuiltinTools()
+		if err != nil {
+			return err
+		}
+		for _, tool := range allTools {
+			builtinTools = append(builtinTools, tool.Name)
+		}
+	}
+	if toolDefaultCwd == "" {
+		toolDefaultCwd, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+	}
+
+	messages, err := loadHistoricalMessages(recordFile)
+	if err != nil {
+		return err
+	}
+	results, err := chat.ReplayTools(context.Background(), messages, chat.ReplayOptions{
+		Tools:            builtinTools,
+		ToolFiles:        toolCustomFiles,
+		ToolJSONs:        toolCustomJSONs,
+		MCPServers:       mcpServers,
+		DefaultToolCwd:   toolDefaultCwd,
+		AllowDestructive: allowDestructive,
+	})
+	if err != nil {
+		return err
+	}
+	printReplayReport(os.Stdout, results, verbose)
+	return nil
+}
+
+// printReplayReport prints skipped and changed results, and unchanged ones if verbose
+func printReplayReport(w io.Writer, results []chat.ReplayResult, verbose bool) {
+	var changed int
+	var skipped int
+	for _, result := range results {
+		if result.Skipped {
+			skipped++
+			fmt.Fprintf(w, "[SKIPPED] %s(%s) %s\n", result.ToolName, result.ToolUseID, limitPrintLength(result.Arguments))
+			continue
+		}
+		if result.Changed {
+			changed++
+		} else if !verbose {
+			continue
+		}
+		status := "SAME"
+		if result.Changed {
+			status = "CHANGED"
+		}
+		fmt.Fprintf(w, "[%s] %s(%s) %s\n", status, result.ToolName, result.ToolUseID, limitPrintLength(result.Arguments))
+		if !result.Changed {
+			continue
+		}
+		if result.HasRecorded {
+			fmt.Fprintf(w, "  recorded: %s\n", limitPrintLength(result.Recorded))
+		} else {
+			fmt.Fprintf(w, "  recorded: (missing)\n")
+		}
+		fmt.Fprintf(w, "  current:  %s\n", limitPrintLength(result.Current))
+	}
+	fmt.Fprintf(w, "%d tool call(s) replayed, %d changed", len(results)-skipped, changed)
+	if skipped > 0 {
+		fmt.Fprintf(w, ", %d destructive skipped, use --allow-destructive to replay them", skipped)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,98 @@
+package run
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// resumeRecord rewinds recordFile to resumeFrom, writing the kept messages to
+// branchFile if given, otherwise rewriting recordFile. It returns the record file
+// the chat should continue with
+func resumeRecord(recordFile string, resumeFrom string, branchFile string) (string, error) {
+	messages, err := loadHistoricalMessages(recordFile)
+	if err != nil {
+		return "", err
+	}
+	kept, err := rewindMessages(messages, resumeFrom)
+	if err != nil {
+		return "", fmt.Errorf("--resume-from: %w", err)
+	}
+	target := recordFile
+	if branchFile != "" {
+		target = branchFile
+	}
+	if err := chat.SaveHistory(target, kept); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// rewindMessages keeps messages up to resumeFrom, which is either N, the number
+// of conversation messages(msg, tool call and tool result) to keep, or a RFC3339
+// time, keeping messages created at or before it. Non-conversation messages
+// like token usage are kept if they precede the cut
+func rewindMessages(messages types.Messages, resumeFrom string) (types.Messages, error) {
+	var keep func(msg types.Message) bool
+	if n, err := strconv.Atoi(resumeFrom); err == nil {
+		if n < 0 {
+			return nil, fmt.Errorf("invalid %d, must not be negative", n)
+		}
+		var count int
+		keep = func(msg types.Message) bool {
+			if !msg.Type.HistorySendable() {
+				return count < n
+			}
+			if count >= n {
+				return false
+			}
+			count++
+			return true
+		}
+	} else {
+		at, err := time.Parse(time.RFC3339, resumeFrom)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s, expect N or RFC3339 time", resumeFrom)
+		}
+		keep = func(msg types.Message) bool {
+			msgTime, ok := messageTime(msg)
+			return ok && !msgTime.After(at)
+		}
+	}
+
+	var end int
+	for i, msg := range messages {
+		if !keep(msg) {
+			break
+		}
+		end = i + 1
+	}
+	kept := messages[:end]
+
+	// a tool call must be followed by its result
+	for i := len(kept) - 1; i >= 0; i-- {
+		if !kept[i].Type.HistorySendable() {
+			continue
+		}
+		if kept[i].Type == types.MsgType_ToolCall {
+			return nil, fmt.Errorf("would end with tool call %s without its result", kept[i].ToolName)
+		}
+		break
+	}
+	return kept, nil
+}
+
+func messageTime(msg types.Message) (time.Time, bool) {
+	if msg.Timestamp != 0 {
+		return time.Unix(msg.Timestamp, 0), true
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05-07:00"} {
+		if t, err := time.Parse(layout, msg.Time); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
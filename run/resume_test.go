@@ -0,0 +1,202 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func writeTestRecord(t *testing.T, file string, n int) types.Messages {
+	t.Helper()
+	var messages types.Messages
+	for i := 0; i < n; i++ {
+		role := types.Role_User
+		if i%2 == 1 {
+			role = types.Role_Assistant
+		}
+		messages = append(messages, types.Message{
+			Type:      types.MsgType_Msg,
+			Role:      role,
+			Content:   fmt.Sprintf("msg %d", i),
+			Timestamp: int64(1700000000 + i*60),
+		})
+	}
+	if err := chat.SaveHistory(file, messages); err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestResumeFromMessage(t *testing.T) {
+	var sent []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		json.Unmarshal(data, &body)
+		sent = body.Messages
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recordFile := filepath.Join(dir, "record.json")
+	branchFile := filepath.Join(dir, "branch.json")
+	writeTestRecord(t, recordFile, 6)
+
+	target, err := resumeRecord(recordFile, "2", branchFile)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if target != branchFile {
+		t.Errorf("expected to continue with %s, got %s", branchFile, target)
+	}
+
+	client, err := chat.NewClient(chat.Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := chat.NewCliHandler(client, chat.CliOptions{RecordFile: target})
+	if err := handler.HandleCli(context.Background(), "again"); err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	// the 2 kept messages and the new one
+	if len(sent) != 3 {
+		t.Fatalf("expected 3 messages sent, got %d: %v", len(sent), sent)
+	}
+	for i, want := range []string{"msg 0", "msg 1", "again"} {
+		if sent[i]["content"] != want {
+			t.Errorf("message %d: expected %q, got %v", i, want, sent[i]["content"])
+		}
+	}
+
+	original, err := loadHistoricalMessages(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(original) != 6 {
+		t.Errorf("expected original record untouched, got %d messages", len(original))
+	}
+}
+
+func TestRewindMessages(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "record.json")
+	messages := writeTestRecord(t, recordFile, 6)
+
+	// in place
+	target, err := resumeRecord(recordFile, "4", "")
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	kept, err := loadHistoricalMessages(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != recordFile || len(kept) != 4 {
+		t.Errorf("expected record rewound to 4 messages, got %d in %s", len(kept), target)
+	}
+
+	// by time, the 3rd message is at +120s
+	kept, err = rewindMessages(messages, "2023-11-14T22:15:20Z")
+	if err != nil {
+		t.Fatalf("rewind: %v", err)
+	}
+	if len(kept) != 3 {
+		t.Errorf("expected 3 messages before time, got %d", len(kept))
+	}
+
+	withToolCall := types.Messages{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir"},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir"},
+	}
+	if _, err := rewindMessages(withToolCall, "2"); err == nil {
+		t.Errorf("expected error when cutting between tool call and result")
+	}
+	if _, err := rewindMessages(messages, "yesterday"); err == nil {
+		t.Errorf("expected error for invalid value")
+	}
+}
+
+func TestResumeWithAnotherModel(t *testing.T) {
+	var sent []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		json.Unmarshal(data, &body)
+		sent = body.Messages
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":10,"total_tokens":110}}`)
+	}))
+	defer server.Close()
+
+	// recorded by claude, token usage without model as in older records
+	recordFile := filepath.Join(t.TempDir(), "record.json")
+	claudeUsage := types.TokenUsage{Input: 200, Output: 20, Total: 220, InputBreakdown: types.TokenUsageInputBreakdown{NonCacheRead: 200}}
+	err := chat.SaveHistory(recordFile, types.Messages{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, Model: "claude-3-7-sonnet", ToolName: "list_dir", ToolUseID: "toolu_1", Content: `{"relative_workspace_path":"."}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, Model: "claude-3-7-sonnet", ToolName: "list_dir", ToolUseID: "toolu_1", Content: `{"files":["a.go"]}`},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "a.go"},
+		{Type: types.MsgType_TokenUsage, TokenUsage: &claudeUsage},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := chat.NewClient(chat.Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := chat.NewCliHandler(client, chat.CliOptions{RecordFile: recordFile})
+	if err := handler.HandleCli(context.Background(), "again"); err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	// the claude tool call is replayed in OpenAI format
+	if len(sent) != 5 {
+		t.Fatalf("expected 5 messages sent, got %d: %v", len(sent), sent)
+	}
+	if sent[2]["role"] != "tool" || sent[2]["tool_call_id"] != "toolu_1" {
+		t.Errorf("expected tool result of toolu_1, got %v", sent[2])
+	}
+
+	messages, err := loadHistoricalMessages(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := usageByModel(messages)
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("expected usage of 2 models, got %d", len(models))
+	}
+	if models[0].Model != "claude-3-7-sonnet" || models[0].Usage.Input != 200 {
+		t.Errorf("expected 200 input tokens of claude-3-7-sonnet, got %d of %s", models[0].Usage.Input, models[0].Model)
+	}
+	if models[1].Model != "gpt-4o" || models[1].Usage.Input != 100 {
+		t.Errorf("expected 100 input tokens of gpt-4o, got %d of %s", models[1].Usage.Input, models[1].Model)
+	}
+}
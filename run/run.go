@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +21,7 @@ import (
 	"github.com/xhd2015/kode-ai/tools"
 	"github.com/xhd2015/kode-ai/types"
 	"github.com/xhd2015/less-gen/flags"
+	"github.com/xhd2015/xgo/support/git"
 )
 
 //go:embed VERSION.txt
@@ -38,6 +41,15 @@ Available commands:
   chat-server                     start a WebSocket chat server
   view <files...>                 view recorded chat files
   mock-server                     start a mock HTTP server for integration testing
+  doctor                          check environment and provider connectivity
+  replay <record>                 re-execute tool calls from a recorded chat and report changed results
+  debug-convert <record>          print the provider-native messages a record is converted to
+  batch <prompts.jsonl>           run each prompt of a JSONL file, writing results as JSONL
+  pipeline <config.json> [msg]    run agents in order, passing the output of each as the input of the next
+  migrate <record>                upgrade a record file to the current record version
+  diff-records <a> <b>            compare two recorded chats turn by turn
+  stats <record>                  print message counts, rounds, tools, tokens and cost of a recorded chat
+  config dump                     print the effective configuration of chat as JSON, the token redacted
   example                         show examples
   version                         version info
   revision                        revision info
@@ -45,26 +57,91 @@ Available commands:
 
 Options:
   --max-round N                   maximum number of chat rounds
-  --token TOKEN                   the token
+  --token TOKEN                   the token(default: provider env like OPENAI_API_KEY, then ~/.kode/credentials.json)
   --base-url BASE_URL             the base url
-  --model MODEL                   llm model(default: gpt-4.1)
+  --header K=V                    extra HTTP header sent with each API request, e.g. X-Routing-Tag=canary, repeatable
+  --openai-org ORG                OpenAI organization for billing attribution, sent as the OpenAI-Organization header
+  --openai-project PROJECT        OpenAI project for billing attribution, sent as the OpenAI-Project header
+  --model MODEL                   llm model(default: resolved from available API key env, gpt-4.1 if none),
+                                  can differ from the model of a resumed --record, usage is attributed per model
+  --default-model MODEL           the model to use when --model is not specified
+  --pricing FILE                  JSON of model prices in USD per 1M tokens overriding the built-in ones, e.g.
+                                  {"my-model": {"input": 0.2, "output": 0.6, "cache_read": 0.05, "api_shape": "openai", "provider": "openai"}},
+                                  api_shape and provider register a model not built in
   --system PROMPT                 set the system prompt, PROMPT can also be a file
+  --edit                          compose the msg in $EDITOR before sending, starting from the given msg if any
+  --edit-system                   edit the system prompt in $EDITOR before sending
+  --context-file FILE             inject file content as context before the user msg, repeatable
+  --user-metadata JSON            tags of the user msg like {"task_id": "T-1"}, kept in the --record and events but not sent to the model
+  --git-diff[=REV]                inject the git diff against REV(default: HEAD, i.e. staged and unstaged changes) as context
+  --document FILE                 attach a PDF or text file the model can cite(Anthropic only), repeatable
   --tool NAME                     predefined tool: batch_read_file,list_dir,grep_search...
-                                  use kode chat --tool list to see all possible tools
+                                  use kode chat --tool list to see all possible tools, add --json for their schemas
+  --tool-preset PRESET            add a set of builtin tools: minimal(no tools modifying files or running commands), full
   --tool-custom FILE              tool provided to LLM
   --tool-custom-json JSON         tool provided to LLM, in json, see tool example
   --tool-default-cwd DIR          the default working directory for tools, default current dir
-                                  use --tool-default-cwd=none to unset it
+                                  use --tool-default-cwd=none to unset it, --tool-default-cwd=git-root for the
+                                  top level of the enclosing git repo(default current dir outside a repo)
+  --sandbox                       reject builtin file tool paths resolving outside the --tool-default-cwd
+  --abort-on-tool-error           fail the chat as soon as a tool fails, instead of sending the error to the model
+  --strict-tool-args              validate tool call arguments against the tool's schema, invalid calls get an error result to retry
+  --once-per-tool                 answer a tool call repeating the name and arguments of an earlier call with the earlier result and a warning
+  --nudge-on-empty                ask the model to continue once when it responds with neither content nor tool calls
+  --max-tool-retries N            send the error of a malformed tool call back to the model to retry, at most N times
+  --compact-tool-results BYTES    send a tool result longer than BYTES to the model as a summary, the record keeps the full result
+  --compact-model MODEL           the model summarizing tool results, served with the same token(default: the chat model)
+  --stop-on-send-answer           end the chat once the model calls send_answer, whose answer is the final answer, adds the send_answer tool
+  --tool-timeout [NAME=]DURATION  give a tool running longer a timeout result, e.g. 30s for all tools, web_search=1m
+                                  for a tool, repeatable(default: no timeout)
+  --on-max-rounds ACTION           when --max-round ends the chat while the model still calls tools: stop(default, with stop reason max_rounds),
+                                  final-message(also add an assistant message saying so)
+  --tool-resolution MODE          precedence of tool callback and builtin tools: callback-first(default), builtin-first, callback-only, builtin-only
+  --logprobs                      return token log probabilities in assistant msg events, OpenAI only
+  --top-logprobs N                most likely tokens returned at each position(0-20), requires --logprobs
+  --record-logprobs               keep log probabilities in the --record file, requires --logprobs
+  --stream-tool-args              show progress while the model streams tool call arguments
+  --tool-choice CHOICE            whether the model calls tools: auto(default), none, required, or a tool name to force
+  --reasoning-effort EFFORT       how much a reasoning model thinks: low, medium or high, maps to the thinking budget of Anthropic and Gemini
+  --safety-setting CATEGORY=THRESHOLD
+                                  threshold of a Gemini safety filter, e.g. dangerous_content=block_only_high, repeatable
+  --assistant-prefill TEXT        the start of the reply the model continues, Moonshot only(partial mode)
+  --prediction FILE_OR_TEXT       content the reply mostly repeats, e.g. the file being edited, speeds up the reply, OpenAI only(predicted outputs)
+  --seed N                        sample deterministically as far as the backend can, records the system_fingerprint of each reply, OpenAI only
   --mcp SERVER                    connect to MCP server (ip:port or command)
+  --mcp-namespace                 name MCP tools SERVER__TOOL, SERVER being the base name of the command, so same-named tools of different servers coexist
+  --mcp-concurrency N             start at most N MCP servers at a time(default: 4)
   --record FILE                   record chat history to given json file, which can be used to store and resume the chat
+  --resume-from N|TIME            rewind the --record file to its first N messages, or to messages before TIME(RFC3339)
+  --branch FILE                   with --resume-from, write the rewound messages to FILE and continue there, leaving --record untouched
+  --history-last N                send only the last N turns of the --record file and its system prompt, the record is kept whole
+  --auto-save-interval DURATION   periodically rewrite the --record file with the in-memory session, e.g. 30s
+  --no-incremental-record         do not append each message to the --record file, requires --auto-save-interval
+                                  or --save-on-interrupt
+  --save-on-interrupt             on Ctrl-C, stop the chat and save the session to the --record file before exiting
+  --diff-apply-confirm            show the diff of file-changing tools and ask to accept or reject it before it is applied,
+                                  not supported with --with-server or --tool-resolution builtin-first, builtin-only
+  --diff-apply-policy POLICY      the decision of --diff-apply-confirm when stdin is not a terminal: accept(default) or reject
   --no-cache                      disable token caching
+  --prompt-cache-key KEY          route requests with the same KEY to the same prompt cache, OpenAI only
   --show-usage                    show usage from the file specified by --record
   --ignore-duplicate-msg          ignore duplicate user msg
   --log-request                   log http request
+  --log-redact MODE               what --log-request masks: secrets(default, API keys and bearer tokens), body(also request and response bodies), none
+  --trace-file FILE               append request and response JSON of each API call to FILE
+  --audit-log FILE                append each tool execution with its arguments, working dir, duration and outcome to FILE as JSON lines
+  --tool-cache DIR                keep read_file results in DIR across runs, reused while the file is unchanged
+  --event-sink URL                POST each event as JSON to URL
+  --estimate,--count-only         print the input tokens and cost of the request, then exit without sending it
+  --print-shape                   print the provider, API shape and base url resolved for the model, then exit
+  --api-shape SHAPE               the API shape of a model not built in, e.g. one served by a gateway: openai, anthropic or gemini
   --log-chat                      log chat(default: true)
   --json                          output response as JSON
+  --show-roles                    prefix assistant messages with the role and model, e.g. [assistant gpt-4o]
+  --round-separator STR           print STR between rounds, e.g. ---
   --std-stream                    enable bidirectional tool callback communication via stdin/stdout
   -c,--config FILE                load configuration from JSON file
+  --profile NAME                  merge the profile NAME of the config over it, default $KODE_PROFILE
   --config-example                show example of config file	
   --with-server SERVER            connect to a WebSocket chat server, e.g. http://localhost:8080, check 'kode chat-server --help' for more details
   -v,--verbose                    show verbose info
@@ -88,6 +165,11 @@ Examples:
 Tool example:
   kode example --tool
 
+Credentials:
+  --token and --base-url are resolved with precedence: flag > env > credentials file.
+  The credentials file is ~/.kode/credentials.json, or $KODE_CREDENTIALS_FILE, keyed by provider:
+    {"openai": {"token": "sk-..."}, "anthropic": {"token": "sk-ant-...", "base_url": "https://..."}}
+
 Available models:
   open-ai: gpt-4.1, gpt-4.1-mini, gpt-4o, gpt-4o-mini, gpt-4o-nano, o4-mini, o3
   anthropic: claude-3-7-sonnet
@@ -97,6 +179,7 @@ For system prompt, see https://docs.anthropic.com/en/docs/build-with-claude/prom
 To smoothly chat with llm, you can use VSCode to edit your cli:
   export EDITOR='code --wait'
   kode chat ... (click CTRL-X CTRL-E to enter edit, then save and close the editor)
+  or let kode open it: kode chat --edit ...
 `
 
 type Options struct {
@@ -130,6 +213,24 @@ func Main(args []string, opts Options) error {
 		return handleView(args)
 	case "mock-server":
 		return handleMockServer(args)
+	case "doctor":
+		return handleDoctor(args, opts.DefaultBaseURL)
+	case "replay":
+		return handleReplay(args)
+	case "debug-convert":
+		return handleDebugConvert(args)
+	case "batch":
+		return handleBatch(args, opts.DefaultBaseURL)
+	case "pipeline":
+		return handlePipeline(args, opts.DefaultBaseURL)
+	case "migrate":
+		return handleMigrate(args)
+	case "diff-records":
+		return handleDiffRecords(args)
+	case "stats":
+		return handleStats(args)
+	case "config":
+		return handleConfig(args, opts.DefaultBaseURL)
 	case "example", "examples":
 		return handleExample(args)
 	case "version":
@@ -165,12 +266,31 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 
 	var token string
 	var baseUrl string
+	var headers []string
+	var openAIOrg string
+	var openAIProject string
 	var systemPrompt string
+	var editMsg bool
+	var editSystem bool
+	var contextFiles []string
+	var userMetadataJSON string
+	var documents []string
 	var model string
+	var defaultModel string
+	var pricingFile string
 
 	var recordFile string
+	var resumeFrom string
+	var branchFile string
+	var historyLast int
+	var autoSaveInterval time.Duration
+	var noIncrementalRecord bool
+	var saveOnInterrupt bool
+	var diffApplyConfirm bool
+	var diffApplyPolicy string
 
 	var tools []string
+	var toolPreset string
 	var toolCustomFiles []string
 	var toolCustomJSONs []string
 
@@ -178,16 +298,52 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 	var ignoreDuplicateMsg bool
 
 	var toolDefaultCwd string
+	var sandbox bool
+	var abortOnToolError bool
+	var strictToolArgs bool
+	var oncePerTool bool
+	var nudgeOnEmpty bool
+	var maxToolRetries int
+	var compactToolResults int
+	var compactModel string
+	var stopOnSendAnswer bool
+	var toolTimeoutFlags []string
+	var toolResolution string
+	var onMaxRounds string
+	var toolChoice string
+	var reasoningEffort string
+	var safetySettingFlags []string
+	var assistantPrefill string
+	var prediction string
+	var seed *int64
+	var streamToolArgs bool
+	var logProbs bool
+	var topLogProbs int
+	var recordLogProbs bool
 	var maxRound int
 	var noCache bool
+	var promptCacheKey string
 
 	var logRequest bool
+	var logRedact string
+	var traceFile string
+	var auditLog string
+	var toolCacheDir string
+	var eventSink string
+	var estimate bool
+	var printShape bool
+	var apiShapeFlag string
 	var logChatFlag *bool
 	var verbose bool
 	var mcpServers []string
+	var mcpNamespace bool
+	var mcpConcurrency int
 	var configFile string
+	var profile string
 	var configExample bool
 	var jsonOutput bool
+	var showRoles bool
+	var roundSeparator string
 	var stdStream bool
 	var waitForStreamEvents bool
 
@@ -198,33 +354,106 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 	flagsParser := flags.String("--token", &token).
 		Int("--max-round", &maxRound).
 		String("--base-url", &baseUrl).
+		StringSlice("--header", &headers).
+		String("--openai-org", &openAIOrg).
+		String("--openai-project", &openAIProject).
 		String("--system", &systemPrompt).
+		Bool("--edit", &editMsg).
+		Bool("--edit-system", &editSystem).
+		StringSlice("--context-file", &contextFiles).
+		String("--user-metadata", &userMetadataJSON).
+		StringSlice("--document", &documents).
 		StringSlice("--tool", &tools).
+		String("--tool-preset", &toolPreset).
 		StringSlice("--tool-custom", &toolCustomFiles).
 		StringSlice("--tool-custom-json", &toolCustomJSONs).
 		String("--tool-default-cwd", &toolDefaultCwd).
+		Bool("--sandbox", &sandbox).
+		Bool("--abort-on-tool-error", &abortOnToolError).
+		Bool("--strict-tool-args", &strictToolArgs).
+		Bool("--once-per-tool", &oncePerTool).
+		Bool("--nudge-on-empty", &nudgeOnEmpty).
+		Int("--max-tool-retries", &maxToolRetries).
+		Int("--compact-tool-results", &compactToolResults).
+		String("--compact-model", &compactModel).
+		Bool("--stop-on-send-answer", &stopOnSendAnswer).
+		StringSlice("--tool-timeout", &toolTimeoutFlags).
+		String("--tool-resolution", &toolResolution).
+		String("--on-max-rounds", &onMaxRounds).
+		String("--tool-choice", &toolChoice).
+		String("--reasoning-effort", &reasoningEffort).
+		StringSlice("--safety-setting", &safetySettingFlags).
+		String("--assistant-prefill", &assistantPrefill).
+		String("--prediction", &prediction).
+		Int("--seed", &seed).
+		Bool("--stream-tool-args", &streamToolArgs).
+		Bool("--logprobs", &logProbs).
+		Int("--top-logprobs", &topLogProbs).
+		Bool("--record-logprobs", &recordLogProbs).
 		String("--model", &model).
+		String("--default-model", &defaultModel).
+		String("--pricing", &pricingFile).
 		String("--record", &recordFile).
+		String("--resume-from", &resumeFrom).
+		String("--branch", &branchFile).
+		Int("--history-last", &historyLast).
+		Duration("--auto-save-interval", &autoSaveInterval).
+		Bool("--no-incremental-record", &noIncrementalRecord).
+		Bool("--save-on-interrupt", &saveOnInterrupt).
+		Bool("--diff-apply-confirm", &diffApplyConfirm).
+		String("--diff-apply-policy", &diffApplyPolicy).
 		Bool("--no-cache", &noCache).
+		String("--prompt-cache-key", &promptCacheKey).
 		Bool("--show-usage", &showUsage).
 		Bool("--ignore-duplicate-msg", &ignoreDuplicateMsg).
 		Bool("--log-request", &logRequest).
+		String("--log-redact", &logRedact).
+		String("--trace-file", &traceFile).
+		String("--audit-log", &auditLog).
+		String("--tool-cache", &toolCacheDir).
+		String("--event-sink", &eventSink).
+		Bool("--estimate,--count-only", &estimate).
+		Bool("--print-shape", &printShape).
+		String("--api-shape", &apiShapeFlag).
 		Bool("--log-chat", &logChatFlag).
 		Bool("-v,--verbose", &verbose).
 		StringSlice("--mcp", &mcpServers).
+		Bool("--mcp-namespace", &mcpNamespace).
+		Int("--mcp-concurrency", &mcpConcurrency).
 		String("-c,--config", &configFile).
+		String("--profile", &profile).
 		Bool("--config-example", &configExample).
 		Bool("--json", &jsonOutput).
+		Bool("--show-roles", &showRoles).
+		String("--round-separator", &roundSeparator).
 		Bool("--std-stream", &stdStream).
 		Bool("--wait-for-stream-events", &waitForStreamEvents).
 		String("--with-server", &withServer).
 		Bool("--view", &viewFlag).
 		Help("-h,--help", getHelp(baesCmd))
 
+	// REV is optional, so --git-diff must not take the next arg as its value
+	args, gitDiff, gitDiffRev := extractGitDiffFlag(args)
 	args, err = flagsParser.Parse(args)
 	if err != nil {
 		return err
 	}
+	err = validateChatFlags(chatFlagSet{
+		hasMsg:              len(args) > 0,
+		recordFile:          recordFile,
+		configFile:          configFile,
+		view:                viewFlag,
+		showUsage:           showUsage,
+		estimate:            estimate,
+		jsonOutput:          jsonOutput,
+		stdStream:           stdStream,
+		waitForStreamEvents: waitForStreamEvents,
+		showRoles:           showRoles,
+		roundSeparator:      roundSeparator,
+	})
+	if err != nil {
+		return err
+	}
 
 	if configExample {
 		fmt.Println(ExampleConfig)
@@ -232,9 +461,6 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 	}
 
 	if viewFlag {
-		if recordFile == "" {
-			return fmt.Errorf("--view requires --record")
-		}
 		return handleViewWithOptions(viewOptions{
 			verbose:       verbose,
 			lastAssistant: false,
@@ -243,48 +469,48 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 		}, []string{recordFile})
 	}
 
-	if stdStream && jsonOutput {
-		return fmt.Errorf("--std-stream always uses json format, --json is unnecessary")
-	}
-
-	// Validate flag dependencies
-	if waitForStreamEvents && !stdStream {
-		return fmt.Errorf("--wait-for-stream-events requires --std-stream")
-	}
-
 	if len(tools) > 0 {
 		for _, tool := range tools {
 			if tool == "list" {
-				return listTools()
+				return listTools(os.Stdout, jsonOutput)
 			}
 		}
 	}
 
+	if pricingFile != "" {
+		if err := loadPricing(pricingFile); err != nil {
+			return fmt.Errorf("--pricing: %w", err)
+		}
+	}
+
 	// Load and apply configuration file
 	config, err := LoadConfig(configFile)
 	if err != nil {
 		return err
 	}
 
-	err = ApplyConfig(config, &token, &maxRound, &baseUrl, &model, &systemPrompt, &tools, &toolCustomFiles, &toolCustomJSONs, &toolDefaultCwd, &recordFile, &noCache, &showUsage, &ignoreDuplicateMsg, &logRequest, &logChatFlag, &verbose, &mcpServers)
+	if profile == "" && configFile != "" {
+		profile = os.Getenv(profileEnvKey)
+	}
+	err = ApplyConfig(config, profile, &token, &maxRound, &baseUrl, &model, &systemPrompt, &tools, &toolCustomFiles, &toolCustomJSONs, &toolDefaultCwd, &recordFile, &noCache, &showUsage, &ignoreDuplicateMsg, &logRequest, &logChatFlag, &verbose, &mcpServers)
 	if err != nil {
 		return err
 	}
-
-	if toolDefaultCwd == "" {
-		toolDefaultCwd = cwd
-	} else if toolDefaultCwd == "none" {
-		stat, _ := os.Stat("none")
-		if stat == nil {
-			toolDefaultCwd = ""
+	if toolPreset != "" {
+		tools, err = addToolPreset(tools, toolPreset)
+		if err != nil {
+			return err
 		}
-	} else if toolDefaultCwd == "/none" || toolDefaultCwd == "NONE" {
-		toolDefaultCwd = ""
+	}
+	if stopOnSendAnswer && !slices.Contains(tools, "send_answer") {
+		tools = append(tools, "send_answer")
 	}
 
+	toolDefaultCwd = resolveToolDefaultCwd(toolDefaultCwd, cwd)
+
 	if showUsage {
 		if recordFile == "" {
-			return fmt.Errorf("requires --record")
+			return fmt.Errorf("--show-usage requires --record")
 		}
 		return showUsageFromRecordFile(recordFile)
 	}
@@ -293,7 +519,10 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 	}
 
 	if model == "" {
-		model = providers.ModelGPT4_1
+		if defaultModel == "" {
+			defaultModel = config.DefaultModel
+		}
+		model = ResolveDefaultModel(defaultModel, os.Getenv)
 	}
 
 	var msg string
@@ -310,12 +539,130 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 	if len(args) > 0 {
 		return fmt.Errorf("unrecognized extra: %s", strings.Join(args, ","))
 	}
+	if editSystem {
+		if systemPrompt != "" {
+			systemPrompt, err = ioread.ReadOrContent(systemPrompt)
+			if err != nil {
+				return err
+			}
+		}
+		systemPrompt, err = editContent("system", systemPrompt)
+		if err != nil {
+			return err
+		}
+	}
+	if editMsg {
+		msg, err = editContent("msg", msg)
+		if err != nil {
+			return err
+		}
+	}
 
 	if maxRound != 0 {
 		if maxRound < 0 {
 			return fmt.Errorf("invalid --max-round: %d, must be positive", maxRound)
 		}
 	}
+	if (autoSaveInterval > 0 || noIncrementalRecord || saveOnInterrupt) && recordFile == "" {
+		return fmt.Errorf("--auto-save-interval, --no-incremental-record and --save-on-interrupt require --record")
+	}
+	if historyLast < 0 {
+		return fmt.Errorf("invalid --history-last: %d, must be positive", historyLast)
+	}
+	if historyLast > 0 && recordFile == "" {
+		return fmt.Errorf("--history-last requires --record")
+	}
+	if noIncrementalRecord && autoSaveInterval <= 0 && !saveOnInterrupt {
+		return fmt.Errorf("--no-incremental-record requires --auto-save-interval or --save-on-interrupt")
+	}
+	if diffApplyPolicy != "" && !diffApplyConfirm {
+		return fmt.Errorf("--diff-apply-policy requires --diff-apply-confirm")
+	}
+	if err := chat.ValidateFileChangePolicy(diffApplyPolicy); err != nil {
+		return fmt.Errorf("--diff-apply-policy: %w", err)
+	}
+	if (topLogProbs != 0 || recordLogProbs) && !logProbs {
+		return fmt.Errorf("--top-logprobs and --record-logprobs require --logprobs")
+	}
+	if maxToolRetries < 0 {
+		return fmt.Errorf("invalid --max-tool-retries: %d, must be positive", maxToolRetries)
+	}
+	if mcpConcurrency < 0 {
+		return fmt.Errorf("invalid --mcp-concurrency: %d, must be positive", mcpConcurrency)
+	}
+	if compactToolResults < 0 {
+		return fmt.Errorf("invalid --compact-tool-results: %d, must be positive", compactToolResults)
+	}
+	if compactModel != "" {
+		if compactToolResults == 0 {
+			return fmt.Errorf("--compact-model requires --compact-tool-results")
+		}
+		compactModel = providers.GetUnderlyingModel(compactModel)
+	}
+	if topLogProbs < 0 || topLogProbs > 20 {
+		return fmt.Errorf("invalid --top-logprobs: %d, must be within 0-20", topLogProbs)
+	}
+	if err := types.MaxRoundsAction(onMaxRounds).Validate(); err != nil {
+		return fmt.Errorf("--on-max-rounds: %w", err)
+	}
+	if err := types.ToolResolution(toolResolution).Validate(); err != nil {
+		return fmt.Errorf("--tool-resolution: %w", err)
+	}
+	if diffApplyConfirm {
+		if err := chat.ValidateConfirmFileChanges(types.ToolResolution(toolResolution), withServer != ""); err != nil {
+			return fmt.Errorf("--diff-apply-confirm: %w", err)
+		}
+	}
+	if err := types.ReasoningEffort(reasoningEffort).Validate(); err != nil {
+		return fmt.Errorf("--reasoning-effort: %w", err)
+	}
+	if prediction != "" {
+		prediction, err = ioread.ReadOrContent(prediction)
+		if err != nil {
+			return fmt.Errorf("--prediction: %w", err)
+		}
+	}
+	if err := types.LogRedact(logRedact).Validate(); err != nil {
+		return fmt.Errorf("--log-redact: %w", err)
+	}
+	var userMetadata map[string]interface{}
+	if userMetadataJSON != "" {
+		if err := json.Unmarshal([]byte(userMetadataJSON), &userMetadata); err != nil {
+			return fmt.Errorf("--user-metadata: expect JSON object: %w", err)
+		}
+	}
+	safetySettings, err := parseSafetySettings(safetySettingFlags)
+	if err != nil {
+		return fmt.Errorf("--safety-setting: %w", err)
+	}
+	headerMap, err := parseHeaders(headers)
+	if err != nil {
+		return fmt.Errorf("--header: %w", err)
+	}
+	toolTimeout, toolTimeouts, err := parseToolTimeouts(toolTimeoutFlags)
+	if err != nil {
+		return fmt.Errorf("--tool-timeout: %w", err)
+	}
+	if apiShapeFlag != "" {
+		if err := registerModelAPIShape(model, providers.APIShape(apiShapeFlag)); err != nil {
+			return fmt.Errorf("--api-shape: %w", err)
+		}
+	}
+	if printShape {
+		return printResolvedShape(os.Stdout, model, toolDefaultCwd, baseUrl, defaultBaseURL)
+	}
+	if branchFile != "" && resumeFrom == "" {
+		return fmt.Errorf("--branch requires --resume-from")
+	}
+	if resumeFrom != "" {
+		if recordFile == "" {
+			return fmt.Errorf("--resume-from requires --record")
+		}
+		recordFile, err = resumeRecord(recordFile, resumeFrom, branchFile)
+		if err != nil {
+			return err
+		}
+	}
 
 	model = providers.GetUnderlyingModel(model)
 	apiShape, err := providers.GetModelAPIShape(model)
@@ -326,6 +673,18 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 	if err != nil {
 		return err
 	}
+	if apiShape != providers.APIShapeOpenAI {
+		if openAIOrg != "" || openAIProject != "" {
+			return fmt.Errorf("--openai-org and --openai-project require an OpenAI model, got %s", model)
+		}
+	} else {
+		if openAIOrg == "" {
+			openAIOrg = config.OpenAIOrg
+		}
+		if openAIProject == "" {
+			openAIProject = config.OpenAIProject
+		}
+	}
 
 	resolvedOpts, err := ResolveProviderDefaultEnvOptions(apiShape, provider, toolDefaultCwd, token, baseUrl, defaultBaseURL)
 	if err != nil {
@@ -345,27 +704,284 @@ func handleChat(mode string, args []string, baesCmd string, defaultBaseURL strin
 		withServer:       withServer,
 		chatWithServerFn: cli.ChatWithServer,
 
-		systemPrompt:   systemPrompt,
-		logRequest:     logRequest,
-		toolBuiltins:   tools,
-		toolFiles:      toolCustomFiles,
-		toolJSONs:      toolCustomJSONs,
-		recordFile:     recordFile,
-		toolDefaultCwd: resolvedOpts.AbsDefaultToolCwd,
-
-		noCache: noCache,
+		headers:       headerMap,
+		openAIOrg:     openAIOrg,
+		openAIProject: openAIProject,
+
+		systemPrompt: systemPrompt,
+		contextFiles: contextFiles,
+		userMetadata: userMetadata,
+		gitDiff:      gitDiff,
+		gitDiffRev:   gitDiffRev,
+		documents:    documents,
+		logRequest:   logRequest,
+		logRedact:    types.LogRedact(logRedact),
+		traceFile:    traceFile,
+		auditLog:     auditLog,
+		toolCacheDir: toolCacheDir,
+		eventSink:    eventSink,
+		estimate:     estimate,
+		toolBuiltins: tools,
+		toolFiles:    toolCustomFiles,
+		toolJSONs:    toolCustomJSONs,
+		recordFile:   recordFile,
+
+		autoSaveInterval:    autoSaveInterval,
+		noIncrementalRecord: noIncrementalRecord,
+		historyLast:         historyLast,
+		saveOnInterrupt:     saveOnInterrupt,
+		diffApplyConfirm:    diffApplyConfirm,
+		diffApplyPolicy:     diffApplyPolicy,
+		toolDefaultCwd:      resolvedOpts.AbsDefaultToolCwd,
+		sandbox:             sandbox,
+		abortOnToolError:    abortOnToolError,
+		strictToolArgs:      strictToolArgs,
+		oncePerTool:         oncePerTool,
+		nudgeOnEmpty:        nudgeOnEmpty,
+		maxToolRetries:      maxToolRetries,
+		compactToolResults:  compactToolResults,
+		compactModel:        compactModel,
+		stopOnSendAnswer:    stopOnSendAnswer,
+		toolTimeout:         toolTimeout,
+		toolTimeouts:        toolTimeouts,
+		toolResolution:      types.ToolResolution(toolResolution),
+		onMaxRounds:         types.MaxRoundsAction(onMaxRounds),
+		toolChoice:          toolChoice,
+		reasoningEffort:     types.ReasoningEffort(reasoningEffort),
+		safetySettings:      safetySettings,
+		assistantPrefill:    assistantPrefill,
+		prediction:          prediction,
+		seed:                seed,
+		streamToolArgs:      streamToolArgs,
+		logProbs:            logProbs,
+		topLogProbs:         topLogProbs,
+		recordLogProbs:      recordLogProbs,
+
+		noCache:        noCache,
+		promptCacheKey: promptCacheKey,
 
 		ignoreDuplicateMsg:  ignoreDuplicateMsg,
 		logChat:             logChat,
 		verbose:             verbose,
 		jsonOutput:          jsonOutput,
+		showRoles:           showRoles,
+		roundSeparator:      roundSeparator,
 		stdStream:           stdStream,
 		waitForStreamEvents: waitForStreamEvents,
 
-		mcpServers: mcpServers,
+		mcpServers:     mcpServers,
+		mcpNamespace:   mcpNamespace,
+		mcpConcurrency: mcpConcurrency,
 	})
 }
 
+// chatFlagSet holds the chat flags whose combinations are checked by validateChatFlags
+type chatFlagSet struct {
+	hasMsg              bool
+	recordFile          string
+	configFile          string
+	view                bool
+	showUsage           bool
+	estimate            bool
+	jsonOutput          bool
+	stdStream           bool
+	waitForStreamEvents bool
+	showRoles           bool
+	roundSeparator      string
+}
+
+// validateChatFlags rejects conflicting or incomplete flags right after parsing, before
+// any work is done. Combinations depending on the config are checked once it is applied
+func validateChatFlags(flags chatFlagSet) error {
+	if flags.stdStream && flags.jsonOutput {
+		return fmt.Errorf("--std-stream always uses json format, --json is unnecessary")
+	}
+	if flags.waitForStreamEvents && !flags.stdStream {
+		return fmt.Errorf("--wait-for-stream-events requires --std-stream")
+	}
+	if flags.view {
+		if flags.recordFile == "" {
+			return fmt.Errorf("--view requires --record")
+		}
+		if flags.showUsage {
+			return fmt.Errorf("--view and --show-usage cannot be specified at the same time")
+		}
+	}
+	if flags.showUsage {
+		// the config may set the record file
+		if flags.recordFile == "" && flags.configFile == "" {
+			return fmt.Errorf("--show-usage requires --record")
+		}
+		if flags.hasMsg {
+			return fmt.Errorf("--show-usage only shows the usage of --record, remove the message")
+		}
+		if flags.estimate {
+			return fmt.Errorf("--show-usage and --estimate cannot be specified at the same time")
+		}
+	}
+	if flags.estimate && flags.stdStream {
+		return fmt.Errorf("--estimate sends no request, --std-stream is unnecessary")
+	}
+	if (flags.showRoles || flags.roundSeparator != "") && (flags.jsonOutput || flags.stdStream) {
+		return fmt.Errorf("--show-roles and --round-separator only apply to plain output, cannot be used with --json or --std-stream")
+	}
+	return nil
+}
+
+// parseSafetySettings parses CATEGORY=THRESHOLD pairs of --safety-setting,
+// case-insensitive and the HARM_CATEGORY_ prefix of the category optional
+func parseSafetySettings(flagValues []string) ([]types.SafetySetting, error) {
+	var settings []types.SafetySetting
+	for _, flagValue := range flagValues {
+		category, threshold, ok := strings.Cut(flagValue, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid safety setting %q, expect CATEGORY=THRESHOLD", flagValue)
+		}
+		category = strings.ToUpper(strings.TrimSpace(category))
+		if !strings.HasPrefix(category, "HARM_CATEGORY_") {
+			category = "HARM_CATEGORY_" + category
+		}
+		setting := types.SafetySetting{
+			Category:  category,
+			Threshold: strings.ToUpper(strings.TrimSpace(threshold)),
+		}
+		if err := setting.Validate(); err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// parseHeaders parses K=V pairs of --header, the value may be empty
+func parseHeaders(headers []string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	headerMap := make(map[string]string, len(headers))
+	for _, header := range headers {
+		key, value, ok := strings.Cut(header, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid header %q, expect K=V", header)
+		}
+		headerMap[key] = value
+	}
+	return headerMap, nil
+}
+
+// extractGitDiffFlag removes --git-diff and --git-diff=REV from args
+func extractGitDiffFlag(args []string) (rest []string, gitDiff bool, rev string) {
+	rest = make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if arg == "--git-diff" {
+			gitDiff = true
+			continue
+		}
+		if value, ok := strings.CutPrefix(arg, "--git-diff="); ok {
+			gitDiff = true
+			rev = value
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, gitDiff, rev
+}
+
+// toolDefaultCwdGitRoot is the --tool-default-cwd value resolving to the enclosing git repo
+const toolDefaultCwdGitRoot = "git-root"
+
+// resolveToolDefaultCwd resolves the special values of --tool-default-cwd: empty is
+// cwd, none unsets it, git-root is the top level of the git repo enclosing cwd, or
+// cwd if not in a repo. A dir named none or git-root in cwd is taken literally
+func resolveToolDefaultCwd(toolDefaultCwd string, cwd string) string {
+	switch toolDefaultCwd {
+	case "":
+		return cwd
+	case "none":
+		stat, _ := os.Stat(filepath.Join(cwd, "none"))
+		if stat == nil {
+			return ""
+		}
+	case "/none", "NONE":
+		return ""
+	case toolDefaultCwdGitRoot:
+		stat, _ := os.Stat(filepath.Join(cwd, toolDefaultCwdGitRoot))
+		if stat != nil {
+			return toolDefaultCwd
+		}
+		topLevel, err := git.ShowTopLevel(cwd)
+		if err != nil || topLevel == "" {
+			fmt.Fprintf(os.Stderr, "--tool-default-cwd=git-root: %s is not in a git repo, using it as the default cwd\n", cwd)
+			return cwd
+		}
+		return topLevel
+	}
+	return toolDefaultCwd
+}
+
+// parseToolTimeouts parses --tool-timeout values, DURATION is the
+// default timeout of all tools and NAME=DURATION the timeout of a tool
+func parseToolTimeouts(values []string) (time.Duration, map[string]time.Duration, error) {
+	var defaultTimeout time.Duration
+	var timeouts map[string]time.Duration
+	for _, value := range values {
+		name, durationStr, ok := strings.Cut(value, "=")
+		if !ok {
+			name, durationStr = "", value
+		}
+		name = strings.TrimSpace(name)
+		if ok && name == "" {
+			return 0, nil, fmt.Errorf("invalid %q, expect DURATION or NAME=DURATION", value)
+		}
+		timeout, err := time.ParseDuration(durationStr)
+		if err != nil || timeout <= 0 {
+			return 0, nil, fmt.Errorf("invalid duration of %q, expect a positive duration like 30s", value)
+		}
+		if name == "" {
+			defaultTimeout = timeout
+			continue
+		}
+		if timeouts == nil {
+			timeouts = make(map[string]time.Duration)
+		}
+		timeouts[name] = timeout
+	}
+	return defaultTimeout, timeouts, nil
+}
+
+// providerDefaultModels maps providers to their default models,
+// the first provider whose token env is found decides the default model
+var providerDefaultModels = []struct {
+	provider providers.Provider
+	model    string
+}{
+	{providers.ProviderOpenAI, providers.ModelGPT4_1},
+	{providers.ProviderAnthropic, providers.ModelClaudeSonnet4},
+	{providers.ProviderGemini, providers.ModelGemini2_5_Pro},
+	{providers.ProviderMoonshot, providers.ModelKimiK2},
+	{providers.ProviderOpenRouter, providers.ModelOpenRouterKimiK2},
+}
+
+// ResolveDefaultModel returns defaultModel if set, otherwise picks a model
+// of the provider whose token env is available, falling back to gpt-4.1
+func ResolveDefaultModel(defaultModel string, getenv func(key string) string) string {
+	if defaultModel != "" {
+		return defaultModel
+	}
+	for _, p := range providerDefaultModels {
+		tokenEnvKey, _, _ := providerEnvKeys(p.provider)
+		if getenv(tokenEnvKey) != "" {
+			return p.model
+		}
+	}
+	return providers.ModelGPT4_1
+}
+
 type ResolvedOptions struct {
 	AbsDefaultToolCwd string
 	Token             string
@@ -373,36 +989,50 @@ type ResolvedOptions struct {
 }
 
 func ResolveProviderDefaultEnvOptions(apiShape providers.APIShape, provider providers.Provider, defaultToolCwd string, token string, baseUrl string, defaultBaseUrl string) (ResolvedOptions, error) {
-	var tokenEnvKey string
-	var baseUrlEnvKey string
-	switch provider {
-	case providers.ProviderOpenAI:
-		tokenEnvKey = "OPENAI_API_KEY"
-		baseUrlEnvKey = "OPENAI_BASE_URL"
-	case providers.ProviderAnthropic:
-		tokenEnvKey = "ANTHROPIC_API_KEY"
-		baseUrlEnvKey = "ANTHROPIC_BASE_URL"
-	case providers.ProviderGemini:
-		tokenEnvKey = "GEMINI_API_KEY"
-		baseUrlEnvKey = "GEMINI_BASE_URL"
-	case providers.ProviderMoonshot:
-		tokenEnvKey = "MOONSHOT_API_KEY"
-		baseUrlEnvKey = "MOONSHOT_BASE_URL"
-	case providers.ProviderOpenRouter:
-		tokenEnvKey = "OPENROUTER_API_KEY"
-		baseUrlEnvKey = "OPENROUTER_BASE_URL"
-	default:
+	tokenEnvKey, baseUrlEnvKey, ok := providerEnvKeys(provider)
+	if !ok {
 		return ResolvedOptions{}, fmt.Errorf("resolve provider env, unsupported provider: %s", apiShape)
 	}
 
-	resolvedOpts, err := ResolveEnvOptions(defaultToolCwd, token, tokenEnvKey, baseUrl, baseUrlEnvKey, "KODE_DEFAULT_BASE_URL", defaultBaseUrl)
+	loadCredentials := func() (providerCredentials, error) {
+		file, err := credentialsFile()
+		if err != nil {
+			return providerCredentials{}, err
+		}
+		return loadProviderCredentials(file, provider)
+	}
+	resolvedOpts, err := resolveEnvOptions(defaultToolCwd, token, tokenEnvKey, baseUrl, baseUrlEnvKey, "KODE_DEFAULT_BASE_URL", defaultBaseUrl, loadCredentials)
 	if err != nil {
 		return ResolvedOptions{}, err
 	}
 	return resolvedOpts, nil
 }
 
+// providerEnvKeys returns the env keys of token and base url for provider
+func providerEnvKeys(provider providers.Provider) (tokenEnvKey string, baseUrlEnvKey string, ok bool) {
+	switch provider {
+	case providers.ProviderOpenAI:
+		return "OPENAI_API_KEY", "OPENAI_BASE_URL", true
+	case providers.ProviderAnthropic:
+		return "ANTHROPIC_API_KEY", "ANTHROPIC_BASE_URL", true
+	case providers.ProviderGemini:
+		return "GEMINI_API_KEY", "GEMINI_BASE_URL", true
+	case providers.ProviderMoonshot:
+		return "MOONSHOT_API_KEY", "MOONSHOT_BASE_URL", true
+	case providers.ProviderOpenRouter:
+		return "OPENROUTER_API_KEY", "OPENROUTER_BASE_URL", true
+	}
+	return "", "", false
+}
+
 func ResolveEnvOptions(defaultToolCwd string, token string, tokenEnvKey string, baseUrl string, baseUrlEnvKey string, defaultBaseUrlEnvKey string, defaultBaseUrl string) (ResolvedOptions, error) {
+	return resolveEnvOptions(defaultToolCwd, token, tokenEnvKey, baseUrl, baseUrlEnvKey, defaultBaseUrlEnvKey, defaultBaseUrl, nil)
+}
+
+// resolveEnvOptions resolves token and base url with precedence flag > env > credentials file,
+// loadCredentials is only called if the flag and env are both empty, and the base url of the
+// credentials file is only used with its token
+func resolveEnvOptions(defaultToolCwd string, token string, tokenEnvKey string, baseUrl string, baseUrlEnvKey string, defaultBaseUrlEnvKey string, defaultBaseUrl string, loadCredentials func() (providerCredentials, error)) (ResolvedOptions, error) {
 	var absDefaultToolCwd string
 	if defaultToolCwd != "" {
 		var err error
@@ -412,12 +1042,25 @@ func ResolveEnvOptions(defaultToolCwd string, token string, tokenEnvKey string,
 		}
 	}
 
+	// the base url of the credentials file only goes with its token,
+	// a token from elsewhere is never sent to it
+	var credentials providerCredentials
+	var tokenFromCredentials bool
 	if token == "" {
 		var envOption string
 		if tokenEnvKey != "" {
 			token = os.Getenv(tokenEnvKey)
 			envOption = " or " + tokenEnvKey
 		}
+		if token == "" && loadCredentials != nil {
+			var err error
+			credentials, err = loadCredentials()
+			if err != nil {
+				return ResolvedOptions{}, err
+			}
+			token = credentials.Token
+			tokenFromCredentials = true
+		}
 		if token == "" {
 			return ResolvedOptions{}, errors.New("requires --token" + envOption)
 		}
@@ -430,6 +1073,9 @@ func ResolveEnvOptions(defaultToolCwd string, token string, tokenEnvKey string,
 		if envBaseURL == "" && defaultBaseUrlEnvKey != "" {
 			envBaseURL = os.Getenv(defaultBaseUrlEnvKey)
 		}
+		if envBaseURL == "" && tokenFromCredentials {
+			envBaseURL = credentials.BaseURL
+		}
 		if envBaseURL == "" {
 			envBaseURL = defaultBaseUrl
 		}
@@ -442,6 +1088,65 @@ func ResolveEnvOptions(defaultToolCwd string, token string, tokenEnvKey string,
 	}, nil
 }
 
+// registerModelAPIShape registers model with apiShape if it is not built in,
+// its provider being the one of apiShape. A built-in model keeps its API shape
+func registerModelAPIShape(model string, apiShape providers.APIShape) error {
+	model = providers.GetUnderlyingModel(model)
+	if known, err := providers.GetModelAPIShape(model); err == nil {
+		if known != apiShape {
+			return fmt.Errorf("%s is sent with API shape %s, got %s", model, known, apiShape)
+		}
+		return nil
+	}
+	return providers.RegisterModel(types.ModelInfo{
+		Name:     model,
+		APIShape: apiShape,
+		Provider: providers.Provider(apiShape),
+	})
+}
+
+// printResolvedShape prints the shape of model without resolving the token,
+// a model not built in is printed as unknown instead of failing
+func printResolvedShape(w io.Writer, model string, toolDefaultCwd string, baseUrl string, defaultBaseURL string) error {
+	model = providers.GetUnderlyingModel(model)
+	apiShape, err := providers.GetModelAPIShape(model)
+	if err != nil {
+		if baseUrl == "" {
+			baseUrl = defaultBaseURL
+		}
+		printModelShape(w, model, "", "", baseUrl)
+		return nil
+	}
+	provider, err := providers.GetModelProvider(model)
+	if err != nil {
+		return err
+	}
+	// the token is not printed, a placeholder skips resolving it
+	resolvedOpts, err := ResolveProviderDefaultEnvOptions(apiShape, provider, toolDefaultCwd, "-", baseUrl, defaultBaseURL)
+	if err != nil {
+		return err
+	}
+	printModelShape(w, model, provider, apiShape, resolvedOpts.BaseUrl)
+	return nil
+}
+
+// printModelShape prints how requests of model are sent, an empty baseUrl being the default of the provider SDK
+// and an empty apiShape a model not built in
+func printModelShape(w io.Writer, model string, provider providers.Provider, apiShape providers.APIShape, baseUrl string) {
+	if baseUrl == "" {
+		baseUrl = "(provider default)"
+	}
+	fmt.Fprintf(w, "model: %s\n", model)
+	if apiShape == "" {
+		fmt.Fprintf(w, "provider: unknown\n")
+		fmt.Fprintf(w, "api shape: unknown, use --api-shape\n")
+	} else {
+		fmt.Fprintf(w, "provider: %s\n", provider)
+		fmt.Fprintf(w, "api shape: %s\n", apiShape)
+	}
+	fmt.Fprintf(w, "base url: %s\n", baseUrl)
+}
+
 func listModels() error {
 	for _, model := range providers.GetAllModels() {
 		fmt.Println(model)
@@ -456,6 +1161,10 @@ Options:
   --last-assistant                show the last assistant message
   --show-usage                    show usage from the file specified by --record
   --tools                         show tools used in the chats
+  --template TEMPLATE             render each message with a Go text/template instead, TEMPLATE can also be a file.
+                                  Fields: .Index .Type .Role .Model .Time .Content .ToolName .ToolUseID .Error,
+                                  and .Tokens.Input .Tokens.Output .Tokens.Total .CostUSD of token_usage messages.
+                                  Functions: json quotes a value, csv quotes a CSV field
   -v,--verbose                    show verbose info
 
 Examples:
@@ -463,13 +1172,11 @@ Examples:
   kode view tmp/chat.json --last-assistant
   kode view tmp/chat.json --show-usage
   kode view tmp/chat.json --tools
+  kode view tmp/chat.json --template '{{if eq .Type "msg"}}**{{.Role}}**: {{.Content}}{{"\n\n"}}{{end}}'
 `
 
 func limitPrintLength(s string) string {
-	if len(s) < chat.MAX_PRINT_LIMIT+3 {
-		return s
-	}
-	return s[:chat.MAX_PRINT_LIMIT] + "..."
+	return chat.LimitPrintLength(s, chat.MAX_PRINT_LIMIT)
 }
 
 type viewOptions struct {
@@ -477,6 +1184,7 @@ type viewOptions struct {
 	lastAssistant bool
 	showUsage     bool
 	toolsOnly     bool
+	template      string
 }
 
 // just like replay the whole messages
@@ -487,6 +1195,7 @@ func handleView(args []string) error {
 		Bool("--last-assistant", &opts.lastAssistant).
 		Bool("--show-usage", &opts.showUsage).
 		Bool("--tools", &opts.toolsOnly).
+		String("--template", &opts.template).
 		Help("-h,--help", viewHelp).
 		Parse(args)
 	if err != nil {
@@ -514,6 +1223,29 @@ func handleViewWithOptions(opts viewOptions, files []string) error {
 		return fmt.Errorf("--show-usage and --last-assistant cannot be specified at the same time")
 	}
 
+	if opts.template != "" {
+		if showUsage || lastAssistant {
+			return fmt.Errorf("--template cannot be used with --show-usage or --last-assistant")
+		}
+		text, err := ioread.ReadOrContent(opts.template)
+		if err != nil {
+			return err
+		}
+		tpl, err := parseViewTemplate(text)
+		if err != nil {
+			return err
+		}
+		var allMessages types.Messages
+		for _, file := range files {
+			msg, err := loadHistoricalMessages(file)
+			if err != nil {
+				return err
+			}
+			allMessages = append(allMessages, fillUsageModels(msg)...)
+		}
+		return renderViewTemplate(os.Stdout, tpl, allMessages, toolsOnly)
+	}
+
 	if showUsage {
 		var allMessages types.Messages
 		for _, file := range files {
@@ -545,11 +1277,14 @@ func handleViewWithOptions(opts viewOptions, files []string) error {
 	}
 
 	var total types.TokenUsageCost
+	var allMessages types.Messages
 	for _, file := range files {
 		msg, err := loadHistoricalMessages(file)
 		if err != nil {
 			return err
 		}
+		msg = fillUsageModels(msg)
+		allMessages = append(allMessages, msg...)
 
 		for _, m := range msg {
 			if toolsOnly {
@@ -567,6 +1302,10 @@ func handleViewWithOptions(opts viewOptions, files []string) error {
 				limitedContent := limitPrintLength(m.Content)
 				fmt.Printf("%s: <tool_call tool=%q>%s</tool_call>\n", m.Role, m.ToolName, limitedContent)
 			case types.MsgType_ToolResult:
+				if m.Metadata.Todos != nil {
+					fmt.Printf("%s: <plan tool=%q>\n%s</plan>\n", m.Role, m.ToolName, chat.FormatTodos(m.Metadata.Todos))
+					continue
+				}
 				limitedContent := limitPrintLength(m.Content)
 				fmt.Printf("%s: <tool_result tool=%q>%s</tool_result>\n", m.Role, m.ToolName, limitedContent)
 			case types.MsgType_TokenUsage:
@@ -611,6 +1350,13 @@ func handleViewWithOptions(opts viewOptions, files []string) error {
 		}
 	}
 
+	// a resumed session may switch models, attribute usage to each
+	if models, err := usageByModel(allMessages); err == nil && len(models) > 1 {
+		for _, m := range models {
+			printTokenUsage(os.Stdout, "Usage of "+m.Model, m.Usage, "$"+m.Cost.TotalUSD)
+		}
+	}
+
 	var totalCostUSD string
 	if total.Cost.TotalUSD != "" {
 		totalCostUSD = "$" + total.Cost.TotalUSD
@@ -627,35 +1373,23 @@ func mustMarshal(v interface{}) string {
 	return string(jsonData)
 }
 
-// loadHistoricalMessages loads historical chat messages from the record file in unified format
+// loadHistoricalMessages loads historical chat messages from the record file,
+// upgraded to the current record version
 func loadHistoricalMessages(recordFile string) (types.Messages, error) {
-	var messages []types.Message
-
 	file, err := os.Open(recordFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// File doesn't exist, return empty messages
-			return messages, nil
+			return nil, nil
 		}
 		return nil, err
 	}
 	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	for {
-		var chatMsg types.Message
-		if err := decoder.Decode(&chatMsg); err != nil {
-			if err == io.EOF {
-				// End of file, break the loop
-				break
-			}
-			return nil, fmt.Errorf("failed to parse chat message: %v", err)
-		}
-
-		// Convert to unified message format (now just the same as ChatMessage)
-		messages = append(messages, chatMsg)
+	messages, _, err := chat.ReadRecord(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", recordFile, err)
 	}
-
 	return messages, nil
 }
 
@@ -737,11 +1471,19 @@ func handleMockServer(args []string) error {
 	var port int = 8080
 	var provider string = "openai"
 	var firstMsgToolCall bool
+	var latency time.Duration
+	var failureRate string
+	var seed int
+	var stateful bool
 	var help bool
 
 	args, err := flags.Int("--port", &port).
 		String("--provider", &provider).
 		Bool("--first-msg-tool-call", &firstMsgToolCall).
+		Duration("--latency", &latency).
+		String("--failure-rate", &failureRate).
+		Int("--seed", &seed).
+		Bool("--stateful", &stateful).
 		Bool("-h,--help", &help).
 		Parse(args)
 	if err != nil {
@@ -757,6 +1499,10 @@ Options:
   --port PORT            port to listen on (default: 8080)
   --provider PROVIDER    provider to simulate: openai(default), anthropic, gemini, all
   --first-msg-tool-call  first message respond with tool call when tools are available
+  --latency DURATION     artificial delay before each response, e.g. 500ms
+  --failure-rate RATE    probability(0~1) of responding with 429/500/529
+  --seed SEED            seed of the random generator, for reproducible runs
+  --stateful             remember user turns per X-Conversation-Id header and reference them in responses
   -h, --help             show this help message
 
 The mock server simulates OpenAI, Anthropic, and Gemini APIs with random responses
@@ -768,6 +1514,7 @@ Examples:
   kode mock-server --port 9000 --provider openai
   kode mock-server --provider anthropic
   kode mock-server --always-call-tool
+  kode mock-server --latency 200ms --failure-rate 0.3 --seed 1
   kode chat --base-url http://localhost:8080 "Hello world"
 `)
 		return nil
@@ -777,10 +1524,25 @@ Examples:
 		return fmt.Errorf("unexpected arguments: %v", args)
 	}
 
+	var failureRateNum float64
+	if failureRate != "" {
+		failureRateNum, err = strconv.ParseFloat(failureRate, 64)
+		if err != nil {
+			return fmt.Errorf("invalid --failure-rate: %w", err)
+		}
+		if failureRateNum < 0 || failureRateNum > 1 {
+			return fmt.Errorf("invalid --failure-rate: %s, must be between 0 and 1", failureRate)
+		}
+	}
+
 	// Start the mock server using the new package
 	return mock_server.Start(mock_server.Config{
 		Port:             port,
 		Provider:         provider,
 		FirstMsgToolCall: firstMsgToolCall,
+		Latency:          latency,
+		FailureRate:      failureRateNum,
+		Seed:             int64(seed),
+		Stateful:         stateful,
 	})
 }
@@ -0,0 +1,235 @@
+package run
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestResolveDefaultModel(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		defaultModel string
+		want         string
+		wantProvider providers.Provider
+	}{
+		{"NoEnv", nil, "", providers.ModelGPT4_1, providers.ProviderOpenAI},
+		{"OpenAI", map[string]string{"OPENAI_API_KEY": "x"}, "", providers.ModelGPT4_1, providers.ProviderOpenAI},
+		{"Anthropic", map[string]string{"ANTHROPIC_API_KEY": "x"}, "", providers.ModelClaudeSonnet4, providers.ProviderAnthropic},
+		{"Gemini", map[string]string{"GEMINI_API_KEY": "x"}, "", providers.ModelGemini2_5_Pro, providers.ProviderGemini},
+		{"Moonshot", map[string]string{"MOONSHOT_API_KEY": "x"}, "", providers.ModelKimiK2, providers.ProviderMoonshot},
+		{"OpenRouter", map[string]string{"OPENROUTER_API_KEY": "x"}, "", providers.ModelOpenRouterKimiK2, providers.ProviderOpenRouter},
+		{"OpenAIPreferred", map[string]string{"OPENAI_API_KEY": "x", "ANTHROPIC_API_KEY": "x"}, "", providers.ModelGPT4_1, providers.ProviderOpenAI},
+		{"Override", map[string]string{"ANTHROPIC_API_KEY": "x"}, providers.ModelGemini2_5_Flash, providers.ModelGemini2_5_Flash, providers.ProviderGemini},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResolveDefaultModel(tt.defaultModel, func(key string) string {
+				return tt.env[key]
+			})
+			if got != tt.want {
+				t.Fatalf("expected model %s, got %s", tt.want, got)
+			}
+			provider, err := providers.GetModelProvider(providers.GetUnderlyingModel(got))
+			if err != nil {
+				t.Fatalf("get provider: %v", err)
+			}
+			if provider != tt.wantProvider {
+				t.Errorf("expected provider %s, got %s", tt.wantProvider, provider)
+			}
+		})
+	}
+}
+
+func TestParseToolTimeouts(t *testing.T) {
+	defaultTimeout, timeouts, err := parseToolTimeouts([]string{"30s", "web_search=1m", "sleep_tool=5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultTimeout != 30*time.Second {
+		t.Errorf("expected default 30s, got %v", defaultTimeout)
+	}
+	if len(timeouts) != 2 || timeouts["web_search"] != time.Minute || timeouts["sleep_tool"] != 5*time.Second {
+		t.Errorf("unexpected timeouts: %v", timeouts)
+	}
+
+	for _, invalid := range []string{"=30s", "web_search=", "web_search=-1s", "soon"} {
+		if _, _, err := parseToolTimeouts([]string{invalid}); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestParseSafetySettings(t *testing.T) {
+	settings, err := parseSafetySettings([]string{"dangerous_content=block_only_high", "HARM_CATEGORY_HARASSMENT=OFF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.SafetySetting{
+		{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"},
+		{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "OFF"},
+	}
+	if len(settings) != len(expected) || settings[0] != expected[0] || settings[1] != expected[1] {
+		t.Errorf("expected %+v, got %+v", expected, settings)
+	}
+
+	for _, invalid := range []string{"dangerous_content", "violence=off", "dangerous_content=sometimes"} {
+		if _, err := parseSafetySettings([]string{invalid}); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestValidateChatFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   chatFlagSet
+		wantErr string
+	}{
+		{"Valid", chatFlagSet{hasMsg: true, recordFile: "chat.json", jsonOutput: true}, ""},
+		{"ShowUsageFromConfigRecord", chatFlagSet{showUsage: true, configFile: "config.json"}, ""},
+		{"StdStreamWithJSON", chatFlagSet{stdStream: true, jsonOutput: true}, "--json is unnecessary"},
+		{"WaitWithoutStdStream", chatFlagSet{waitForStreamEvents: true}, "--wait-for-stream-events requires --std-stream"},
+		{"ViewWithoutRecord", chatFlagSet{view: true}, "--view requires --record"},
+		{"ViewWithShowUsage", chatFlagSet{view: true, showUsage: true, recordFile: "chat.json"}, "--view and --show-usage"},
+		{"ShowUsageWithoutRecord", chatFlagSet{showUsage: true}, "--show-usage requires --record"},
+		{"ShowUsageWithMsg", chatFlagSet{showUsage: true, recordFile: "chat.json", hasMsg: true}, "remove the message"},
+		{"ShowUsageWithEstimate", chatFlagSet{showUsage: true, recordFile: "chat.json", estimate: true}, "--show-usage and --estimate"},
+		{"EstimateWithStdStream", chatFlagSet{estimate: true, stdStream: true}, "--std-stream is unnecessary"},
+		{"ShowRolesWithJSON", chatFlagSet{showRoles: true, jsonOutput: true}, "only apply to plain output"},
+		{"RoundSeparatorWithStdStream", chatFlagSet{roundSeparator: "---", stdStream: true}, "only apply to plain output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateChatFlags(tt.flags)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestExtractGitDiffFlag(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantArgs    []string
+		wantGitDiff bool
+		wantRev     string
+	}{
+		{[]string{"--git-diff", "review my changes"}, []string{"review my changes"}, true, ""},
+		{[]string{"--model", "gpt-4o", "--git-diff=main", "review"}, []string{"--model", "gpt-4o", "review"}, true, "main"},
+		{[]string{"review", "--", "--git-diff"}, []string{"review", "--", "--git-diff"}, false, ""},
+		{[]string{"review"}, []string{"review"}, false, ""},
+	}
+	for _, tt := range tests {
+		args, gitDiff, rev := extractGitDiffFlag(tt.args)
+		if strings.Join(args, " ") != strings.Join(tt.wantArgs, " ") || gitDiff != tt.wantGitDiff || rev != tt.wantRev {
+			t.Errorf("extractGitDiffFlag(%q) = %q, %v, %q, want %q, %v, %q", tt.args, args, gitDiff, rev, tt.wantArgs, tt.wantGitDiff, tt.wantRev)
+		}
+	}
+}
+
+func TestResolveToolDefaultCwdGitRoot(t *testing.T) {
+	repo, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Skipf("git init: %v %s", err, out)
+	}
+	nested := filepath.Join(repo, "a", "b")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolveToolDefaultCwd("git-root", nested); got != repo {
+		t.Errorf("expected the repo root %s, got %s", repo, got)
+	}
+
+	// outside a repo it stays at cwd
+	outside := t.TempDir()
+	if got := resolveToolDefaultCwd("git-root", outside); got != outside {
+		t.Errorf("expected %s outside a repo, got %s", outside, got)
+	}
+
+	if got := resolveToolDefaultCwd("", nested); got != nested {
+		t.Errorf("expected %s for empty, got %s", nested, got)
+	}
+	if got := resolveToolDefaultCwd("none", nested); got != "" {
+		t.Errorf("expected none to unset, got %s", got)
+	}
+}
+
+func TestPrintShape(t *testing.T) {
+	var err error
+	output := captureStdout(t, func() {
+		err = handleChat("chat", []string{"--model", "claude-sonnet-4", "--base-url", "http://gateway.local", "--print-shape"}, "kode", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"provider: anthropic\n", "api shape: anthropic\n", "base url: http://gateway.local\n"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, output)
+		}
+	}
+}
+
+func TestPrintShapeUnknownModel(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "chat.json")
+	var err error
+	output := captureStdout(t, func() {
+		err = handleChat("chat", []string{"--model", "my-gateway-model", "--base-url", "http://x", "--record", recordFile, "--resume-from", "1", "--print-shape"}, "kode", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"provider: unknown\n", "api shape: unknown, use --api-shape\n", "base url: http://x\n"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, output)
+		}
+	}
+	// printed before the record is resumed
+	if _, err := os.Stat(recordFile); !os.IsNotExist(err) {
+		t.Errorf("expected no record file, got %v", err)
+	}
+}
+
+func TestPrintShapeWithAPIShape(t *testing.T) {
+	model := "my-anthropic-gateway-model"
+	defer delete(types.AllModelInfos, model)
+
+	var err error
+	output := captureStdout(t, func() {
+		err = handleChat("chat", []string{"--model", model, "--base-url", "http://x", "--api-shape", "anthropic", "--print-shape"}, "kode", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"provider: anthropic\n", "api shape: anthropic\n", "base url: http://x\n"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, output)
+		}
+	}
+
+	err = handleChat("chat", []string{"--model", "claude-sonnet-4", "--api-shape", "openai", "--print-shape"}, "kode", "")
+	if err == nil || !strings.Contains(err.Error(), "--api-shape") {
+		t.Errorf("expected --api-shape error for a built-in model of another shape, got %v", err)
+	}
+	err = handleChat("chat", []string{"--model", "other-gateway-model", "--api-shape", "cohere", "--print-shape"}, "kode", "")
+	if err == nil || !strings.Contains(err.Error(), "unsupported API shape") {
+		t.Errorf("expected unsupported API shape error, got %v", err)
+	}
+}
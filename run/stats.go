@@ -0,0 +1,136 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/less-gen/flags"
+)
+
+const statsHelp = `
+stats - Print statistics of a recorded chat
+
+Usage: kode stats <record> [OPTIONS]
+
+Counts messages by type and role, rounds, distinct tools used, tokens
+and cost, a quick profile of a session without viewing it all. Each
+token usage in the record is one round.
+
+Options:
+  -h, --help                 show this help message
+
+Examples:
+  kode stats tmp/chat.json
+`
+
+func handleStats(args []string) error {
+	args, err := flags.Help("-h,--help", strings.TrimPrefix(statsHelp, "\n")).
+		Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("requires one record file, try `kode stats --help`")
+	}
+	messages, err := loadHistoricalMessages(args[0])
+	if err != nil {
+		return err
+	}
+	printRecordStats(os.Stdout, computeRecordStats(messages))
+	return nil
+}
+
+// recordStats profiles a recorded chat
+type recordStats struct {
+	// Messages counts messages by "type/role", or type for messages without role
+	Messages map[string]int
+	Rounds   int
+	// Tools are the distinct tools called, sorted
+	Tools []string
+	Usage types.TokenUsage
+	// CostUSD is the total cost, empty if the cost of some model is unknown
+	CostUSD string
+}
+
+func computeRecordStats(messages types.Messages) *recordStats {
+	stats := &recordStats{Messages: make(map[string]int)}
+	tools := make(map[string]bool)
+	costKnown := true
+	var totalCost types.TokenCost
+	for _, msg := range fillUsageModels(messages) {
+		key := string(msg.Type)
+		if msg.Role != "" {
+			key += "/" + string(msg.Role)
+		}
+		stats.Messages[key]++
+
+		switch msg.Type {
+		case types.MsgType_ToolCall:
+			if !tools[msg.ToolName] {
+				tools[msg.ToolName] = true
+				stats.Tools = append(stats.Tools, msg.ToolName)
+			}
+		case types.MsgType_TokenUsage:
+			if msg.TokenUsage == nil {
+				continue
+			}
+			stats.Rounds++
+			stats.Usage = stats.Usage.Add(*msg.TokenUsage)
+			cost, ok := computeMessageCost(msg.Model, *msg.TokenUsage)
+			if !ok {
+				costKnown = false
+				continue
+			}
+			totalCost = totalCost.Add(cost)
+		}
+	}
+	sort.Strings(stats.Tools)
+	if costKnown {
+		stats.CostUSD = totalCost.TotalUSD
+		if stats.CostUSD == "" {
+			stats.CostUSD = "0"
+		}
+	}
+	return stats
+}
+
+func computeMessageCost(model string, usage types.TokenUsage) (types.TokenCost, bool) {
+	apiShape, err := providers.GetModelAPIShape(model)
+	if err != nil {
+		return types.TokenCost{}, false
+	}
+	return providers.ComputeCost(apiShape, model, usage)
+}
+
+func printRecordStats(w io.Writer, stats *recordStats) {
+	keys := make([]string, 0, len(stats.Messages))
+	for key := range stats.Messages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "Messages:\n")
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %-24s %d\n", key, stats.Messages[key])
+	}
+	fmt.Fprintf(w, "Rounds: %d\n", stats.Rounds)
+	fmt.Fprintf(w, "Tools: %d", len(stats.Tools))
+	if len(stats.Tools) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(stats.Tools, ", "))
+	}
+	fmt.Fprintf(w, "\n")
+	var perRound int64
+	if stats.Rounds > 0 {
+		perRound = stats.Usage.Total / int64(stats.Rounds)
+	}
+	fmt.Fprintf(w, "Tokens: %d total, %d per round (input %d, output %d)\n", stats.Usage.Total, perRound, stats.Usage.Input, stats.Usage.Output)
+	cost := "unknown"
+	if stats.CostUSD != "" {
+		cost = "$" + stats.CostUSD
+	}
+	fmt.Fprintf(w, "Cost: %s\n", cost)
+}
@@ -0,0 +1,69 @@
+package run
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestRecordStats(t *testing.T) {
+	record := filepath.Join(t.TempDir(), "chat.json")
+	err := chat.SaveHistory(record, []types.Message{
+		{Type: types.MsgType_Msg, Role: types.Role_System, Content: "be brief"},
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, Model: "gpt-4o", ToolName: "list_dir", ToolUseID: "call_1", Content: `{"dir":"."}`},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, Model: "gpt-4o", ToolName: "read_file", ToolUseID: "call_2", Content: `{"target_file":"a.go"}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"files":["a.go"]}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "read_file", ToolUseID: "call_2", Content: `"package main"`},
+		{Type: types.MsgType_TokenUsage, Model: "gpt-4o", TokenUsage: &types.TokenUsage{Input: 100, Output: 20, Total: 120}},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, Model: "gpt-4o", ToolName: "list_dir", ToolUseID: "call_3", Content: `{"dir":"sub"}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_3", Content: `{"files":[]}`},
+		{Type: types.MsgType_TokenUsage, Model: "gpt-4o", TokenUsage: &types.TokenUsage{Input: 150, Output: 30, Total: 180}},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Model: "gpt-4o", Content: "a.go"},
+		{Type: types.MsgType_TokenUsage, Model: "gpt-4o", TokenUsage: &types.TokenUsage{Input: 200, Output: 10, Total: 210}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := loadHistoricalMessages(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := computeRecordStats(messages)
+	expectedMessages := map[string]int{
+		"msg/system":          1,
+		"msg/user":            1,
+		"msg/assistant":       1,
+		"tool_call/assistant": 3,
+		"tool_result/user":    3,
+		"token_usage":         3,
+	}
+	if !reflect.DeepEqual(stats.Messages, expectedMessages) {
+		t.Errorf("expected messages %v, got %v", expectedMessages, stats.Messages)
+	}
+	if stats.Rounds != 3 {
+		t.Errorf("expected 3 rounds, got %d", stats.Rounds)
+	}
+	if !reflect.DeepEqual(stats.Tools, []string{"list_dir", "read_file"}) {
+		t.Errorf("expected tools list_dir and read_file, got %v", stats.Tools)
+	}
+	if stats.Usage.Total != 510 || stats.Usage.Input != 450 || stats.Usage.Output != 60 {
+		t.Errorf("expected 510 tokens in total, got %+v", stats.Usage)
+	}
+	if stats.CostUSD == "" || stats.CostUSD == "0" {
+		t.Errorf("expected the cost of gpt-4o computed, got %q", stats.CostUSD)
+	}
+
+	var out strings.Builder
+	printRecordStats(&out, stats)
+	for _, line := range []string{"Rounds: 3\n", "Tools: 2 (list_dir, read_file)\n", "Tokens: 510 total, 170 per round (input 450, output 60)\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
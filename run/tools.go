@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 
@@ -27,17 +28,45 @@ type ToolInfo struct {
 
 type ToolInfoMapping map[string]*ToolInfo
 
-func listTools() error {
+// listTools prints the names of builtin tools, or with jsonOutput
+// their full definitions including the parameters schema
+func listTools(w io.Writer, jsonOutput bool) error {
 	toolBuiltins, err := tools.GetAllBuiltinTools()
 	if err != nil {
 		return err
 	}
+	if jsonOutput {
+		data, err := json.MarshalIndent(toolBuiltins, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	}
 	for _, tool := range toolBuiltins {
-		fmt.Println(tool.Name)
+		fmt.Fprintln(w, tool.Name)
 	}
 	return nil
 }
 
+// addToolPreset appends builtin tools of preset not already in toolNames
+func addToolPreset(toolNames []string, preset string) ([]string, error) {
+	presetNames, err := tools.GetPresetToolNames(preset)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(toolNames))
+	for _, name := range toolNames {
+		seen[name] = true
+	}
+	for _, name := range presetNames {
+		if !seen[name] {
+			toolNames = append(toolNames, name)
+		}
+	}
+	return toolNames, nil
+}
+
 // executeTool checks if the tool name matches a builtin and executes it
 func executeTool(ctx context.Context, toolName string, arguments string, defaultWorkingDir string, toolInfoMapping map[string]*ToolInfo) (string, bool) {
 	toolInfo, ok := toolInfoMapping[toolName]
@@ -54,7 +83,7 @@ func executeTool(ctx context.Context, toolName string, arguments string, default
 		}
 
 		// Execute the tool with compile-time type safety
-		res, err = executor.Execute(arguments, tools.ExecuteOptions{
+		res, err = executor.Execute(ctx, arguments, tools.ExecuteOptions{
 			DefaultWorkspaceRoot: defaultWorkingDir,
 		})
 		if err != nil {
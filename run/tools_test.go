@@ -0,0 +1,39 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/tools"
+)
+
+func TestListToolsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := listTools(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	var listed []*tools.UnifiedTool
+	if err := json.Unmarshal(buf.Bytes(), &listed); err != nil {
+		t.Fatalf("decode %s: %v", buf.String(), err)
+	}
+	builtins, err := tools.GetAllBuiltinTools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != len(builtins) {
+		t.Fatalf("expected %d tools, got %d", len(builtins), len(listed))
+	}
+	for i, tool := range builtins {
+		got := listed[i]
+		if got.Name != tool.Name || got.Description != tool.Description {
+			t.Errorf("expected %s, got %s", tool.Name, got.Name)
+			continue
+		}
+		want, _ := json.Marshal(tool.Parameters)
+		params, _ := json.Marshal(got.Parameters)
+		if got.Parameters == nil || string(params) != string(want) {
+			t.Errorf("%s: expected parameters %s, got %s", tool.Name, want, params)
+		}
+	}
+}
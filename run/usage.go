@@ -20,11 +20,68 @@ func showUsageFromRecordFile(recordFile string) error {
 	return showUsageFromMessages(messages)
 }
 
+// modelUsage is the token usage and cost of one model in a record
+type modelUsage struct {
+	Model string
+	types.TokenUsageCost
+}
+
+// fillUsageModels returns messages with each token usage attributed to a model.
+// Older records lack the model of token usage, it is taken from the closest
+// previous msg, as a resumed session may switch models
+func fillUsageModels(messages types.Messages) types.Messages {
+	filled := make(types.Messages, len(messages))
+	var lastModel string
+	for i, msg := range messages {
+		if msg.Type == types.MsgType_TokenUsage && msg.Model == "" {
+			msg.Model = lastModel
+		}
+		if msg.Model != "" {
+			lastModel = msg.Model
+		}
+		filled[i] = msg
+	}
+	return filled
+}
+
+// usageByModel sums the token usage of each model, in order of first use
+func usageByModel(messages types.Messages) ([]*modelUsage, error) {
+	var usages []*modelUsage
+	byModel := make(map[string]*modelUsage)
+	for _, msg := range fillUsageModels(messages) {
+		if msg.Type != types.MsgType_TokenUsage || msg.TokenUsage == nil {
+			continue
+		}
+		provider, err := providers.GetModelAPIShape(msg.Model)
+		if err != nil {
+			return nil, err
+		}
+		cost, ok := providers.ComputeCost(provider, msg.Model, *msg.TokenUsage)
+		if !ok {
+			return nil, fmt.Errorf("cannot compute cost for model: %s", msg.Model)
+		}
+		usage := byModel[msg.Model]
+		if usage == nil {
+			usage = &modelUsage{Model: msg.Model}
+			byModel[msg.Model] = usage
+			usages = append(usages, usage)
+		}
+		usage.Usage = usage.Usage.Add(*msg.TokenUsage)
+		usage.Cost = usage.Cost.Add(cost)
+	}
+	return usages, nil
+}
+
 func showUsageFromMessages(messages types.Messages) error {
+	models, err := usageByModel(messages)
+	if err != nil {
+		return err
+	}
+
 	// calculate the usage
 	var total types.TokenUsageCost
 	var costs []types.TokenUsageCost
-	for _, msg := range messages {
+	for _, msg := range fillUsageModels(messages) {
 		if msg.Type != types.MsgType_TokenUsage {
 			continue
 		}
@@ -69,6 +126,14 @@ func showUsageFromMessages(messages types.Messages) error {
 			fmt.Fprintf(w, "|-----|-------|-------------------|----------------------|--------|------|\n")
 		}
 
+		if len(models) > 1 {
+			for _, m := range models {
+				fmt.Fprintf(w, "| %s-Token | %d | %d | %d | %d | %d |\n", m.Model, m.Usage.Input, m.Usage.InputBreakdown.CacheRead, m.Usage.InputBreakdown.CacheWrite, m.Usage.Output, m.Usage.Total)
+				fmt.Fprintf(w, "| %s-Cost | %s | %s | %s | %s | $%s |\n", m.Model, m.Cost.InputUSD, m.Cost.InputBreakdown.CacheReadUSD, m.Cost.InputBreakdown.CacheWriteUSD, m.Cost.OutputUSD, m.Cost.TotalUSD)
+			}
+			fmt.Fprintf(w, "|-----|-------|-------------------|----------------------|--------|------|\n")
+		}
+
 		fmt.Fprintf(w, "| ALL-Token | %d | %d | %d | %d | %d |\n", usage.Input, usage.InputBreakdown.CacheRead, usage.InputBreakdown.CacheWrite, usage.Output, usage.Total)
 		fmt.Fprintf(w, "| ALL-Cost | %s | %s | %s | %s | $%s |\n", totalCost.InputUSD, cacheInputReadUSD, cacheInputWriteUSD, totalCost.OutputUSD, totalCost.TotalUSD)
 	})
@@ -0,0 +1,86 @@
+package run
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// viewTemplateData is what the --template of kode view is applied to, once per message
+type viewTemplateData struct {
+	Index     int
+	Type      string
+	Role      string
+	Model     string
+	Time      string
+	Content   string
+	ToolName  string
+	ToolUseID string
+	Error     string
+
+	// of token_usage messages, CostUSD is empty if the price of the model is unknown
+	Tokens  *types.TokenUsage
+	CostUSD string
+}
+
+var viewTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"csv": func(s string) (string, error) {
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		if err := w.Write([]string{s}); err != nil {
+			return "", err
+		}
+		w.Flush()
+		return strings.TrimSuffix(b.String(), "\n"), w.Error()
+	},
+}
+
+func parseViewTemplate(text string) (*template.Template, error) {
+	tpl, err := template.New("view").Funcs(viewTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("--template: %w", err)
+	}
+	return tpl, nil
+}
+
+// renderViewTemplate executes tpl for each message, only tool calls and results if toolsOnly
+func renderViewTemplate(w io.Writer, tpl *template.Template, messages types.Messages, toolsOnly bool) error {
+	for i, msg := range messages {
+		if toolsOnly && msg.Type != types.MsgType_ToolCall && msg.Type != types.MsgType_ToolResult {
+			continue
+		}
+		data := viewTemplateData{
+			Index:     i,
+			Type:      string(msg.Type),
+			Role:      string(msg.Role),
+			Model:     msg.Model,
+			Time:      msg.Time,
+			Content:   msg.Content,
+			ToolName:  msg.ToolName,
+			ToolUseID: msg.ToolUseID,
+			Error:     msg.Error,
+			Tokens:    msg.TokenUsage,
+		}
+		if msg.TokenUsage != nil && msg.Model != "" {
+			if apiShape, err := providers.GetModelAPIShape(msg.Model); err == nil {
+				if cost, ok := providers.ComputeCost(apiShape, msg.Model, *msg.TokenUsage); ok {
+					data.CostUSD = cost.TotalUSD
+				}
+			}
+		}
+		if err := tpl.Execute(w, data); err != nil {
+			return fmt.Errorf("--template: message %d: %w", i, err)
+		}
+	}
+	return nil
+}
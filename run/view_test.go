@@ -0,0 +1,148 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/chat"
+	"github.com/xhd2015/kode-ai/providers"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestViewRendersTodos(t *testing.T) {
+	var n int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"todo_write","arguments":"{\"merge\":false,\"todos\":[{\"id\":\"1\",\"content\":\"Write code\",\"status\":\"completed\",\"dependencies\":[]},{\"id\":\"2\",\"content\":\"Run tests\",\"status\":\"pending\",\"dependencies\":[]}]}"}}]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"planned"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recordFile := filepath.Join(dir, "record.json")
+	client, err := chat.NewClient(chat.Config{
+		Model:   "gpt-4o",
+		Token:   "test-token",
+		BaseURL: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := chat.NewCliHandler(client, chat.CliOptions{RecordFile: recordFile})
+	captureStdout(t, func() {
+		err = handler.HandleCli(context.Background(), "plan it", chat.WithTools("todo_write"), chat.WithDefaultToolCwd(dir))
+	})
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+
+	var viewErr error
+	output := captureStdout(t, func() {
+		viewErr = handleViewWithOptions(viewOptions{}, []string{recordFile})
+	})
+	if viewErr != nil {
+		t.Fatalf("view: %v", viewErr)
+	}
+	want := "user: <plan tool=\"todo_write\">\n[x] Write code\n[ ] Run tests\n</plan>\n"
+	if !strings.Contains(output, want) {
+		t.Errorf("expected plan rendered as %q, got %q", want, output)
+	}
+}
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestViewTemplate(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "record.json")
+	err := chat.SaveHistory(recordFile, []types.Message{
+		{Type: types.MsgType_Msg, Role: types.Role_User, Content: "list files"},
+		{Type: types.MsgType_ToolCall, Role: types.Role_Assistant, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"dir":"."}`},
+		{Type: types.MsgType_ToolResult, Role: types.Role_User, ToolName: "list_dir", ToolUseID: "call_1", Content: `{"files":["a.go"]}`},
+		{Type: types.MsgType_Msg, Role: types.Role_Assistant, Content: "a.go, \"main\""},
+		{Type: types.MsgType_TokenUsage, Model: "gpt-4o", TokenUsage: &types.TokenUsage{Input: 100, Output: 20, Total: 120}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cost, ok := providers.ComputeCost(providers.APIShapeOpenAI, "gpt-4o", types.TokenUsage{Input: 100, Output: 20, Total: 120})
+	if !ok {
+		t.Fatal("expected price of gpt-4o")
+	}
+
+	tests := []struct {
+		name      string
+		template  string
+		toolsOnly bool
+		want      string
+	}{
+		{
+			name:     "markdown",
+			template: `{{if eq .Type "msg"}}**{{.Role}}**: {{.Content}}{{"\n"}}{{else if eq .Type "tool_call"}}- {{.ToolName}} {{.Content}}{{"\n"}}{{end}}`,
+			want:     "**user**: list files\n- list_dir {\"dir\":\".\"}\n**assistant**: a.go, \"main\"\n",
+		},
+		{
+			name:     "csv",
+			template: `{{if eq .Type "msg"}}{{.Index}},{{.Role}},{{csv .Content}}{{"\n"}}{{end}}`,
+			want:     "0,user,list files\n3,assistant,\"a.go, \"\"main\"\"\"\n",
+		},
+		{
+			name:     "tokens",
+			template: `{{with .Tokens}}{{.Input}}/{{.Output}}/{{.Total}}{{end}}{{if .CostUSD}} ${{.CostUSD}}{{end}}`,
+			want:     "100/20/120 $" + cost.TotalUSD,
+		},
+		{
+			name:      "tools only",
+			template:  `{{.Type}}:{{.ToolUseID}};`,
+			toolsOnly: true,
+			want:      "tool_call:call_1;tool_result:call_1;",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var viewErr error
+			output := captureStdout(t, func() {
+				viewErr = handleViewWithOptions(viewOptions{template: tt.template, toolsOnly: tt.toolsOnly}, []string{recordFile})
+			})
+			if viewErr != nil {
+				t.Fatalf("view: %v", viewErr)
+			}
+			if output != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, output)
+			}
+		})
+	}
+
+	if err := handleViewWithOptions(viewOptions{template: "{{.Unknown}}"}, []string{recordFile}); err == nil {
+		t.Errorf("expected error of unknown field")
+	}
+}
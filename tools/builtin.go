@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -22,19 +23,27 @@ import (
 	"github.com/xhd2015/llm-tools/tools/send_answer"
 	"github.com/xhd2015/llm-tools/tools/todo_write"
 	"github.com/xhd2015/llm-tools/tools/tree"
-	"github.com/xhd2015/llm-tools/tools/write_file"
-
-	// "github.com/xhd2015/llm-tools/tools/tree"
 	"github.com/xhd2015/llm-tools/tools/web_search"
+	"github.com/xhd2015/llm-tools/tools/write_file"
 )
 
 type ExecutorInfo struct {
 	Name       string
 	Definition defs.ToolDefinition
 	Executor   Executor
+
+	// Destructive tools modify the workspace or run arbitrary commands,
+	// they are excluded from ToolPresetMinimal
+	Destructive bool
 }
 
-// TODO: add tree
+const (
+	// ToolPresetMinimal contains builtin tools that are not destructive
+	ToolPresetMinimal = "minimal"
+	// ToolPresetFull contains all builtin tools
+	ToolPresetFull = "full"
+)
+
 var tools = []*ExecutorInfo{
 	{
 		Name:       "get_workspace_root",
@@ -62,9 +71,10 @@ var tools = []*ExecutorInfo{
 		Executor:   GrepSearchExecutor{},
 	},
 	{
-		Name:       "create_file_with_content",
-		Definition: create_file_with_content.GetToolDefinition(),
-		Executor:   CreateFileWithContentExecutor{},
+		Name:        "create_file_with_content",
+		Definition:  create_file_with_content.GetToolDefinition(),
+		Executor:    CreateFileWithContentExecutor{},
+		Destructive: true,
 	},
 	{
 		Name:       "read_file",
@@ -72,24 +82,28 @@ var tools = []*ExecutorInfo{
 		Executor:   ReadFileExecutor{},
 	},
 	{
-		Name:       "write_file",
-		Definition: write_file.GetToolDefinition(),
-		Executor:   WriteFileExecutor{},
+		Name:        "write_file",
+		Definition:  write_file.GetToolDefinition(),
+		Executor:    WriteFileExecutor{},
+		Destructive: true,
 	},
 	{
-		Name:       "rename_file",
-		Definition: rename_file.GetToolDefinition(),
-		Executor:   RenameFileExecutor{},
+		Name:        "rename_file",
+		Definition:  rename_file.GetToolDefinition(),
+		Executor:    RenameFileExecutor{},
+		Destructive: true,
 	},
 	{
-		Name:       "delete_file",
-		Definition: delete_file.GetToolDefinition(),
-		Executor:   DeleteFileExecutor{},
+		Name:        "delete_file",
+		Definition:  delete_file.GetToolDefinition(),
+		Executor:    DeleteFileExecutor{},
+		Destructive: true,
 	},
 	{
-		Name:       "search_replace",
-		Definition: search_replace.GetToolDefinition(),
-		Executor:   SearchReplaceExecutor{},
+		Name:        "search_replace",
+		Definition:  search_replace.GetToolDefinition(),
+		Executor:    SearchReplaceExecutor{},
+		Destructive: true,
 	},
 	{
 		Name:       "send_answer",
@@ -97,14 +111,16 @@ var tools = []*ExecutorInfo{
 		Executor:   SendAnswerExecutor{},
 	},
 	{
-		Name:       "run_terminal_cmd",
-		Definition: run_terminal_cmd.GetToolDefinition(),
-		Executor:   RunTerminalCmdExecutor{},
+		Name:        "run_terminal_cmd",
+		Definition:  run_terminal_cmd.GetToolDefinition(),
+		Executor:    RunTerminalCmdExecutor{},
+		Destructive: true,
 	},
 	{
-		Name:       "run_bash_script",
-		Definition: run_bash_script.GetToolDefinition(),
-		Executor:   RunBashScriptExecutor{},
+		Name:        "run_bash_script",
+		Definition:  run_bash_script.GetToolDefinition(),
+		Executor:    RunBashScriptExecutor{},
+		Destructive: true,
 	},
 	{
 		Name:       "file_search",
@@ -121,6 +137,11 @@ var tools = []*ExecutorInfo{
 		Definition: web_search.GetToolDefinition(),
 		Executor:   WebSearchExecutor{},
 	},
+	{
+		Name:       "get_conversation_summary",
+		Definition: getConversationSummaryDefinition(),
+		Executor:   GetConversationSummaryExecutor{},
+	},
 }
 
 func GetExecutor(toolName string) Executor {
@@ -131,13 +152,44 @@ func GetExecutor(toolName string) Executor {
 	return toolInfo.Executor
 }
 
+// IsDestructive reports whether toolName is a builtin tool that modifies
+// the workspace or runs arbitrary commands
+func IsDestructive(toolName string) bool {
+	toolInfo := toolMapping[toolName]
+	return toolInfo != nil && toolInfo.Destructive
+}
+
 type ExecuteOptions struct {
 	DefaultWorkspaceRoot string
 	EventCallback        types.EventCallback
+
+	// Sandbox rejects file tool paths resolving outside DefaultWorkspaceRoot
+	Sandbox bool
+
+	// Conversation reads the state of the running conversation, nil outside a chat
+	Conversation func() ConversationSummary
 }
 
+// Executor executes a builtin tool, commands it runs are killed once ctx is done
 type Executor interface {
-	Execute(arguments string, opts ExecuteOptions) (interface{}, error)
+	Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error)
+}
+
+// GetPresetToolNames returns the names of builtin tools in preset
+func GetPresetToolNames(preset string) ([]string, error) {
+	switch preset {
+	case ToolPresetFull:
+		return append([]string(nil), allTools...), nil
+	case ToolPresetMinimal:
+		var names []string
+		for _, tool := range tools {
+			if !tool.Destructive {
+				names = append(names, tool.Name)
+			}
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("unrecognized tool preset: %s, available: %s, %s", preset, ToolPresetMinimal, ToolPresetFull)
 }
 
 func GetBuiltinTools(toolBuiltins []string) ([]*UnifiedTool, error) {
@@ -186,14 +238,14 @@ func getBuiltinTools(builtinTools []string) ([]*UnifiedTool, error) {
 type GetWorkspaceRootExecutor struct {
 }
 
-func (e GetWorkspaceRootExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e GetWorkspaceRootExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	return get_workspace_root.GetWorkspaceRoot(get_workspace_root.GetWorkspaceRootRequest{}, opts.DefaultWorkspaceRoot)
 }
 
 type BatchReadFileExecutor struct {
 }
 
-func (e BatchReadFileExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e BatchReadFileExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := batch_read_file.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -201,13 +253,24 @@ func (e BatchReadFileExecutor) Execute(arguments string, opts ExecuteOptions) (i
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, batchReadFilePaths(req)...); violation != nil {
+		return violation, nil
+	}
 	return batch_read_file.BatchReadFile(req)
 }
 
+func batchReadFilePaths(req batch_read_file.BatchReadFileRequest) []string {
+	paths := make([]string, 0, len(req.Files))
+	for _, file := range req.Files {
+		paths = append(paths, file.TargetFile)
+	}
+	return paths
+}
+
 type ListDirExecutor struct {
 }
 
-func (e ListDirExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e ListDirExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := list_dir.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -215,13 +278,16 @@ func (e ListDirExecutor) Execute(arguments string, opts ExecuteOptions) (interfa
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.RelativeWorkspacePath); violation != nil {
+		return violation, nil
+	}
 	return list_dir.ListDir(req)
 }
 
 type RunTerminalCmdExecutor struct {
 }
 
-func (e RunTerminalCmdExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e RunTerminalCmdExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := run_terminal_cmd.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -229,7 +295,7 @@ func (e RunTerminalCmdExecutor) Execute(arguments string, opts ExecuteOptions) (
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
-	return run_terminal_cmd.RunTerminalCmd(req)
+	return runTerminalCmd(ctx, req)
 }
 
 type RunBashScriptExecutor struct {
@@ -242,7 +308,7 @@ func (RunBashScriptExecutor) ToolName() string {
 // to be aware of bash script execution traces and errors
 const _SETUP_BASH_TRAP = false
 
-func (e RunBashScriptExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e RunBashScriptExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := run_bash_script.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -274,7 +340,7 @@ func (e RunBashScriptExecutor) Execute(arguments string, opts ExecuteOptions) (i
 		}
 	}
 
-	return run_bash_script.RunBashScript(req)
+	return runBashScript(ctx, req)
 }
 
 func joinDir(workspaceRoot, dir string) string {
@@ -293,7 +359,7 @@ func joinDir(workspaceRoot, dir string) string {
 type TreeExecutor struct {
 }
 
-func (e TreeExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e TreeExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := tree.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -301,13 +367,16 @@ func (e TreeExecutor) Execute(arguments string, opts ExecuteOptions) (interface{
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.RelativeWorkspacePath); violation != nil {
+		return violation, nil
+	}
 	return tree.ExecuteTree(req)
 }
 
 type GrepSearchExecutor struct {
 }
 
-func (e GrepSearchExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e GrepSearchExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := grep_search.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -315,13 +384,16 @@ func (e GrepSearchExecutor) Execute(arguments string, opts ExecuteOptions) (inte
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.RelativePathToSearch); violation != nil {
+		return violation, nil
+	}
 	return grep_search.GrepSearch(req)
 }
 
 type CreateFileWithContentExecutor struct {
 }
 
-func (e CreateFileWithContentExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e CreateFileWithContentExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := create_file_with_content.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -329,13 +401,16 @@ func (e CreateFileWithContentExecutor) Execute(arguments string, opts ExecuteOpt
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.TargetFile); violation != nil {
+		return violation, nil
+	}
 	return create_file_with_content.CreateFileWithContent(req)
 }
 
 type ReadFileExecutor struct {
 }
 
-func (e ReadFileExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e ReadFileExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := read_file.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -343,13 +418,16 @@ func (e ReadFileExecutor) Execute(arguments string, opts ExecuteOptions) (interf
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.TargetFile); violation != nil {
+		return violation, nil
+	}
 	return read_file.ReadFile(req)
 }
 
 type WriteFileExecutor struct {
 }
 
-func (e WriteFileExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e WriteFileExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := write_file.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -357,24 +435,30 @@ func (e WriteFileExecutor) Execute(arguments string, opts ExecuteOptions) (inter
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.TargetFile); violation != nil {
+		return violation, nil
+	}
 	return write_file.WriteFile(req)
 }
 
 type SearchReplaceExecutor struct {
 }
 
-func (e SearchReplaceExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e SearchReplaceExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := search_replace.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
 	}
+	if violation := checkSandbox(opts, opts.DefaultWorkspaceRoot, req.File); violation != nil {
+		return violation, nil
+	}
 	return search_replace.SearchReplace(req, opts.DefaultWorkspaceRoot)
 }
 
 type SendAnswerExecutor struct {
 }
 
-func (e SendAnswerExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e SendAnswerExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := send_answer.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -385,7 +469,7 @@ func (e SendAnswerExecutor) Execute(arguments string, opts ExecuteOptions) (inte
 type FileSearchExecutor struct {
 }
 
-func (e FileSearchExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e FileSearchExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := file_search.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -393,13 +477,16 @@ func (e FileSearchExecutor) Execute(arguments string, opts ExecuteOptions) (inte
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot); violation != nil {
+		return violation, nil
+	}
 	return file_search.FileSearch(req)
 }
 
 type TodoWriteExecutor struct {
 }
 
-func (e TodoWriteExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e TodoWriteExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := todo_write.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -407,13 +494,16 @@ func (e TodoWriteExecutor) Execute(arguments string, opts ExecuteOptions) (inter
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.TodoFilePath); violation != nil {
+		return violation, nil
+	}
 	return todo_write.TodoWrite(req)
 }
 
 type WebSearchExecutor struct {
 }
 
-func (e WebSearchExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e WebSearchExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := web_search.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -424,7 +514,7 @@ func (e WebSearchExecutor) Execute(arguments string, opts ExecuteOptions) (inter
 type RenameFileExecutor struct {
 }
 
-func (e RenameFileExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e RenameFileExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := rename_file.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -432,13 +522,16 @@ func (e RenameFileExecutor) Execute(arguments string, opts ExecuteOptions) (inte
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.SourceFile, req.TargetFile); violation != nil {
+		return violation, nil
+	}
 	return rename_file.RenameFile(req)
 }
 
 type DeleteFileExecutor struct {
 }
 
-func (e DeleteFileExecutor) Execute(arguments string, opts ExecuteOptions) (interface{}, error) {
+func (e DeleteFileExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
 	req, err := delete_file.ParseJSONRequest(arguments)
 	if err != nil {
 		return nil, fmt.Errorf("parse args: %v", err)
@@ -446,5 +539,8 @@ func (e DeleteFileExecutor) Execute(arguments string, opts ExecuteOptions) (inte
 	if req.WorkspaceRoot == "" && opts.DefaultWorkspaceRoot != "" {
 		req.WorkspaceRoot = opts.DefaultWorkspaceRoot
 	}
+	if violation := checkSandbox(opts, req.WorkspaceRoot, req.TargetFile); violation != nil {
+		return violation, nil
+	}
 	return delete_file.DeleteFile(req)
 }
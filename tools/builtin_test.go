@@ -0,0 +1,41 @@
+package tools
+
+import "testing"
+
+func TestPresetMinimalExcludesDestructive(t *testing.T) {
+	names, err := GetPresetToolNames(ToolPresetMinimal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	included := make(map[string]bool, len(names))
+	for _, name := range names {
+		included[name] = true
+	}
+	destructive := []string{"write_file", "create_file_with_content", "delete_file", "rename_file", "search_replace", "run_terminal_cmd", "run_bash_script"}
+	for _, name := range destructive {
+		if included[name] {
+			t.Errorf("expected minimal preset to exclude %s", name)
+		}
+	}
+	for _, name := range []string{"read_file", "list_dir", "tree", "grep_search"} {
+		if !included[name] {
+			t.Errorf("expected minimal preset to include %s", name)
+		}
+	}
+	if _, err := GetBuiltinTools(names); err != nil {
+		t.Errorf("minimal preset: %v", err)
+	}
+}
+
+func TestPresetFull(t *testing.T) {
+	names, err := GetPresetToolNames(ToolPresetFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(tools) {
+		t.Errorf("expected %d tools, got %d", len(tools), len(names))
+	}
+	if _, err := GetPresetToolNames("unknown"); err == nil {
+		t.Errorf("expected error for unknown preset")
+	}
+}
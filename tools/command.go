@@ -0,0 +1,325 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/run_bash_script"
+	"github.com/xhd2015/llm-tools/tools/run_terminal_cmd"
+)
+
+const (
+	// bashScriptTimeout kills a bash script running longer
+	bashScriptTimeout = 30 * time.Second
+	// maxBashOutputLen truncates the output of a bash script
+	maxBashOutputLen = 3612
+	// commandWaitDelay is how long the output of a killed command is
+	// waited for, processes it started may still hold the pipes open
+	commandWaitDelay = time.Second
+)
+
+// runTerminalCmd is run_terminal_cmd.RunTerminalCmd with a foreground command
+// killed once ctx is done. A background command outlives the call, so it is not
+func runTerminalCmd(ctx context.Context, req run_terminal_cmd.RunTerminalCmdRequest) (*run_terminal_cmd.RunTerminalCmdResponse, error) {
+	if req.IsBackground {
+		return run_terminal_cmd.RunTerminalCmd(req)
+	}
+	if req.Command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		workingDir = "unknown"
+	}
+	shell := terminalShell()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", req.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, shell, "-c", req.Command)
+	}
+	cmd.Dir = workingDir
+
+	startTime := time.Now()
+	output, err := runCommand(cmd)
+	response := &run_terminal_cmd.RunTerminalCmdResponse{
+		Command:       req.Command,
+		CommandOutput: strings.TrimRight(output, "\n"),
+		WorkingDir:    workingDir,
+		Duration:      time.Since(startTime).String(),
+		ShellInfo:     fmt.Sprintf("Command completed, shell: %s, directory: %s", filepath.Base(shell), workingDir),
+	}
+	if err != nil {
+		response.Error = commandError(ctx, err)
+		response.ExitCode = exitCode(err)
+	}
+	return response, nil
+}
+
+// runBashScript is run_bash_script.RunBashScript with the script killed once
+// ctx is done, or after bashScriptTimeout
+func runBashScript(ctx context.Context, req run_bash_script.RunBashScriptRequest) (*run_bash_script.RunBashScriptResponse, error) {
+	if req.Script == "" {
+		return nil, fmt.Errorf("requires script")
+	}
+	cleanOutput := req.CleanOutput != nil && *req.CleanOutput
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, bashScriptTimeout)
+	defer cancel()
+
+	traps := &scriptTraps{}
+	defer traps.close()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(timeoutCtx, "cmd", "/C", req.Script)
+	} else {
+		// -e: exit on error
+		// -o pipefail: exit on error in a pipeline
+		setups := []string{"set -eo pipefail"}
+		if cleanOutput {
+			setups = append(setups, lsWithoutLongFormat)
+		}
+		if req.TrapCommandError != nil {
+			setup, err := traps.add(trapCommandError, req.TrapCommandError)
+			if err != nil {
+				return nil, err
+			}
+			setups = append(setups, setup)
+		}
+		if req.TrapRunCommand != nil {
+			setup, err := traps.add(trapDebugCommand, req.TrapRunCommand)
+			if err != nil {
+				return nil, err
+			}
+			setups = append(setups, setup)
+		}
+		cmd = exec.CommandContext(timeoutCtx, "bash", "-c", strings.Join(setups, "\n")+"\n"+req.Script)
+	}
+	cmd.Dir = req.Cwd
+	cmd.Env = append(os.Environ(), "NO_COLOR=1")
+	cmd.ExtraFiles = traps.writers
+
+	startTime := time.Now()
+	output, err := runCommand(cmd)
+	response := &run_bash_script.RunBashScriptResponse{}
+	if duration := time.Since(startTime); duration > time.Second {
+		response.Duration = duration.String()
+	}
+	if err != nil {
+		if ctx.Err() == nil && timeoutCtx.Err() != nil {
+			response.Hint = fmt.Sprintf("script timed out after %v, try to adjust the script with smaller scope", bashScriptTimeout)
+		}
+		response.Error = commandError(ctx, err)
+		response.ExitCode = exitCode(err)
+	}
+
+	if cleanOutput {
+		output = compactJSON(output)
+	}
+	if runes := []rune(output); len(runes) > maxBashOutputLen+3 {
+		response.Hint = appendHint(response.Hint, fmt.Sprintf("output is truncated to %d, original len %d is too large, use proper tool to iteratively inspect the content", maxBashOutputLen, len(output)))
+		output = string(runes[:maxBashOutputLen]) + "..."
+	}
+	response.Output = output
+	return response, nil
+}
+
+// scriptTraps reports the lines bash traps write to extra files,
+// the first extra file is fd 3 in the script
+type scriptTraps struct {
+	wg      sync.WaitGroup
+	readers []*os.File
+	writers []*os.File
+}
+
+// add creates the extra file for a trap, returns its setup
+func (c *scriptTraps) add(getSetup func(fd int) string, onLine func(line string)) (string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to create pipe: %w", err)
+	}
+	c.readers = append(c.readers, reader)
+	c.writers = append(c.writers, writer)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			onLine(scanner.Text())
+		}
+	}()
+	return getSetup(2 + len(c.writers)), nil
+}
+
+// close waits for the traps to be reported, processes the script
+// started may still hold the extra files open, so the wait is
+// bounded by commandWaitDelay like the output
+func (c *scriptTraps) close() {
+	for _, writer := range c.writers {
+		writer.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(commandWaitDelay):
+	}
+	for _, reader := range c.readers {
+		reader.Close()
+	}
+	<-done
+}
+
+func trapDebugCommand(fd int) string {
+	return fmt.Sprintf(`trap 'echo "$BASH_COMMAND" >&%d' DEBUG`, fd)
+}
+
+func trapCommandError(fd int) string {
+	return fmt.Sprintf(`trap 'echo "$BASH_COMMAND; EXIT_CODE=$?" >&%d' ERR`, fd)
+}
+
+// runCommand runs cmd, created by exec.CommandContext, and returns
+// its output with lines from stderr prefixed by "STDERR: "
+func runCommand(cmd *exec.Cmd) (string, error) {
+	var mutex sync.Mutex
+	var output strings.Builder
+	stdout := &lineWriter{mutex: &mutex, output: &output}
+	stderr := &lineWriter{mutex: &mutex, output: &output, prefix: "STDERR: "}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = commandWaitDelay
+
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	return output.String(), err
+}
+
+// lineWriter writes complete lines to output, each with prefix
+type lineWriter struct {
+	mutex  *sync.Mutex
+	output *strings.Builder
+	prefix string
+	line   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		w.output.WriteString(w.prefix)
+		w.output.Write(w.line[:i+1])
+		w.line = w.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush writes the last line not ended by a newline
+func (w *lineWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.line) > 0 {
+		w.output.WriteString(w.prefix)
+		w.output.Write(w.line)
+		w.output.WriteString("\n")
+		w.line = nil
+	}
+}
+
+func commandError(ctx context.Context, err error) string {
+	if ctx.Err() != nil {
+		return fmt.Sprintf("killed: %v", ctx.Err())
+	}
+	return err.Error()
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+func appendHint(hint string, s string) string {
+	if hint == "" {
+		return s
+	}
+	return hint + "\n" + s
+}
+
+// terminalShell is the shell run_terminal_cmd runs commands with
+func terminalShell() string {
+	if runtime.GOOS == "windows" {
+		return "cmd"
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	switch runtime.GOOS {
+	case "darwin", "linux":
+		return "/bin/bash"
+	}
+	return "/bin/sh"
+}
+
+// compactJSON removes the whitespaces of output if it is JSON
+func compactJSON(output string) string {
+	trimmed := strings.TrimSpace(output)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return output
+	}
+	var data interface{}
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return output
+	}
+	compacted, err := json.Marshal(data)
+	if err != nil || len(compacted) >= len(output) {
+		return output
+	}
+	return string(compacted)
+}
+
+// lsWithoutLongFormat drops -l from ls in a bash script, the long format costs tokens
+const lsWithoutLongFormat = `shopt -s expand_aliases
+ls_without_l() {
+    local args=()
+    for arg in "$@"; do
+        if [[ "$arg" == "-l" ]]; then
+            continue
+        elif [[ "$arg" =~ ^-.*l.*$ ]]; then
+            local new_arg="${arg//l/}"
+            if [[ "$new_arg" != "-" && -n "$new_arg" ]]; then
+                args+=("$new_arg")
+            fi
+        else
+            args+=("$arg")
+        fi
+    done
+    command ls "${args[@]}"
+}
+alias ls='ls_without_l'`
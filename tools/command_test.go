@@ -0,0 +1,115 @@
+//go:build !windows
+
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/llm-tools/tools/run_bash_script"
+	"github.com/xhd2015/llm-tools/tools/run_terminal_cmd"
+)
+
+func TestCommandKilledOnCancel(t *testing.T) {
+	tests := []struct {
+		name     string
+		executor Executor
+		args     func(script string) interface{}
+	}{
+		{"run_terminal_cmd", RunTerminalCmdExecutor{}, func(script string) interface{} {
+			return run_terminal_cmd.RunTerminalCmdRequest{Command: script}
+		}},
+		{"run_bash_script", RunBashScriptExecutor{}, func(script string) interface{} {
+			return run_bash_script.RunBashScriptRequest{Script: script}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pid")
+			args, err := json.Marshal(tt.args("echo $$ > " + pidFile + "; exec sleep 30"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				// cancel once the command is running
+				for {
+					if data, err := os.ReadFile(pidFile); err == nil && strings.HasSuffix(string(data), "\n") {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				cancel()
+			}()
+
+			start := time.Now()
+			res, err := tt.executor.Execute(ctx, string(args), ExecuteOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("expected return soon after cancel, took %v", elapsed)
+			}
+			data, err := json.Marshal(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), "killed: context canceled") {
+				t.Errorf("expected killed error, got %s", data)
+			}
+
+			content, err := os.ReadFile(pidFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+				t.Errorf("expected process %d killed, got %v", pid, err)
+			}
+		})
+	}
+}
+
+func TestBashScriptWithTrapsKilledOnCancel(t *testing.T) {
+	var mutex sync.Mutex
+	var commands []string
+	req := run_bash_script.RunBashScriptRequest{Script: "echo started\nsleep 30"}
+	req.TrapRunCommand = func(line string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		commands = append(commands, line)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	res, err := runBashScript(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected return soon after cancel, took %v", elapsed)
+	}
+	if !strings.Contains(res.Error, "killed: context deadline exceeded") {
+		t.Errorf("expected killed error, got %q", res.Error)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if strings.Join(commands, "\n") != "echo started\nsleep 30" {
+		t.Errorf("expected the trapped commands, got %q", commands)
+	}
+}
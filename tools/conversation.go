@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xhd2015/kode-ai/types"
+	"github.com/xhd2015/llm-tools/jsonschema"
+	"github.com/xhd2015/llm-tools/tools/defs"
+)
+
+// ConversationSummary is the state of the running conversation
+type ConversationSummary struct {
+	// Rounds is the number of model requests so far, including the current one
+	Rounds int `json:"rounds"`
+	// TokenUsage includes the response whose tool calls are being executed
+	TokenUsage types.TokenUsage `json:"token_usage"`
+	// CostUSD is empty if the price of the model is unknown
+	CostUSD string `json:"cost_usd,omitempty"`
+	// ToolCalls are the names of the tools executed so far, in order
+	ToolCalls []string `json:"tool_calls"`
+}
+
+func getConversationSummaryDefinition() defs.ToolDefinition {
+	return defs.ToolDefinition{
+		Description: `Get the state of the current conversation: token usage, cost, rounds and the tools called so far. Use it to keep within the budget.`,
+		Name:        "get_conversation_summary",
+		Parameters: &jsonschema.JsonSchema{
+			Type: jsonschema.ParamTypeObject,
+			Properties: map[string]*jsonschema.JsonSchema{
+				"explanation": {
+					Type:        jsonschema.ParamTypeString,
+					Description: "brief explanation",
+				},
+			},
+		},
+	}
+}
+
+type GetConversationSummaryExecutor struct {
+}
+
+func (e GetConversationSummaryExecutor) Execute(ctx context.Context, arguments string, opts ExecuteOptions) (interface{}, error) {
+	if opts.Conversation == nil {
+		return nil, fmt.Errorf("no conversation state, only available in a chat")
+	}
+	return opts.Conversation(), nil
+}
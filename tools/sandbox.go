@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SandboxViolation is returned to the model in place of the result of a
+// file tool whose path resolves outside the workspace root
+type SandboxViolation struct {
+	Error         string `json:"error"`
+	Path          string `json:"path"`
+	WorkspaceRoot string `json:"workspace_root"`
+}
+
+// checkSandbox returns a violation if workspaceRoot, or any of paths relative to it,
+// resolves outside opts.DefaultWorkspaceRoot. It returns nil if opts.Sandbox is off
+func checkSandbox(opts ExecuteOptions, workspaceRoot string, paths ...string) *SandboxViolation {
+	if !opts.Sandbox {
+		return nil
+	}
+	sandboxRoot := opts.DefaultWorkspaceRoot
+	if sandboxRoot == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return &SandboxViolation{Error: fmt.Sprintf("sandbox: get working directory: %v", err)}
+		}
+		sandboxRoot = wd
+	}
+	root, err := resolvePath(sandboxRoot)
+	if err != nil {
+		return &SandboxViolation{Error: fmt.Sprintf("sandbox: %v", err), WorkspaceRoot: sandboxRoot}
+	}
+	if workspaceRoot == "" {
+		workspaceRoot = sandboxRoot
+	}
+
+	for _, path := range append([]string{""}, paths...) {
+		resolved, err := resolvePath(joinDir(workspaceRoot, path))
+		if err != nil {
+			return &SandboxViolation{Error: fmt.Sprintf("sandbox: %v", err), Path: path, WorkspaceRoot: sandboxRoot}
+		}
+		if !isWithinDir(root, resolved) {
+			if path == "" {
+				path = workspaceRoot
+			}
+			return &SandboxViolation{
+				Error:         fmt.Sprintf("sandbox: %s resolves outside the workspace root, only paths within %s are allowed", path, sandboxRoot),
+				Path:          path,
+				WorkspaceRoot: sandboxRoot,
+			}
+		}
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path with symlinks of its
+// longest existing prefix resolved, so a link cannot escape
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	existing := abs
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+}
+
+func isWithinDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "workspace")
+	outside := filepath.Join(dir, "secret.txt")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ExecuteOptions{DefaultWorkspaceRoot: root, Sandbox: true}
+	tests := []struct {
+		name     string
+		executor Executor
+		args     map[string]interface{}
+		blocked  bool
+	}{
+		{"inside", ReadFileExecutor{}, map[string]interface{}{"target_file": "a.txt", "should_read_entire_file": true}, false},
+		{"traversal", ReadFileExecutor{}, map[string]interface{}{"target_file": "../secret.txt", "should_read_entire_file": true}, true},
+		{"absolute path", ReadFileExecutor{}, map[string]interface{}{"target_file": outside, "should_read_entire_file": true}, true},
+		{"symlink", ReadFileExecutor{}, map[string]interface{}{"target_file": "link/secret.txt", "should_read_entire_file": true}, true},
+		{"workspace root", ReadFileExecutor{}, map[string]interface{}{"workspace_root": dir, "target_file": "secret.txt", "should_read_entire_file": true}, true},
+		{"write traversal", WriteFileExecutor{}, map[string]interface{}{"target_file": "../new.txt", "content": "x"}, true},
+		{"delete absolute path", DeleteFileExecutor{}, map[string]interface{}{"target_file": outside}, true},
+		{"rename target", RenameFileExecutor{}, map[string]interface{}{"source_file": "a.txt", "target_file": "../a.txt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := json.Marshal(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := tt.executor.Execute(context.Background(), string(args), opts)
+			violation, blocked := res.(*SandboxViolation)
+			if blocked != tt.blocked {
+				t.Fatalf("expected blocked %v, got %v: %v %v", tt.blocked, blocked, res, err)
+			}
+			if blocked && (violation.Error == "" || violation.WorkspaceRoot != root) {
+				t.Errorf("expected violation with error and workspace root, got %+v", violation)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(outside)
+	if err != nil || string(data) != "secret" {
+		t.Errorf("expected file outside untouched, got %q %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected no file written outside, got %v", err)
+	}
+}
+
+func TestSandboxDisabled(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "workspace")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ReadFileExecutor{}.Execute(context.Background(), `{"target_file":"../secret.txt","should_read_entire_file":true}`, ExecuteOptions{DefaultWorkspaceRoot: root})
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, blocked := res.(*SandboxViolation); blocked {
+		t.Errorf("expected no sandbox by default")
+	}
+}
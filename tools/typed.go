@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/xhd2015/kode-ai/internal/jsondecode"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+// TypedResult is a tool result with content of type T
+type TypedResult[T any] struct {
+	Content T
+	Error   string
+}
+
+// ToolResult converts r to a types.ToolResult. A string content is kept as is,
+// other content is marshaled to JSON here so it is sent the same way by every caller
+func (r TypedResult[T]) ToolResult() (types.ToolResult, error) {
+	if s, ok := any(r.Content).(string); ok {
+		return types.ToolResult{Content: s, Error: r.Error}, nil
+	}
+	data, err := json.Marshal(r.Content)
+	if err != nil {
+		return types.ToolResult{}, fmt.Errorf("marshal result: %w", err)
+	}
+	return types.ToolResult{Content: json.RawMessage(data), Error: r.Error}, nil
+}
+
+// DecodeArgs decodes the arguments of call into A
+func DecodeArgs[A any](call types.ToolCall) (A, error) {
+	var args A
+	data := []byte(call.RawArgs)
+	if len(data) == 0 {
+		var err error
+		data, err = json.Marshal(call.Arguments)
+		if err != nil {
+			return args, fmt.Errorf("marshal args: %w", err)
+		}
+	}
+	if err := jsondecode.UnmarshalSafe(data, &args); err != nil {
+		return args, fmt.Errorf("parse args %s: %w", call.Name, err)
+	}
+	return args, nil
+}
+
+// TypedHandler adapts a function taking arguments of type A and returning a
+// TypedResult[R] to a handler of a types.ToolCall
+func TypedHandler[A any, R any](fn func(ctx context.Context, args A) (TypedResult[R], error)) func(ctx context.Context, call types.ToolCall) (types.ToolResult, error) {
+	return func(ctx context.Context, call types.ToolCall) (types.ToolResult, error) {
+		args, err := DecodeArgs[A](call)
+		if err != nil {
+			return types.ToolResult{}, err
+		}
+		result, err := fn(ctx, args)
+		if err != nil {
+			return types.ToolResult{}, err
+		}
+		return result.ToolResult()
+	}
+}
+
+// TypedCallback returns a tool callback handling calls of the tool name with fn,
+// calls of other tools are left unhandled
+func TypedCallback[A any, R any](name string, fn func(ctx context.Context, args A) (TypedResult[R], error)) types.ToolCallback {
+	handle := TypedHandler(fn)
+	return func(ctx context.Context, stream types.StreamContext, call types.ToolCall) (types.ToolResult, bool, error) {
+		if call.Name != name {
+			return types.ToolResult{}, false, nil
+		}
+		result, err := handle(ctx, call)
+		return result, true, err
+	}
+}
@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestTypedResult(t *testing.T) {
+	result, err := TypedResult[string]{Content: "done"}.ToolResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "done" {
+		t.Errorf("expected string content kept as is, got %#v", result.Content)
+	}
+
+	type files struct {
+		Files []string `json:"files"`
+	}
+	result, err = TypedResult[files]{Content: files{Files: []string{"a.go"}}}.ToolResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(result.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"files":["a.go"]}` {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
+
+func TestDecodeArgs(t *testing.T) {
+	type args struct {
+		Path  string `json:"path"`
+		Limit int    `json:"limit"`
+	}
+	// RawArgs is preferred, Arguments is the fallback
+	for _, call := range []types.ToolCall{
+		{Name: "read", RawArgs: `{"path":"a.go","limit":3}`},
+		{Name: "read", Arguments: map[string]interface{}{"path": "a.go", "limit": 3}},
+	} {
+		got, err := DecodeArgs[args](call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != (args{Path: "a.go", Limit: 3}) {
+			t.Errorf("unexpected args: %+v", got)
+		}
+	}
+	if _, err := DecodeArgs[args](types.ToolCall{Name: "read", RawArgs: `{"limit":"x"}`}); err == nil {
+		t.Errorf("expected error decoding invalid args")
+	}
+}
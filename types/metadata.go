@@ -14,6 +14,81 @@ type StreamResponseToolMetadata struct {
 type CacheInfoMetadata struct {
 	CacheEnabled bool   `json:"cache_enabled"`
 	Model        string `json:"model,omitempty"`
+
+	// PrefixHash is the hash of the system prompt and tools
+	PrefixHash string `json:"prefix_hash,omitempty"`
+	// PrefixChanged reports PrefixHash differs from the last run in history,
+	// which makes the prompt cache miss
+	PrefixChanged bool `json:"prefix_changed,omitempty"`
+}
+
+// ToolCallMetadata represents metadata for tool_call events
+type ToolCallMetadata struct {
+	// Partial is set on preview events carrying the arguments received so far,
+	// the complete tool call follows as a normal event
+	Partial bool `json:"partial,omitempty"`
+	// Arguments are the parsed arguments of a complete tool call,
+	// nil when the arguments are malformed
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// FingerprintMetadata identifies the backend configuration that produced
+// an assistant msg or tool_call event, see Request.Seed
+type FingerprintMetadata struct {
+	SystemFingerprint string `json:"system_fingerprint"`
+}
+
+// LogProbsMetadata represents token log probabilities of an assistant msg event
+type LogProbsMetadata struct {
+	Tokens []TokenLogProb `json:"tokens"`
+}
+
+type TokenLogProb struct {
+	Token       string         `json:"token"`
+	LogProb     float64        `json:"log_prob"`
+	TopLogProbs []TokenLogProb `json:"top_log_probs,omitempty"`
+}
+
+// CitationsMetadata represents the document passages an assistant msg event cites
+type CitationsMetadata struct {
+	Citations []Citation `json:"citations"`
+}
+
+type Citation struct {
+	// Type is one of char_location(text), page_location(PDF) or content_block_location
+	Type          string `json:"type"`
+	CitedText     string `json:"cited_text"`
+	DocumentIndex int    `json:"document_index"`
+	DocumentTitle string `json:"document_title,omitempty"`
+
+	StartCharIndex  int `json:"start_char_index,omitempty"`
+	EndCharIndex    int `json:"end_char_index,omitempty"`
+	StartPageNumber int `json:"start_page_number,omitempty"`
+	EndPageNumber   int `json:"end_page_number,omitempty"`
+	StartBlockIndex int `json:"start_block_index,omitempty"`
+	EndBlockIndex   int `json:"end_block_index,omitempty"`
+}
+
+// TodosMetadata represents the whole plan after a todo_write tool_result event
+type TodosMetadata struct {
+	Todos []TodoItem `json:"todos"`
+}
+
+type TodoItem struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+	// Status is one of pending, in_progress, completed or cancelled
+	Status string `json:"status"`
+}
+
+// SummaryMetadata represents metadata for summary events, the totals of a chat
+type SummaryMetadata struct {
+	TokenUsage       TokenUsage `json:"token_usage"`
+	Cost             *TokenCost `json:"cost,omitempty"`
+	Rounds           int        `json:"rounds"`
+	NumToolCalls     int        `json:"num_tool_calls"`
+	StopReason       string     `json:"stop_reason,omitempty"`
+	LastAssistantMsg string     `json:"last_assistant_response,omitempty"`
 }
 
 type RoundStartMetadata struct {
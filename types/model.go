@@ -45,6 +45,9 @@ const (
 	ModelKimiK2              = "kimi-k2"
 	ModelKimiK2_0711_Preview = "kimi-k2-0711-preview"
 	ModelOpenRouterKimiK2    = "moonshotai/kimi-k2"
+	ModelMoonshotV1_8K       = "moonshot-v1-8k"
+	ModelMoonshotV1_32K      = "moonshot-v1-32k"
+	ModelMoonshotV1_128K     = "moonshot-v1-128k"
 
 	ModelDeepSeekR1          = "DeepSeek-R1"
 	ModelQwen25VL72BInstruct = "Qwen2.5-VL-72B-Instruct"
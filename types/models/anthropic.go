@@ -3,9 +3,10 @@ package models
 // AnthropicModels contains all Anthropic model definitions
 var AnthropicModels = map[string]ModelInfo{
 	"claude-3-7-sonnet": {
-		Name:     "claude-3-7-sonnet",
-		Provider: ProviderAnthropic,
-		APIShape: APIShapeAnthropic,
+		Name:      "claude-3-7-sonnet",
+		Provider:  ProviderAnthropic,
+		APIShape:  APIShapeAnthropic,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:           "3.00",
 			InputCacheWriteUSDPer1M: "3.75",
@@ -14,9 +15,10 @@ var AnthropicModels = map[string]ModelInfo{
 		},
 	},
 	"claude-3-7-sonnet@20250219": {
-		Name:     "claude-3-7-sonnet@20250219",
-		Provider: ProviderAnthropic,
-		APIShape: APIShapeAnthropic,
+		Name:      "claude-3-7-sonnet@20250219",
+		Provider:  ProviderAnthropic,
+		APIShape:  APIShapeAnthropic,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:           "3.00",
 			InputCacheWriteUSDPer1M: "3.75",
@@ -25,9 +27,10 @@ var AnthropicModels = map[string]ModelInfo{
 		},
 	},
 	"claude-sonnet-4": {
-		Name:     "claude-sonnet-4",
-		Provider: ProviderAnthropic,
-		APIShape: APIShapeAnthropic,
+		Name:      "claude-sonnet-4",
+		Provider:  ProviderAnthropic,
+		APIShape:  APIShapeAnthropic,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:           "3.00",
 			InputCacheWriteUSDPer1M: "3.75",
@@ -36,9 +39,10 @@ var AnthropicModels = map[string]ModelInfo{
 		},
 	},
 	"claude-sonnet-4@20250514": {
-		Name:     "claude-sonnet-4@20250514",
-		Provider: ProviderAnthropic,
-		APIShape: APIShapeAnthropic,
+		Name:      "claude-sonnet-4@20250514",
+		Provider:  ProviderAnthropic,
+		APIShape:  APIShapeAnthropic,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:           "3.00",
 			InputCacheWriteUSDPer1M: "3.75",
@@ -47,9 +51,10 @@ var AnthropicModels = map[string]ModelInfo{
 		},
 	},
 	"claude-sonnet-4-5": {
-		Name:     "claude-sonnet-4-5",
-		Provider: ProviderAnthropic,
-		APIShape: APIShapeAnthropic,
+		Name:      "claude-sonnet-4-5",
+		Provider:  ProviderAnthropic,
+		APIShape:  APIShapeAnthropic,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:           "3.00",
 			InputCacheWriteUSDPer1M: "3.75",
@@ -58,9 +63,10 @@ var AnthropicModels = map[string]ModelInfo{
 		},
 	},
 	"claude-sonnet-4-5@20250929": {
-		Name:     "claude-sonnet-4-5@20250929",
-		Provider: ProviderAnthropic,
-		APIShape: APIShapeAnthropic,
+		Name:      "claude-sonnet-4-5@20250929",
+		Provider:  ProviderAnthropic,
+		APIShape:  APIShapeAnthropic,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:           "3.00",
 			InputCacheWriteUSDPer1M: "3.75",
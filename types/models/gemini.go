@@ -23,9 +23,10 @@ var GeminiModels = map[string]ModelInfo{
 		},
 	},
 	"gemini-2.5-pro": {
-		Name:     "gemini-2.5-pro",
-		Provider: ProviderGemini,
-		APIShape: APIShapeGemini,
+		Name:      "gemini-2.5-pro",
+		Provider:  ProviderGemini,
+		APIShape:  APIShapeGemini,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "1.25",
 			InputCacheReadUSDPer1M: "0.31",
@@ -33,9 +34,10 @@ var GeminiModels = map[string]ModelInfo{
 		},
 	},
 	"gemini-2.5-pro-preview-06-05": {
-		Name:     "gemini-2.5-pro-preview-06-05",
-		Provider: ProviderGemini,
-		APIShape: APIShapeGemini,
+		Name:      "gemini-2.5-pro-preview-06-05",
+		Provider:  ProviderGemini,
+		APIShape:  APIShapeGemini,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "1.25",
 			InputCacheReadUSDPer1M: "0.31",
@@ -43,9 +45,10 @@ var GeminiModels = map[string]ModelInfo{
 		},
 	},
 	"gemini-2.5-flash": {
-		Name:     "gemini-2.5-flash",
-		Provider: ProviderGemini,
-		APIShape: APIShapeGemini,
+		Name:      "gemini-2.5-flash",
+		Provider:  ProviderGemini,
+		APIShape:  APIShapeGemini,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "0.3",
 			InputCacheReadUSDPer1M: "0.075",
@@ -53,9 +56,10 @@ var GeminiModels = map[string]ModelInfo{
 		},
 	},
 	"gemini-2.5-flash-preview-05-20": {
-		Name:     "gemini-2.5-flash-preview-05-20",
-		Provider: ProviderGemini,
-		APIShape: APIShapeGemini,
+		Name:      "gemini-2.5-flash-preview-05-20",
+		Provider:  ProviderGemini,
+		APIShape:  APIShapeGemini,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "0.3",
 			InputCacheReadUSDPer1M: "0.075",
@@ -63,9 +67,10 @@ var GeminiModels = map[string]ModelInfo{
 		},
 	},
 	"gemini-3-pro-preview": {
-		Name:     "gemini-3-pro-preview",
-		Provider: ProviderGemini,
-		APIShape: APIShapeGemini,
+		Name:      "gemini-3-pro-preview",
+		Provider:  ProviderGemini,
+		APIShape:  APIShapeGemini,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "2",
 			InputCacheReadUSDPer1M: "0.2",
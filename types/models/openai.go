@@ -53,9 +53,10 @@ var OpenAIModels = map[string]ModelInfo{
 		},
 	},
 	"o4-mini": {
-		Name:     "o4-mini",
-		Provider: ProviderOpenAI,
-		APIShape: APIShapeOpenAI,
+		Name:      "o4-mini",
+		Provider:  ProviderOpenAI,
+		APIShape:  APIShapeOpenAI,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "1.10",
 			InputCacheReadUSDPer1M: "0.55",
@@ -63,9 +64,10 @@ var OpenAIModels = map[string]ModelInfo{
 		},
 	},
 	"o3-mini": {
-		Name:     "o3-mini",
-		Provider: ProviderOpenAI,
-		APIShape: APIShapeOpenAI,
+		Name:      "o3-mini",
+		Provider:  ProviderOpenAI,
+		APIShape:  APIShapeOpenAI,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "1.10",
 			InputCacheReadUSDPer1M: "0.55",
@@ -73,9 +75,10 @@ var OpenAIModels = map[string]ModelInfo{
 		},
 	},
 	"o3": {
-		Name:     "o3",
-		Provider: ProviderOpenAI,
-		APIShape: APIShapeOpenAI,
+		Name:      "o3",
+		Provider:  ProviderOpenAI,
+		APIShape:  APIShapeOpenAI,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "2",
 			InputCacheReadUSDPer1M: "0.50",
@@ -83,9 +86,10 @@ var OpenAIModels = map[string]ModelInfo{
 		},
 	},
 	"gpt-5-2025-08-07": {
-		Name:     "gpt-5-2025-08-07",
-		Provider: ProviderOpenAI,
-		APIShape: APIShapeOpenAI,
+		Name:      "gpt-5-2025-08-07",
+		Provider:  ProviderOpenAI,
+		APIShape:  APIShapeOpenAI,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "1.25",
 			InputCacheReadUSDPer1M: "0.125",
@@ -93,9 +97,10 @@ var OpenAIModels = map[string]ModelInfo{
 		},
 	},
 	"gpt-5.2-2025-12-11": {
-		Name:     "gpt-5.2-2025-12-11",
-		Provider: ProviderOpenAI,
-		APIShape: APIShapeOpenAI,
+		Name:      "gpt-5.2-2025-12-11",
+		Provider:  ProviderOpenAI,
+		APIShape:  APIShapeOpenAI,
+		Reasoning: true,
 		Cost: ModelCost{
 			InputUSDPer1M:          "1.75",
 			InputCacheReadUSDPer1M: "0.175",
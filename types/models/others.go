@@ -23,6 +23,33 @@ var OtherModels = map[string]ModelInfo{
 			OutputUSDPer1M:         "2.23",
 		},
 	},
+	"moonshot-v1-8k": {
+		Name:     "moonshot-v1-8k",
+		Provider: ProviderMoonshot,
+		APIShape: APIShapeOpenAI,
+		Cost: ModelCost{
+			InputUSDPer1M:  "0.20",
+			OutputUSDPer1M: "2.00",
+		},
+	},
+	"moonshot-v1-32k": {
+		Name:     "moonshot-v1-32k",
+		Provider: ProviderMoonshot,
+		APIShape: APIShapeOpenAI,
+		Cost: ModelCost{
+			InputUSDPer1M:  "1.00",
+			OutputUSDPer1M: "3.00",
+		},
+	},
+	"moonshot-v1-128k": {
+		Name:     "moonshot-v1-128k",
+		Provider: ProviderMoonshot,
+		APIShape: APIShapeOpenAI,
+		Cost: ModelCost{
+			InputUSDPer1M:  "2.00",
+			OutputUSDPer1M: "5.00",
+		},
+	},
 	"moonshotai/kimi-k2": {
 		Name:     "moonshotai/kimi-k2",
 		Provider: ProviderOpenRouter,
@@ -30,6 +30,9 @@ type ModelInfo struct {
 	Provider Provider
 	Cost     ModelCost
 	APIShape APIShape
+
+	// Reasoning reports the model accepts a reasoning effort or thinking budget
+	Reasoning bool
 }
 
 // ModelCost represents the cost structure for a model
@@ -2,6 +2,7 @@ package types
 
 import (
 	"io"
+	"time"
 )
 
 // ChatOption represents a functional option for chat configuration
@@ -48,6 +49,58 @@ func WithToolDefinitions(tool ...*UnifiedTool) ChatOption {
 	}
 }
 
+// WithOnMaxRounds sets how a chat ends when MaxRounds is reached while the model still calls tools
+func WithOnMaxRounds(action MaxRoundsAction) ChatOption {
+	return func(req *Request) {
+		req.OnMaxRounds = action
+	}
+}
+
+// WithToolResolution sets the precedence of the tool callback and builtin tools
+func WithToolResolution(resolution ToolResolution) ChatOption {
+	return func(req *Request) {
+		req.ToolResolution = resolution
+	}
+}
+
+// WithStreamToolArgs emits partial tool call events while arguments are streamed
+func WithStreamToolArgs(enable bool) ChatOption {
+	return func(req *Request) {
+		req.StreamToolArgs = enable
+	}
+}
+
+// WithLogProbs returns token log probabilities with topLogProbs most likely
+// tokens at each position, only supported by OpenAI
+func WithLogProbs(topLogProbs int) ChatOption {
+	return func(req *Request) {
+		req.LogProbs = true
+		req.TopLogProbs = topLogProbs
+	}
+}
+
+// WithPromptCacheKey pins requests with the same key to the same prompt cache,
+// only supported by OpenAI
+func WithPromptCacheKey(key string) ChatOption {
+	return func(req *Request) {
+		req.PromptCacheKey = key
+	}
+}
+
+// WithReasoningEffort sets how much a reasoning model thinks: low, medium or high
+func WithReasoningEffort(effort ReasoningEffort) ChatOption {
+	return func(req *Request) {
+		req.ReasoningEffort = effort
+	}
+}
+
+// WithToolChoice controls whether the model calls tools: auto, none, required, or a tool name
+func WithToolChoice(choice string) ChatOption {
+	return func(req *Request) {
+		req.ToolChoice = choice
+	}
+}
+
 // WithDefaultToolCwd sets the default working directory for tool execution
 func WithDefaultToolCwd(cwd string) ChatOption {
 	return func(req *Request) {
@@ -55,6 +108,154 @@ func WithDefaultToolCwd(cwd string) ChatOption {
 	}
 }
 
+// WithSandbox confines builtin file tools to the default tool working directory,
+// paths escaping it are rejected with an error returned to the model
+func WithSandbox(sandbox bool) ChatOption {
+	return func(req *Request) {
+		req.Sandbox = sandbox
+	}
+}
+
+// WithAbortOnToolError makes the chat fail as soon as a tool fails,
+// instead of sending the error to the model as the tool result
+func WithAbortOnToolError(abort bool) ChatOption {
+	return func(req *Request) {
+		req.AbortOnToolError = abort
+	}
+}
+
+// WithStrictToolArgs validates tool call arguments against the tool's parameters
+// schema, a call with invalid arguments is not executed but gets an error result
+func WithStrictToolArgs(strict bool) ChatOption {
+	return func(req *Request) {
+		req.StrictToolArgs = strict
+	}
+}
+
+// WithOncePerTool answers a tool call repeating the name and arguments of an earlier
+// call with the earlier result and a warning, instead of executing it again
+func WithOncePerTool(once bool) ChatOption {
+	return func(req *Request) {
+		req.OncePerTool = once
+	}
+}
+
+// WithNudgeOnEmpty asks the model to continue once when it responds
+// with neither content nor tool calls, instead of ending the chat
+func WithNudgeOnEmpty(nudge bool) ChatOption {
+	return func(req *Request) {
+		req.NudgeOnEmpty = nudge
+	}
+}
+
+// WithMaxToolRetries lets the model retry a malformed tool call up to retries times,
+// the parse or validation error is sent back as the tool result instead of failing the chat
+func WithMaxToolRetries(retries int) ChatOption {
+	return func(req *Request) {
+		req.MaxToolRetries = retries
+	}
+}
+
+// WithCompactToolResults sends a tool result longer than threshold bytes to the model as
+// a summary generated by model, empty for the chat model. The record keeps the full result
+func WithCompactToolResults(threshold int, model string) ChatOption {
+	return func(req *Request) {
+		req.CompactToolResults = threshold
+		req.CompactModel = model
+	}
+}
+
+// WithToolTimeout gives a tool running longer than timeout a timeout result,
+// unless overridden by WithToolTimeouts
+func WithToolTimeout(timeout time.Duration) ChatOption {
+	return func(req *Request) {
+		req.ToolTimeout = timeout
+	}
+}
+
+// WithToolTimeouts sets the timeouts of the named tools, overriding WithToolTimeout
+func WithToolTimeouts(timeouts map[string]time.Duration) ChatOption {
+	return func(req *Request) {
+		if req.ToolTimeouts == nil {
+			req.ToolTimeouts = make(map[string]time.Duration, len(timeouts))
+		}
+		for name, timeout := range timeouts {
+			req.ToolTimeouts[name] = timeout
+		}
+	}
+}
+
+// WithEventFilter passes only events of msgTypes to the event callback
+func WithEventFilter(msgTypes ...MsgType) ChatOption {
+	return func(req *Request) {
+		req.EventFilter = append(req.EventFilter, msgTypes...)
+	}
+}
+
+// WithMCPNamespace names MCP tools server__tool, the server being
+// the base name of its command, so same-named tools of different servers coexist
+func WithMCPNamespace() ChatOption {
+	return func(req *Request) {
+		req.MCPNamespace = true
+	}
+}
+
+// WithGitDiff injects the git diff against rev as context, an empty rev
+// means HEAD, i.e. the staged and unstaged changes
+func WithGitDiff(rev string) ChatOption {
+	return func(req *Request) {
+		req.GitDiff = true
+		req.GitDiffRev = rev
+	}
+}
+
+// WithStopOnSendAnswer ends the chat once the model calls
+// send_answer, whose answer becomes Response.Answer
+func WithStopOnSendAnswer() ChatOption {
+	return func(req *Request) {
+		req.StopOnSendAnswer = true
+	}
+}
+
+// WithAssistantPrefill makes the model continue its reply from prefill,
+// only supported by Moonshot(partial mode)
+func WithAssistantPrefill(prefill string) ChatOption {
+	return func(req *Request) {
+		req.AssistantPrefill = prefill
+	}
+}
+
+// WithPredictedOutput sends predicted as the content the reply mostly repeats,
+// speeding it up. Only supported by OpenAI, ignored by other providers
+func WithPredictedOutput(predicted string) ChatOption {
+	return func(req *Request) {
+		req.PredictedOutput = predicted
+	}
+}
+
+// WithSeed samples deterministically as far as the backend can,
+// only supported by OpenAI(-compatible) APIs
+func WithSeed(seed int64) ChatOption {
+	return func(req *Request) {
+		req.Seed = &seed
+	}
+}
+
+// WithSafetySettings sets the thresholds of the Gemini safety filters
+func WithSafetySettings(settings ...SafetySetting) ChatOption {
+	return func(req *Request) {
+		req.SafetySettings = append(req.SafetySettings, settings...)
+	}
+}
+
+// WithUserMetadata attaches opaque tags like task or trace IDs to the
+// recorded user message, they are not sent to the model
+func WithUserMetadata(metadata map[string]interface{}) ChatOption {
+	return func(req *Request) {
+		req.UserMetadata = metadata
+	}
+}
+
 // WithHistory provides historical messages for conversation context
 func WithHistory(messages []Message) ChatOption {
 	return func(req *Request) {
@@ -62,6 +263,59 @@ func WithHistory(messages []Message) ChatOption {
 	}
 }
 
+// WithDocuments attaches PDF or plain text files as documents the model can cite,
+// only supported by Anthropic
+func WithDocuments(files ...string) ChatOption {
+	return func(req *Request) {
+		for _, file := range files {
+			req.Documents = append(req.Documents, Document{File: file})
+		}
+	}
+}
+
+// WithContextFiles injects the content of files as context before the user message
+func WithContextFiles(files ...string) ChatOption {
+	return func(req *Request) {
+		req.ContextFiles = append(req.ContextFiles, files...)
+	}
+}
+
+// WithTraceFile appends the request and response of each API call to file as JSON lines
+func WithTraceFile(file string) ChatOption {
+	return func(req *Request) {
+		req.TraceFile = file
+	}
+}
+
+// WithToolCacheDir keeps results of deterministic builtin tools like read_file in dir,
+// reused by later runs while the files read are unchanged
+func WithToolCacheDir(dir string) ChatOption {
+	return func(req *Request) {
+		req.ToolCacheDir = dir
+	}
+}
+
+// WithAuditLog appends each tool execution and its outcome to file as JSON lines
+func WithAuditLog(file string) ChatOption {
+	return func(req *Request) {
+		req.AuditLog = file
+	}
+}
+
+// WithEventSink POSTs each event as JSON to url, failures do not abort the chat
+func WithEventSink(url string) ChatOption {
+	return func(req *Request) {
+		req.EventSinkURL = url
+	}
+}
+
+// WithEstimateOnly counts the input tokens of the request instead of sending it
+func WithEstimateOnly(estimateOnly bool) ChatOption {
+	return func(req *Request) {
+		req.EstimateOnly = estimateOnly
+	}
+}
+
 // WithCache controls whether caching is enabled (default: true)
 func WithCache(enabled bool) ChatOption {
 	return func(req *Request) {
@@ -69,6 +323,21 @@ func WithCache(enabled bool) ChatOption {
 	}
 }
 
+// WithReconnect retries dialing a chat server and resuming its dropped stream up
+// to maxAttempts times, waiting delay times the attempt before each
+func WithReconnect(maxAttempts int, delay time.Duration) ChatOption {
+	return func(req *Request) {
+		req.Reconnect = &ReconnectPolicy{MaxAttempts: maxAttempts, Delay: delay}
+	}
+}
+
+// WithMCPConcurrency starts at most n MCP servers at a time, 0 means 4
+func WithMCPConcurrency(n int) ChatOption {
+	return func(req *Request) {
+		req.MCPConcurrency = n
+	}
+}
+
 // WithMCPServers specifies MCP servers to connect to
 func WithMCPServers(servers ...string) ChatOption {
 	return func(req *Request) {
@@ -90,6 +359,14 @@ func WithToolCallback(callback ToolCallback) ChatOption {
 	}
 }
 
+// WithInjectUserMsgs sends each user message received from msgs to the server while
+// chatting, joining the chat at the next round boundary
+func WithInjectUserMsgs(msgs <-chan string) ChatOption {
+	return func(req *Request) {
+		req.InjectUserMsgs = msgs
+	}
+}
+
 // WithFollowUpCallback sets a callback for follow-up tool execution
 func WithFollowUpCallback(callback FollowUpCallback) ChatOption {
 	return func(req *Request) {
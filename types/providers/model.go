@@ -2,8 +2,13 @@ package providers
 
 import "github.com/xhd2015/kode-ai/types"
 
-// GetModelCost returns the cost information for a model
+// GetModelCost returns the cost information for a model,
+// prices registered with RegisterModelPricing take precedence
 func GetModelCost(model string) (types.ModelCost, bool) {
+	if cost, ok := getPricingOverride(model); ok {
+		return cost, true
+	}
+
 	// Try direct lookup
 	modelInfo, ok := types.AllModelInfos[model]
 	if ok {
@@ -15,6 +20,9 @@ func GetModelCost(model string) (types.ModelCost, bool) {
 	if underlyingModel == model {
 		return types.ModelCost{}, false
 	}
+	if cost, ok := getPricingOverride(underlyingModel); ok {
+		return cost, true
+	}
 
 	underlyingModelInfo, ok := types.AllModelInfos[underlyingModel]
 	if !ok {
@@ -23,6 +31,15 @@ func GetModelCost(model string) (types.ModelCost, bool) {
 	return underlyingModelInfo.Cost, true
 }
 
+// IsReasoningModel reports whether model accepts a reasoning effort
+func IsReasoningModel(model string) bool {
+	modelInfo, ok := types.AllModelInfos[model]
+	if !ok {
+		modelInfo, ok = types.AllModelInfos[GetUnderlyingModel(model)]
+	}
+	return ok && modelInfo.Reasoning
+}
+
 var modelAlias = map[string]string{
 	types.ModelClaude3_7Sonnet:  types.ModelClaude3_7Sonnet_20250219,
 	types.ModelClaudeSonnet4:    types.ModelClaudeSonnet4_20250514,
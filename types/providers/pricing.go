@@ -0,0 +1,85 @@
+package providers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"github.com/xhd2015/kode-ai/types"
+)
+
+var (
+	pricingMutex     sync.RWMutex
+	pricingOverrides = make(map[string]types.ModelCost)
+)
+
+// RegisterModelPricing sets the price of model in USD per 1M tokens, taking precedence
+// over the built-in price. cacheRead and cacheWrite can be empty if not charged separately
+func RegisterModelPricing(model string, inputPerM string, outputPerM string, cacheRead string, cacheWrite string) error {
+	if model == "" {
+		return fmt.Errorf("requires model")
+	}
+	prices := []struct {
+		name  string
+		value string
+	}{
+		{"input", inputPerM},
+		{"output", outputPerM},
+		{"cache read", cacheRead},
+		{"cache write", cacheWrite},
+	}
+	for _, price := range prices {
+		if price.value == "" && (price.name == "input" || price.name == "output") {
+			return fmt.Errorf("%s: requires %s price", model, price.name)
+		}
+		if price.value == "" {
+			continue
+		}
+		d, err := decimal.NewFromString(price.value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid %s price %q: %w", model, price.name, price.value, err)
+		}
+		if d.IsNegative() {
+			return fmt.Errorf("%s: invalid %s price %q, must not be negative", model, price.name, price.value)
+		}
+	}
+
+	pricingMutex.Lock()
+	defer pricingMutex.Unlock()
+	pricingOverrides[model] = types.ModelCost{
+		InputUSDPer1M:           inputPerM,
+		OutputUSDPer1M:          outputPerM,
+		InputCacheReadUSDPer1M:  cacheRead,
+		InputCacheWriteUSDPer1M: cacheWrite,
+	}
+	return nil
+}
+
+// RegisterModel adds a model not built in, e.g. a self-hosted one served with
+// the API shape of a provider, so that it can be chatted with. It must be called
+// before any chat, typically at startup
+func RegisterModel(info types.ModelInfo) error {
+	if info.Name == "" {
+		return fmt.Errorf("requires model name")
+	}
+	switch info.APIShape {
+	case APIShapeOpenAI, APIShapeAnthropic, APIShapeGemini:
+	default:
+		return fmt.Errorf("%s: unsupported API shape %q, must be one of openai, anthropic, gemini", info.Name, info.APIShape)
+	}
+	if info.Provider == "" {
+		return fmt.Errorf("%s: requires provider", info.Name)
+	}
+	if _, ok := types.AllModelInfos[info.Name]; ok {
+		return fmt.Errorf("%s: already registered", info.Name)
+	}
+	types.AllModelInfos[info.Name] = info
+	return nil
+}
+
+func getPricingOverride(model string) (types.ModelCost, bool) {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	cost, ok := pricingOverrides[model]
+	return cost, ok
+}
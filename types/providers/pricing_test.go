@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestRegisterModelPricing(t *testing.T) {
+	usage := types.TokenUsage{
+		Input:  2_000_000,
+		Output: 1_000_000,
+		InputBreakdown: types.TokenUsageInputBreakdown{
+			NonCacheRead: 1_000_000,
+			CacheRead:    1_000_000,
+		},
+	}
+	if _, ok := ComputeCost(APIShapeOpenAI, "test-custom-model", usage); ok {
+		t.Fatalf("expected no cost before registering")
+	}
+	if err := RegisterModelPricing("test-custom-model", "1", "4", "0.5", ""); err != nil {
+		t.Fatal(err)
+	}
+	cost, ok := ComputeCost(APIShapeOpenAI, "test-custom-model", usage)
+	if !ok {
+		t.Fatalf("expected cost of the registered model")
+	}
+	if cost.InputUSD != "1.5" || cost.OutputUSD != "4" || cost.TotalUSD != "5.5" {
+		t.Errorf("expected input 1.5 output 4 total 5.5, got %+v", cost)
+	}
+}
+
+func TestRegisterModelPricingOverridesBuiltin(t *testing.T) {
+	if err := RegisterModelPricing(types.ModelGPT4o, "100", "200", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		pricingMutex.Lock()
+		delete(pricingOverrides, types.ModelGPT4o)
+		pricingMutex.Unlock()
+	}()
+
+	cost, ok := ComputeCost(APIShapeOpenAI, types.ModelGPT4o, types.TokenUsage{Input: 1_000_000, Output: 1_000_000})
+	if !ok || cost.TotalUSD != "300" {
+		t.Errorf("expected total 300, got %+v", cost)
+	}
+}
+
+func TestRegisterModelPricingInvalid(t *testing.T) {
+	if err := RegisterModelPricing("test-invalid-model", "abc", "1", "", ""); err == nil {
+		t.Errorf("expected error for invalid price")
+	}
+	if err := RegisterModelPricing("test-invalid-model", "1", "", "", ""); err == nil {
+		t.Errorf("expected error for missing output price")
+	}
+}
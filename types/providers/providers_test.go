@@ -0,0 +1,41 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/xhd2015/kode-ai/types"
+)
+
+func TestMoonshotModels(t *testing.T) {
+	for _, model := range []string{types.ModelKimiK2, types.ModelMoonshotV1_8K, types.ModelMoonshotV1_32K, types.ModelMoonshotV1_128K} {
+		provider, err := GetModelProvider(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if provider != ProviderMoonshot {
+			t.Errorf("%s: expected provider %s, got %s", model, ProviderMoonshot, provider)
+		}
+		apiShape, err := GetModelAPIShape(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if apiShape != APIShapeOpenAI {
+			t.Errorf("%s: expected api shape %s, got %s", model, APIShapeOpenAI, apiShape)
+		}
+	}
+
+	usage := types.TokenUsage{
+		Input:  1_000_000,
+		Output: 1_000_000,
+		InputBreakdown: types.TokenUsageInputBreakdown{
+			NonCacheRead: 1_000_000,
+		},
+	}
+	cost, ok := ComputeCost(APIShapeOpenAI, types.ModelMoonshotV1_8K, usage)
+	if !ok {
+		t.Fatalf("expected cost of %s", types.ModelMoonshotV1_8K)
+	}
+	if cost.InputUSD != "0.2" || cost.OutputUSD != "2" || cost.TotalUSD != "2.2" {
+		t.Errorf("expected input 0.2 output 2 total 2.2, got %+v", cost)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"log"
+	"time"
 )
 
 // Request represents a chat request
@@ -11,10 +12,28 @@ type Request struct {
 	Model   string `json:"model"`
 	Token   string `json:"token"`
 	BaseURL string `json:"base_url"`
+	// extra HTTP headers sent with each API request
+	Headers map[string]string `json:"headers"`
+	// OpenAI only, sent as the OpenAI-Organization and OpenAI-Project headers
+	OpenAIOrganization string `json:"openai_organization"`
+	OpenAIProject      string `json:"openai_project"`
 
 	SystemPrompt string    `json:"system_prompt"`
 	Message      string    `json:"message"`
 	History      []Message `json:"history"`
+	// attached to the recorded user message of Message, not sent to the model
+	UserMetadata map[string]interface{} `json:"user_metadata,omitempty"`
+
+	// files injected as a user-role context message before Message
+	ContextFiles []string `json:"context_files"`
+	// inject the git diff of DefaultToolCwd(default: the current directory) as
+	// context, against GitDiffRev(default: HEAD, covering staged and unstaged changes)
+	GitDiff    bool   `json:"git_diff"`
+	GitDiffRev string `json:"git_diff_rev"`
+
+	// documents attached to the user message with citations enabled,
+	// only supported by Anthropic
+	Documents []Document `json:"documents"`
 
 	MaxRounds       int            `json:"max_rounds"`
 	Tools           []string       `json:"tools"`
@@ -22,9 +41,107 @@ type Request struct {
 	ToolJSONs       []string       `json:"tool_jsons"`
 	ToolDefinitions []*UnifiedTool `json:"tool_definitions"`
 	DefaultToolCwd  string         `json:"default_tool_cwd"`
+	// reject builtin file tool paths resolving outside DefaultToolCwd
+	Sandbox        bool           `json:"sandbox"`
+	ToolResolution ToolResolution `json:"tool_resolution"` // precedence of tool callback and builtin tools, default callback-first
+
+	// what a chat ended by MaxRounds while the model still calls tools does, default stop
+	OnMaxRounds MaxRoundsAction `json:"on_max_rounds"`
+	ToolChoice  string          `json:"tool_choice"` // auto, none, required, or a tool name to force calling it
+
+	// return an error from the chat as soon as a tool fails, instead of sending the error to the model
+	AbortOnToolError bool `json:"abort_on_tool_error"`
+
+	// validate tool call arguments against the tool's parameters schema before executing it,
+	// invalid arguments get an error result listing the violations so the model can retry
+	StrictToolArgs bool `json:"strict_tool_args"`
+	// send the error of a malformed tool call back to the model as its result up to
+	// MaxToolRetries times, instead of failing the chat. With StrictToolArgs, invalid
+	// arguments count as malformed
+	MaxToolRetries int `json:"max_tool_retries"`
+	// a tool call repeating the name and arguments of an earlier call in the chat is not
+	// executed again, it gets the earlier result with a warning to break the loop
+	OncePerTool bool `json:"once_per_tool"`
+	// when the model responds with neither content nor tool calls, send a user message asking
+	// it to continue once, in an extra round, instead of ending the chat with the empty response
+	NudgeOnEmpty bool `json:"nudge_on_empty"`
+
+	// a tool result longer than CompactToolResults bytes is sent to the model as a summary
+	// generated by CompactModel(default the chat model), the record keeps the full result.
+	// The tokens of the summary are counted in the usage of the chat. 0 disables it
+	CompactToolResults int    `json:"compact_tool_results"`
+	CompactModel       string `json:"compact_model"`
+
+	// a tool running longer gets a timeout result, ToolTimeouts overrides it per tool name, 0 means no timeout
+	ToolTimeout  time.Duration            `json:"tool_timeout"`
+	ToolTimeouts map[string]time.Duration `json:"tool_timeouts"`
+
+	// emit partial MsgType_ToolCall events while tool call arguments are streamed
+	StreamToolArgs bool `json:"stream_tool_args"`
+
+	// return token log probabilities in the metadata of assistant messages,
+	// only supported by OpenAI, ignored by other providers
+	LogProbs    bool `json:"log_probs"`
+	TopLogProbs int  `json:"top_log_probs"` // 0-20, most likely tokens returned at each position, requires LogProbs
+
+	// low, medium or high, sent as reasoning_effort to OpenAI and mapped to a
+	// thinking budget for Anthropic and Gemini, only supported by reasoning models
+	ReasoningEffort ReasoningEffort `json:"reasoning_effort"`
+
+	// thresholds of the Gemini safety filters, e.g. to not block shell commands
+	// as dangerous content, ignored by other providers
+	SafetySettings []SafetySetting `json:"safety_settings,omitempty"`
+
+	NoCache bool `json:"no_cache"`
+	// routes requests sharing the key to the same prompt cache, e.g. one per session,
+	// only supported by OpenAI, ignored if NoCache is set
+	PromptCacheKey string `json:"prompt_cache_key"`
 
-	NoCache    bool     `json:"no_cache"`
 	MCPServers []string `json:"mcp_servers"`
+	// name MCP tools server__tool, so same-named tools of different MCP servers coexist
+	MCPNamespace bool `json:"mcp_namespace"`
+	// the number of MCP servers started at a time, 0 means 4
+	MCPConcurrency int `json:"mcp_concurrency"`
+
+	// append the request and response JSON of each API call to this file
+	TraceFile string `json:"trace_file"`
+
+	// append each tool execution, with its arguments, working dir, duration and
+	// outcome, to this file as JSON lines, separate from the record
+	AuditLog string `json:"audit_log"`
+
+	// keep results of deterministic builtin tools like read_file in this dir across runs,
+	// keyed by the tool name, arguments and mtime of the files read, so a repeated call
+	// on unchanged files is not executed again
+	ToolCacheDir string `json:"tool_cache_dir"`
+
+	// POST each event as JSON to this URL, in addition to EventCallback
+	EventSinkURL string `json:"event_sink_url"`
+
+	// only events of these types are passed to EventCallback, empty means all
+	EventFilter []MsgType `json:"event_filter"`
+
+	// the start of the reply the model continues, the reply includes it.
+	// Only supported by Moonshot(partial mode)
+	AssistantPrefill string `json:"assistant_prefill"`
+
+	// content the reply is expected to mostly repeat, e.g. a file being edited, sent as
+	// prediction(predicted outputs) to speed up the reply. Only supported by OpenAI,
+	// ignored by other providers
+	PredictedOutput string `json:"predicted_output"`
+
+	// sample deterministically as far as the backend can, sent as seed to OpenAI(-compatible)
+	// APIs and ignored by other providers. The system_fingerprint of each response is in
+	// the metadata of its events, a changed fingerprint means the backend changed
+	Seed *int64 `json:"seed,omitempty"`
+
+	// a call of the send_answer tool ends the chat, its answer being the final
+	// answer, see Response.Answer. The send_answer tool must be in Tools
+	StopOnSendAnswer bool `json:"stop_on_send_answer"`
+
+	// count the input tokens of the assembled request into Response.Estimate
+	// instead of calling the completion API
+	EstimateOnly bool `json:"estimate_only"`
 
 	Logger Logger `json:"-"`
 
@@ -33,8 +150,34 @@ type Request struct {
 	ToolCallback     ToolCallback     `json:"-"` // Cannot be serialized
 	FollowUpCallback FollowUpCallback `json:"-"` // Cannot be serialized
 
+	// user messages sent to the server while chatting, each joins the chat
+	// at the next round boundary, one more round is run once for those injected
+	// in the last round. Only used by ChatWithServer
+	InjectUserMsgs <-chan string `json:"-"`
+
 	// Stream fields for bidirectional tool callback communication
 	StreamPair *StreamPair `json:"-"` // Cannot be serialized
+
+	// how a chat with a server reconnects, nil dials once and resumes a dropped
+	// stream 3 times
+	Reconnect *ReconnectPolicy `json:"-"`
+}
+
+// ReconnectPolicy retries dialing a chat server failing transiently, and resuming
+// a stream closed unexpectedly from the last event received
+type ReconnectPolicy struct {
+	// MaxAttempts bounds the retries of dialing, and of resuming in a row
+	MaxAttempts int
+	// Delay is the wait before the first retry, growing linearly with each one
+	Delay time.Duration
+}
+
+// Document is a PDF or plain text file attached as an Anthropic document block
+type Document struct {
+	File      string `json:"file,omitempty"`       // read if Data is empty
+	Title     string `json:"title,omitempty"`      // defaults to the base name of File
+	MediaType string `json:"media_type,omitempty"` // application/pdf or text/plain, detected if empty
+	Data      []byte `json:"data,omitempty"`
 }
 
 type LogType string
@@ -57,7 +200,7 @@ type StreamPair struct {
 type Response struct {
 	TokenUsage TokenUsage `json:"token_usage"` // Token consumption details
 	Cost       *TokenCost `json:"cost"`        // Cost information if available
-	StopReason string     `json:"stop_reason"` // Why the conversation stopped
+	StopReason string     `json:"stop_reason"` // Why the conversation stopped, e.g. StopReason_SendAnswer
 	RoundsUsed int        `json:"rounds_used"` // Number of conversation rounds used
 
 	NumToolCalls int `json:"num_tool_calls"` // Number of tool calls used
@@ -67,6 +210,32 @@ type Response struct {
 	// LastAssistantMsg in the exactly the last assistant msg, excluding
 	// tool calls
 	LastAssistantMsg string `json:"last_assistant_response"`
+
+	// the answer of the send_answer call ending the chat, StopReason
+	// is StopReason_SendAnswer, see Request.StopOnSendAnswer
+	Answer []string `json:"answer,omitempty"`
+
+	// messages produced by the call in order: assistant msgs, tool calls,
+	// tool results and user msgs read from the stream, excluding Request.Message.
+	// Appending them to Request.History continues the chat
+	Messages []Message `json:"messages,omitempty"`
+
+	// set if Request.EstimateOnly, no round is run
+	Estimate *TokenEstimate `json:"estimate,omitempty"`
+}
+
+// StopReason_SendAnswer is the stop reason of a chat ended by a send_answer call
+const StopReason_SendAnswer = "send_answer"
+
+// StopReason_MaxRounds is the stop reason of a chat ended by MaxRounds while
+// the model still called tools, their results are not answered
+const StopReason_MaxRounds = "max_rounds"
+
+// TokenEstimate is the input size of a request not sent
+type TokenEstimate struct {
+	InputTokens int64  `json:"input_tokens"`
+	Approximate bool   `json:"approximate"`        // the provider cannot count tokens, estimated from the request size
+	CostUSD     string `json:"cost_usd,omitempty"` // input price only, empty if the model price is unknown
 }
 
 type LoggerFunc func(ctx context.Context, logType LogType, format string, args ...interface{})
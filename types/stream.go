@@ -94,6 +94,10 @@ func (sr *stdinReaderImpl) Unsubscribe(id string) {
 	}
 }
 
+// InjectUserMsgStreamID is the stream ID of the user messages a client sends while
+// chatting, each joins the chat at the next round boundary
+const InjectUserMsgStreamID = "inject-user-msg"
+
 // const STREAM_ACK_TIMEOUT = 100 * time.Second
 const STREAM_ACK_TIMEOUT = 1 * time.Second
 
@@ -13,8 +13,11 @@ type UnifiedTool struct {
 	Description string                 `json:"description,omitempty"`
 	Parameters  *jsonschema.JsonSchema `json:"parameters,omitempty"`
 
-	// command to be executed
+	// command to be executed, can reference the arguments like ${name},
+	// the environment like ${env.HOME} and the working dir as ${cwd}
 	Command []string `json:"command"`
+	// extra environment variables of the command
+	Env map[string]string `json:"env,omitempty"`
 
 	Handle func(ctx context.Context, stream StreamContext, call ToolCall) (ToolResult, bool, error) `json:"-"`
 }
@@ -3,7 +3,9 @@ package types
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	_ "embed"
@@ -27,6 +29,83 @@ const (
 	LogLevelDebug
 )
 
+// LogRedact controls what is masked in logged requests and responses
+type LogRedact string
+
+const (
+	LogRedactSecrets LogRedact = "secrets" // default, API keys and bearer tokens
+	LogRedactBody    LogRedact = "body"    // secrets, and the request and response bodies
+	LogRedactNone    LogRedact = "none"
+)
+
+func (c LogRedact) Validate() error {
+	switch c {
+	case "", LogRedactSecrets, LogRedactBody, LogRedactNone:
+		return nil
+	}
+	return fmt.Errorf("invalid log redact: %s, expect secrets, body or none", c)
+}
+
+// ReasoningEffort controls how much a reasoning model thinks before answering
+type ReasoningEffort string
+
+const (
+	ReasoningEffortLow    ReasoningEffort = "low"
+	ReasoningEffortMedium ReasoningEffort = "medium"
+	ReasoningEffortHigh   ReasoningEffort = "high"
+)
+
+func (c ReasoningEffort) Validate() error {
+	switch c {
+	case "", ReasoningEffortLow, ReasoningEffortMedium, ReasoningEffortHigh:
+		return nil
+	}
+	return fmt.Errorf("invalid reasoning effort: %s, expect low, medium or high", c)
+}
+
+// SafetySetting sets the threshold a Gemini safety filter blocks content at
+type SafetySetting struct {
+	Category  string `json:"category"`  // e.g. HARM_CATEGORY_DANGEROUS_CONTENT
+	Threshold string `json:"threshold"` // e.g. BLOCK_ONLY_HIGH
+}
+
+// SafetyCategories are the harm categories a SafetySetting can set
+var SafetyCategories = []string{
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_CIVIC_INTEGRITY",
+}
+
+// SafetyThresholds are the thresholds of a SafetySetting, from blocking the most to turning the filter off
+var SafetyThresholds = []string{
+	"BLOCK_LOW_AND_ABOVE",
+	"BLOCK_MEDIUM_AND_ABOVE",
+	"BLOCK_ONLY_HIGH",
+	"BLOCK_NONE",
+	"OFF",
+}
+
+func (c SafetySetting) Validate() error {
+	if !containsString(SafetyCategories, c.Category) {
+		return fmt.Errorf("invalid safety category: %s, expect one of %s", c.Category, strings.Join(SafetyCategories, ", "))
+	}
+	if !containsString(SafetyThresholds, c.Threshold) {
+		return fmt.Errorf("invalid safety threshold of %s: %s, expect one of %s", c.Category, c.Threshold, strings.Join(SafetyThresholds, ", "))
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
 // MsgType represents the type of message
 type MsgType string
 
@@ -42,13 +121,16 @@ const (
 	MsgType_CacheInfo  MsgType = "cache_info"
 	MsgType_StopReason MsgType = "stop_reason"
 	MsgType_TokenUsage MsgType = "token_usage"
+	// the last event of the JSON output, totals of the chat in Metadata.Summary
+	MsgType_Summary MsgType = "summary"
 
 	// for stream
 	MsgType_StreamRequestTool    MsgType = "stream_request_tool"
 	MsgType_StreamResponseTool   MsgType = "stream_response_tool"
 	MsgType_StreamRequestUserMsg MsgType = "stream_request_user_msg"
 	MsgType_StreamHandleAck      MsgType = "stream_handle_ack"
-	MsgType_StreamEnd            MsgType = "stream_end" // cannot handle message
+	MsgType_StreamEnd            MsgType = "stream_end"    // cannot handle message
+	MsgType_StreamCancel         MsgType = "stream_cancel" // sent by the client to stop the chat
 
 	// for initial stream
 	MsgType_StreamInitRequest        MsgType = "stream_init_request"
@@ -104,8 +186,16 @@ type Message struct {
 	// Extended structured metadata
 	Metadata Metadata `json:"metadata,omitempty"`
 
+	// opaque tags of a user message, e.g. task or trace IDs,
+	// recorded but never sent to the model
+	UserMetadata map[string]interface{} `json:"user_metadata,omitempty"`
+
 	// unix timestamp, accurate
 	Timestamp int64 `json:"timestamp,omitempty"`
+
+	// Seq numbers the events sent by the chat server from 1, a client
+	// reconnecting with ?resume_from=Seq receives the events after it
+	Seq int64 `json:"seq,omitempty"`
 }
 
 type Metadata struct {
@@ -114,11 +204,43 @@ type Metadata struct {
 	RoundEnd           *RoundEndMetadata           `json:"round_end,omitempty"`
 	StreamRequestTool  *StreamRequestToolMetadata  `json:"stream_request_tool,omitempty"`
 	StreamResponseTool *StreamResponseToolMetadata `json:"stream_response_tool,omitempty"`
+	ToolCall           *ToolCallMetadata           `json:"tool_call,omitempty"`
+	LogProbs           *LogProbsMetadata           `json:"log_probs,omitempty"`
+	Citations          *CitationsMetadata          `json:"citations,omitempty"`
+	Todos              *TodosMetadata              `json:"todos,omitempty"`
+	Fingerprint        *FingerprintMetadata        `json:"fingerprint,omitempty"`
+	Summary            *SummaryMetadata            `json:"summary,omitempty"`
+}
+
+// IsPartial reports whether c is a preview of an incomplete tool call,
+// which should neither be recorded nor sent back to the model
+func (c Message) IsPartial() bool {
+	return c.Metadata.ToolCall != nil && c.Metadata.ToolCall.Partial
+}
+
+// clock is the current time filled by TimeFilled
+var clock = time.Now
+
+// SetClock makes TimeFilled fill the time returned by now instead of time.Now,
+// so tests can freeze time. It must not be called concurrently with TimeFilled,
+// the returned restore reinstates the previous clock
+func SetClock(now func() time.Time) (restore func()) {
+	prev := clock
+	clock = now
+	return func() {
+		clock = prev
+	}
+}
+
+// Now returns the time of the clock set by SetClock, which is time.Now by default.
+// Times of messages should be taken from it
+func Now() time.Time {
+	return clock()
 }
 
 func (c Message) TimeFilled() Message {
 	if c.Timestamp == 0 {
-		now := time.Now()
+		now := clock()
 		c.Timestamp = now.Unix()
 		c.Time = now.Format(time.RFC3339)
 	} else if c.Time == "" {
@@ -251,6 +373,47 @@ func (s *streamContext) Write(msg Message) error {
 	return json.NewEncoder(s.out).Encode(msg)
 }
 
+// toolStreamContext is the StreamContext a ToolCallback runs with
+type toolStreamContext struct {
+	stream        StreamContext
+	call          ToolCall
+	eventCallback EventCallback
+}
+
+// NewToolStreamContext wraps stream for a tool callback executing call.
+// MsgType_Info messages written to it are progress of the tool, they reach
+// eventCallback with the tool name and use id of call while the tool runs,
+// instead of being written to stream. Other messages are written to stream, which can be nil
+func NewToolStreamContext(stream StreamContext, call ToolCall, eventCallback EventCallback) StreamContext {
+	return &toolStreamContext{
+		stream:        stream,
+		call:          call,
+		eventCallback: eventCallback,
+	}
+}
+
+func (s *toolStreamContext) ACK(id string) error {
+	if s.stream == nil {
+		return fmt.Errorf("stream not available")
+	}
+	return s.stream.ACK(id)
+}
+
+func (s *toolStreamContext) Write(msg Message) error {
+	if msg.Type == MsgType_Info {
+		if s.eventCallback != nil {
+			msg.ToolName = s.call.Name
+			msg.ToolUseID = s.call.ID
+			s.eventCallback(msg.TimeFilled())
+		}
+		return nil
+	}
+	if s.stream == nil {
+		return fmt.Errorf("stream not available")
+	}
+	return s.stream.Write(msg)
+}
+
 // TokenUsageCost combines usage and cost information
 type TokenUsageCost struct {
 	Usage TokenUsage `json:"usage"`
@@ -260,6 +423,23 @@ type TokenUsageCost struct {
 // EventCallback is called for each message during chat processing
 type EventCallback func(msg Message)
 
+// FilterEvents returns a callback passing only events of msgTypes to callback,
+// callback itself if msgTypes is empty
+func FilterEvents(callback EventCallback, msgTypes []MsgType) EventCallback {
+	if callback == nil || len(msgTypes) == 0 {
+		return callback
+	}
+	allowed := make(map[MsgType]bool, len(msgTypes))
+	for _, msgType := range msgTypes {
+		allowed[msgType] = true
+	}
+	return func(msg Message) {
+		if allowed[msg.Type] {
+			callback(msg)
+		}
+	}
+}
+
 // ToolCall represents a tool call
 type ToolCall struct {
 	ID         string                 `json:"id"`          // Unique identifier for this tool call
@@ -275,11 +455,79 @@ type ToolResult struct {
 	Error   string      `json:"error,omitempty"` // Tool execution error (if any)
 }
 
+// ToolResolution decides the precedence between the tool callback
+// and builtin tools(including MCP and custom tools) when executing a tool call
+type ToolResolution string
+
+const (
+	ToolResolution_CallbackFirst ToolResolution = "callback-first" // default
+	ToolResolution_BuiltinFirst  ToolResolution = "builtin-first"
+	ToolResolution_CallbackOnly  ToolResolution = "callback-only"
+	ToolResolution_BuiltinOnly   ToolResolution = "builtin-only"
+)
+
+// Validate checks r is empty or one of the known resolutions
+func (r ToolResolution) Validate() error {
+	switch r {
+	case "", ToolResolution_CallbackFirst, ToolResolution_BuiltinFirst, ToolResolution_CallbackOnly, ToolResolution_BuiltinOnly:
+		return nil
+	}
+	return fmt.Errorf("invalid tool resolution: %s, available: %s, %s, %s, %s", r, ToolResolution_CallbackFirst, ToolResolution_BuiltinFirst, ToolResolution_CallbackOnly, ToolResolution_BuiltinOnly)
+}
+
+// MaxRoundsAction decides how a chat ends when MaxRounds is reached while the
+// model still calls tools, leaving the last tool results unanswered
+type MaxRoundsAction string
+
+const (
+	// stop with StopReason_MaxRounds and an info event, the default
+	MaxRoundsAction_Stop MaxRoundsAction = "stop"
+	// also add an assistant message saying so, the record then ends with an assistant turn
+	MaxRoundsAction_FinalMessage MaxRoundsAction = "final-message"
+)
+
+// Validate checks a is empty or one of the known actions
+func (a MaxRoundsAction) Validate() error {
+	switch a {
+	case "", MaxRoundsAction_Stop, MaxRoundsAction_FinalMessage:
+		return nil
+	}
+	return fmt.Errorf("invalid max rounds action: %s, available: %s, %s", a, MaxRoundsAction_Stop, MaxRoundsAction_FinalMessage)
+}
+
+// values of Request.ToolChoice besides a tool name
+const (
+	ToolChoice_Auto     = "auto"     // the model decides, the default
+	ToolChoice_None     = "none"     // the model must not call tools
+	ToolChoice_Required = "required" // the model must call at least one tool
+)
+
+// ToolResultPartType is the type of a ToolResultPart
+type ToolResultPartType string
+
+const (
+	ToolResultPartType_Text  ToolResultPartType = "text"
+	ToolResultPartType_JSON  ToolResultPartType = "json"
+	ToolResultPartType_Image ToolResultPartType = "image"
+)
+
+// ToolResultPart is one part of a multi-part tool result.
+// Set ToolResult.Content to []ToolResultPart to keep the parts separate
+// for providers supporting it(Anthropic), others receive the JSON of the parts
+type ToolResultPart struct {
+	Type      ToolResultPartType `json:"type"`
+	Text      string             `json:"text,omitempty"`       // Type == text
+	Data      interface{}        `json:"data,omitempty"`       // Type == json, structured data
+	MediaType string             `json:"media_type,omitempty"` // Type == image, e.g. image/png
+	Base64    string             `json:"base64,omitempty"`     // Type == image, base64 encoded image data
+}
+
 // ToolCallback allows custom tool execution
 // Returns: (result, handled, error)
 // - result: Tool execution result
 // - handled: true if tool was handled by callback, false to fallback to built-in tools
 // - error: Any execution error
+// A MsgType_Info message written to stream while running is progress of the tool, see NewToolStreamContext
 type ToolCallback func(ctx context.Context, stream StreamContext, call ToolCall) (ToolResult, bool, error)
 
 // FollowUpCallback allows custom follow-up tool execution